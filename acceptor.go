@@ -1,7 +1,7 @@
 package uringnet
 
 import (
-	"fmt"
+	"github.com/y001j/uringnet/logging"
 	"github.com/y001j/uringnet/uring"
 )
 
@@ -22,12 +22,17 @@ func NewManyForAcceptor(addr NetAddress, size uint, sqpoll bool, num int, handle
 		uringArray[i].Type = addr.AddrType
 		uringArray[i].Handler = handler
 
+		var err error
 		if sqpoll {
-			uringArray[i].SetUring(size, &uring.IOUringParams{Flags: uring.IORING_SETUP_SQPOLL, Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP}) //Features: uring.IORING_FEAT_FAST_POLL})
+			_, err = uringArray[i].SetUring(size, &uring.IOUringParams{Flags: uring.IORING_SETUP_SQPOLL, Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP}) //Features: uring.IORING_FEAT_FAST_POLL})
 		} else {
-			uringArray[i].SetUring(size, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP})
+			_, err = uringArray[i].SetUring(size, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP})
 		}
-		fmt.Println("Uring instance initiated!")
+		if err != nil {
+			logging.Default().Log(logging.LevelError, "ring setup failed", "ring", i, "err", err)
+			return nil, err
+		}
+		logging.Default().Log(logging.LevelDebug, "ring set up", "ring", i, "entries", size)
 	}
 	return uringArray, nil
 }
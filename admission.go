@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Admission limits the connections a loop accepts on its listener. The zero value admits every
+// connection. Peers which are not IP addresses, e.g. of a Unix socket, are only subject to MaxConns.
+type Admission struct {
+	MaxConns      int          // connections open at once on the loop, 0 for no limit
+	MaxConnsPerIP int          // connections open at once from a source IP, 0 for no limit
+	Allow         []*net.IPNet // if not empty, only sources in these networks are admitted
+	Deny          []*net.IPNet // sources in these networks are rejected, even if allowed
+}
+
+// AcceptHandler is implemented by event handlers which decide whether a connection is admitted.
+type AcceptHandler interface {
+	// OnAccept fires on the ring goroutine once a connection from remote passed the admission
+	// limits of the loop, before OnOpen. Returning false rejects it: the socket is closed before
+	// any read is armed and neither OnOpen nor OnClose fire.
+	OnAccept(ringNet *URingNet, fd int32, remote net.Addr) bool
+}
+
+// admissionState counts the connections admitted by the rings of a loop.
+type admissionState struct {
+	config atomic.Value // *Admission
+
+	mu    sync.Mutex
+	open  int
+	perIP map[string]int
+}
+
+// SetAdmission sets the admission limits of the loop, nil admits every connection. It is safe to
+// call from any goroutine while the loop runs, connections already open are kept.
+func (loop *Ringloop) SetAdmission(a *Admission) {
+	loop.admission.config.Store(a)
+}
+
+// Admission returns the admission limits of the loop, nil if none is set.
+func (loop *Ringloop) Admission() *Admission {
+	a, _ := loop.admission.config.Load().(*Admission)
+	return a
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire counts a connection from ip, key being its source, if a admits it.
+func (s *admissionState) acquire(a *Admission, ip net.IP, key string) (ok bool, reason string) {
+	if ip != nil {
+		if containsIP(a.Deny, ip) {
+			return false, "denied"
+		}
+		if len(a.Allow) > 0 && !containsIP(a.Allow, ip) {
+			return false, "not allowed"
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a.MaxConns > 0 && s.open >= a.MaxConns {
+		return false, "too many connections"
+	}
+	if key != "" && a.MaxConnsPerIP > 0 && s.perIP[key] >= a.MaxConnsPerIP {
+		return false, "too many connections from the source"
+	}
+	s.open++
+	if key != "" {
+		if s.perIP == nil {
+			s.perIP = make(map[string]int)
+		}
+		s.perIP[key]++
+	}
+	return true, ""
+}
+
+// release uncounts a connection counted by acquire.
+func (s *admissionState) release(key string) {
+	s.mu.Lock()
+	s.open--
+	if key != "" {
+		if s.perIP[key]--; s.perIP[key] <= 0 {
+			delete(s.perIP, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// accept opens the connection of data once accepted. If the loop expects a PROXY header, the
+// connection is opened once it has been read.
+func (ringNet *URingNet) accept(data *UserData) {
+	if loop := ringNet.ringloop; loop != nil {
+		if p := loop.ProxyProtocol(); p != nil && p.Mode != ProxyOff {
+			ringNet.readProxyHeader(data, *p)
+			return
+		}
+	}
+	ringNet.open(data, nil)
+}
+
+// open admits the connection of data, h being its PROXY header if any, fires OnOpen and arms its
+// first read.
+func (ringNet *URingNet) open(data *UserData, h *ProxyHeader) {
+	if !ringNet.admit(data.Fd, data.ClientSock, h) {
+		return
+	}
+	atomic.AddUint64(&ringNet.metrics.accepted, 1)
+	atomic.AddInt64(&ringNet.metrics.active, 1)
+	ringNet.log(logging.LevelDebug, "connection accepted", "fd", data.Fd)
+	if h != nil {
+		ringNet.logProxy(data.Fd, h)
+	}
+	ringNet.Handler.OnOpen(data)
+	if c := ringNet.conns[data.Fd]; c == nil || !c.hijacked {
+		ringNet.armRead(data.Fd)
+	}
+}
+
+// admit decides whether the connection fd accepted from rsa is admitted, and registers it if so.
+// The address of the client is taken from the PROXY header h if it carries one. A rejected
+// connection is closed right away.
+func (ringNet *URingNet) admit(fd int32, rsa *syscall.RawSockaddrAny, h *ProxyHeader) bool {
+	var peer unix.Sockaddr
+	var remote net.Addr
+	if rsa != nil {
+		peer = rawToSockaddr((*unix.RawSockaddrAny)(unsafe.Pointer(rsa)))
+		remote = socket.SockaddrToTCPOrUnixAddr(peer)
+	}
+	var ip net.IP
+	switch sa := peer.(type) {
+	case *unix.SockaddrInet4:
+		ip = net.IP(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		ip = net.IP(sa.Addr[:])
+	}
+	if source, sourceIP := proxyAddrs(h); source != nil {
+		remote, ip = source, sourceIP
+	}
+	key := ""
+	if ip != nil {
+		key = string(ip.To16())
+	}
+
+	counted := false
+	if loop := ringNet.ringloop; loop != nil {
+		if a := loop.Admission(); a != nil {
+			ok, reason := loop.admission.acquire(a, ip, key)
+			if !ok {
+				ringNet.reject(fd, remote, reason)
+				return false
+			}
+			counted = true
+		}
+	}
+	if h, ok := ringNet.Handler.(AcceptHandler); ok && !h.OnAccept(ringNet, fd, remote) {
+		if counted {
+			ringNet.ringloop.admission.release(key)
+		}
+		ringNet.reject(fd, remote, "vetoed")
+		return false
+	}
+	c := ringNet.register(fd, peer, remote, key, h)
+	c.admitted = counted
+	return true
+}
+
+// reject closes the connection fd which has not been admitted.
+func (ringNet *URingNet) reject(fd int32, remote net.Addr, reason string) {
+	atomic.AddUint64(&ringNet.metrics.rejected, 1)
+	ringNet.log(logging.LevelDebug, "connection rejected", "fd", fd, "remote", remote, "reason", reason)
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Close(sqe, uintptr(fd))
+	}, func(int32, uint32) {})
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type vetoHandler struct {
+	echoHandler
+	vetoed int32 // rejects the connections while not zero
+	opened int32
+}
+
+func (h *vetoHandler) OnAccept(_ *URingNet, _ int32, _ net.Addr) bool {
+	return atomic.LoadInt32(&h.vetoed) == 0
+}
+
+func (h *vetoHandler) OnOpen(*UserData) ([]byte, Action) {
+	atomic.AddInt32(&h.opened, 1)
+	return nil, None
+}
+
+// dialAdmitted connects to addr and reports whether the server keeps the connection open.
+func dialAdmitted(t *testing.T, addr string) (net.Conn, bool) {
+	t.Helper()
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if _, err = c.Write([]byte("x")); err != nil {
+		return c, false
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadFull(c, make([]byte, 1))
+	return c, err == nil
+}
+
+func TestAdmission(t *testing.T) {
+	h := &vetoHandler{}
+	loop, addr := listen(t, 2, h)
+	loop.SetAdmission(&Admission{MaxConnsPerIP: 2})
+
+	first, ok := dialAdmitted(t, addr)
+	if !ok {
+		t.Fatal("expect the first connection to be admitted")
+	}
+	if _, ok = dialAdmitted(t, addr); !ok {
+		t.Fatal("expect the second connection to be admitted")
+	}
+	if _, ok = dialAdmitted(t, addr); ok {
+		t.Fatal("expect the third connection from the source to be rejected")
+	}
+	// closing a connection frees its slot.
+	_ = first.Close()
+	waitUntil(t, func() bool {
+		n := 0
+		loop.Range(func(ConnInfo) bool { n++; return true })
+		return n == 1
+	})
+	if _, ok = dialAdmitted(t, addr); !ok {
+		t.Fatal("expect a connection to be admitted once another is closed")
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	loop.SetAdmission(&Admission{Deny: []*net.IPNet{loopback}})
+	if _, ok = dialAdmitted(t, addr); ok {
+		t.Fatal("expect a denied source to be rejected")
+	}
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+	loop.SetAdmission(&Admission{Allow: []*net.IPNet{other}})
+	if _, ok = dialAdmitted(t, addr); ok {
+		t.Fatal("expect a source not allowed to be rejected")
+	}
+
+	loop.SetAdmission(nil)
+	atomic.StoreInt32(&h.vetoed, 1)
+	if _, ok = dialAdmitted(t, addr); ok {
+		t.Fatal("expect the connection vetoed by OnAccept to be rejected")
+	}
+	if n := atomic.LoadInt32(&h.opened); n != 3 {
+		t.Fatalf("expect OnOpen to fire for the 3 admitted connections, got %d", n)
+	}
+	var rejected uint64
+	for _, m := range loop.Metrics() {
+		rejected += m.Rejected
+	}
+	if rejected != 4 {
+		t.Fatalf("expect 4 rejected connections, got %d", rejected)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	bsPool "github.com/y001j/uringnet/byteslice"
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Group is a set of connections, identified by their IDs, to broadcast to. It is safe to use
+// from any goroutine. Connections stay in a group once closed, until they are removed.
+type Group struct {
+	mu  sync.RWMutex
+	ids map[uint64]struct{}
+}
+
+// NewGroup returns an empty group.
+func NewGroup() *Group {
+	return &Group{ids: make(map[uint64]struct{})}
+}
+
+// Add adds the connection id to the group.
+func (g *Group) Add(id uint64) {
+	g.mu.Lock()
+	g.ids[id] = struct{}{}
+	g.mu.Unlock()
+}
+
+// Remove removes the connection id from the group.
+func (g *Group) Remove(id uint64) {
+	g.mu.Lock()
+	delete(g.ids, id)
+	g.mu.Unlock()
+}
+
+// Contains reports whether the connection id is in the group.
+func (g *Group) Contains(id uint64) bool {
+	g.mu.RLock()
+	_, ok := g.ids[id]
+	g.mu.RUnlock()
+	return ok
+}
+
+// Len returns the number of connections in the group.
+func (g *Group) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.ids)
+}
+
+// sharedBuffer is the payload of a broadcast, returned to the pool once every send has completed.
+type sharedBuffer struct {
+	b    []byte
+	refs int64
+}
+
+func (s *sharedBuffer) release() {
+	if atomic.AddInt64(&s.refs, -1) == 0 {
+		bsPool.Put(s.b)
+	}
+}
+
+// Broadcast sends payload to every open connection of the loop for which match returns true,
+// or to all of them if match is nil. It returns the number of connections the payload is sent to.
+//
+// The payload is copied once, into a buffer shared by all the sends. The sends of each ring are
+// queued together and submitted at once, on the goroutine of the ring. Broadcast does not wait
+// for them: failed, if not nil, is called on the goroutine of the ring with the ID of each
+// connection the payload could not be sent to, which may have been closed meanwhile.
+//
+// The sends are not ordered with the other writes of the connections, handlers must not write
+// to the connections concurrently with a broadcast if messages could be interleaved.
+func (loop *Ringloop) Broadcast(payload []byte, match func(info ConnInfo) bool, failed func(id uint64, err error)) (int, error) {
+	var targets []*conn
+	loop.connections.Range(func(_, v interface{}) bool {
+		c := v.(*conn)
+		if match == nil || match(c.info()) {
+			targets = append(targets, c)
+		}
+		return true
+	})
+	return loop.broadcast(targets, payload, failed)
+}
+
+// BroadcastGroup sends payload to the connections of g like Broadcast. The connections of g which
+// are not open are reported to failed with ErrConnectionNotFound, unlike the failed sends on the
+// calling goroutine, before BroadcastGroup returns.
+func (loop *Ringloop) BroadcastGroup(g *Group, payload []byte, failed func(id uint64, err error)) (int, error) {
+	g.mu.RLock()
+	targets := make([]*conn, 0, len(g.ids))
+	var missing []uint64
+	for id := range g.ids {
+		if v, ok := loop.connections.Load(id); ok {
+			targets = append(targets, v.(*conn))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	g.mu.RUnlock()
+	if failed != nil {
+		for _, id := range missing {
+			failed(id, errors.ErrConnectionNotFound)
+		}
+	}
+	return loop.broadcast(targets, payload, failed)
+}
+
+// States of the task sending a broadcast on a ring.
+const (
+	taskQueued int32 = iota
+	taskRun
+	taskDropped
+)
+
+func (loop *Ringloop) broadcast(targets []*conn, payload []byte, failed func(id uint64, err error)) (int, error) {
+	if len(targets) == 0 || len(payload) == 0 {
+		return 0, nil
+	}
+	byRing := make(map[*URingNet][]*conn)
+	for _, c := range targets {
+		byRing[c.ring] = append(byRing[c.ring], c)
+	}
+	buf := &sharedBuffer{b: bsPool.Get(len(payload)), refs: int64(len(targets))}
+	copy(buf.b, payload)
+	sent := 0
+	var err error
+	for ringNet, conns := range byRing {
+		ringNet, conns := ringNet, conns
+		// state tells whether the task has run, or been dropped after Trigger failed. Trigger may
+		// fail once the task is queued, which then runs unless it is dropped first.
+		var state int32
+		if terr := ringNet.Trigger(func() {
+			if !atomic.CompareAndSwapInt32(&state, taskQueued, taskRun) {
+				return
+			}
+			for _, c := range conns {
+				// the connection may have been closed, and its fd reused, meanwhile.
+				if cur, ok := ringNet.conns[int32(c.fd)]; !ok || cur != c {
+					buf.release()
+					if failed != nil {
+						failed(c.id, errors.ErrConnectionNotFound)
+					}
+					continue
+				}
+				ringNet.sendShared(c, buf, 0, failed)
+			}
+			_, _ = ringNet.submit(0)
+		}); terr != nil {
+			err = terr
+			if atomic.CompareAndSwapInt32(&state, taskQueued, taskDropped) {
+				for range conns {
+					buf.release()
+				}
+				continue
+			}
+		}
+		sent += len(conns)
+	}
+	return sent, err
+}
+
+// sendShared queues the send of buf from offset to the connection c, the rest of a partial send
+// is sent once it completes.
+func (ringNet *URingNet) sendShared(c *conn, buf *sharedBuffer, offset int, failed func(id uint64, err error)) {
+	ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Send(sqe, uintptr(c.fd), buf.b[offset:], unix.MSG_NOSIGNAL)
+	}, func(res int32, _ uint32) {
+		if res <= 0 {
+			buf.release()
+			if failed != nil {
+				err := error(io.ErrShortWrite)
+				if res < 0 {
+					err = unix.Errno(-res)
+				}
+				failed(c.id, err)
+			}
+			return
+		}
+		if offset += int(res); offset < len(buf.b) {
+			ringNet.sendShared(c, buf, offset, failed)
+			_, _ = ringNet.submit(0)
+			return
+		}
+		buf.release()
+	})
+}
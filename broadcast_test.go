@@ -0,0 +1,106 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	"golang.org/x/sys/unix"
+)
+
+func TestBroadcast(t *testing.T) {
+	loop, clients, ids := startEcho(t, 2, 3)
+
+	expect := func(i int, payload []byte) {
+		t.Helper()
+		_ = clients[i].SetReadDeadline(time.Now().Add(5 * time.Second))
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(clients[i], got); err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("client %d: expect the payload, got %d bytes, %v", i, len(got), err)
+		}
+	}
+
+	g := NewGroup()
+	g.Add(ids[0])
+	g.Add(ids[2])
+	g.Add(1 << 62) // not a connection
+	var mu sync.Mutex
+	failures := map[uint64]error{}
+	failed := func(id uint64, err error) {
+		mu.Lock()
+		failures[id] = err
+		mu.Unlock()
+	}
+	n, err := loop.BroadcastGroup(g, []byte("to the group"), failed)
+	if err != nil || n != 2 {
+		t.Fatalf("expect 2 sends, got %d, %v", n, err)
+	}
+	expect(0, []byte("to the group"))
+	expect(2, []byte("to the group"))
+	mu.Lock()
+	if len(failures) != 1 || failures[1<<62] != errors.ErrConnectionNotFound {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	mu.Unlock()
+
+	// a payload larger than the socket buffers is sent in several parts.
+	large := bytes.Repeat([]byte("0123456789"), 400000)
+	if n, err = loop.Broadcast(large, nil, failed); err != nil || n != 3 {
+		t.Fatalf("expect 3 sends, got %d, %v", n, err)
+	}
+	var wg sync.WaitGroup
+	for i := range clients {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = clients[i].SetReadDeadline(time.Now().Add(5 * time.Second))
+			got := make([]byte, len(large))
+			if _, err := io.ReadFull(clients[i], got); err != nil || !bytes.Equal(got, large) {
+				t.Errorf("client %d: expect the large payload, got %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, _ = loop.Broadcast([]byte("one"), func(info ConnInfo) bool { return info.ID == ids[1] }, failed)
+	if n != 1 {
+		t.Fatalf("expect 1 send, got %d", n)
+	}
+	expect(1, []byte("one"))
+
+	// a broadcast whose ring cannot be woken up is dropped, even once the ring wakes up later.
+	target := -1
+	for i, id := range ids {
+		if info, ok := loop.Get(id); ok && info.Ring == 0 {
+			target = i
+		}
+	}
+	if target < 0 {
+		t.Fatal("expect a client on the first ring")
+	}
+	ringNet := loop.RingNet[0]
+	only := func(info ConnInfo) bool { return info.ID == ids[target] }
+	readOnly, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(readOnly)
+	wakeFd := atomic.SwapInt32(&ringNet.wakeFd, int32(readOnly))
+	n, err = loop.Broadcast([]byte("dropped"), only, failed)
+	atomic.StoreInt32(&ringNet.wakeFd, wakeFd)
+	atomic.StoreInt32(&ringNet.wakePending, 0)
+	if err == nil || n != 0 {
+		t.Fatalf("expect the broadcast to fail, got %d, %v", n, err)
+	}
+	if n, err = loop.Broadcast([]byte("two"), only, failed); err != nil || n != 1 {
+		t.Fatalf("expect 1 send, got %d, %v", n, err)
+	}
+	expect(target, []byte("two"))
+}
@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/logging"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Capabilities reports what the io_uring of the running kernel supports.
+type Capabilities struct {
+	Kernel     string // release of the kernel
+	Probe      bool   // the kernel can be probed for its operations, Linux 5.6
+	Features   uint32 // IORING_FEAT_* flags reported by the kernel
+	SetupFlags uint32 // IORING_SETUP_* flags a ring can be set up with
+	// BufRing is true if rings of provided buffers can be registered, Linux 5.19.
+	BufRing bool
+	// MultishotAccept is true if an accept can post a completion per connection, Linux 5.19.
+	MultishotAccept bool
+
+	ops [uring.IORING_OP_LAST]bool
+}
+
+// Supports reports whether the operation op is supported.
+func (c *Capabilities) Supports(op uint8) bool {
+	return int(op) < len(c.ops) && c.ops[op]
+}
+
+// opNames names the operations of the capability report.
+var opNames = [uring.IORING_OP_LAST]string{
+	"NOP", "READV", "WRITEV", "FSYNC", "READ_FIXED", "WRITE_FIXED", "POLL_ADD", "POLL_REMOVE",
+	"SYNC_FILE_RANGE", "SENDMSG", "RECVMSG", "TIMEOUT", "TIMEOUT_REMOVE", "ACCEPT", "ASYNC_CANCEL",
+	"LINK_TIMEOUT", "CONNECT", "FALLOCATE", "OPENAT", "CLOSE", "FILES_UPDATE", "STATX", "READ", "WRITE",
+	"FADVISE", "MADVISE", "SEND", "RECV", "OPENAT2", "EPOLL_CTL", "SPLICE", "PROVIDE_BUFFERS",
+	"REMOVE_BUFFERS", "TEE", "SHUTDOWN", "RENAMEAT", "UNLINKAT", "MKDIRAT", "SYMLINKAT", "LINKAT",
+	"MSG_RING", "FSETXATTR", "SETXATTR", "FGETXATTR", "GETXATTR", "SOCKET", "URING_CMD", "SEND_ZC",
+	"SENDMSG_ZC",
+}
+
+var setupFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{uring.IORING_SETUP_SQPOLL, "SQPOLL"},
+	{uring.IORING_SETUP_SQ_AFF, "SQ_AFF"},
+	{uring.IORING_SETUP_CQSIZE, "CQSIZE"},
+	{uring.IORING_SETUP_CLAMP, "CLAMP"},
+	{uring.IORING_SETUP_R_DISABLED, "R_DISABLED"},
+	{uring.IORING_SETUP_SUBMIT_ALL, "SUBMIT_ALL"},
+	{uring.IORING_SETUP_COOP_TASKRUN, "COOP_TASKRUN"},
+	{uring.IORING_SETUP_TASKRUN_FLAG, "TASKRUN_FLAG"},
+	{uring.IORING_SETUP_SINGLE_ISSUER, "SINGLE_ISSUER"},
+	{uring.IORING_SETUP_DEFER_TASKRUN, "DEFER_TASKRUN"},
+}
+
+var featureNames = []string{
+	"SINGLE_MMAP", "NODROP", "SUBMIT_STABLE", "RW_CUR_POS", "CUR_PERSONALITY", "FAST_POLL", "POLL_32BITS",
+	"SQPOLL_NONFIXED", "EXT_ARG", "NATIVE_WORKERS", "RSRC_TAGS", "CQE_SKIP", "LINKED_FILE", "REG_REG_RING",
+}
+
+// requiredOps are the operations the engine cannot run without.
+var requiredOps = []uint8{
+	uring.IORING_OP_ACCEPT, uring.IORING_OP_READ, uring.IORING_OP_WRITE, uring.IORING_OP_SEND,
+	uring.IORING_OP_RECV, uring.IORING_OP_CLOSE, uring.IORING_OP_TIMEOUT, uring.IORING_OP_ASYNC_CANCEL,
+}
+
+var (
+	capsOnce sync.Once
+	caps     *Capabilities
+	capsErr  error
+)
+
+// KernelCapabilities probes the io_uring of the running kernel once and returns what it supports.
+// It fails if the kernel has no io_uring, or lacks operations the engine requires.
+func KernelCapabilities() (*Capabilities, error) {
+	capsOnce.Do(func() {
+		caps, capsErr = probeCapabilities()
+	})
+	return caps, capsErr
+}
+
+// kernelCapabilities returns KernelCapabilities, logging the report or the error.
+func kernelCapabilities() (*Capabilities, error) {
+	c, err := KernelCapabilities()
+	if err != nil {
+		logging.Default().Log(logging.LevelError, "io_uring is not usable", "err", err)
+		return nil, err
+	}
+	logging.Default().Log(logging.LevelDebug, "kernel capabilities", "report", c.String())
+	return c, nil
+}
+
+func probeCapabilities() (*Capabilities, error) {
+	c := &Capabilities{}
+	var uts unix.Utsname
+	if unix.Uname(&uts) == nil {
+		c.Kernel = unix.ByteSliceToString(uts.Release[:])
+	}
+	ring, err := uring.Setup(4, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrKernelUnsupported, err)
+	}
+	defer ring.Close()
+	c.Features = ring.Features()
+
+	var probe uring.Probe
+	if err = ring.RegisterProbe(&probe); err == nil {
+		c.Probe = true
+		for op := range c.ops {
+			c.ops[op] = probe.IsSupported(uint8(op))
+		}
+	}
+	var missing []string
+	for _, op := range requiredOps {
+		if !c.Supports(op) {
+			missing = append(missing, opNames[op])
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: kernel %s lacks %s", errors.ErrKernelUnsupported, c.Kernel, strings.Join(missing, ", "))
+	}
+
+	for _, f := range setupFlagNames {
+		params := uring.IOUringParams{Flags: f.flag, CQEntries: 8}
+		switch f.flag {
+		case uring.IORING_SETUP_SQ_AFF:
+			params.Flags |= uring.IORING_SETUP_SQPOLL
+		case uring.IORING_SETUP_TASKRUN_FLAG:
+			params.Flags |= uring.IORING_SETUP_COOP_TASKRUN
+		case uring.IORING_SETUP_DEFER_TASKRUN:
+			params.Flags |= uring.IORING_SETUP_SINGLE_ISSUER
+		}
+		if r, err := uring.Setup(4, &params); err == nil {
+			c.SetupFlags |= f.flag
+			_ = r.Close()
+		}
+	}
+	if br, err := ring.RegisterBufRing(1, 0); err == nil {
+		c.BufRing = true
+		_ = br.Close()
+	}
+	// multishot accept came with IORING_OP_SOCKET.
+	c.MultishotAccept = c.Supports(uring.IORING_OP_SOCKET)
+	return c, nil
+}
+
+// String returns the capability report.
+func (c *Capabilities) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "kernel %s, buffer rings %t, multishot accept %t\nfeatures:", c.Kernel, c.BufRing, c.MultishotAccept)
+	for i, name := range featureNames {
+		if c.Features&(1<<i) != 0 {
+			b.WriteString(" " + name)
+		}
+	}
+	b.WriteString("\nsetup flags:")
+	for _, f := range setupFlagNames {
+		if c.SetupFlags&f.flag != 0 {
+			b.WriteString(" " + f.name)
+		}
+	}
+	b.WriteString("\noperations:")
+	for op, name := range opNames {
+		if c.ops[op] {
+			b.WriteString(" " + name)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+func TestKernelCapabilities(t *testing.T) {
+	caps, err := KernelCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range requiredOps {
+		if !caps.Supports(op) {
+			t.Errorf("expect %s to be supported", opNames[op])
+		}
+	}
+	report := caps.String()
+	for _, s := range []string{"kernel " + caps.Kernel, "features:", "setup flags:", "operations:", " ACCEPT", " RECV"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("expect the report to contain %q, got\n%s", s, report)
+		}
+	}
+	if again, _ := KernelCapabilities(); again != caps {
+		t.Error("expect the kernel to be probed once")
+	}
+}
+
+// TestCapabilityFallbacks runs an echo server as on kernels lacking the newer io_uring features.
+func TestCapabilityFallbacks(t *testing.T) {
+	kernel, err := KernelCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noProvide := *kernel
+	noProvide.BufRing, noProvide.MultishotAccept = false, false
+	noProvide.ops[uring.IORING_OP_PROVIDE_BUFFERS] = false
+	noBufRing := *kernel
+	noBufRing.BufRing, noBufRing.MultishotAccept = false, false
+
+	for _, tc := range []struct {
+		name     string
+		caps     *Capabilities
+		provided bool
+	}{
+		{"current", kernel, true},
+		{"provide buffers", &noBufRing, true},
+		{"own buffers", &noProvide, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 256, false, 1, socket.SocketOptions{}, &echoHandler{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ringNets[0].caps = tc.caps
+			sa, err := unix.Getsockname(ringNets[0].SocketFd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop := SetLoops(ringNets, 64)
+			if ringNets[0].providedBuffers != tc.provided || (ringNets[0].bufRing != nil) != tc.caps.BufRing {
+				t.Fatalf("expect provided buffers %t and a buffer ring %t", tc.provided, tc.caps.BufRing)
+			}
+			loop.RunMany2()
+			for i := 0; i < 3; i++ {
+				c, err := net.Dial("tcp4", socket.SockaddrToTCPOrUnixAddr(sa).String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				ping(t, c, "hello")
+				_ = c.Close()
+			}
+		})
+	}
+}
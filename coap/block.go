@@ -0,0 +1,52 @@
+package coap
+
+// Block is the value of a Block1 or Block2 option of a block-wise transfer (RFC 7959).
+type Block struct {
+	Num  uint32 // number of the block, counted from 0
+	More bool   // more blocks follow
+	SZX  uint8  // the size of the blocks is 1<<(SZX+4), from 16 to 1024 bytes
+}
+
+// maxSZX is the exponent of the largest block size, 7 is reserved.
+const maxSZX = 6
+
+// Size returns the size of the blocks.
+func (b Block) Size() int {
+	return 1 << (b.SZX + 4)
+}
+
+// Offset returns the offset of the block in the body.
+func (b Block) Offset() int {
+	return int(b.Num) << (b.SZX + 4)
+}
+
+func (b Block) value() uint32 {
+	v := b.Num<<4 | uint32(b.SZX)
+	if b.More {
+		v |= 8
+	}
+	return v
+}
+
+// Block returns the Block1 or Block2 option of m, ok is false if it is absent or invalid.
+func (m *Message) Block(id OptionID) (b Block, ok bool) {
+	v, ok := m.Uint(id)
+	if !ok || v>>4 > 1<<20-1 || v&7 > maxSZX {
+		return Block{}, false
+	}
+	return Block{Num: v >> 4, More: v&8 != 0, SZX: uint8(v & 7)}, true
+}
+
+// SetBlock sets the Block1 or Block2 option of m.
+func (m *Message) SetBlock(id OptionID, b Block) {
+	m.SetUint(id, b.value())
+}
+
+// szxFor returns the largest block size exponent whose size does not exceed size.
+func szxFor(size int) uint8 {
+	szx := uint8(0)
+	for szx < maxSZX && 1<<(szx+5) <= size {
+		szx++
+	}
+	return szx
+}
@@ -0,0 +1,449 @@
+//go:build linux
+
+package coap
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/y001j/uringnet"
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Transmission parameters (RFC 7252, section 4.8).
+const (
+	DefaultACKTimeout    = 2 * time.Second
+	DefaultMaxRetransmit = 4
+
+	// DefaultTimeout is MAX_TRANSMIT_WAIT, the time from the first transmission of a confirmable
+	// request to the moment the client gives up waiting for the response.
+	DefaultTimeout = 93 * time.Second
+
+	maxDatagram = 65535
+)
+
+var (
+	// ErrTimeout is returned when no response has been received in time.
+	ErrTimeout = errors.New("coap: request timed out")
+
+	// ErrReset is returned when the server rejected the request with a reset message.
+	ErrReset = errors.New("coap: request reset by the server")
+
+	// ErrClosed is returned by the requests of a closed client.
+	ErrClosed = errors.New("coap: client closed")
+
+	errBlock = errors.New("coap: invalid block-wise transfer")
+)
+
+// the rings of the clients, shared by every client of the process.
+var (
+	clientRingOnce sync.Once
+	clientRing     *uringnet.URingNet
+	clientRingErr  error
+)
+
+func sharedRing() (*uringnet.URingNet, error) {
+	clientRingOnce.Do(func() {
+		clientRing, clientRingErr = uringnet.New(uringnet.NetAddress{}, ringSize, false, socket.SocketOptions{})
+		if clientRingErr != nil {
+			return
+		}
+		clientRing.Handler = &uringnet.BuiltinEventEngine{}
+		go clientRing.Run2(0)
+	})
+	return clientRing, clientRingErr
+}
+
+// Client sends requests to a CoAP server. Its methods are safe for concurrent use, its
+// parameters must be set before the first request.
+//
+// The datagrams of every client are sent and received by a ring shared by the clients of the process.
+type Client struct {
+	// ACKTimeout is the initial timeout of a confirmable request, it is doubled at every retransmission.
+	ACKTimeout time.Duration
+
+	// MaxRetransmit is the number of retransmissions of a confirmable request.
+	MaxRetransmit int
+
+	// Timeout limits the time waiting for the response of a request.
+	Timeout time.Duration
+
+	// BlockSize is the size of the blocks request bodies are split into, it is rounded down
+	// to a power of two between 16 and 1024.
+	BlockSize int
+
+	ring       *uringnet.URingNet
+	fd         int
+	remoteAddr net.Addr
+
+	// ring goroutine only
+	lastMID uint16
+	byMID   map[uint16]*clientExchange
+	byToken map[string]*clientExchange
+	buf     []byte
+	recvID  uint64
+	closed  bool
+}
+
+// clientExchange is a request waiting for its response.
+type clientExchange struct {
+	msg         []byte
+	mid         uint16
+	token       string
+	confirmable bool
+	timeout     time.Duration
+	retransmits int
+	retransmit  *uringnet.Timer
+	deadline    *uringnet.Timer
+	done        chan clientResult
+}
+
+type clientResult struct {
+	m   *Message
+	err error
+}
+
+// Dial returns a client sending requests to the server at address, e.g. "device.local:5683".
+func Dial(address string) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	var sa unix.Sockaddr
+	family := unix.AF_INET
+	if ip4 := raddr.IP.To4(); ip4 != nil || raddr.IP == nil {
+		sa4 := &unix.SockaddrInet4{Port: raddr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family = unix.AF_INET6
+		sa6 := &unix.SockaddrInet6{Port: raddr.Port}
+		copy(sa6.Addr[:], raddr.IP)
+		sa = sa6
+	}
+	ring, err := sharedRing()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.IPPROTO_UDP)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Connect(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	c := &Client{
+		ACKTimeout:    DefaultACKTimeout,
+		MaxRetransmit: DefaultMaxRetransmit,
+		Timeout:       DefaultTimeout,
+		BlockSize:     DefaultBlockSize,
+		ring:          ring,
+		fd:            fd,
+		remoteAddr:    raddr,
+		lastMID:       uint16(rand.Uint32()),
+		byMID:         make(map[uint16]*clientExchange),
+		byToken:       make(map[string]*clientExchange),
+		buf:           make([]byte, maxDatagram),
+	}
+	if err = ring.Trigger(c.receive); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return c, nil
+}
+
+// RemoteAddr returns the address of the server.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Close closes the client, the pending requests fail with ErrClosed.
+func (c *Client) Close() error {
+	return c.ring.Trigger(func() {
+		if c.closed {
+			return
+		}
+		c.closed = true
+		for _, ex := range c.byToken {
+			c.finish(ex, clientResult{err: ErrClosed})
+		}
+		// the socket is closed once the pending receive has been canceled.
+		c.ring.Cancel(c.recvID)
+	})
+}
+
+// Get fetches the resource at path.
+func (c *Client) Get(path string) (*Message, error) {
+	return c.Do(c.NewRequest(GET, path, nil))
+}
+
+// Post sends payload to the resource at path, format is its content format.
+func (c *Client) Post(path string, format uint32, payload []byte) (*Message, error) {
+	req := c.NewRequest(POST, path, payload)
+	req.SetUint(ContentFormat, format)
+	return c.Do(req)
+}
+
+// Put stores payload at path, format is its content format.
+func (c *Client) Put(path string, format uint32, payload []byte) (*Message, error) {
+	req := c.NewRequest(PUT, path, payload)
+	req.SetUint(ContentFormat, format)
+	return c.Do(req)
+}
+
+// Delete deletes the resource at path.
+func (c *Client) Delete(path string) (*Message, error) {
+	return c.Do(c.NewRequest(DELETE, path, nil))
+}
+
+// NewRequest returns a confirmable request for path, the query follows a "?" in path.
+func (c *Client) NewRequest(method Code, path string, payload []byte) *Message {
+	req := &Message{Type: Confirmable, Code: method, Payload: payload}
+	path, query, _ := strings.Cut(path, "?")
+	req.SetPath(path)
+	if query != "" {
+		for _, q := range strings.Split(query, "&") {
+			req.AddOption(URIQuery, []byte(q))
+		}
+	}
+	return req
+}
+
+// Do sends the request and returns the response. The message ID and token of req are set by
+// the client. A payload larger than the block size is sent in Block1 blocks, and a response
+// sent in Block2 blocks is fetched block by block and returned with its whole payload.
+func (c *Client) Do(req *Message) (*Message, error) {
+	var resp *Message
+	var err error
+	szx := szxFor(c.BlockSize)
+	if len(req.Payload) <= 1<<(szx+4) {
+		if resp, err = c.roundTrip(req); err != nil {
+			return nil, err
+		}
+	} else {
+		if resp, err = c.upload(req, szx); err != nil {
+			return nil, err
+		}
+	}
+
+	b2, ok := resp.Block(Block2)
+	if !ok || !b2.More {
+		return resp, nil
+	}
+	body := append([]byte(nil), resp.Payload...)
+	for b2.More {
+		m := cloneRequest(req)
+		m.Payload = nil
+		m.RemoveOption(Block1)
+		m.SetBlock(Block2, Block{Num: b2.Num + 1, SZX: b2.SZX})
+		next, err := c.roundTrip(m)
+		if err != nil {
+			return nil, err
+		}
+		if next.Code.Class() != 2 {
+			return next, nil
+		}
+		if b2, ok = next.Block(Block2); !ok || b2.Offset() != len(body) {
+			return nil, errBlock
+		}
+		body = append(body, next.Payload...)
+	}
+	resp.Payload = body
+	resp.RemoveOption(Block2)
+	return resp, nil
+}
+
+// upload sends the payload of req in Block1 blocks, it returns the response to the last block.
+func (c *Client) upload(req *Message, szx uint8) (*Message, error) {
+	body := req.Payload
+	for off := 0; ; {
+		size := 1 << (szx + 4)
+		end := off + size
+		if end > len(body) {
+			end = len(body)
+		}
+		b := Block{Num: uint32(off / size), More: end < len(body), SZX: szx}
+		m := cloneRequest(req)
+		m.Payload = body[off:end]
+		m.SetBlock(Block1, b)
+		if off == 0 {
+			m.SetUint(Size1, uint32(len(body)))
+		}
+		resp, err := c.roundTrip(m)
+		if err != nil {
+			return nil, err
+		}
+		if !b.More || resp.Code != Continue {
+			return resp, nil
+		}
+		// the server may ask for smaller blocks.
+		if ack, ok := resp.Block(Block1); ok && ack.SZX < szx {
+			szx = ack.SZX
+		}
+		off = end
+	}
+}
+
+func cloneRequest(req *Message) *Message {
+	m := *req
+	m.Options = append([]Option(nil), req.Options...)
+	return &m
+}
+
+// roundTrip sends a single message and waits for its response.
+func (c *Client) roundTrip(req *Message) (*Message, error) {
+	m := *req
+	ex := &clientExchange{done: make(chan clientResult, 1), confirmable: m.Type == Confirmable}
+	if err := c.ring.Trigger(func() { c.start(ex, &m) }); err != nil {
+		return nil, err
+	}
+	r := <-ex.done
+	return r.m, r.err
+}
+
+// start sends the message of ex, it runs on the ring goroutine.
+func (c *Client) start(ex *clientExchange, m *Message) {
+	if c.closed {
+		ex.done <- clientResult{err: ErrClosed}
+		return
+	}
+	for {
+		c.lastMID++
+		if c.byMID[c.lastMID] == nil {
+			break
+		}
+	}
+	var token [4]byte
+	for {
+		_, _ = crand.Read(token[:])
+		if c.byToken[string(token[:])] == nil {
+			break
+		}
+	}
+	m.MessageID, m.Token = c.lastMID, token[:]
+	ex.mid, ex.token = m.MessageID, string(m.Token)
+	ex.msg = AppendMessage(nil, m)
+	c.byMID[ex.mid] = ex
+	c.byToken[ex.token] = ex
+
+	c.send(ex.msg)
+	if ex.confirmable {
+		// the initial timeout is randomized between ACKTimeout and 1.5 times it (section 4.2).
+		ex.timeout = c.ACKTimeout + time.Duration(rand.Int63n(int64(c.ACKTimeout)/2+1))
+		ex.retransmit = c.ring.AfterFunc(ex.timeout, func() { c.retransmit(ex) })
+	}
+	ex.deadline = c.ring.AfterFunc(c.Timeout, func() {
+		c.finish(ex, clientResult{err: ErrTimeout})
+	})
+}
+
+// retransmit sends the message of ex again and doubles its timeout, until the maximum number of retransmissions.
+func (c *Client) retransmit(ex *clientExchange) {
+	ex.retransmit = nil
+	if c.byMID[ex.mid] != ex {
+		return
+	}
+	if ex.retransmits == c.MaxRetransmit {
+		c.finish(ex, clientResult{err: ErrTimeout})
+		return
+	}
+	ex.retransmits++
+	ex.timeout *= 2
+	c.send(ex.msg)
+	ex.retransmit = c.ring.AfterFunc(ex.timeout, func() { c.retransmit(ex) })
+}
+
+// finish completes ex with r, it runs on the ring goroutine.
+func (c *Client) finish(ex *clientExchange, r clientResult) {
+	if c.byToken[ex.token] != ex {
+		return
+	}
+	delete(c.byToken, ex.token)
+	if c.byMID[ex.mid] == ex {
+		delete(c.byMID, ex.mid)
+	}
+	if ex.retransmit != nil {
+		ex.retransmit.Stop()
+	}
+	if ex.deadline != nil {
+		ex.deadline.Stop()
+	}
+	ex.done <- r
+}
+
+func (c *Client) send(b []byte) {
+	c.ring.Submit(func(sqe *uring.SQEntry) {
+		uring.Send(sqe, uintptr(c.fd), b, 0)
+	}, func(int32, uint32) {})
+}
+
+// receive arms the receive of the next datagram from the server.
+func (c *Client) receive() {
+	c.recvID = c.ring.Submit(func(sqe *uring.SQEntry) {
+		uring.Recv(sqe, uintptr(c.fd), c.buf, 0)
+	}, func(res int32, _ uint32) {
+		if c.closed {
+			_ = unix.Close(c.fd)
+			return
+		}
+		if res >= 0 {
+			c.handle(c.buf[:res])
+		}
+		c.receive()
+	})
+}
+
+// handle matches a datagram received from the server with the pending requests.
+func (c *Client) handle(data []byte) {
+	m, err := ParseMessage(data)
+	if err != nil {
+		return
+	}
+	switch m.Type {
+	case Acknowledgement, Reset:
+		ex := c.byMID[m.MessageID]
+		if ex == nil {
+			return
+		}
+		if m.Type == Reset {
+			c.finish(ex, clientResult{err: ErrReset})
+			return
+		}
+		// the request has been received, it is not retransmitted anymore.
+		delete(c.byMID, ex.mid)
+		if ex.retransmit != nil {
+			ex.retransmit.Stop()
+			ex.retransmit = nil
+		}
+		if m.Code != Empty && string(m.Token) == ex.token {
+			c.finish(ex, clientResult{m: copyMessage(data)})
+		}
+	default:
+		// a separate response, a confirmable one is acknowledged.
+		ex := c.byToken[string(m.Token)]
+		if m.Type == Confirmable {
+			reply := &Message{Type: Acknowledgement, MessageID: m.MessageID}
+			if ex == nil {
+				reply.Type = Reset
+			}
+			c.send(AppendMessage(nil, reply))
+		}
+		if ex != nil && m.Code.Class() >= 2 {
+			c.finish(ex, clientResult{m: copyMessage(data)})
+		}
+	}
+}
+
+// copyMessage parses a copy of the datagram, which is overwritten by the next receive.
+func copyMessage(data []byte) *Message {
+	m, _ := ParseMessage(append([]byte(nil), data...))
+	return m
+}
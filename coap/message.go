@@ -0,0 +1,358 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a message (RFC 7252, section 4.2).
+type Type uint8
+
+const (
+	Confirmable     Type = 0
+	NonConfirmable  Type = 1
+	Acknowledgement Type = 2
+	Reset           Type = 3
+)
+
+var typeNames = [...]string{"CON", "NON", "ACK", "RST"}
+
+func (t Type) String() string {
+	return typeNames[t&3]
+}
+
+// Code is the request method or the response code of a message, its class is in the 3 high bits.
+type Code uint8
+
+// Method codes.
+const (
+	Empty  Code = 0
+	GET    Code = 1
+	POST   Code = 2
+	PUT    Code = 3
+	DELETE Code = 4
+)
+
+// Response codes.
+const (
+	Created                  Code = 2<<5 | 1
+	Deleted                  Code = 2<<5 | 2
+	Valid                    Code = 2<<5 | 3
+	Changed                  Code = 2<<5 | 4
+	Content                  Code = 2<<5 | 5
+	Continue                 Code = 2<<5 | 31
+	BadRequest               Code = 4<<5 | 0
+	Unauthorized             Code = 4<<5 | 1
+	BadOption                Code = 4<<5 | 2
+	Forbidden                Code = 4<<5 | 3
+	NotFound                 Code = 4<<5 | 4
+	MethodNotAllowed         Code = 4<<5 | 5
+	NotAcceptable            Code = 4<<5 | 6
+	RequestEntityIncomplete  Code = 4<<5 | 8
+	PreconditionFailed       Code = 4<<5 | 12
+	RequestEntityTooLarge    Code = 4<<5 | 13
+	UnsupportedContentFormat Code = 4<<5 | 15
+	InternalServerError      Code = 5<<5 | 0
+	NotImplemented           Code = 5<<5 | 1
+	ServiceUnavailable       Code = 5<<5 | 3
+)
+
+// Class returns the class of c, 0 for requests, 2 to 5 for responses.
+func (c Code) Class() uint8 {
+	return uint8(c) >> 5
+}
+
+// IsRequest reports whether c is a request method.
+func (c Code) IsRequest() bool {
+	return c.Class() == 0 && c != Empty
+}
+
+// String returns c in the "c.dd" notation, e.g. "2.05".
+func (c Code) String() string {
+	detail := uint8(c) & 0x1f
+	s := strconv.Itoa(int(c.Class())) + "."
+	if detail < 10 {
+		s += "0"
+	}
+	return s + strconv.Itoa(int(detail))
+}
+
+// OptionID is the number of an option.
+type OptionID uint16
+
+const (
+	IfMatch       OptionID = 1
+	URIHost       OptionID = 3
+	ETag          OptionID = 4
+	IfNoneMatch   OptionID = 5
+	Observe       OptionID = 6
+	URIPort       OptionID = 7
+	LocationPath  OptionID = 8
+	URIPath       OptionID = 11
+	ContentFormat OptionID = 12
+	MaxAge        OptionID = 14
+	URIQuery      OptionID = 15
+	Accept        OptionID = 17
+	LocationQuery OptionID = 20
+	Block2        OptionID = 23
+	Block1        OptionID = 27
+	Size2         OptionID = 28
+	ProxyURI      OptionID = 35
+	ProxyScheme   OptionID = 39
+	Size1         OptionID = 60
+)
+
+// Critical reports whether an endpoint must reject a message carrying the option if it does not know it.
+func (id OptionID) Critical() bool {
+	return id&1 != 0
+}
+
+// Content formats.
+const (
+	TextPlain     uint32 = 0
+	AppLinkFormat uint32 = 40
+	AppXML        uint32 = 41
+	AppOctets     uint32 = 42
+	AppJSON       uint32 = 50
+	AppCBOR       uint32 = 60
+)
+
+// Option is an option of a message.
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+// Message is a CoAP message.
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option // kept in the order of their numbers when encoded
+	Payload   []byte
+}
+
+const (
+	version       = 1
+	payloadMarker = 0xff
+	maxTokenLen   = 8
+)
+
+// ErrFormat is returned by ParseMessage for a malformed message.
+var ErrFormat = errors.New("coap: message format error")
+
+// ParseMessage parses the message in the datagram buf. The token, options and payload refer to buf.
+func ParseMessage(buf []byte) (*Message, error) {
+	if len(buf) < 4 || buf[0]>>6 != version {
+		return nil, ErrFormat
+	}
+	m := &Message{
+		Type:      Type(buf[0] >> 4 & 3),
+		Code:      Code(buf[1]),
+		MessageID: binary.BigEndian.Uint16(buf[2:]),
+	}
+	tkl := int(buf[0] & 0x0f)
+	if tkl > maxTokenLen || len(buf) < 4+tkl {
+		return nil, ErrFormat
+	}
+	if tkl > 0 {
+		m.Token = buf[4 : 4+tkl]
+	}
+	b := buf[4+tkl:]
+	if m.Code == Empty && (tkl > 0 || len(b) > 0) {
+		return nil, ErrFormat
+	}
+	id := 0
+	for len(b) > 0 {
+		if b[0] == payloadMarker {
+			if len(b) == 1 {
+				// a payload marker followed by a zero-length payload is a format error.
+				return nil, ErrFormat
+			}
+			m.Payload = b[1:]
+			break
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0x0f)
+		b = b[1:]
+		var err error
+		if delta, b, err = optionNibble(delta, b); err != nil {
+			return nil, err
+		}
+		if length, b, err = optionNibble(length, b); err != nil {
+			return nil, err
+		}
+		if len(b) < length {
+			return nil, ErrFormat
+		}
+		id += delta
+		if id > 0xffff {
+			return nil, ErrFormat
+		}
+		m.Options = append(m.Options, Option{OptionID(id), b[:length:length]})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+// optionNibble decodes the extended option delta or length announced by n.
+func optionNibble(n int, b []byte) (int, []byte, error) {
+	switch n {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, ErrFormat
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, ErrFormat
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, ErrFormat
+	}
+	return n, b, nil
+}
+
+// AppendMessage appends the encoding of m to dst.
+func AppendMessage(dst []byte, m *Message) []byte {
+	dst = append(dst, version<<6|byte(m.Type)<<4|byte(len(m.Token)), byte(m.Code))
+	dst = binary.BigEndian.AppendUint16(dst, m.MessageID)
+	dst = append(dst, m.Token...)
+
+	opts := m.Options
+	if !sort.SliceIsSorted(opts, func(i, j int) bool { return opts[i].ID < opts[j].ID }) {
+		opts = append([]Option(nil), opts...)
+		sort.SliceStable(opts, func(i, j int) bool { return opts[i].ID < opts[j].ID })
+	}
+	prev := 0
+	for _, o := range opts {
+		delta, length := int(o.ID)-prev, len(o.Value)
+		prev = int(o.ID)
+		i := len(dst)
+		dst = append(dst, 0)
+		var d, l byte
+		d, dst = appendNibble(dst, delta)
+		l, dst = appendNibble(dst, length)
+		dst[i] = d<<4 | l
+		dst = append(dst, o.Value...)
+	}
+	if len(m.Payload) > 0 {
+		dst = append(dst, payloadMarker)
+		dst = append(dst, m.Payload...)
+	}
+	return dst
+}
+
+// appendNibble returns the nibble encoding n and appends its extended bytes.
+func appendNibble(dst []byte, n int) (byte, []byte) {
+	switch {
+	case n < 13:
+		return byte(n), dst
+	case n < 269:
+		return 13, append(dst, byte(n-13))
+	}
+	return 14, binary.BigEndian.AppendUint16(dst, uint16(n-269))
+}
+
+// Option returns the value of the first option id.
+func (m *Message) Option(id OptionID) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.ID == id {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Uint returns the value of the first option id as an unsigned integer.
+func (m *Message) Uint(id OptionID) (uint32, bool) {
+	v, ok := m.Option(id)
+	if !ok || len(v) > 4 {
+		return 0, false
+	}
+	var n uint32
+	for _, b := range v {
+		n = n<<8 | uint32(b)
+	}
+	return n, true
+}
+
+// AddOption appends an option, repeatable options like URIPath are added in order.
+func (m *Message) AddOption(id OptionID, value []byte) {
+	m.Options = append(m.Options, Option{id, value})
+}
+
+// SetOption replaces the options id by a single one.
+func (m *Message) SetOption(id OptionID, value []byte) {
+	m.RemoveOption(id)
+	m.AddOption(id, value)
+}
+
+// SetUint replaces the options id by a single one holding n with the minimal number of bytes.
+func (m *Message) SetUint(id OptionID, n uint32) {
+	m.SetOption(id, appendUint(nil, n))
+}
+
+// RemoveOption removes the options id.
+func (m *Message) RemoveOption(id OptionID) {
+	opts := m.Options[:0]
+	for _, o := range m.Options {
+		if o.ID != id {
+			opts = append(opts, o)
+		}
+	}
+	m.Options = opts
+}
+
+// Path returns the URI path of a request, without a leading slash.
+func (m *Message) Path() string {
+	var sb strings.Builder
+	for _, o := range m.Options {
+		if o.ID == URIPath {
+			if sb.Len() > 0 {
+				sb.WriteByte('/')
+			}
+			sb.Write(o.Value)
+		}
+	}
+	return sb.String()
+}
+
+// SetPath replaces the URI path of a request by the segments of path.
+func (m *Message) SetPath(path string) {
+	m.RemoveOption(URIPath)
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		if seg != "" {
+			m.AddOption(URIPath, []byte(seg))
+		}
+	}
+}
+
+// Queries returns the URI query arguments of a request, e.g. "ver=2".
+func (m *Message) Queries() []string {
+	var q []string
+	for _, o := range m.Options {
+		if o.ID == URIQuery {
+			q = append(q, string(o.Value))
+		}
+	}
+	return q
+}
+
+func appendUint(dst []byte, n uint32) []byte {
+	switch {
+	case n == 0:
+		return dst
+	case n < 1<<8:
+		return append(dst, byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(dst, uint16(n))
+	case n < 1<<24:
+		return append(dst, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return binary.BigEndian.AppendUint32(dst, n)
+}
@@ -0,0 +1,106 @@
+package coap
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		Type:      Confirmable,
+		Code:      GET,
+		MessageID: 0x7d34,
+		Token:     []byte{1, 2, 3, 4},
+		Payload:   []byte("hello"),
+	}
+	m.SetPath("/sensors/temp/")
+	m.AddOption(URIQuery, []byte("unit=c"))
+	m.SetUint(Accept, AppJSON)
+	m.AddOption(Size1, []byte{1, 2})
+	m.AddOption(OptionID(2000), bytes.Repeat([]byte("x"), 300))
+
+	b := AppendMessage(nil, m)
+	got, err := ParseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != m.Type || got.Code != m.Code || got.MessageID != m.MessageID || !bytes.Equal(got.Token, m.Token) ||
+		!bytes.Equal(got.Payload, m.Payload) {
+		t.Fatalf("expect %+v, got %+v", m, got)
+	}
+	if got.Path() != "sensors/temp" || !reflect.DeepEqual(got.Queries(), []string{"unit=c"}) {
+		t.Fatalf("unexpected path %q or queries %q", got.Path(), got.Queries())
+	}
+	if v, ok := got.Uint(Accept); !ok || v != AppJSON {
+		t.Fatalf("unexpected Accept %d", v)
+	}
+	if v, ok := got.Option(OptionID(2000)); !ok || len(v) != 300 {
+		t.Fatalf("unexpected extended option of %d bytes", len(v))
+	}
+	// the options are encoded in the order of their numbers.
+	for i := 1; i < len(got.Options); i++ {
+		if got.Options[i].ID < got.Options[i-1].ID {
+			t.Fatalf("options out of order: %v", got.Options)
+		}
+	}
+
+	// example of RFC 7252, appendix A: a GET with a Uri-Path option.
+	raw := []byte{0x40, 0x01, 0x7d, 0x34, 0xb4, 't', 'e', 'm', 'p'}
+	if b := AppendMessage(nil, &Message{Type: Confirmable, Code: GET, MessageID: 0x7d34, Options: []Option{{URIPath, []byte("temp")}}}); !bytes.Equal(b, raw) {
+		t.Fatalf("expect %x, got %x", raw, b)
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	cases := map[string][]byte{
+		"short":                 {0x40, 0x01, 0x00},
+		"version 2":             {0x80, 0x01, 0x00, 0x01},
+		"token length 9":        {0x49, 0x01, 0x00, 0x01, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		"truncated token":       {0x42, 0x01, 0x00, 0x01, 1},
+		"empty with token":      {0x41, 0x00, 0x00, 0x01, 1},
+		"marker without body":   {0x40, 0x01, 0x00, 0x01, 0xff},
+		"reserved delta":        {0x40, 0x01, 0x00, 0x01, 0xf1, 0},
+		"reserved length":       {0x40, 0x01, 0x00, 0x01, 0x1f},
+		"truncated option":      {0x40, 0x01, 0x00, 0x01, 0xb4, 't'},
+		"truncated extension":   {0x40, 0x01, 0x00, 0x01, 0xd0},
+		"option number too big": {0x40, 0x01, 0x00, 0x01, 0xe0, 0xff, 0xff, 0xe0, 0xff, 0xff},
+	}
+	for name, raw := range cases {
+		if _, err := ParseMessage(raw); err != ErrFormat {
+			t.Errorf("%s: expect ErrFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestBlock(t *testing.T) {
+	m := &Message{}
+	for _, b := range []Block{{0, true, 6}, {1, false, 0}, {1<<20 - 1, true, 2}} {
+		m.SetBlock(Block2, b)
+		got, ok := m.Block(Block2)
+		if !ok || got != b {
+			t.Fatalf("expect %+v, got %+v", b, got)
+		}
+	}
+	m.SetUint(Block1, 7)
+	if _, ok := m.Block(Block1); ok {
+		t.Fatal("expect SZX 7 to be rejected")
+	}
+	if b := (Block{Num: 3, SZX: 2}); b.Size() != 64 || b.Offset() != 192 {
+		t.Fatalf("unexpected size %d or offset %d", b.Size(), b.Offset())
+	}
+	for size, szx := range map[int]uint8{1024: 6, 4096: 6, 1000: 5, 16: 0, 1: 0, 64: 2} {
+		if got := szxFor(size); got != szx {
+			t.Errorf("szxFor(%d) = %d, expect %d", size, got, szx)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	if Content.String() != "2.05" || Continue.String() != "2.31" || NotFound.String() != "4.04" {
+		t.Fatalf("unexpected codes %v %v %v", Content, Continue, NotFound)
+	}
+	if !GET.IsRequest() || Empty.IsRequest() || Content.IsRequest() {
+		t.Fatal("unexpected request codes")
+	}
+}
@@ -0,0 +1,440 @@
+//go:build linux
+
+// Package coap implements the Constrained Application Protocol (RFC 7252) over UDP, with
+// block-wise transfers (RFC 7959), on UringNet rings.
+//
+// The server answers confirmable requests with piggybacked acknowledgements and deduplicates
+// the requests retransmitted by the clients. Request bodies sent in Block1 blocks are reassembled
+// before reaching the handler, and responses larger than a block are split into Block2 blocks:
+//
+//	mux := coap.NewServeMux()
+//	mux.HandleFunc("firmware", func(w *coap.Message, r *coap.Request) {
+//		w.SetUint(coap.ContentFormat, coap.AppOctets)
+//		w.Payload = image
+//	})
+//	log.Fatal((&coap.Server{Addr: ":5683", Handler: mux}).ListenAndServe())
+//
+// The Client retransmits confirmable requests with an exponential backoff driven by the timeouts
+// of a ring, and fetches or sends large bodies block by block.
+package coap
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks of the responses split by the server, and of
+	// the request bodies split by the client.
+	DefaultBlockSize = 1024
+
+	// DefaultMaxBodyBytes is the maximum size of a request body reassembled from Block1 blocks.
+	DefaultMaxBodyBytes = 8 << 20 // 8 MB
+
+	// exchangeLifetime is how long a message ID is remembered to detect duplicates (section 4.8.2).
+	exchangeLifetime = 247 * time.Second
+
+	ringSize    = 1024
+	ringBuffers = 16
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method     Code
+	Path       string
+	Message    *Message // the last message of the request, it refers to the receive buffer of the ring
+	Payload    []byte   // the body of the request, reassembled if it was sent in blocks
+	RemoteAddr net.Addr
+}
+
+// Handler responds to a request by filling resp, whose code is Content by default.
+// The server sets the type, message ID and token of resp, and splits its payload into blocks if needed.
+type Handler interface {
+	ServeCoAP(resp *Message, req *Request)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as handlers.
+type HandlerFunc func(resp *Message, req *Request)
+
+// ServeCoAP calls f(resp, req).
+func (f HandlerFunc) ServeCoAP(resp *Message, req *Request) {
+	f(resp, req)
+}
+
+// ServeMux dispatches requests to the handler registered for their path.
+type ServeMux struct {
+	handlers map[string]Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for path, the leading and trailing slashes are ignored.
+func (mux *ServeMux) Handle(path string, handler Handler) {
+	mux.handlers[strings.Trim(path, "/")] = handler
+}
+
+// HandleFunc registers the handler function for path.
+func (mux *ServeMux) HandleFunc(path string, handler func(resp *Message, req *Request)) {
+	mux.Handle(path, HandlerFunc(handler))
+}
+
+// ServeCoAP dispatches the request, it answers NotFound if no handler is registered for its path.
+func (mux *ServeMux) ServeCoAP(resp *Message, req *Request) {
+	h, ok := mux.handlers[req.Path]
+	if !ok {
+		resp.Code = NotFound
+		return
+	}
+	h.ServeCoAP(resp, req)
+}
+
+// A Server defines parameters for running a CoAP server on UringNet.
+type Server struct {
+	// Addr specifies the UDP address for the server to listen on, ":5683" if empty.
+	Addr string
+
+	// Handler to invoke for every request.
+	Handler Handler
+
+	// BlockSize is the maximum size of the blocks of the responses, DefaultBlockSize is used if it is zero.
+	// It is rounded down to a power of two between 16 and 1024.
+	BlockSize int
+
+	// MaxBodyBytes limits the size of the request bodies sent in blocks, DefaultMaxBodyBytes is used if it is zero.
+	MaxBodyBytes int
+
+	// Rings is the number of io_uring instances receiving datagrams, runtime.NumCPU() if zero.
+	Rings int
+
+	// ErrorLog specifies an optional logger for the errors of the rings and for panics in handlers, it is
+	// used when Logger is nil.
+	ErrorLog *log.Logger
+
+	// Logger logs the events of the rings and the panics in handlers, with the fields of the connection.
+	// ErrorLog is used when it is nil, logging.Default when both are.
+	Logger logging.Logger
+
+	lastMID uint32 // message ID of the last non-confirmable response, accessed atomically
+
+	// the datagrams of a client may be received by any ring, the state of the exchanges is shared.
+	mu        sync.Mutex
+	exchanges map[exchangeKey]*exchange
+	expiries  []exchangeKey // in the order the exchanges expire
+	uploads   map[uploadKey]*upload
+}
+
+// exchangeKey identifies a message received from an endpoint.
+type exchangeKey struct {
+	addr string
+	mid  uint16
+}
+
+// exchange is the response to a message, it is sent again if the message is duplicated.
+type exchange struct {
+	resp    []byte // nil while the request is being served
+	expires time.Time
+}
+
+// uploadKey identifies the request body a client is sending in Block1 blocks.
+type uploadKey struct {
+	addr string
+	path string
+}
+
+type upload struct {
+	body     []byte
+	lastSeen time.Time
+}
+
+// ListenAndServe listens on srv.Addr and serves requests on the rings. It only returns on setup errors.
+func (srv *Server) ListenAndServe() error {
+	if srv.Handler == nil {
+		return errors.New("coap: nil Handler")
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":5683"
+	}
+	rings := srv.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	srv.exchanges = make(map[exchangeKey]*exchange)
+	srv.uploads = make(map[uploadKey]*upload)
+	srv.lastMID = rand.Uint32()
+
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Udp, Address: addr}, ringSize, false, rings, socket.SocketOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Logger = srv.logger()
+		ringNet.Handler = &ringHandler{srv: srv}
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	loop.RunMany()
+	select {}
+}
+
+func (srv *Server) blockSZX() uint8 {
+	if srv.BlockSize > 0 {
+		return szxFor(srv.BlockSize)
+	}
+	return szxFor(DefaultBlockSize)
+}
+
+func (srv *Server) maxBodyBytes() int {
+	if srv.MaxBodyBytes > 0 {
+		return srv.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// logger returns Logger, or ErrorLog for errors, or the default logger.
+func (srv *Server) logger() logging.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	if srv.ErrorLog != nil {
+		return logging.NewStd(srv.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// begin records the exchange of key, it returns false with the response to send again if the
+// message is a duplicate. The response is nil if the original message is still being served.
+func (srv *Server) begin(key exchangeKey) ([]byte, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for ; n < len(srv.expiries); n++ {
+		k := srv.expiries[n]
+		if ex := srv.exchanges[k]; ex != nil && now.Before(ex.expires) {
+			break
+		}
+		delete(srv.exchanges, k)
+	}
+	srv.expiries = srv.expiries[:copy(srv.expiries, srv.expiries[n:])]
+
+	if ex, ok := srv.exchanges[key]; ok {
+		return ex.resp, false
+	}
+	srv.exchanges[key] = &exchange{expires: now.Add(exchangeLifetime)}
+	srv.expiries = append(srv.expiries, key)
+	return nil, true
+}
+
+// end stores the response of the exchange of key.
+func (srv *Server) end(key exchangeKey, resp []byte) {
+	srv.mu.Lock()
+	if ex := srv.exchanges[key]; ex != nil {
+		ex.resp = resp
+	}
+	srv.mu.Unlock()
+}
+
+// receiveBlock adds a Block1 block to the body being uploaded by a client. It returns the complete
+// body once the last block has been received, or the code of the error response.
+func (srv *Server) receiveBlock(key uploadKey, b Block, data []byte) (body []byte, code Code) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	now := time.Now()
+	u := srv.uploads[key]
+	if b.Num == 0 {
+		// the uploads abandoned by the clients are dropped when a new one starts.
+		for k, u := range srv.uploads {
+			if now.Sub(u.lastSeen) > exchangeLifetime {
+				delete(srv.uploads, k)
+			}
+		}
+		u = &upload{}
+		srv.uploads[key] = u
+	}
+	if u == nil || b.Offset() != len(u.body) || b.More && len(data) != b.Size() {
+		delete(srv.uploads, key)
+		return nil, RequestEntityIncomplete
+	}
+	if len(u.body)+len(data) > srv.maxBodyBytes() {
+		delete(srv.uploads, key)
+		return nil, RequestEntityTooLarge
+	}
+	u.body = append(u.body, data...)
+	u.lastSeen = now
+	if b.More {
+		return nil, 0
+	}
+	delete(srv.uploads, key)
+	return u.body, 0
+}
+
+// serve handles a request and returns the response.
+func (srv *Server) serve(m *Message, addr string, from unix.Sockaddr) *Message {
+	resp := &Message{Type: Acknowledgement, Code: Content, MessageID: m.MessageID, Token: m.Token}
+	if m.Type == NonConfirmable {
+		resp.Type, resp.MessageID = NonConfirmable, uint16(atomic.AddUint32(&srv.lastMID, 1))
+	}
+	for _, o := range m.Options {
+		if o.ID.Critical() && !knownOption(o.ID) {
+			resp.Code = BadOption
+			return resp
+		}
+	}
+
+	payload := m.Payload
+	b1, upload := m.Block(Block1)
+	if upload {
+		body, code := srv.receiveBlock(uploadKey{addr, m.Path()}, b1, m.Payload)
+		if code != 0 {
+			resp.Code = code
+			if code == RequestEntityTooLarge {
+				resp.SetUint(Size1, uint32(srv.maxBodyBytes()))
+			}
+			return resp
+		}
+		if body == nil {
+			// ask for the next block, with a smaller size if the server prefers it.
+			szx := b1.SZX
+			if srv.blockSZX() < szx {
+				szx = srv.blockSZX()
+			}
+			resp.Code = Continue
+			resp.SetBlock(Block1, Block{Num: b1.Num, More: true, SZX: szx})
+			return resp
+		}
+		payload = body
+	}
+
+	req := &Request{Method: m.Code, Path: m.Path(), Message: m, Payload: payload, RemoteAddr: socket.SockaddrToUDPAddr(from)}
+	srv.invoke(resp, req)
+	resp.Type, resp.Token = Acknowledgement, m.Token
+	if m.Type == NonConfirmable {
+		resp.Type = NonConfirmable
+	}
+	if upload {
+		resp.SetBlock(Block1, Block{Num: b1.Num, SZX: b1.SZX})
+	}
+
+	// split the response into blocks.
+	szx := srv.blockSZX()
+	var num uint32
+	b2, ok := m.Block(Block2)
+	if ok {
+		if b2.SZX < szx {
+			szx = b2.SZX
+		}
+		num = uint32(b2.Offset() >> (szx + 4))
+	}
+	size := 1 << (szx + 4)
+	if !ok && len(resp.Payload) <= size {
+		return resp
+	}
+	off := int(num) * size
+	if off >= len(resp.Payload) && off > 0 {
+		return &Message{Type: resp.Type, Code: BadOption, MessageID: resp.MessageID, Token: resp.Token}
+	}
+	end := off + size
+	if end > len(resp.Payload) {
+		end = len(resp.Payload)
+	}
+	resp.SetBlock(Block2, Block{Num: num, More: end < len(resp.Payload), SZX: szx})
+	if num == 0 {
+		resp.SetUint(Size2, uint32(len(resp.Payload)))
+	}
+	resp.Payload = resp.Payload[off:end]
+	return resp
+}
+
+// invoke calls the handler and recovers from its panics, which must not bring the ring down.
+func (srv *Server) invoke(resp *Message, req *Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			srv.logger().Log(logging.LevelError, "panic serving a request", "remote", req.RemoteAddr,
+				"method", req.Method, "path", req.Path, "err", err, "stack", string(buf))
+			*resp = Message{Code: InternalServerError, MessageID: resp.MessageID}
+		}
+	}()
+	srv.Handler.ServeCoAP(resp, req)
+}
+
+// knownOption reports whether the server understands the critical option id.
+func knownOption(id OptionID) bool {
+	switch id {
+	case IfMatch, URIHost, IfNoneMatch, URIPort, URIPath, URIQuery, Accept, Block2, Block1, Size1:
+		return true
+	}
+	return false
+}
+
+// ringHandler serves the datagrams received by one ring, its callbacks are only invoked by the goroutine running the ring.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	srv *Server
+}
+
+func (h *ringHandler) OnDatagram(ring *uringnet.URingNet, data []byte, from unix.Sockaddr) {
+	m, err := ParseMessage(data)
+	if err != nil {
+		// a confirmable message which cannot be parsed is rejected (section 4.2).
+		if len(data) >= 4 && data[0]>>6 == version && Type(data[0]>>4&3) == Confirmable {
+			reset := &Message{Type: Reset, MessageID: uint16(data[2])<<8 | uint16(data[3])}
+			ring.SendTo(ring.SocketFd, AppendMessage(nil, reset), from, nil)
+		}
+		return
+	}
+	if m.Type == Acknowledgement || m.Type == Reset {
+		// the server does not send confirmable messages.
+		return
+	}
+	if !m.Code.IsRequest() {
+		// an empty confirmable message is a ping, it is answered with a reset.
+		if m.Type == Confirmable {
+			ring.SendTo(ring.SocketFd, AppendMessage(nil, &Message{Type: Reset, MessageID: m.MessageID}), from, nil)
+		}
+		return
+	}
+
+	addr := addrKey(from)
+	key := exchangeKey{addr, m.MessageID}
+	if resp, first := h.srv.begin(key); !first {
+		if resp != nil {
+			ring.SendTo(ring.SocketFd, resp, from, nil)
+		}
+		return
+	}
+	resp := AppendMessage(nil, h.srv.serve(m, addr, from))
+	h.srv.end(key, resp)
+	ring.SendTo(ring.SocketFd, resp, from, nil)
+}
+
+// addrKey returns a string identifying the endpoint sa.
+func addrKey(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return string(sa.Addr[:]) + string([]byte{byte(sa.Port >> 8), byte(sa.Port)})
+	case *unix.SockaddrInet6:
+		return string(sa.Addr[:]) + string([]byte{byte(sa.Port >> 8), byte(sa.Port)})
+	}
+	return ""
+}
@@ -0,0 +1,359 @@
+//go:build linux
+
+package coap
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAddr = "127.0.0.1:18934"
+
+var (
+	startOnce sync.Once
+	firmware  = bytes.Repeat([]byte("0123456789abcdef"), 321) // 5136 bytes, 6 blocks of 1024
+	counter   int64
+)
+
+func startServer(t *testing.T) {
+	t.Helper()
+	startOnce.Do(func() {
+		mux := NewServeMux()
+		mux.HandleFunc("hello", func(w *Message, r *Request) {
+			w.SetUint(ContentFormat, TextPlain)
+			w.Payload = []byte("hello " + r.RemoteAddr.Network())
+		})
+		mux.HandleFunc("firmware", func(w *Message, r *Request) {
+			w.SetUint(ContentFormat, AppOctets)
+			w.Payload = firmware
+		})
+		mux.HandleFunc("upload", func(w *Message, r *Request) {
+			if r.Method != PUT {
+				w.Code = MethodNotAllowed
+				return
+			}
+			w.Code = Changed
+			w.Payload = []byte(strconv.Itoa(len(r.Payload)))
+			if !bytes.Equal(r.Payload, firmware) {
+				w.Code = BadRequest
+			}
+		})
+		mux.HandleFunc("counter", func(w *Message, r *Request) {
+			w.Payload = []byte(strconv.FormatInt(atomic.AddInt64(&counter, 1), 10))
+		})
+		mux.HandleFunc("panic", func(w *Message, r *Request) {
+			panic("boom")
+		})
+		srv := &Server{Addr: testAddr, Handler: mux, Rings: 2, ErrorLog: log.New(io.Discard, "", 0)}
+		go func() { t.Error(srv.ListenAndServe()) }()
+	})
+	// the server answers a ping with a reset once it runs.
+	conn := dialRaw(t)
+	defer conn.Close()
+	ping := AppendMessage(nil, &Message{Type: Confirmable, MessageID: 1})
+	for i := 0; i < 100; i++ {
+		_, _ = conn.Write(ping)
+		_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		var b [16]byte
+		if n, err := conn.Read(b[:]); err == nil {
+			if m, err := ParseMessage(b[:n]); err != nil || m.Type != Reset || m.MessageID != 1 {
+				t.Fatalf("expect a reset to the ping, got %+v", m)
+			}
+			return
+		}
+		// the read fails at once while the port is unreachable.
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+}
+
+func dialRaw(t *testing.T) *net.UDPConn {
+	t.Helper()
+	raddr, _ := net.ResolveUDPAddr("udp4", testAddr)
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// exchangeRaw sends m and returns the datagram answering it.
+func exchangeRaw(t *testing.T, conn *net.UDPConn, m *Message) *Message {
+	t.Helper()
+	if _, err := conn.Write(AppendMessage(nil, m)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 2048)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ParseMessage(b[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func dialServer(t *testing.T) *Client {
+	t.Helper()
+	startServer(t)
+	c, err := Dial(testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 5 * time.Second
+	return c
+}
+
+func TestGet(t *testing.T) {
+	c := dialServer(t)
+	defer c.Close()
+	resp, err := c.Get("/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != Acknowledgement || resp.Code != Content || string(resp.Payload) != "hello udp" {
+		t.Fatalf("unexpected response %v %v %q", resp.Type, resp.Code, resp.Payload)
+	}
+	if f, ok := resp.Uint(ContentFormat); !ok || f != TextPlain {
+		t.Fatalf("unexpected content format %d", f)
+	}
+
+	if resp, err = c.Get("missing"); err != nil || resp.Code != NotFound {
+		t.Fatalf("expect NotFound, got %v, %v", resp, err)
+	}
+	if resp, err = c.Get("panic"); err != nil || resp.Code != InternalServerError {
+		t.Fatalf("expect InternalServerError, got %v, %v", resp, err)
+	}
+
+	req := c.NewRequest(GET, "hello", nil)
+	req.Type = NonConfirmable
+	if resp, err = c.Do(req); err != nil || resp.Type != NonConfirmable || resp.Code != Content {
+		t.Fatalf("expect a non-confirmable response, got %+v, %v", resp, err)
+	}
+}
+
+func TestBlockwise(t *testing.T) {
+	c := dialServer(t)
+	defer c.Close()
+	resp, err := c.Get("firmware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != Content || !bytes.Equal(resp.Payload, firmware) {
+		t.Fatalf("expect the firmware, got %v with %d bytes", resp.Code, len(resp.Payload))
+	}
+
+	// a client asking for smaller blocks gets them.
+	conn := dialRaw(t)
+	defer conn.Close()
+	req := &Message{Type: Confirmable, Code: GET, MessageID: 100, Token: []byte{9}}
+	req.SetPath("firmware")
+	req.SetBlock(Block2, Block{Num: 3, SZX: 2})
+	raw := exchangeRaw(t, conn, req)
+	if b, ok := raw.Block(Block2); !ok || b != (Block{Num: 3, More: true, SZX: 2}) || !bytes.Equal(raw.Payload, firmware[192:256]) {
+		t.Fatalf("unexpected block %+v with %q", b, raw.Payload)
+	}
+	req.MessageID++
+	req.SetBlock(Block2, Block{Num: 1000, SZX: 2})
+	if raw = exchangeRaw(t, conn, req); raw.Code != BadOption {
+		t.Fatalf("expect BadOption for a block out of range, got %v", raw.Code)
+	}
+
+	c.BlockSize = 256
+	resp, err = c.Put("upload", AppOctets, firmware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != Changed || string(resp.Payload) != strconv.Itoa(len(firmware)) {
+		t.Fatalf("unexpected response %v %q", resp.Code, resp.Payload)
+	}
+
+	// a block received out of order is refused.
+	req = &Message{Type: Confirmable, Code: PUT, MessageID: 200, Payload: make([]byte, 16)}
+	req.SetPath("upload")
+	req.SetBlock(Block1, Block{Num: 2, More: true, SZX: 0})
+	if raw = exchangeRaw(t, conn, req); raw.Code != RequestEntityIncomplete {
+		t.Fatalf("expect RequestEntityIncomplete, got %v", raw.Code)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	startServer(t)
+	conn := dialRaw(t)
+	defer conn.Close()
+	req := &Message{Type: Confirmable, Code: GET, MessageID: 4242, Token: []byte{1, 2}}
+	req.SetPath("counter")
+	first := exchangeRaw(t, conn, req)
+	again := exchangeRaw(t, conn, req)
+	if first.MessageID != 4242 || !bytes.Equal(first.Payload, again.Payload) {
+		t.Fatalf("expect the duplicate to be answered with the same response, got %q and %q", first.Payload, again.Payload)
+	}
+	req.MessageID++
+	if next := exchangeRaw(t, conn, req); bytes.Equal(next.Payload, first.Payload) {
+		t.Fatalf("expect a new message ID to be served again, got %q", next.Payload)
+	}
+
+	// an unknown critical option is refused.
+	req.MessageID++
+	req.AddOption(OptionID(9), nil)
+	if resp := exchangeRaw(t, conn, req); resp.Code != BadOption {
+		t.Fatalf("expect BadOption, got %v", resp.Code)
+	}
+}
+
+// fakeServer passes the messages it receives to reply, which may ignore them.
+func fakeServer(t *testing.T, reply func(conn *net.UDPConn, from *net.UDPAddr, m *Message)) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		b := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			m, err := ParseMessage(append([]byte(nil), b[:n]...))
+			if err != nil {
+				continue
+			}
+			reply(conn, from, m)
+		}
+	}()
+	return conn
+}
+
+func TestRetransmission(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	var mids []uint16
+	srv := fakeServer(t, func(conn *net.UDPConn, from *net.UDPAddr, m *Message) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mids = append(mids, m.MessageID)
+		n := len(arrivals)
+		mu.Unlock()
+		if n < 4 {
+			return
+		}
+		resp := &Message{Type: Acknowledgement, Code: Content, MessageID: m.MessageID, Token: m.Token, Payload: []byte("late")}
+		_, _ = conn.WriteToUDP(AppendMessage(nil, resp), from)
+	})
+	defer srv.Close()
+
+	c, err := Dial(srv.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ACKTimeout = 40 * time.Millisecond
+	resp, err := c.Get("x")
+	if err != nil || string(resp.Payload) != "late" {
+		t.Fatalf("expect the response to the third retransmission, got %v, %v", resp, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(arrivals); i++ {
+		if mids[i] != mids[0] {
+			t.Fatalf("expect the retransmissions to keep the message ID, got %v", mids)
+		}
+		// the timeout starts between 40 and 60ms and doubles.
+		gap := arrivals[i].Sub(arrivals[i-1])
+		if min := 40 * time.Millisecond << (i - 1); gap < min-5*time.Millisecond {
+			t.Fatalf("retransmission %d after %v, expect at least %v", i, gap, min)
+		}
+	}
+
+	srv2 := fakeServer(t, func(*net.UDPConn, *net.UDPAddr, *Message) {})
+	defer srv2.Close()
+	c2, err := Dial(srv2.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.ACKTimeout, c2.MaxRetransmit = 20*time.Millisecond, 1
+	start := time.Now()
+	if _, err := c2.Get("x"); err != ErrTimeout {
+		t.Fatalf("expect ErrTimeout, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expect the request to give up after the retransmissions, took %v", d)
+	}
+}
+
+func TestSeparateResponse(t *testing.T) {
+	acked := make(chan uint16, 1)
+	srv := fakeServer(t, func(conn *net.UDPConn, from *net.UDPAddr, m *Message) {
+		if m.Type == Acknowledgement {
+			acked <- m.MessageID
+			return
+		}
+		if m.Path() == "reset" {
+			_, _ = conn.WriteToUDP(AppendMessage(nil, &Message{Type: Reset, MessageID: m.MessageID}), from)
+			return
+		}
+		// acknowledge the request, then send the response in a confirmable message.
+		_, _ = conn.WriteToUDP(AppendMessage(nil, &Message{Type: Acknowledgement, MessageID: m.MessageID}), from)
+		time.Sleep(20 * time.Millisecond)
+		resp := &Message{Type: Confirmable, Code: Content, MessageID: 777, Token: m.Token, Payload: []byte("separate")}
+		_, _ = conn.WriteToUDP(AppendMessage(nil, resp), from)
+	})
+	defer srv.Close()
+
+	c, err := Dial(srv.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ACKTimeout = 10 * time.Millisecond
+	resp, err := c.Get("slow")
+	if err != nil || string(resp.Payload) != "separate" {
+		t.Fatalf("expect the separate response, got %v, %v", resp, err)
+	}
+	select {
+	case mid := <-acked:
+		if mid != 777 {
+			t.Fatalf("expect the response to be acknowledged, got an ACK for %d", mid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the response to be acknowledged")
+	}
+
+	if _, err := c.Get("reset"); err != ErrReset {
+		t.Fatalf("expect ErrReset, got %v", err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	srv := fakeServer(t, func(*net.UDPConn, *net.UDPAddr, *Message) {})
+	defer srv.Close()
+	c, err := Dial(srv.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Get("x")
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+	if _, err := c.Get("x"); err != ErrClosed {
+		t.Fatalf("expect ErrClosed after Close, got %v", err)
+	}
+}
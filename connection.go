@@ -4,9 +4,23 @@ import (
 	"bytes"
 	"golang.org/x/sys/unix"
 	"net"
+	"time"
 )
 
 type conn struct {
+	bytesIn  uint64    // bytes read from the connection, accessed atomically
+	bytesOut uint64    // bytes written to the connection, accessed atomically
+	id       uint64    // unique ID of the connection, fds are reused
+	ring     *URingNet // ring owning the connection
+	openedAt time.Time // time the connection was accepted
+	admitted bool      // counted by the admission of the loop
+	ipKey    string    // source IP of the connection, empty if the peer is not an IP address
+	rate     *bucket   // rate limit of the connection
+	ipRate   *ipBucket // rate limit shared with the connections from the source IP
+	proxy    *ProxyHeader
+	hijacked bool    // the engine does not read the connection
+	mirror   *Mirror // mirror of the inbound stream, the mirror of the loop applies if nil
+
 	fd             int           // file descriptor
 	ctx            interface{}   // user-defined context
 	peer           unix.Sockaddr // remote socket address
@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// maxDatagram is the size of the buffer receiving datagrams, the largest UDP payload.
+const maxDatagram = 65535
+
+// DatagramHandler is implemented by the event handler of a ring listening on a UDP address.
+// The ring receives the datagrams of the socket instead of accepting connections.
+type DatagramHandler interface {
+	// OnDatagram fires when a datagram has been received from the address from.
+	// data is only valid until OnDatagram returns.
+	OnDatagram(ringNet *URingNet, data []byte, from unix.Sockaddr)
+}
+
+// datagramReader holds the buffers of the pending receive of a ring, they are used by the kernel
+// until the receive completes.
+type datagramReader struct {
+	buf  [maxDatagram]byte
+	name unix.RawSockaddrAny
+	iov  unix.Iovec
+	msg  unix.Msghdr
+}
+
+// isDatagram reports whether the ring listens on a UDP address.
+func (ringNet *URingNet) isDatagram() bool {
+	switch ringNet.Type {
+	case socket.Udp, socket.Udp4, socket.Udp6:
+		return true
+	}
+	return false
+}
+
+// armDatagram arms the receive of the next datagram of the listening socket.
+func (ringNet *URingNet) armDatagram() {
+	r := ringNet.dgram
+	if r == nil {
+		r = &datagramReader{}
+		r.iov.Base = &r.buf[0]
+		r.iov.SetLen(len(r.buf))
+		r.msg.Name = (*byte)(unsafe.Pointer(&r.name))
+		r.msg.Iov = &r.iov
+		r.msg.Iovlen = 1
+		ringNet.dgram = r
+	}
+	r.msg.Namelen = unix.SizeofSockaddrAny
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.RecvMsg(sqe, uintptr(ringNet.SocketFd), &r.msg, 0)
+	}, func(res int32, _ uint32) {
+		if res == -int32(unix.EBADF) || res == -int32(unix.EINVAL) || res == 0 && r.msg.Namelen == 0 {
+			// the socket has been closed or shut down, a datagram always comes with the address of its sender.
+			return
+		}
+		if res >= 0 {
+			if h, ok := ringNet.Handler.(DatagramHandler); ok {
+				h.OnDatagram(ringNet, r.buf[:res], rawToSockaddr(&r.name))
+			}
+		}
+		ringNet.armDatagram()
+	})
+}
+
+// SendTo sends the datagram b to the address to from the socket fd, done is called with the result
+// once it has been sent and may be nil. b must not be modified until then.
+// SendTo must only be called on the ring goroutine.
+func (ringNet *URingNet) SendTo(fd int, b []byte, to unix.Sockaddr, done func(err error)) {
+	name, namelen := sockaddrToRaw(to)
+	iov := &unix.Iovec{}
+	if len(b) > 0 {
+		iov.Base = &b[0]
+	}
+	iov.SetLen(len(b))
+	msg := &unix.Msghdr{Name: (*byte)(name), Namelen: namelen, Iov: iov, Iovlen: 1}
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.SendMsg(sqe, uintptr(fd), msg, 0)
+	}, func(res int32, _ uint32) {
+		// msg and its buffers are referenced by the closure until the kernel is done with them.
+		_ = msg.Iov.Base
+		if done == nil {
+			return
+		}
+		if res < 0 {
+			done(unix.Errno(-res))
+			return
+		}
+		done(nil)
+	})
+}
+
+// rawToSockaddr converts an address filled by the kernel, of a datagram or an accepted connection.
+func rawToSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet4{Port: int(binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&pp.Port))[:]))}
+		sa.Addr = pp.Addr
+		return sa
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet6{Port: int(binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&pp.Port))[:])), ZoneId: pp.Scope_id}
+		sa.Addr = pp.Addr
+		return sa
+	case unix.AF_UNIX:
+		pp := (*unix.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		n := 0
+		for n < len(pp.Path) && pp.Path[n] != 0 {
+			n++
+		}
+		return &unix.SockaddrUnix{Name: string(unsafe.Slice((*byte)(unsafe.Pointer(&pp.Path[0])), n))}
+	}
+	return nil
+}
+
+// sockaddrToRaw converts sa to the representation of the kernel.
+func sockaddrToRaw(sa unix.Sockaddr) (unsafe.Pointer, uint32) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		rsa := &unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: sa.Addr}
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&rsa.Port))[:], uint16(sa.Port))
+		return unsafe.Pointer(rsa), unix.SizeofSockaddrInet4
+	case *unix.SockaddrInet6:
+		rsa := &unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: sa.Addr, Scope_id: sa.ZoneId}
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&rsa.Port))[:], uint16(sa.Port))
+		return unsafe.Pointer(rsa), unix.SizeofSockaddrInet6
+	}
+	return nil, 0
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"os"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Hijack takes the connection fd over from the engine: no read is armed for it once OnOpen
+// returns, the handler drives its I/O with Submit and closes it with CloseFd. It must be called
+// from OnOpen, later reads are stopped by returning None from OnTraffic.
+func (ringNet *URingNet) Hijack(fd int32) error {
+	c, ok := ringNet.conns[fd]
+	if !ok {
+		return errors.ErrConnectionNotFound
+	}
+	c.hijacked = true
+	return nil
+}
+
+// Dial connects a new TCP socket to sa without blocking the ring. done is called on the ring
+// goroutine with the connected socket, owned by the caller, or with the error; os.ErrDeadlineExceeded
+// if timeout, when positive, elapses first. Dial must be called on the ring goroutine.
+func (ringNet *URingNet) Dial(sa unix.Sockaddr, timeout time.Duration, done func(fd int32, err error)) {
+	family := unix.AF_INET
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		family = unix.AF_INET6
+	}
+	name, namelen := sockaddrToRaw(sa)
+	if name == nil {
+		done(-1, errors.ErrUnsupportedTCPProtocol)
+		return
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		done(-1, os.NewSyscallError("socket", err))
+		return
+	}
+	var timer *Timer
+	timedOut := false
+	id := ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Connect(sqe, uintptr(fd), name, namelen)
+	}, func(res int32, _ uint32) {
+		// name is referenced by the closure until the kernel is done with it.
+		_ = name
+		if timer != nil {
+			timer.Stop()
+		}
+		switch {
+		case res == 0:
+			done(int32(fd), nil)
+			return
+		case timedOut && res == -int32(unix.ECANCELED):
+			err = os.ErrDeadlineExceeded
+		default:
+			err = os.NewSyscallError("connect", unix.Errno(-res))
+		}
+		_ = unix.Close(fd)
+		done(-1, err)
+	})
+	if timeout > 0 {
+		timer = ringNet.AfterFunc(timeout, func() {
+			timedOut = true
+			ringNet.Cancel(id)
+		})
+	}
+}
@@ -39,4 +39,12 @@ var (
 	ErrUnsupportedOp = errors.New("unsupported operation")
 	// ErrNegativeSize occurs when trying to pass a negative size to a buffer.
 	ErrNegativeSize = errors.New("negative size is invalid")
+	// ErrConnectionNotFound occurs when looking up a connection which is not open.
+	ErrConnectionNotFound = errors.New("connection is not found")
+	// ErrInvalidProxyHeader occurs when a connection starts with a malformed PROXY protocol header.
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+	// ErrRelayIdle occurs when a relay is closed because no data moved for its idle timeout.
+	ErrRelayIdle = errors.New("relay idle timeout")
+	// ErrKernelUnsupported occurs when the io_uring of the kernel lacks what the engine requires.
+	ErrKernelUnsupported = errors.New("io_uring of the kernel is not supported")
 )
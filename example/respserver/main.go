@@ -0,0 +1,167 @@
+// Command respserver is an in-memory key-value server speaking the Redis protocol on UringNet,
+// it can be tested with redis-cli or any Redis client:
+//
+//	go run ./example/respserver -addr :6380
+//	redis-cli -p 6380 set greeting hello
+//	redis-cli -p 6380 get greeting
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/y001j/uringnet/resp"
+)
+
+type entry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// store is shared by the rings, every command locks it.
+type store struct {
+	mu   sync.Mutex
+	data map[string]entry
+}
+
+// get returns the value of key, the expired keys are deleted lazily. The store must be locked.
+func (s *store) get(key string) ([]byte, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+		delete(s.data, key)
+		return nil, false
+	}
+	return e.value, ok
+}
+
+func (s *store) register(mux *resp.ServeMux) {
+	mux.HandleFunc("ping", -1, func(c *resp.Conn, cmd resp.Command) {
+		if len(cmd.Args) > 1 {
+			c.WriteBulk(cmd.Args[1])
+			return
+		}
+		c.WriteString("PONG")
+	})
+	mux.HandleFunc("echo", 2, func(c *resp.Conn, cmd resp.Command) {
+		c.WriteBulk(cmd.Args[1])
+	})
+	// redis-cli asks for the command docs when it starts.
+	mux.HandleFunc("command", -1, func(c *resp.Conn, cmd resp.Command) {
+		c.WriteArray(0)
+	})
+	mux.HandleFunc("set", -3, func(c *resp.Conn, cmd resp.Command) {
+		e := entry{value: append([]byte(nil), cmd.Args[2]...)}
+		for i := 3; i < len(cmd.Args); i += 2 {
+			opt := strings.ToLower(string(cmd.Args[i]))
+			if i+1 == len(cmd.Args) || opt != "ex" && opt != "px" {
+				c.WriteError("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(cmd.Args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				c.WriteError("ERR invalid expire time in 'set' command")
+				return
+			}
+			d := time.Duration(n) * time.Second
+			if opt == "px" {
+				d = time.Duration(n) * time.Millisecond
+			}
+			e.expireAt = time.Now().Add(d)
+		}
+		s.mu.Lock()
+		s.data[string(cmd.Args[1])] = e
+		s.mu.Unlock()
+		c.WriteString("OK")
+	})
+	mux.HandleFunc("get", 2, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if v, ok := s.get(string(cmd.Args[1])); ok {
+			c.WriteBulk(v)
+		} else {
+			c.WriteNull()
+		}
+	})
+	mux.HandleFunc("mget", -2, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.WriteArray(len(cmd.Args) - 1)
+		for _, key := range cmd.Args[1:] {
+			if v, ok := s.get(string(key)); ok {
+				c.WriteBulk(v)
+			} else {
+				c.WriteNull()
+			}
+		}
+	})
+	mux.HandleFunc("del", -2, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var n int64
+		for _, key := range cmd.Args[1:] {
+			if _, ok := s.get(string(key)); ok {
+				delete(s.data, string(key))
+				n++
+			}
+		}
+		c.WriteInt(n)
+	})
+	mux.HandleFunc("exists", -2, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var n int64
+		for _, key := range cmd.Args[1:] {
+			if _, ok := s.get(string(key)); ok {
+				n++
+			}
+		}
+		c.WriteInt(n)
+	})
+	mux.HandleFunc("incr", 2, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		key := string(cmd.Args[1])
+		v, _ := s.get(key)
+		n := int64(0)
+		if v != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				c.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+		}
+		n++
+		e := s.data[key]
+		e.value = strconv.AppendInt(nil, n, 10)
+		s.data[key] = e
+		c.WriteInt(n)
+	})
+	mux.HandleFunc("dbsize", 1, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.WriteInt(int64(len(s.data)))
+	})
+	mux.HandleFunc("flushall", -1, func(c *resp.Conn, cmd resp.Command) {
+		s.mu.Lock()
+		s.data = make(map[string]entry)
+		s.mu.Unlock()
+		c.WriteString("OK")
+	})
+}
+
+func main() {
+	addr := flag.String("addr", ":6380", "address to listen on")
+	rings := flag.Int("rings", 0, "number of rings, the number of CPUs if zero")
+	flag.Parse()
+
+	s := &store{data: make(map[string]entry)}
+	mux := resp.NewServeMux()
+	s.register(mux)
+
+	srv := &resp.Server{Addr: *addr, Handler: mux, Rings: *rings}
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// maxChunkLineLength bounds the length of a chunk-size line, including extensions.
+const maxChunkLineLength = 4096
+
+// decodeChunked decodes a body sent with chunked transfer coding from the beginning of buf.
+// It returns the payload, the trailer fields and the number of bytes consumed,
+// or ErrNeedMore if the last chunk has not arrived yet.
+func decodeChunked(buf []byte, maxBodyBytes int64) (body []byte, trailer Header, n int, err error) {
+	for {
+		i := bytes.Index(buf[n:], crlf)
+		if i < 0 {
+			if len(buf)-n > maxChunkLineLength {
+				return nil, nil, 0, errBadChunk
+			}
+			return nil, nil, 0, ErrNeedMore
+		}
+		line := buf[n : n+i]
+		// chunk extensions are ignored.
+		if j := bytes.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+		size, ok := parseHexUint(bytes.Trim(line, " \t"))
+		if !ok {
+			return nil, nil, 0, errBadChunk
+		}
+		n += i + len(crlf)
+
+		if size == 0 {
+			trailer, m, err := parseTrailer(buf[n:])
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			if body == nil {
+				body = []byte{}
+			}
+			return body, trailer, n + m, nil
+		}
+		if size > uint64(maxBodyBytes-int64(len(body))) {
+			return nil, nil, 0, errBodyTooLarge
+		}
+		if uint64(len(buf)-n) < size+uint64(len(crlf)) {
+			return nil, nil, 0, ErrNeedMore
+		}
+		end := n + int(size)
+		body = append(body, buf[n:end]...)
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return nil, nil, 0, errBadChunk
+		}
+		n = end + len(crlf)
+	}
+}
+
+// parseTrailer parses the trailer section which follows the last chunk.
+func parseTrailer(buf []byte) (Header, int, error) {
+	if bytes.HasPrefix(buf, crlf) {
+		return nil, len(crlf), nil
+	}
+	end := bytes.Index(buf, crlfcrlf)
+	if end < 0 {
+		if len(buf) > maxChunkLineLength {
+			return nil, 0, errBadChunk
+		}
+		return nil, 0, ErrNeedMore
+	}
+	trailer := make(Header)
+	if err := parseFields(buf[:end], trailer); err != nil {
+		return nil, 0, err
+	}
+	return trailer, end + len(crlfcrlf), nil
+}
+
+// parseHexUint parses the hexadecimal chunk size.
+func parseHexUint(v []byte) (uint64, bool) {
+	if len(v) == 0 || len(v) > 16 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(v), 16, 64)
+	return n, err == nil
+}
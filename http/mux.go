@@ -0,0 +1,181 @@
+package http
+
+import (
+	nethttp "net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Handler responds to an HTTP request.
+//
+// ServeHTTP runs on the goroutine of the ring that owns the connection, so it should not block.
+type Handler interface {
+	ServeHTTP(ResponseWriter, *Request)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as HTTP handlers.
+type HandlerFunc func(ResponseWriter, *Request)
+
+// ServeHTTP calls f(w, r).
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Error replies to the request with the specified error message and HTTP code.
+func Error(w ResponseWriter, error string, code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(error + "\n"))
+}
+
+// NotFound replies to the request with an HTTP 404 not found error.
+func NotFound(w ResponseWriter, _ *Request) {
+	Error(w, "404 page not found", nethttp.StatusNotFound)
+}
+
+// NotFoundHandler returns a simple request handler that replies to each request with a "404 page not found" reply.
+func NotFoundHandler() Handler {
+	return HandlerFunc(NotFound)
+}
+
+// ServeMux is an HTTP request multiplexer.
+//
+// Patterns name fixed, rooted paths like "/favicon.ico", or rooted subtrees like "/images/"
+// (note the trailing slash), optionally preceded by a method and a space, like "POST /items".
+// Longer patterns take precedence over shorter ones, a pattern with a method takes precedence
+// over the same pattern without one, and a GET pattern also matches HEAD requests.
+type ServeMux struct {
+	mu      sync.RWMutex
+	entries map[string]*muxEntry // keyed by path
+	subtree []*muxEntry          // entries whose path ends in a slash, longest first
+}
+
+type muxEntry struct {
+	path     string
+	any      Handler            // handler registered without a method
+	byMethod map[string]Handler // handlers registered with a method
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{entries: make(map[string]*muxEntry)}
+}
+
+// DefaultServeMux is the default ServeMux used by Serve when no handler is given.
+var DefaultServeMux = NewServeMux()
+
+// Handle registers the handler for the given pattern. It panics if a handler already exists for pattern.
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	method, path := "", pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		method, path = pattern[:i], strings.TrimLeft(pattern[i+1:], " ")
+		if !validToken(method) {
+			panic("http: invalid method in pattern " + pattern)
+		}
+	}
+	if path == "" || path[0] != '/' {
+		panic("http: invalid pattern " + pattern)
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.entries == nil {
+		mux.entries = make(map[string]*muxEntry)
+	}
+	e, ok := mux.entries[path]
+	if !ok {
+		e = &muxEntry{path: path}
+		mux.entries[path] = e
+		if strings.HasSuffix(path, "/") {
+			mux.subtree = append(mux.subtree, e)
+			sort.SliceStable(mux.subtree, func(i, j int) bool {
+				return len(mux.subtree[i].path) > len(mux.subtree[j].path)
+			})
+		}
+	}
+	if method == "" {
+		if e.any != nil {
+			panic("http: multiple registrations for " + pattern)
+		}
+		e.any = handler
+		return
+	}
+	if e.byMethod == nil {
+		e.byMethod = make(map[string]Handler)
+	}
+	if _, exist := e.byMethod[method]; exist {
+		panic("http: multiple registrations for " + pattern)
+	}
+	e.byMethod[method] = handler
+}
+
+// HandleFunc registers the handler function for the given pattern.
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+// Handler returns the handler to use for the given request, it never returns nil.
+func (mux *ServeMux) Handler(r *Request) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	path := r.URL.Path
+	e, ok := mux.entries[path]
+	if !ok {
+		for _, se := range mux.subtree {
+			if strings.HasPrefix(path, se.path) {
+				e = se
+				break
+			}
+		}
+	}
+	if e == nil {
+		return NotFoundHandler()
+	}
+	if h, ok := e.byMethod[r.Method]; ok {
+		return h
+	}
+	if r.Method == "HEAD" {
+		if h, ok := e.byMethod["GET"]; ok {
+			return h
+		}
+	}
+	if e.any != nil {
+		return e.any
+	}
+	return methodNotAllowed(e)
+}
+
+// ServeHTTP dispatches the request to the handler whose pattern most closely matches the request URL.
+func (mux *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
+	mux.Handler(r).ServeHTTP(w, r)
+}
+
+func methodNotAllowed(e *muxEntry) Handler {
+	allow := make([]string, 0, len(e.byMethod))
+	for m := range e.byMethod {
+		allow = append(allow, m)
+	}
+	sort.Strings(allow)
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		Error(w, nethttp.StatusText(nethttp.StatusMethodNotAllowed), nethttp.StatusMethodNotAllowed)
+	})
+}
+
+// Handle registers the handler for the given pattern in the DefaultServeMux.
+func Handle(pattern string, handler Handler) {
+	DefaultServeMux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the given pattern in the DefaultServeMux.
+func HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	DefaultServeMux.HandleFunc(pattern, handler)
+}
@@ -0,0 +1,313 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Request represents an HTTP request received by the server.
+//
+// The body of a request is read completely before the handler is called, so Body never blocks.
+type Request struct {
+	// Method specifies the HTTP method (GET, POST, PUT, etc.).
+	Method string
+
+	// URL specifies the URI being requested, parsed from RequestURI.
+	URL *url.URL
+
+	// The protocol version of the request, "HTTP/1.0" or "HTTP/1.1".
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+
+	// Header contains the request header fields, the keys are canonicalized.
+	Header Header
+
+	// Body is the request body. It is always non-nil and returns io.EOF at the end of the body.
+	Body io.ReadCloser
+
+	// ContentLength is the size of the body, -1 means the body was sent with chunked encoding
+	// and its length is len(Bytes()).
+	ContentLength int64
+
+	// TransferEncoding lists the transfer encodings from outermost to innermost.
+	TransferEncoding []string
+
+	// Close indicates whether to close the connection after replying to this request.
+	Close bool
+
+	// Host is the host on which the URL is sought, taken from the URL or the Host header.
+	Host string
+
+	// Trailer contains the trailer fields sent after a chunked body.
+	Trailer Header
+
+	// RemoteAddr is the network address of the peer that sent the request.
+	RemoteAddr string
+
+	// RequestURI is the unmodified request-target of the Request-Line.
+	RequestURI string
+
+	body []byte
+}
+
+// Bytes returns the whole request body without copying it.
+func (r *Request) Bytes() []byte {
+	return r.body
+}
+
+// ProtoAtLeast reports whether the HTTP protocol used in the request is at least major.minor.
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || r.ProtoMajor == major && r.ProtoMinor >= minor
+}
+
+// UserAgent returns the client's User-Agent, if sent in the request.
+func (r *Request) UserAgent() string {
+	return r.Header.Get("User-Agent")
+}
+
+// expectsContinue reports whether the client waits for "100 Continue" before sending the body.
+func (r *Request) expectsContinue() bool {
+	return r.ProtoAtLeast(1, 1) && r.Header.hasToken("Expect", "100-continue")
+}
+
+// protocolError is a malformed request. It is answered with its status code and the connection is closed.
+type protocolError struct {
+	status int
+	msg    string
+}
+
+func (e *protocolError) Error() string {
+	return "http: " + e.msg
+}
+
+// ErrNeedMore means the buffer does not hold a complete request yet.
+var ErrNeedMore = errors.New("http: need more data")
+
+var (
+	errHeaderTooLarge  = &protocolError{431, "request header too large"}
+	errBodyTooLarge    = &protocolError{413, "request body too large"}
+	errMalformedLine   = &protocolError{400, "malformed request line"}
+	errMalformedHeader = &protocolError{400, "malformed header line"}
+	errMissingHost     = &protocolError{400, "missing required Host header"}
+	errBadLength       = &protocolError{400, "bad Content-Length"}
+	errBadChunk        = &protocolError{400, "malformed chunked encoding"}
+	errUnsupportedTE   = &protocolError{501, "unsupported transfer encoding"}
+	errBadVersion      = &protocolError{505, "unsupported protocol version"}
+	errBadExpect       = &protocolError{417, "unsupported Expect header"}
+	errInternal        = &protocolError{500, "internal server error"}
+)
+
+var (
+	crlf     = []byte("\r\n")
+	crlfcrlf = []byte("\r\n\r\n")
+)
+
+// ParseRequest parses the request line and the header fields at the beginning of buf, the body is not read.
+// It is meant for protocols upgrading from HTTP on their own ring handler, like WebSocket.
+//
+// It returns the request and the length of its header section, ErrNeedMore if buf does not hold
+// the complete header section yet, or an error describing why the request is malformed.
+func ParseRequest(buf []byte, maxHeaderBytes int) (*Request, int, error) {
+	req, n, err := parseHeader(buf, maxHeaderBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(nil))
+	return req, n, nil
+}
+
+// parseHeader parses the request line and the header fields at the beginning of buf.
+// It returns the request without its body and the length of the header section,
+// ErrNeedMore if the header section is not complete yet, or a *protocolError.
+func parseHeader(buf []byte, maxHeaderBytes int) (*Request, int, error) {
+	// Servers should ignore empty lines received prior to the request line (RFC 7230, section 3.5).
+	skip := 0
+	for len(buf)-skip >= 2 && buf[skip] == '\r' && buf[skip+1] == '\n' {
+		skip += 2
+	}
+	end := bytes.Index(buf[skip:], crlfcrlf)
+	if end < 0 {
+		if len(buf)-skip > maxHeaderBytes {
+			return nil, 0, errHeaderTooLarge
+		}
+		return nil, 0, ErrNeedMore
+	}
+	if end+len(crlfcrlf) > maxHeaderBytes {
+		return nil, 0, errHeaderTooLarge
+	}
+	lines := buf[skip : skip+end]
+
+	line, lines, _ := bytes.Cut(lines, crlf)
+	method, rest, ok1 := strings.Cut(string(line), " ")
+	uri, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !validToken(method) || uri == "" {
+		return nil, 0, errMalformedLine
+	}
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok {
+		return nil, 0, errMalformedLine
+	}
+	if major != 1 {
+		return nil, 0, errBadVersion
+	}
+
+	req := &Request{
+		Method:     method,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		RequestURI: uri,
+		Header:     make(Header),
+	}
+	if err := parseFields(lines, req.Header); err != nil {
+		return nil, 0, err
+	}
+
+	var err error
+	if method == "CONNECT" && !strings.HasPrefix(uri, "/") {
+		req.URL = &url.URL{Host: uri}
+	} else if req.URL, err = url.ParseRequestURI(uri); err != nil {
+		return nil, 0, errMalformedLine
+	}
+
+	hosts := req.Header["Host"]
+	if len(hosts) > 1 || len(hosts) == 0 && req.ProtoAtLeast(1, 1) {
+		return nil, 0, errMissingHost
+	}
+	req.Host = req.URL.Host
+	if req.Host == "" && len(hosts) == 1 {
+		req.Host = hosts[0]
+	}
+	req.Close = shouldClose(major, minor, req.Header)
+
+	if err = setBodyFraming(req); err != nil {
+		return nil, 0, err
+	}
+	return req, skip + end + len(crlfcrlf), nil
+}
+
+// parseFields parses header field lines separated by CRLF into h.
+func parseFields(lines []byte, h Header) error {
+	for len(lines) > 0 {
+		var line []byte
+		line, lines, _ = bytes.Cut(lines, crlf)
+		// obsolete line folding is rejected (RFC 7230, section 3.2.4).
+		if len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+			return errMalformedHeader
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !validToken(string(line[:i])) {
+			return errMalformedHeader
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(line[:i]))
+		value := strings.Trim(string(line[i+1:]), " \t")
+		h[key] = append(h[key], value)
+	}
+	return nil
+}
+
+// setBodyFraming determines how the length of the request body is defined (RFC 7230, section 3.3.3).
+func setBodyFraming(req *Request) error {
+	if te := req.Header["Transfer-Encoding"]; len(te) > 0 {
+		if !req.ProtoAtLeast(1, 1) {
+			return errMalformedHeader
+		}
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return errUnsupportedTE
+		}
+		// Transfer-Encoding overrides Content-Length.
+		req.Header.Del("Content-Length")
+		req.TransferEncoding = []string{"chunked"}
+		req.ContentLength = -1
+		return nil
+	}
+	cl := req.Header["Content-Length"]
+	if len(cl) == 0 {
+		return nil
+	}
+	first := strings.TrimSpace(cl[0])
+	for _, v := range cl[1:] {
+		if strings.TrimSpace(v) != first {
+			return errBadLength
+		}
+	}
+	n, err := strconv.ParseUint(first, 10, 63)
+	if err != nil {
+		return errBadLength
+	}
+	req.ContentLength = int64(n)
+	return nil
+}
+
+// readBody reads the body of req from the beginning of buf.
+// It returns the number of bytes consumed or ErrNeedMore if the body is not complete yet.
+func readBody(req *Request, buf []byte, maxBodyBytes int64) (int, error) {
+	var n int
+	if req.ContentLength >= 0 {
+		if req.ContentLength > maxBodyBytes {
+			return 0, errBodyTooLarge
+		}
+		n = int(req.ContentLength)
+		if len(buf) < n {
+			return 0, ErrNeedMore
+		}
+		if n > 0 {
+			req.body = make([]byte, n)
+			copy(req.body, buf)
+		}
+	} else {
+		body, trailer, m, err := decodeChunked(buf, maxBodyBytes)
+		if err != nil {
+			return 0, err
+		}
+		req.body, req.Trailer, n = body, trailer, m
+	}
+	req.Body = io.NopCloser(bytes.NewReader(req.body))
+	return n, nil
+}
+
+// parseHTTPVersion parses an HTTP version string such as "HTTP/1.1".
+func parseHTTPVersion(vers string) (major, minor int, ok bool) {
+	switch vers {
+	case "HTTP/1.1":
+		return 1, 1, true
+	case "HTTP/1.0":
+		return 1, 0, true
+	}
+	if len(vers) != len("HTTP/X.Y") || !strings.HasPrefix(vers, "HTTP/") || vers[6] != '.' {
+		return 0, 0, false
+	}
+	if vers[5] < '0' || vers[5] > '9' || vers[7] < '0' || vers[7] > '9' {
+		return 0, 0, false
+	}
+	return int(vers[5] - '0'), int(vers[7] - '0'), true
+}
+
+// shouldClose reports whether the connection should be closed after the request.
+// HTTP/1.1 connections persist unless "close" is asked, HTTP/1.0 ones only persist on "keep-alive".
+func shouldClose(major, minor int, h Header) bool {
+	if major == 1 && minor == 0 {
+		return !h.hasToken("Connection", "keep-alive")
+	}
+	return h.hasToken("Connection", "close")
+}
+
+// validToken reports whether s is a token as defined in RFC 7230, section 3.2.6.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	raw := "\r\nGET /index.html?q=1 HTTP/1.1\r\nHost: example.com\r\nX-Multi: a\r\nx-multi:  b \r\n\r\ntrailing"
+	req, n, err := parseHeader([]byte(raw), DefaultMaxHeaderBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(raw)-len("trailing") {
+		t.Fatalf("expect header length %d, got %d", len(raw)-len("trailing"), n)
+	}
+	if req.Method != "GET" || req.URL.Path != "/index.html" || req.URL.Query().Get("q") != "1" {
+		t.Fatalf("unexpected request line: %s %v", req.Method, req.URL)
+	}
+	if req.Host != "example.com" || req.Close {
+		t.Fatalf("unexpected host %q or close %v", req.Host, req.Close)
+	}
+	if got := req.Header.Values("X-Multi"); len(got) != 2 || got[1] != "b" {
+		t.Fatalf("unexpected header values %q", got)
+	}
+}
+
+func TestParseHeaderIncomplete(t *testing.T) {
+	if _, _, err := parseHeader([]byte("GET / HTTP/1.1\r\nHost: a\r\n"), DefaultMaxHeaderBytes); err != ErrNeedMore {
+		t.Fatalf("expect ErrNeedMore, got %v", err)
+	}
+	long := "GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 100)
+	if _, _, err := parseHeader([]byte(long), 64); err != errHeaderTooLarge {
+		t.Fatalf("expect errHeaderTooLarge, got %v", err)
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	cases := map[string]error{
+		"GET /\r\n\r\n":                                                 errMalformedLine,
+		"GET / HTTP/2.0\r\nHost: a\r\n\r\n":                             errBadVersion,
+		"GET / HTTP/1.1\r\n\r\n":                                        errMissingHost,
+		"GET / HTTP/1.1\r\nHost: a\r\n folded\r\n\r\n":                  errMalformedHeader,
+		"GET / HTTP/1.1\r\nHost : a\r\n\r\n":                            errMalformedHeader,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: x\r\n\r\n":       errBadLength,
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n": errUnsupportedTE,
+	}
+	for raw, want := range cases {
+		if _, _, err := parseHeader([]byte(raw), DefaultMaxHeaderBytes); err != want {
+			t.Errorf("%q: expect %v, got %v", raw, want, err)
+		}
+	}
+}
+
+func TestShouldClose(t *testing.T) {
+	cases := []struct {
+		raw   string
+		close bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", false},
+		{"GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n", true},
+		{"GET / HTTP/1.0\r\n\r\n", true},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", false},
+	}
+	for _, c := range cases {
+		req, _, err := parseHeader([]byte(c.raw), DefaultMaxHeaderBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Close != c.close {
+			t.Errorf("%q: expect close %v", c.raw, c.close)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello"
+	req, n, err := parseHeader([]byte(raw), DefaultMaxHeaderBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readBody(req, []byte(raw[n:n+3]), DefaultMaxBodyBytes); err != ErrNeedMore {
+		t.Fatalf("expect ErrNeedMore, got %v", err)
+	}
+	m, err := readBody(req, []byte(raw[n:]), DefaultMaxBodyBytes)
+	if err != nil || m != 5 {
+		t.Fatalf("expect 5 bytes, got %d %v", m, err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "hello" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if _, err = readBody(req, []byte(raw[n:]), 4); err != errBodyTooLarge {
+		t.Fatalf("expect errBodyTooLarge, got %v", err)
+	}
+}
+
+func TestDecodeChunked(t *testing.T) {
+	raw := "4;ext=1\r\nWiki\r\n5\r\npedia\r\n0\r\nX-Sum: 9\r\n\r\nnext"
+	body, trailer, n, err := decodeChunked([]byte(raw), DefaultMaxBodyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Wikipedia" || n != len(raw)-len("next") {
+		t.Fatalf("unexpected body %q or length %d", body, n)
+	}
+	if trailer.Get("X-Sum") != "9" {
+		t.Fatalf("unexpected trailer %v", trailer)
+	}
+	for i := 0; i < len(raw)-len("next"); i++ {
+		if _, _, _, err = decodeChunked([]byte(raw[:i]), DefaultMaxBodyBytes); err != ErrNeedMore {
+			t.Fatalf("prefix %d: expect ErrNeedMore, got %v", i, err)
+		}
+	}
+	if _, _, _, err = decodeChunked([]byte("4\r\nWikiXX"), DefaultMaxBodyBytes); err != errBadChunk {
+		t.Fatalf("expect errBadChunk, got %v", err)
+	}
+	if _, _, _, err = decodeChunked([]byte("10\r\n"), 8); err != errBodyTooLarge {
+		t.Fatalf("expect errBodyTooLarge, got %v", err)
+	}
+}
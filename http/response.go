@@ -0,0 +1,190 @@
+package http
+
+import (
+	nethttp "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A ResponseWriter is used by a handler to construct the response to a request.
+//
+// The response is buffered and sent once the handler returns, so the ResponseWriter
+// must not be used after that.
+type ResponseWriter interface {
+	// Header returns the header map that will be sent by WriteHeader.
+	Header() Header
+
+	// Write appends data to the response body. If WriteHeader has not yet been called,
+	// Write calls WriteHeader(200) before writing the data.
+	Write([]byte) (int, error)
+
+	// WriteHeader sets the status code of the response, only the first call has effect.
+	WriteHeader(statusCode int)
+}
+
+// response is the ResponseWriter handed to handlers.
+type response struct {
+	req         *Request
+	header      Header
+	status      int
+	wroteHeader bool
+	body        []byte
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	w.body = append(w.body, p...)
+	return len(p), nil
+}
+
+// WriteString appends s to the response body without converting it to a byte slice first.
+func (w *response) WriteString(s string) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	w.body = append(w.body, s...)
+	return len(s), nil
+}
+
+func (w *response) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+}
+
+var headerNewlines = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// appendTo serializes the response to dst. keepAlive tells whether the connection stays open.
+func (w *response) appendTo(dst []byte, keepAlive bool) []byte {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	h := w.header
+	isHead := w.req.Method == "HEAD"
+	bodyAllowed := bodyAllowedForStatus(w.status)
+	chunked := bodyAllowed && w.req.ProtoAtLeast(1, 1) && h.hasToken("Transfer-Encoding", "chunked")
+
+	if !bodyAllowed {
+		h.Del("Content-Length")
+		h.Del("Transfer-Encoding")
+	} else if chunked {
+		h.Del("Content-Length")
+	} else {
+		h.Del("Transfer-Encoding")
+		if _, ok := h["Content-Length"]; !ok && !(isHead && len(w.body) == 0) {
+			h.Set("Content-Length", strconv.Itoa(len(w.body)))
+		}
+		if _, ok := h["Content-Type"]; !ok && len(w.body) > 0 {
+			h.Set("Content-Type", nethttp.DetectContentType(w.body))
+		}
+	}
+	if !keepAlive {
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+
+	dst = appendStatusLine(dst, w.status)
+	if _, ok := h["Date"]; !ok {
+		dst = append(dst, "Date: "...)
+		dst = append(dst, httpDate()...)
+		dst = append(dst, crlf...)
+	}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			dst = append(dst, k...)
+			dst = append(dst, ": "...)
+			dst = append(dst, headerNewlines.Replace(v)...)
+			dst = append(dst, crlf...)
+		}
+	}
+	dst = append(dst, crlf...)
+
+	if isHead || !bodyAllowed {
+		return dst
+	}
+	if !chunked {
+		return append(dst, w.body...)
+	}
+	if len(w.body) > 0 {
+		dst = strconv.AppendInt(dst, int64(len(w.body)), 16)
+		dst = append(dst, crlf...)
+		dst = append(dst, w.body...)
+		dst = append(dst, crlf...)
+	}
+	return append(dst, "0\r\n\r\n"...)
+}
+
+func appendStatusLine(dst []byte, status int) []byte {
+	dst = append(dst, "HTTP/1.1 "...)
+	dst = strconv.AppendInt(dst, int64(status), 10)
+	dst = append(dst, ' ')
+	text := nethttp.StatusText(status)
+	if text == "" {
+		text = "status code " + strconv.Itoa(status)
+	}
+	dst = append(dst, text...)
+	return append(dst, crlf...)
+}
+
+// appendError serializes the response for a request that could not be parsed or served,
+// the connection is always closed afterwards.
+func appendError(dst []byte, err error) []byte {
+	pe, ok := err.(*protocolError)
+	if !ok {
+		pe = errInternal
+	}
+	text := strconv.Itoa(pe.status) + " " + nethttp.StatusText(pe.status)
+	dst = appendStatusLine(dst, pe.status)
+	dst = append(dst, "Content-Type: text/plain; charset=utf-8\r\nConnection: close\r\nContent-Length: "...)
+	dst = strconv.AppendInt(dst, int64(len(text)), 10)
+	dst = append(dst, crlfcrlf...)
+	return append(dst, text...)
+}
+
+var continueResponse = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+
+// bodyAllowedForStatus reports whether a given response status code permits a body (RFC 7230, section 3.3).
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == 204, status == 304:
+		return false
+	}
+	return true
+}
+
+type cachedDate struct {
+	sec  int64
+	date string
+}
+
+var dateCache atomic.Value // *cachedDate
+
+// httpDate returns the current time formatted for the Date header, it is refreshed once per second.
+func httpDate() string {
+	now := time.Now()
+	if d, ok := dateCache.Load().(*cachedDate); ok && d.sec == now.Unix() {
+		return d.date
+	}
+	d := &cachedDate{sec: now.Unix(), date: now.UTC().Format(nethttp.TimeFormat)}
+	dateCache.Store(d)
+	return d.date
+}
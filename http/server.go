@@ -0,0 +1,416 @@
+//go:build linux
+
+// Package http implements an HTTP/1.1 server which runs natively on UringNet rings.
+//
+// Requests are parsed incrementally from the bytes delivered by the rings, keep-alive,
+// pipelining and chunked transfer coding are supported, and requests are routed to handlers
+// with an API close to net/http:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("GET /hello", func(w http.ResponseWriter, r *http.Request) {
+//		w.Write([]byte("Hello World!"))
+//	})
+//	srv := &http.Server{Addr: ":8080", Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+//	log.Fatal(srv.ListenAndServe())
+//
+// Handlers run on the goroutine of the ring that owns the connection, so a handler that blocks
+// stalls every connection of that ring.
+package http
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the maximum permitted size of the headers in an HTTP request.
+	DefaultMaxHeaderBytes = 1 << 20 // 1 MB
+
+	// DefaultMaxBodyBytes is the maximum permitted size of a request body.
+	DefaultMaxBodyBytes = 4 << 20 // 4 MB
+
+	// ringSize is the number of SQ entries of each ring.
+	ringSize = 4096
+
+	// ringBuffers is the number of kernel buffers provided to each ring.
+	ringBuffers = 4096
+)
+
+// A Server defines parameters for running an HTTP server on UringNet.
+type Server struct {
+	// Addr specifies the TCP address for the server to listen on, ":http" if empty.
+	Addr string
+
+	// Handler to invoke, DefaultServeMux if nil.
+	Handler Handler
+
+	// ReadHeaderTimeout is the amount of time allowed to read request headers,
+	// ReadTimeout is used if it is zero.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout is the maximum duration for reading the entire request, including the body.
+	ReadTimeout time.Duration
+
+	// IdleTimeout is the maximum amount of time to wait for the next request when keep-alives are enabled,
+	// ReadTimeout is used if it is zero.
+	IdleTimeout time.Duration
+
+	// MaxHeaderBytes controls the maximum number of bytes the server will read parsing the request header,
+	// including the request line. DefaultMaxHeaderBytes is used if it is zero.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes is the maximum size of a request body, DefaultMaxBodyBytes is used if it is zero.
+	// Request bodies are buffered completely before the handler is invoked.
+	MaxBodyBytes int64
+
+	// DisableKeepAlives closes every connection after its first response.
+	DisableKeepAlives bool
+
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+
+	// ErrorLog specifies an optional logger for the errors of the rings and for panics in handlers, it is
+	// used when Logger is nil.
+	ErrorLog *log.Logger
+
+	// Logger logs the events of the rings and the panics in handlers, with the fields of the connection.
+	// ErrorLog is used when it is nil, logging.Default when both are.
+	Logger logging.Logger
+}
+
+// ListenAndServe listens on srv.Addr and serves requests on the rings. It only returns on setup errors.
+func (srv *Server) ListenAndServe() error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	rings := srv.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp, Address: addr}, ringSize, srv.SQPoll, rings, options, nil)
+	if err != nil {
+		return err
+	}
+	handlers := make([]*ringHandler, rings)
+	for i := range ringNets {
+		handlers[i] = &ringHandler{srv: srv, ring: ringNets[i], conns: make(map[int32]*conn)}
+		ringNets[i].Logger = srv.logger()
+		ringNets[i].Handler = handlers[i]
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	loop.RunMany2()
+
+	srv.expireConns(handlers)
+	return nil
+}
+
+// ListenAndServe listens on the TCP network address addr and serves requests with handler on UringNet rings.
+func ListenAndServe(addr string, handler Handler) error {
+	srv := &Server{Addr: addr, Handler: handler}
+	return srv.ListenAndServe()
+}
+
+func (srv *Server) maxHeaderBytes() int {
+	if srv.MaxHeaderBytes > 0 {
+		return srv.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (srv *Server) maxBodyBytes() int64 {
+	if srv.MaxBodyBytes > 0 {
+		return srv.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+func (srv *Server) readHeaderTimeout() time.Duration {
+	if srv.ReadHeaderTimeout != 0 {
+		return srv.ReadHeaderTimeout
+	}
+	return srv.ReadTimeout
+}
+
+func (srv *Server) idleTimeout() time.Duration {
+	if srv.IdleTimeout != 0 {
+		return srv.IdleTimeout
+	}
+	return srv.ReadTimeout
+}
+
+// logger returns Logger, or ErrorLog for errors, or the default logger.
+func (srv *Server) logger() logging.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	if srv.ErrorLog != nil {
+		return logging.NewStd(srv.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// conn is the state of an HTTP connection, owned by the ring serving it.
+type conn struct {
+	fd         int32
+	remoteAddr string
+
+	in  []byte // received bytes which have not been consumed yet
+	off int    // offset of the first unconsumed byte in in
+
+	req          *Request // parsed header of a request whose body is incomplete
+	headerLen    int      // length of the header section of req
+	sentContinue bool     // "100 Continue" has been sent for req
+	reqStart     time.Time
+
+	out     []byte // responses waiting for the send in flight
+	sending bool   // a send is in flight
+	closing bool   // the connection is shut down once the responses are sent
+	closed  bool   // the ring has closed the connection
+
+	deadline int64 // unix nanoseconds after which the connection is shut down, guarded by ringHandler.mu
+}
+
+// serve handles every complete request buffered in c and returns the responses to send.
+// closeConn tells whether the connection must be closed once they are sent.
+func (srv *Server) serve(c *conn) (out []byte, closeConn bool) {
+	for c.off < len(c.in) {
+		buf := c.in[c.off:]
+		if c.req == nil {
+			req, n, err := parseHeader(buf, srv.maxHeaderBytes())
+			if err == ErrNeedMore {
+				break
+			}
+			if err != nil {
+				return appendError(out, err), true
+			}
+			if req.Header.Get("Expect") != "" && !req.expectsContinue() {
+				return appendError(out, errBadExpect), true
+			}
+			c.req, c.headerLen = req, n
+		}
+		n, err := readBody(c.req, buf[c.headerLen:], srv.maxBodyBytes())
+		if err == ErrNeedMore {
+			if !c.sentContinue && c.req.expectsContinue() {
+				out = append(out, continueResponse...)
+				c.sentContinue = true
+			}
+			break
+		}
+		if err != nil {
+			return appendError(out, err), true
+		}
+
+		req := c.req
+		c.off += c.headerLen + n
+		c.req, c.headerLen, c.sentContinue = nil, 0, false
+		req.RemoteAddr = c.remoteAddr
+
+		keepAlive := !req.Close && !srv.DisableKeepAlives
+		if out, keepAlive = srv.handle(out, c, req, keepAlive); !keepAlive {
+			return out, true
+		}
+	}
+	// move the unconsumed bytes to the front of the buffer.
+	if c.off > 0 {
+		c.in = c.in[:copy(c.in, c.in[c.off:])]
+		c.off = 0
+	}
+	return out, false
+}
+
+// handle invokes the handler for req, received on c, and appends the response to out.
+func (srv *Server) handle(out []byte, c *conn, req *Request, keepAlive bool) ([]byte, bool) {
+	w := &response{req: req, header: make(Header)}
+	handler := srv.Handler
+	if handler == nil {
+		handler = DefaultServeMux
+	}
+	if !srv.invoke(c, handler, w, req) {
+		return appendError(out, errInternal), false
+	}
+	if w.header.hasToken("Connection", "close") {
+		keepAlive = false
+	}
+	return w.appendTo(out, keepAlive), keepAlive
+}
+
+// invoke calls the handler and recovers from its panics, which must not bring the ring down.
+func (srv *Server) invoke(c *conn, handler Handler, w *response, req *Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			srv.logger().Log(logging.LevelError, "panic serving a request", "fd", c.fd, "remote", req.RemoteAddr,
+				"method", req.Method, "uri", req.RequestURI, "err", err, "stack", string(buf))
+		}
+	}()
+	handler.ServeHTTP(w, req)
+	return true
+}
+
+// deadlineOf returns when c times out, zero if it never does.
+func (srv *Server) deadlineOf(c *conn, now time.Time) int64 {
+	var start time.Time
+	var d time.Duration
+	switch {
+	case c.off < len(c.in) && c.req == nil:
+		start, d = c.reqStart, srv.readHeaderTimeout()
+	case c.req != nil:
+		start, d = c.reqStart, srv.ReadTimeout
+	default:
+		start, d = now, srv.idleTimeout()
+	}
+	if d <= 0 {
+		return 0
+	}
+	return start.Add(d).UnixNano()
+}
+
+// expireConns shuts down the connections whose deadline has passed. The pending read of such a
+// connection then completes with EOF and the ring closes the socket. It never returns, Serve blocks
+// in it even if no timeout is set.
+func (srv *Server) expireConns(handlers []*ringHandler) {
+	interval := time.Second
+	for _, d := range []time.Duration{srv.readHeaderTimeout(), srv.ReadTimeout, srv.idleTimeout()} {
+		if d > 0 && d/2 < interval {
+			interval = d / 2
+		}
+	}
+	if srv.readHeaderTimeout() <= 0 && srv.ReadTimeout <= 0 && srv.idleTimeout() <= 0 {
+		select {}
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		ns := now.UnixNano()
+		for _, h := range handlers {
+			h.mu.Lock()
+			for fd, c := range h.conns {
+				if c.deadline != 0 && c.deadline < ns {
+					c.deadline = 0
+					_ = h.ring.ShutdownConn(fd)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// ringHandler serves the connections of one ring. Its callbacks are only invoked by the goroutine
+// running the ring, mu guards conns against the goroutine expiring connections.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	srv   *Server
+	ring  *uringnet.URingNet
+	mu    sync.Mutex
+	conns map[int32]*conn
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	h.open(data.Fd)
+	return nil, uringnet.None
+}
+
+func (h *ringHandler) open(fd int32) *conn {
+	now := time.Now()
+	c := &conn{fd: fd, remoteAddr: h.ring.RemoteAddr(fd), reqStart: now}
+	// a new connection has to send its first request header in time.
+	if d := h.srv.readHeaderTimeout(); d > 0 {
+		c.deadline = now.Add(d).UnixNano()
+	}
+	h.mu.Lock()
+	h.conns[fd] = c
+	h.mu.Unlock()
+	return c
+}
+
+func (h *ringHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	h.mu.Lock()
+	c := h.conns[data.Fd]
+	h.mu.Unlock()
+	if c == nil {
+		c = h.open(data.Fd)
+	}
+	if c.closing {
+		// the bytes following the last request are dropped.
+		return uringnet.Read
+	}
+	now := time.Now()
+	if c.off == len(c.in) {
+		c.reqStart = now
+	}
+	c.in = append(c.in, data.Buffer[:data.BufSize]...)
+
+	out, closeConn := h.srv.serve(c)
+	c.out = append(c.out, out...)
+	c.closing = closeConn
+
+	h.mu.Lock()
+	if closeConn {
+		c.deadline = 0
+	} else {
+		c.deadline = h.srv.deadlineOf(c, now)
+	}
+	h.mu.Unlock()
+
+	if closeConn && len(c.out) == 0 && !c.sending {
+		return uringnet.Close
+	}
+	h.flush(c)
+	return uringnet.Read
+}
+
+// flush sends the responses of c, only one send is in flight at a time: its completion sends the
+// responses appended meanwhile. A closing connection is shut down once they all are sent, its
+// read then completes with EOF and the ring closes it.
+func (h *ringHandler) flush(c *conn) {
+	if c.closed || c.sending {
+		return
+	}
+	if len(c.out) == 0 {
+		if c.closing {
+			_ = h.ring.ShutdownConn(c.fd)
+		}
+		return
+	}
+	buf := c.out
+	c.out, c.sending = nil, true
+	h.ring.SendAll(c.fd, buf, func(err error) {
+		c.sending = false
+		if c.closed {
+			return
+		}
+		if err != nil {
+			c.closing, c.out = true, nil
+		}
+		h.flush(c)
+	})
+}
+
+func (h *ringHandler) OnClose(data uringnet.UserData) uringnet.Action {
+	h.mu.Lock()
+	if c := h.conns[data.Fd]; c != nil {
+		c.closed = true
+		delete(h.conns, data.Fd)
+	}
+	h.mu.Unlock()
+	return uringnet.None
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeBody is far larger than the send buffer of a socket, the kernel takes it in several sends.
+var largeBody = bytes.Repeat([]byte("0123456789abcdef"), 8<<20/16)
+
+func testServer() *Server {
+	mux := NewServeMux()
+	mux.HandleFunc("GET /hello", func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hi " + r.URL.Query().Get("n")))
+	})
+	mux.HandleFunc("POST /echo", func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(r.Bytes())
+	})
+	mux.HandleFunc("GET /large", func(w ResponseWriter, r *Request) {
+		_, _ = w.Write(largeBody)
+	})
+	mux.HandleFunc("/panic", func(w ResponseWriter, r *Request) {
+		panic("boom")
+	})
+	return &Server{Handler: mux, ErrorLog: log.New(io.Discard, "", 0)}
+}
+
+func TestServePipelined(t *testing.T) {
+	srv := testServer()
+	c := &conn{}
+	c.in = []byte("GET /hello?n=1 HTTP/1.1\r\nHost: a\r\n\r\nGET /hello?n=2 HTTP/1.1\r\nHost: a\r\n\r\nGET /hel")
+	out, closeConn := srv.serve(c)
+	if closeConn {
+		t.Fatal("connection should be kept alive")
+	}
+	resp := string(out)
+	if strings.Count(resp, "HTTP/1.1 200 OK\r\n") != 2 || !strings.Contains(resp, "hi 1") ||
+		strings.Index(resp, "hi 1") > strings.Index(resp, "hi 2") {
+		t.Fatalf("unexpected responses %q", resp)
+	}
+	if string(c.in) != "GET /hel" || c.off != 0 {
+		t.Fatalf("unexpected leftover %q", c.in[c.off:])
+	}
+}
+
+func TestServeChunkedAndContinue(t *testing.T) {
+	srv := testServer()
+	c := &conn{}
+	c.in = []byte("POST /echo HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n")
+	out, _ := srv.serve(c)
+	if string(out) != string(continueResponse) {
+		t.Fatalf("expect 100 Continue, got %q", out)
+	}
+	c.in = append(c.in, "3\r\nabc\r\n0\r\n\r\n"...)
+	out, _ = srv.serve(c)
+	resp := string(out)
+	if !strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n") || !strings.Contains(resp, "Content-Length: 3\r\n") ||
+		!strings.HasSuffix(resp, "\r\n\r\nabc") {
+		t.Fatalf("unexpected response %q", resp)
+	}
+}
+
+func TestServeClose(t *testing.T) {
+	srv := testServer()
+	c := &conn{in: []byte("GET /hello HTTP/1.0\r\n\r\nGET /hello HTTP/1.0\r\n\r\n")}
+	out, closeConn := srv.serve(c)
+	if !closeConn || strings.Count(string(out), "HTTP/1.1 200") != 1 || !strings.Contains(string(out), "Connection: close\r\n") {
+		t.Fatalf("expect a single response closing the connection, got %q", out)
+	}
+
+	c = &conn{in: []byte("GET /nope HTTP/1.1\r\nHost: a\r\n\r\nBAD\r\n\r\n")}
+	out, closeConn = srv.serve(c)
+	if !closeConn || !strings.Contains(string(out), "404 Not Found") || !strings.Contains(string(out), "400 Bad Request") {
+		t.Fatalf("unexpected responses %q", out)
+	}
+}
+
+func TestServeMethodNotAllowedAndHead(t *testing.T) {
+	srv := testServer()
+	c := &conn{in: []byte("PUT /hello HTTP/1.1\r\nHost: a\r\n\r\nHEAD /hello HTTP/1.1\r\nHost: a\r\n\r\n")}
+	out, _ := srv.serve(c)
+	resp := string(out)
+	if !strings.Contains(resp, "405 Method Not Allowed") || !strings.Contains(resp, "Allow: GET\r\n") {
+		t.Fatalf("expect 405, got %q", resp)
+	}
+	if !strings.HasSuffix(resp, "Content-Length: 3\r\nContent-Type: text/plain\r\n\r\n") {
+		t.Fatalf("HEAD response must not carry a body, got %q", resp)
+	}
+}
+
+func TestServePanic(t *testing.T) {
+	srv := testServer()
+	c := &conn{in: []byte("GET /panic HTTP/1.1\r\nHost: a\r\n\r\n")}
+	out, closeConn := srv.serve(c)
+	if !closeConn || !strings.HasPrefix(string(out), "HTTP/1.1 500 Internal Server Error") {
+		t.Fatalf("unexpected response %q", out)
+	}
+}
+
+func TestServeLargeResponse(t *testing.T) {
+	const addr = "127.0.0.1:18940"
+	srv := testServer()
+	srv.Addr, srv.Rings = addr, 1
+	go func() { t.Error(srv.ListenAndServe()) }()
+	var c net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if c, err = net.Dial("tcp4", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("server did not start")
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(c)
+	// the second response is sent once the first one is, the last one closes the connection.
+	if _, err = c.Write([]byte("GET /large HTTP/1.1\r\nHost: a\r\n\r\nGET /large HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		length := -1
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			if v := strings.TrimPrefix(line, "Content-Length: "); v != line {
+				length, _ = strconv.Atoi(strings.TrimSpace(v))
+			}
+		}
+		if length != len(largeBody) {
+			t.Fatalf("response %d: unexpected length %d", i, length)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if !bytes.Equal(body, largeBody) {
+			t.Fatalf("response %d: the body is corrupted", i)
+		}
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %d bytes and %v", n, err)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"github.com/y001j/uringnet/logging"
+)
+
+// logger returns the logger of the ring: Logger, or ErrorLog for errors, or the default logger.
+func (ringNet *URingNet) logger() logging.Logger {
+	if ringNet.Logger != nil {
+		return ringNet.Logger
+	}
+	if ringNet.ErrorLog != nil {
+		return logging.NewStd(ringNet.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// log logs msg with the index of the ring and keyvals.
+func (ringNet *URingNet) log(level logging.Level, msg string, keyvals ...interface{}) {
+	l := ringNet.logger()
+	if !l.Enabled(level) {
+		return
+	}
+	l.Log(level, msg, append([]interface{}{"ring", ringNet.ringIndex}, keyvals...)...)
+}
+
+// ConnLogger returns the logger of the ring with the fields of the connection fd, for the event handler.
+func (ringNet *URingNet) ConnLogger(fd int32) logging.Logger {
+	return ringNet.logger().With("ring", ringNet.ringIndex, "fd", fd)
+}
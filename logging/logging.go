@@ -0,0 +1,143 @@
+// Package logging defines the logger used by UringNet, its rings and the uring packages.
+//
+// Records carry a level, a message and fields given as alternating keys and values, in the
+// manner of log/slog. Nothing is logged by default: set a logger with SetDefault, or on a ring
+// with URingNet.Logger. NewStd adapts a *log.Logger and NewSlog a *slog.Logger.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a record, its values are those of the levels of log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Logger logs records with fields. Its methods are safe to call from any goroutine.
+type Logger interface {
+	// Enabled reports whether records of level are logged, it lets callers skip building their fields.
+	Enabled(level Level) bool
+
+	// Log logs msg with the fields keyvals, alternating keys and values.
+	Log(level Level, msg string, keyvals ...interface{})
+
+	// With returns a logger adding keyvals to the fields of every record.
+	With(keyvals ...interface{}) Logger
+}
+
+type discard struct{}
+
+func (discard) Enabled(Level) bool                { return false }
+func (discard) Log(Level, string, ...interface{}) {}
+func (d discard) With(...interface{}) Logger      { return d }
+
+// Discard is a logger dropping every record.
+var Discard Logger = discard{}
+
+type holder struct{ Logger }
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(holder{Discard})
+}
+
+// Default returns the logger set by SetDefault, Discard if none has been set.
+func Default() Logger {
+	return defaultLogger.Load().(holder).Logger
+}
+
+// SetDefault sets the logger of the components which are not given one, nil restores Discard.
+func SetDefault(l Logger) {
+	if l == nil {
+		l = Discard
+	}
+	defaultLogger.Store(holder{l})
+}
+
+// Std is a Logger writing records to a *log.Logger in the logfmt format:
+//
+//	level=ERROR msg="submit failed" ring=0 fd=9 err="bad file descriptor"
+type Std struct {
+	l      *log.Logger
+	min    Level
+	fields string
+}
+
+// NewStd returns a logger writing the records of level min and above to l.
+func NewStd(l *log.Logger, min Level) *Std {
+	return &Std{l: l, min: min}
+}
+
+// Enabled implements Logger.
+func (s *Std) Enabled(level Level) bool {
+	return level >= s.min
+}
+
+// Log implements Logger.
+func (s *Std) Log(level Level, msg string, keyvals ...interface{}) {
+	if !s.Enabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	b.WriteString(s.fields)
+	appendFields(&b, keyvals)
+	_ = s.l.Output(2, b.String())
+}
+
+// With implements Logger.
+func (s *Std) With(keyvals ...interface{}) Logger {
+	var b strings.Builder
+	b.WriteString(s.fields)
+	appendFields(&b, keyvals)
+	return &Std{l: s.l, min: s.min, fields: b.String()}
+}
+
+func appendFields(b *strings.Builder, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(keyvals) {
+			// a value without a key, as log/slog does.
+			b.WriteString("!BADKEY=")
+			b.WriteString(quote(fmt.Sprint(keyvals[i])))
+			return
+		}
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(keyvals[i+1])))
+	}
+}
+
+// quote quotes s if it is empty or holds spaces, quotes or equal signs.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestStd(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStd(log.New(&buf, "", 0), LevelInfo)
+	l.Log(LevelDebug, "hidden")
+	if l.Enabled(LevelDebug) || buf.Len() != 0 {
+		t.Fatalf("expect debug records to be dropped, got %q", buf.String())
+	}
+	l.With("ring", 1).With("fd", 9).Log(LevelError, "submit failed", "err", "bad file descriptor", "n", 3)
+	if got, expect := buf.String(), "level=ERROR msg=\"submit failed\" ring=1 fd=9 err=\"bad file descriptor\" n=3\n"; got != expect {
+		t.Fatalf("expect %q, got %q", expect, got)
+	}
+	buf.Reset()
+	l.Log(LevelWarn, "odd", "key")
+	if got, expect := buf.String(), "level=WARN msg=odd !BADKEY=key\n"; got != expect {
+		t.Fatalf("expect %q, got %q", expect, got)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	if Default() != Discard || Default().Enabled(LevelError) {
+		t.Fatal("expect nothing to be logged by default")
+	}
+	var buf bytes.Buffer
+	SetDefault(NewStd(log.New(&buf, "", 0), LevelDebug))
+	defer SetDefault(nil)
+	Default().Log(LevelInfo, "hello")
+	if buf.String() != "level=INFO msg=hello\n" {
+		t.Fatalf("unexpected record %q", buf.String())
+	}
+	SetDefault(nil)
+	if Default() != Discard {
+		t.Fatal("expect SetDefault(nil) to restore Discard")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlog returns a logger writing the records to l.
+func NewSlog(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) Enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slog.Level(level))
+}
+
+func (s slogLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.Level(level), msg, keyvals...)
+}
+
+func (s slogLogger) With(keyvals ...interface{}) Logger {
+	return slogLogger{l: s.l.With(keyvals...)}
+}
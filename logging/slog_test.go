@@ -0,0 +1,22 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlog(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	if l.Enabled(LevelInfo) || !l.Enabled(LevelWarn) {
+		t.Fatal("expect the level of the handler to be honoured")
+	}
+	l.With("ring", 2).Log(LevelError, "accept failed", "err", "EMFILE")
+	if got := buf.String(); !strings.Contains(got, "level=ERROR msg=\"accept failed\" ring=2 err=EMFILE") {
+		t.Fatalf("unexpected record %q", got)
+	}
+}
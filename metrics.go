@@ -0,0 +1,217 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet/uring"
+)
+
+// LatencyBounds are the upper bounds of the buckets of the completion latency histogram.
+var LatencyBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// ringMetrics holds the counters of a ring. They are updated on the ring goroutine and read
+// atomically by Metrics.
+type ringMetrics struct {
+	accepted        uint64
+	closed          uint64
+	active          int64
+	bytesIn         uint64
+	bytesOut        uint64
+	sqesSubmitted   uint64
+	cqesReaped      uint64
+	submitErrors    uint64
+	waits           uint64
+	sqFull          uint64
+	backlogged      uint64
+	sqWakeups       uint64
+	cqOverflows     uint64
+	cqesDropped     uint64
+	bufferExhausted uint64
+	rejected        uint64
+	throttled       uint64
+	throttledTime   uint64 // in nanoseconds
+
+	latency      [len(LatencyBounds) + 1]uint64 // the last bucket counts the completions above every bound
+	latencySum   uint64                         // in nanoseconds
+	latencyCount uint64
+}
+
+// LatencyHistogram is the distribution of the time from the submission of an operation to its
+// completion. Operations waiting for the peer or for time to pass, i.e. accepts, reads and
+// timeouts, are not observed.
+type LatencyHistogram struct {
+	// Counts holds the number of completions of each bucket, Counts[i] those not above LatencyBounds[i]
+	// and above the previous bound. The last one counts the completions above every bound.
+	Counts [len(LatencyBounds) + 1]uint64
+	Sum    time.Duration
+	Count  uint64
+}
+
+// MetricsSnapshot is a copy of the counters of a ring.
+type MetricsSnapshot struct {
+	Ring              int           // index of the ring in its loop, set by Ringloop.Metrics
+	Accepted          uint64        // connections accepted
+	Rejected          uint64        // connections closed right after their accept by the admission control
+	Closed            uint64        // connections closed
+	Active            int64         // connections open
+	BytesIn           uint64        // bytes read from connections and sockets
+	BytesOut          uint64        // bytes written to connections and sockets
+	SQEsSubmitted     uint64        // entries handed to the kernel
+	CQEsReaped        uint64        // completions consumed
+	SubmitErrors      uint64        // failed io_uring_enter calls submitting entries
+	Waits             uint64        // io_uring_enter calls waiting for completions
+	SQFull            uint64        // times no free entry was left in the submission queue
+	Backlogged        uint64        // entries queued in user space until the submission queue had room
+	SQWakeups         uint64        // times the sleeping SQ thread was woken up to submit entries
+	CQOverflows       uint64        // times completions overflowed the completion queue
+	CQEsDropped       uint64        // completions lost by the kernel to an overflow
+	BufferExhaustions uint64        // reads failed because every kernel buffer was in use
+	Throttled         uint64        // reads delayed by the rate limiting
+	ThrottledTime     time.Duration // total delay of the reads
+	Latency           LatencyHistogram
+}
+
+// Metrics returns a snapshot of the counters of the ring, it is safe to call from any goroutine.
+func (ringNet *URingNet) Metrics() MetricsSnapshot {
+	m := &ringNet.metrics
+	s := MetricsSnapshot{
+		Accepted:          atomic.LoadUint64(&m.accepted),
+		Rejected:          atomic.LoadUint64(&m.rejected),
+		Closed:            atomic.LoadUint64(&m.closed),
+		Active:            atomic.LoadInt64(&m.active),
+		BytesIn:           atomic.LoadUint64(&m.bytesIn),
+		BytesOut:          atomic.LoadUint64(&m.bytesOut),
+		SQEsSubmitted:     atomic.LoadUint64(&m.sqesSubmitted),
+		CQEsReaped:        atomic.LoadUint64(&m.cqesReaped),
+		SubmitErrors:      atomic.LoadUint64(&m.submitErrors),
+		Waits:             atomic.LoadUint64(&m.waits),
+		SQFull:            atomic.LoadUint64(&m.sqFull),
+		Backlogged:        atomic.LoadUint64(&m.backlogged),
+		SQWakeups:         atomic.LoadUint64(&m.sqWakeups),
+		CQOverflows:       atomic.LoadUint64(&m.cqOverflows),
+		CQEsDropped:       atomic.LoadUint64(&m.cqesDropped),
+		BufferExhaustions: atomic.LoadUint64(&m.bufferExhausted),
+		Throttled:         atomic.LoadUint64(&m.throttled),
+		ThrottledTime:     time.Duration(atomic.LoadUint64(&m.throttledTime)),
+	}
+	for i := range m.latency {
+		s.Latency.Counts[i] = atomic.LoadUint64(&m.latency[i])
+	}
+	s.Latency.Sum = time.Duration(atomic.LoadUint64(&m.latencySum))
+	s.Latency.Count = atomic.LoadUint64(&m.latencyCount)
+	return s
+}
+
+// Metrics returns a snapshot of the counters of every ring of the loop.
+func (loop *Ringloop) Metrics() []MetricsSnapshot {
+	snapshots := make([]MetricsSnapshot, len(loop.RingNet))
+	for i, ringNet := range loop.RingNet {
+		snapshots[i] = ringNet.Metrics()
+		snapshots[i].Ring = i
+	}
+	return snapshots
+}
+
+var (
+	loopsMu sync.Mutex
+	loops   []*Ringloop
+)
+
+// Loops returns the loops created by SetLoops, in the order of their creation.
+// The metrics exporters use it to find the rings of the process.
+func Loops() []*Ringloop {
+	loopsMu.Lock()
+	defer loopsMu.Unlock()
+	return append([]*Ringloop(nil), loops...)
+}
+
+func registerLoop(loop *Ringloop) {
+	loopsMu.Lock()
+	loops = append(loops, loop)
+	loopsMu.Unlock()
+}
+
+// epoch is the origin of the monotonic timestamps of the submissions.
+var epoch = time.Now()
+
+func monotonic() int64 {
+	return int64(time.Since(epoch))
+}
+
+// observe records the latency of the completed operation of data.
+func (ringNet *URingNet) observe(data *UserData) {
+	m := &ringNet.metrics
+	if data.start == 0 {
+		return
+	}
+	d := time.Duration(monotonic() - data.start)
+	i := 0
+	for i < len(LatencyBounds) && d > LatencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&m.latency[i], 1)
+	atomic.AddUint64(&m.latencySum, uint64(d))
+	atomic.AddUint64(&m.latencyCount, 1)
+}
+
+// countTransfer adds the result of a completed read or write operation of fd to the byte counters.
+func (ringNet *URingNet) countTransfer(opcode uint8, fd int32, res int32) {
+	if res <= 0 {
+		return
+	}
+	switch opcode {
+	case uring.IORING_OP_READ, uring.IORING_OP_READV, uring.IORING_OP_READ_FIXED,
+		uring.IORING_OP_RECV, uring.IORING_OP_RECVMSG:
+		ringNet.countIn(fd, res)
+	case uring.IORING_OP_WRITE, uring.IORING_OP_WRITEV, uring.IORING_OP_WRITE_FIXED,
+		uring.IORING_OP_SEND, uring.IORING_OP_SENDMSG:
+		ringNet.countOut(fd, res)
+	}
+}
+
+// countIn adds n bytes read from fd to the counters of the ring and of the connection.
+func (ringNet *URingNet) countIn(fd int32, n int32) {
+	atomic.AddUint64(&ringNet.metrics.bytesIn, uint64(n))
+	if c := ringNet.conns[fd]; c != nil {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+		ringNet.charge(c, int(n), 1)
+	}
+}
+
+// countOut adds n bytes written to fd to the counters of the ring and of the connection.
+func (ringNet *URingNet) countOut(fd int32, n int32) {
+	atomic.AddUint64(&ringNet.metrics.bytesOut, uint64(n))
+	if c := ringNet.conns[fd]; c != nil {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		ringNet.charge(c, int(n), 0)
+	}
+}
+
+// waits reports whether an operation waits for the peer or for time to pass, its latency is not observed.
+func waits(opcode uint8) bool {
+	switch opcode {
+	case uring.IORING_OP_READ, uring.IORING_OP_READV, uring.IORING_OP_READ_FIXED,
+		uring.IORING_OP_RECV, uring.IORING_OP_RECVMSG, uring.IORING_OP_ACCEPT,
+		uring.IORING_OP_POLL_ADD, uring.IORING_OP_TIMEOUT, uring.IORING_OP_LINK_TIMEOUT:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+// Package metrics exports the counters of UringNet rings in the Prometheus text format and
+// through expvar.
+//
+// The counters are kept by every ring, this package only formats their snapshots:
+//
+//	http.Handle("/metrics", metrics.Handler())
+//	metrics.Publish("uringnet")
+//
+// Without loops, the exporters report every loop created by uringnet.SetLoops in the process.
+// Each series is labelled with the index of its loop and the index of its ring in the loop.
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/y001j/uringnet"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type ringSnapshot struct {
+	loop int
+	uringnet.MetricsSnapshot
+}
+
+func snapshots(loops []*uringnet.Ringloop) []ringSnapshot {
+	if len(loops) == 0 {
+		loops = uringnet.Loops()
+	}
+	var rings []ringSnapshot
+	for i, loop := range loops {
+		for _, s := range loop.Metrics() {
+			rings = append(rings, ringSnapshot{loop: i, MetricsSnapshot: s})
+		}
+	}
+	return rings
+}
+
+var series = []struct {
+	name, kind, help string
+	value            func(s *uringnet.MetricsSnapshot) float64
+}{
+	{"uringnet_connections_accepted_total", "counter", "Connections accepted by the ring.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Accepted) }},
+	{"uringnet_connections_rejected_total", "counter", "Connections rejected by the admission control.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Rejected) }},
+	{"uringnet_connections_closed_total", "counter", "Connections closed by the ring.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Closed) }},
+	{"uringnet_connections_active", "gauge", "Connections open on the ring.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Active) }},
+	{"uringnet_received_bytes_total", "counter", "Bytes read from connections and sockets.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.BytesIn) }},
+	{"uringnet_sent_bytes_total", "counter", "Bytes written to connections and sockets.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.BytesOut) }},
+	{"uringnet_sqes_submitted_total", "counter", "Submission queue entries handed to the kernel.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.SQEsSubmitted) }},
+	{"uringnet_cqes_reaped_total", "counter", "Completion queue entries consumed.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.CQEsReaped) }},
+	{"uringnet_submit_errors_total", "counter", "Failed submissions to the kernel.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.SubmitErrors) }},
+	{"uringnet_waits_total", "counter", "Calls to io_uring_enter waiting for completions.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Waits) }},
+	{"uringnet_sq_full_total", "counter", "Times the submission queue had no free entry.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.SQFull) }},
+	{"uringnet_sqes_backlogged_total", "counter", "Entries queued in user space until the submission queue had room.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Backlogged) }},
+	{"uringnet_sq_wakeups_total", "counter", "Times the sleeping SQ thread was woken up to submit entries.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.SQWakeups) }},
+	{"uringnet_cq_overflows_total", "counter", "Times completions overflowed the completion queue.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.CQOverflows) }},
+	{"uringnet_cqes_dropped_total", "counter", "Completions lost by the kernel to an overflow.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.CQEsDropped) }},
+	{"uringnet_buffer_exhaustions_total", "counter", "Reads failed because every kernel buffer was in use.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.BufferExhaustions) }},
+	{"uringnet_throttled_reads_total", "counter", "Reads delayed by the rate limiting.",
+		func(s *uringnet.MetricsSnapshot) float64 { return float64(s.Throttled) }},
+	{"uringnet_throttled_seconds_total", "counter", "Total delay of the reads throttled by the rate limiting.",
+		func(s *uringnet.MetricsSnapshot) float64 { return s.ThrottledTime.Seconds() }},
+}
+
+const latencyName = "uringnet_completion_latency_seconds"
+
+// WritePrometheus writes the counters of the rings of loops to w in the Prometheus text format.
+func WritePrometheus(w io.Writer, loops ...*uringnet.Ringloop) error {
+	rings := snapshots(loops)
+	bw := bufio.NewWriter(w)
+	for _, m := range series {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i := range rings {
+			fmt.Fprintf(bw, "%s{%s} %s\n", m.name, labels(&rings[i]), formatFloat(m.value(&rings[i].MetricsSnapshot)))
+		}
+	}
+	fmt.Fprintf(bw, "# HELP %s Time from the submission of an operation to its completion.\n# TYPE %s histogram\n",
+		latencyName, latencyName)
+	for i := range rings {
+		r := &rings[i]
+		var cumulative uint64
+		for b, bound := range uringnet.LatencyBounds {
+			cumulative += r.Latency.Counts[b]
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", latencyName, labels(r), formatFloat(bound.Seconds()), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", latencyName, labels(r), r.Latency.Count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", latencyName, labels(r), formatFloat(r.Latency.Sum.Seconds()))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", latencyName, labels(r), r.Latency.Count)
+	}
+	return bw.Flush()
+}
+
+func labels(r *ringSnapshot) string {
+	return "loop=\"" + strconv.Itoa(r.loop) + "\",ring=\"" + strconv.Itoa(r.Ring) + "\""
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Handler returns a handler serving the counters of the rings of loops in the Prometheus text format.
+func Handler(loops ...*uringnet.Ringloop) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = WritePrometheus(w, loops...)
+	})
+}
+
+// Ring is the expvar representation of the counters of a ring.
+type Ring struct {
+	Loop int `json:"loop"`
+	uringnet.MetricsSnapshot
+}
+
+// Publish publishes the counters of the rings of loops as the expvar variable name, a list of Ring.
+// Like expvar.Publish, it panics if name is already registered.
+func Publish(name string, loops ...*uringnet.Ringloop) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		rings := snapshots(loops)
+		vars := make([]Ring, len(rings))
+		for i, r := range rings {
+			vars[i] = Ring{Loop: r.loop, MetricsSnapshot: r.MetricsSnapshot}
+		}
+		return vars
+	}))
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet"
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+const testAddr = "127.0.0.1:18935"
+
+type echoHandler struct {
+	uringnet.BuiltinEventEngine
+}
+
+func (h *echoHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	data.WriteBuf = append([]byte(nil), data.Buffer[:data.BufSize]...)
+	return uringnet.Echo
+}
+
+func startEcho(t *testing.T) *uringnet.Ringloop {
+	t.Helper()
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp4, Address: testAddr}, 256, false, 1,
+		socket.SocketOptions{}, &echoHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := uringnet.SetLoops(ringNets, 64)
+	loop.RunMany2()
+	return loop
+}
+
+// waitFor polls the counters of loop until cond holds.
+func waitFor(t *testing.T, loop *uringnet.Ringloop, cond func(s uringnet.MetricsSnapshot) bool) uringnet.MetricsSnapshot {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s := loop.Metrics()[0]
+		if cond(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected metrics %+v", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	loop := startEcho(t)
+	found := false
+	for _, l := range uringnet.Loops() {
+		found = found || l == loop
+	}
+	if !found {
+		t.Fatal("expect the loop to be registered")
+	}
+
+	conn, err := net.Dial("tcp4", testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello, rings")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, msg) {
+		t.Fatalf("expect the echo, got %q, %v", buf, err)
+	}
+	s := waitFor(t, loop, func(s uringnet.MetricsSnapshot) bool { return s.BytesOut == uint64(len(msg)) })
+	if s.Accepted != 1 || s.Active != 1 || s.BytesIn != uint64(len(msg)) {
+		t.Fatalf("unexpected metrics %+v", s)
+	}
+	_ = conn.Close()
+	s = waitFor(t, loop, func(s uringnet.MetricsSnapshot) bool { return s.Closed == 1 })
+	if s.Active != 0 || s.SQEsSubmitted == 0 || s.CQEsReaped == 0 || s.Latency.Count == 0 {
+		t.Fatalf("unexpected metrics %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(loop).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE uringnet_connections_accepted_total counter\n",
+		"uringnet_connections_accepted_total{loop=\"0\",ring=\"0\"} 1\n",
+		"uringnet_connections_active{loop=\"0\",ring=\"0\"} 0\n",
+		"uringnet_received_bytes_total{loop=\"0\",ring=\"0\"} 12\n",
+		"# TYPE uringnet_completion_latency_seconds histogram\n",
+		"uringnet_completion_latency_seconds_bucket{loop=\"0\",ring=\"0\",le=\"1e-05\"} ",
+		"uringnet_completion_latency_seconds_bucket{loop=\"0\",ring=\"0\",le=\"+Inf\"} ",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expect %q in\n%s", line, body)
+		}
+	}
+
+	Publish("uringnet_test", loop)
+	var rings []Ring
+	if err := json.Unmarshal([]byte(expvar.Get("uringnet_test").String()), &rings); err != nil {
+		t.Fatal(err)
+	}
+	if len(rings) != 1 || rings[0].Accepted != 1 || rings[0].Closed != 1 {
+		t.Fatalf("unexpected expvar %+v", rings)
+	}
+}
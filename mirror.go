@@ -0,0 +1,378 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	bsPool "github.com/y001j/uringnet/byteslice"
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMirrorQueue is the number of bytes a mirror queues for its sink if MirrorConfig.MaxQueued is 0.
+const DefaultMirrorQueue = 4 << 20
+
+// mirrorHeaderLen is the size of the header of a framed chunk: the ID of the connection and the
+// length of the chunk.
+const mirrorHeaderLen = 12
+
+// MirrorConfig configures a mirror.
+type MirrorConfig struct {
+	// Framed prefixes each chunk with the ID of its connection, 8 bytes, and its length, 4 bytes,
+	// both big endian, so that the streams of several connections can be told apart.
+	Framed bool
+	// MaxQueued is the number of bytes waiting for the sink beyond which chunks are dropped rather
+	// than slowing the connections down, DefaultMirrorQueue if 0.
+	MaxQueued int
+}
+
+// MirrorStats counts the chunks of a mirror.
+type MirrorStats struct {
+	Chunks       uint64 // chunks written to the sink
+	Bytes        uint64 // bytes of the connections written to the sink
+	DroppedBytes uint64 // bytes of the connections dropped because the sink was too slow or failed
+	Errors       uint64 // failed writes to the sink
+}
+
+// Mirror copies the inbound byte stream of connections to a capture sink, a file or a socket,
+// without disturbing them: the data is written to the sink asynchronously and dropped if the
+// sink does not keep up.
+//
+// The bytes read by the engine are copied to a buffer queued for the sink. Those moved by a relay
+// in splice mode never reach user space, they are duplicated with tee into a pipe spliced to the
+// sink. Chunks are written one at a time, in the order they were queued, by the ring which queued
+// the first of them.
+type Mirror struct {
+	fd     int
+	socket bool
+	file   *os.File // closed by Close, if the mirror opened it
+	cfg    MirrorConfig
+
+	mu       sync.Mutex
+	queue    []mirrorChunk
+	queued   int // bytes of the queue and reserved for chunks to come
+	writing  bool
+	closed   bool
+	finished bool // the file has been closed
+
+	chunks  uint64
+	bytes   uint64
+	dropped uint64
+	errs    uint64
+}
+
+// mirrorChunk is a chunk queued for the sink: the bytes of buf, the header and data of a copy or
+// the header of a pipe chunk, followed by n bytes of pipe, if any.
+type mirrorChunk struct {
+	buf  []byte
+	off  int // bytes of buf written
+	pipe *mirrorPipe
+	n    int // bytes of pipe not written yet
+	data int // bytes of the connection in the chunk
+}
+
+// NewMirror returns a mirror writing to fd, a file or a socket owned by the caller. The data of a
+// relay in splice mode cannot be written to a file opened with O_APPEND.
+func NewMirror(fd int, cfg MirrorConfig) *Mirror {
+	m := &Mirror{fd: fd, cfg: cfg}
+	var st unix.Stat_t
+	if unix.Fstat(fd, &st) == nil && st.Mode&unix.S_IFMT == unix.S_IFSOCK {
+		m.socket = true
+	}
+	return m
+}
+
+// NewFileMirror creates or opens the file name and returns a mirror appending to it.
+func NewFileMirror(name string, cfg MirrorConfig) (*Mirror, error) {
+	// splice cannot write to a file opened for appending, the mirror writes at the position of the
+	// file from its end.
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekEnd); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	m := NewMirror(int(f.Fd()), cfg)
+	m.file = f
+	return m, nil
+}
+
+// Stats returns the counters of the mirror, it is safe to call from any goroutine.
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Chunks:       atomic.LoadUint64(&m.chunks),
+		Bytes:        atomic.LoadUint64(&m.bytes),
+		DroppedBytes: atomic.LoadUint64(&m.dropped),
+		Errors:       atomic.LoadUint64(&m.errs),
+	}
+}
+
+// Close stops the mirror, the chunks already queued are still written. The file of a mirror
+// created by NewFileMirror is closed once they are.
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	idle := !m.writing
+	m.mu.Unlock()
+	if idle {
+		m.finish()
+	}
+	return nil
+}
+
+func (m *Mirror) finish() {
+	m.mu.Lock()
+	done := m.finished
+	m.finished = true
+	m.mu.Unlock()
+	if !done && m.file != nil {
+		_ = m.file.Close()
+	}
+}
+
+func (m *Mirror) maxQueued() int {
+	if m.cfg.MaxQueued > 0 {
+		return m.cfg.MaxQueued
+	}
+	return DefaultMirrorQueue
+}
+
+// frame returns a buffer for n bytes of the connection id, preceded by their header if the mirror
+// is framed, and the offset of the data in it.
+func (m *Mirror) frame(id uint64, n int) ([]byte, int) {
+	if !m.cfg.Framed {
+		return bsPool.Get(n), 0
+	}
+	b := bsPool.Get(mirrorHeaderLen + n)
+	binary.BigEndian.PutUint64(b, id)
+	binary.BigEndian.PutUint32(b[8:], uint32(n))
+	return b, mirrorHeaderLen
+}
+
+func (m *Mirror) size(n int) int {
+	if m.cfg.Framed {
+		return mirrorHeaderLen + n
+	}
+	return n
+}
+
+// reserve reserves room in the queue for a chunk of n bytes of a connection, it reports false if
+// the chunk must be dropped.
+func (m *Mirror) reserve(n int) bool {
+	m.mu.Lock()
+	ok := !m.closed && m.queued+m.size(n) <= m.maxQueued()
+	if ok {
+		m.queued += m.size(n)
+	}
+	m.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&m.dropped, uint64(n))
+	}
+	return ok
+}
+
+// push queues a chunk for which room for reserved bytes of a connection has been reserved, and
+// starts writing the queue on ringNet if no write is in progress.
+func (m *Mirror) push(ringNet *URingNet, reserved int, c mirrorChunk) {
+	m.mu.Lock()
+	m.queued -= m.size(reserved) - m.size(c.data)
+	m.queue = append(m.queue, c)
+	start := !m.writing
+	m.writing = true
+	m.mu.Unlock()
+	if start {
+		m.next(ringNet)
+	}
+}
+
+// unreserve gives back the room reserved for a chunk of n bytes which is dropped.
+func (m *Mirror) unreserve(n int) {
+	m.mu.Lock()
+	m.queued -= m.size(n)
+	m.mu.Unlock()
+	atomic.AddUint64(&m.dropped, uint64(n))
+}
+
+// copyChunk queues a copy of b, read from the connection id.
+func (m *Mirror) copyChunk(ringNet *URingNet, id uint64, b []byte) {
+	if len(b) == 0 || !m.reserve(len(b)) {
+		return
+	}
+	buf, off := m.frame(id, len(b))
+	copy(buf[off:], b)
+	m.push(ringNet, len(b), mirrorChunk{buf: buf, data: len(b)})
+}
+
+// pipeChunk queues n bytes of the connection id teed into p, for which room has been reserved.
+func (m *Mirror) pipeChunk(ringNet *URingNet, id uint64, p *mirrorPipe, reserved, n int) {
+	c := mirrorChunk{pipe: p, n: n, data: n}
+	if m.cfg.Framed {
+		c.buf, _ = m.frame(id, 0)
+		binary.BigEndian.PutUint32(c.buf[8:], uint32(n))
+	}
+	atomic.AddInt32(&p.refs, 1)
+	m.push(ringNet, reserved, c)
+}
+
+// next writes the first chunk of the queue, or ends the writing if it is empty. The chunks of a
+// pipe which could not be written to the sink are dropped, the pipe no longer holds their data.
+func (m *Mirror) next(ringNet *URingNet) {
+	m.mu.Lock()
+	for len(m.queue) > 0 && m.queue[0].pipe != nil && atomic.LoadInt32(&m.queue[0].pipe.broken) != 0 {
+		m.drop()
+	}
+	if len(m.queue) == 0 {
+		m.writing = false
+		closed := m.closed
+		m.mu.Unlock()
+		if closed {
+			m.finish()
+		}
+		return
+	}
+	c := m.queue[0]
+	m.mu.Unlock()
+
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		switch {
+		case c.off < len(c.buf) && m.socket:
+			uring.Send(sqe, uintptr(m.fd), c.buf[c.off:], unix.MSG_NOSIGNAL)
+		case c.off < len(c.buf):
+			uring.Write(sqe, uintptr(m.fd), c.buf[c.off:])
+			// at the position of the file, which it advances.
+			sqe.SetOffset(^uint64(0))
+		default:
+			uring.Splice(sqe, int32(c.pipe.fds[0]), -1, int32(m.fd), -1, uint32(c.n), 0)
+		}
+	}, func(res int32, _ uint32) {
+		m.mu.Lock()
+		head := &m.queue[0]
+		if res <= 0 {
+			atomic.AddUint64(&m.errs, 1)
+			m.drop()
+			m.mu.Unlock()
+			m.next(ringNet)
+			return
+		}
+		m.queued -= int(res)
+		if head.off < len(head.buf) {
+			head.off += int(res)
+		} else {
+			head.n -= int(res)
+		}
+		if head.off == len(head.buf) && head.n == 0 {
+			atomic.AddUint64(&m.chunks, 1)
+			atomic.AddUint64(&m.bytes, uint64(head.data))
+			m.pop()
+		}
+		m.mu.Unlock()
+		m.next(ringNet)
+	})
+}
+
+// drop drops the first chunk of the queue, which has not been written entirely. m.mu is held.
+func (m *Mirror) drop() {
+	head := &m.queue[0]
+	if head.n > 0 {
+		// the rest of the chunk stays in the pipe, the chunks after it would write it.
+		atomic.StoreInt32(&head.pipe.broken, 1)
+	}
+	atomic.AddUint64(&m.dropped, uint64(head.data))
+	m.queued -= len(head.buf) - head.off + head.n
+	m.pop()
+}
+
+// pop removes the first chunk of the queue. m.mu is held.
+func (m *Mirror) pop() {
+	head := &m.queue[0]
+	if head.buf != nil {
+		bsPool.Put(head.buf)
+	}
+	if head.pipe != nil {
+		head.pipe.release()
+	}
+	m.queue[0] = mirrorChunk{}
+	m.queue = m.queue[1:]
+}
+
+// mirrorPipe is the pipe a relay in splice mode tees the data of a connection into. It is
+// closed once the relay and the chunks queued from it are done with it.
+type mirrorPipe struct {
+	fds    [2]int
+	refs   int32
+	broken int32 // a chunk was dropped, the pipe holds data which is not queued, accessed atomically
+}
+
+func newMirrorPipe() (*mirrorPipe, error) {
+	p := &mirrorPipe{refs: 1}
+	if err := unix.Pipe2(p.fds[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	// it holds the chunks of the connection waiting for the sink, the default size is used if the
+	// limit of the system is lower.
+	_, _ = unix.FcntlInt(uintptr(p.fds[1]), unix.F_SETPIPE_SZ, sendFilePipeSize)
+	return p, nil
+}
+
+func (p *mirrorPipe) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		_ = unix.Close(p.fds[0])
+		_ = unix.Close(p.fds[1])
+	}
+}
+
+// SetMirror mirrors the inbound stream of every connection of the loop to m, nil stops it. It is
+// safe to call from any goroutine. A mirror set for a connection by MirrorConn takes precedence.
+func (loop *Ringloop) SetMirror(m *Mirror) {
+	loop.mirror.Store(mirrorRef{m})
+}
+
+// mirrorRef wraps a mirror in an atomic.Value, which cannot store nil.
+type mirrorRef struct{ m *Mirror }
+
+// MirrorConn mirrors the inbound stream of the connection id of the loop to m, nil stops it;
+// the mirror of the loop, if any, then applies. It is safe to call from any goroutine, the
+// mirror applies to the data read once the ring owning the connection has run the change.
+func (loop *Ringloop) MirrorConn(id uint64, m *Mirror) error {
+	v, ok := loop.connections.Load(id)
+	if !ok {
+		return errors.ErrConnectionNotFound
+	}
+	c := v.(*conn)
+	return c.ring.Trigger(func() {
+		c.mirror = m
+	})
+}
+
+// mirrorOf returns the mirror of the connection c, nil if it is not mirrored.
+func (ringNet *URingNet) mirrorOf(c *conn) *Mirror {
+	if c.mirror != nil {
+		return c.mirror
+	}
+	if ringNet.ringloop != nil {
+		if ref, ok := ringNet.ringloop.mirror.Load().(mirrorRef); ok {
+			return ref.m
+		}
+	}
+	return nil
+}
+
+// mirrorIn mirrors b, read from the connection fd, if it is mirrored.
+func (ringNet *URingNet) mirrorIn(fd int32, b []byte) {
+	c := ringNet.conns[fd]
+	if c == nil {
+		return
+	}
+	if m := ringNet.mirrorOf(c); m != nil {
+		m.copyChunk(ringNet, c.id, b)
+	}
+}
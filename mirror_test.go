@@ -0,0 +1,109 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/y001j/uringnet/errors"
+)
+
+func ping(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != msg {
+		t.Fatalf("expect the echo of %q, got %q, %v", msg, buf, err)
+	}
+}
+
+func fileMirror(t *testing.T, framed bool) (*Mirror, string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "capture")
+	m, err := NewFileMirror(name, MirrorConfig{Framed: framed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m, name
+}
+
+func TestMirror(t *testing.T) {
+	loop, clients, ids := startEcho(t, 1, 2)
+
+	// every connection of the listener, framed.
+	all, allName := fileMirror(t, true)
+	loop.SetMirror(all)
+	ping(t, clients[0], "first")
+	ping(t, clients[1], "second")
+	waitUntil(t, func() bool { return all.Stats().Bytes == 11 })
+	loop.SetMirror(nil)
+	ping(t, clients[0], "unmirrored")
+
+	// a single connection.
+	one, oneName := fileMirror(t, false)
+	if err := loop.MirrorConn(ids[1], one); err != nil {
+		t.Fatal(err)
+	}
+	ping(t, clients[0], "other")
+	ping(t, clients[1], "mirrored")
+	waitUntil(t, func() bool { return one.Stats().Bytes == 8 })
+	if err := loop.MirrorConn(ids[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	ping(t, clients[1], "stopped")
+	if err := loop.MirrorConn(1<<62, one); err != errors.ErrConnectionNotFound {
+		t.Fatalf("expect ErrConnectionNotFound, got %v", err)
+	}
+
+	if stats := all.Stats(); stats.Chunks != 2 || stats.DroppedBytes != 0 || stats.Errors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	captured, err := os.ReadFile(allName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, msg := range []string{"first", "second"} {
+		if len(captured) < mirrorHeaderLen {
+			t.Fatalf("expect a frame for %q", msg)
+		}
+		id, n := binary.BigEndian.Uint64(captured), int(binary.BigEndian.Uint32(captured[8:]))
+		captured = captured[mirrorHeaderLen:]
+		if id != ids[i] || n != len(msg) || string(captured[:n]) != msg {
+			t.Fatalf("expect the frame of %q from %d, got %d bytes %q from %d", msg, ids[i], n, captured[:n], id)
+		}
+		captured = captured[n:]
+	}
+	if len(captured) != 0 {
+		t.Fatalf("unexpected capture %q", captured)
+	}
+	if captured, err = os.ReadFile(oneName); err != nil || !bytes.Equal(captured, []byte("mirrored")) {
+		t.Fatalf("expect the capture of the connection, got %q, %v", captured, err)
+	}
+}
+
+func TestMirrorQueueLimit(t *testing.T) {
+	loop, clients, _ := startEcho(t, 1, 1)
+	name := filepath.Join(t.TempDir(), "capture")
+	m, err := NewFileMirror(name, MirrorConfig{MaxQueued: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	loop.SetMirror(m)
+	defer loop.SetMirror(nil)
+	ping(t, clients[0], "too large")
+	ping(t, clients[0], "fits")
+	waitUntil(t, func() bool { return m.Stats().Bytes == 4 })
+	if stats := m.Stats(); stats.DroppedBytes != 9 {
+		t.Fatalf("expect the chunk over the limit to be dropped, got %+v", stats)
+	}
+}
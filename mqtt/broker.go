@@ -0,0 +1,424 @@
+//go:build linux
+
+// Package mqtt implements an MQTT 3.1.1 and 5.0 broker running natively on UringNet rings.
+//
+// The broker supports QoS 0 and 1, retained messages, will messages, wildcard subscriptions and
+// persistent sessions. Keep alives are enforced with timeouts of the rings, no goroutine is
+// started per connection:
+//
+//	b := &mqtt.Broker{Addr: ":1883"}
+//	log.Fatal(b.ListenAndServe())
+//
+// Subscriptions asking for QoS 2 are granted QoS 1, and a client publishing with QoS 2 is
+// disconnected. Shared subscriptions, subscription identifiers, topic aliases and will delays
+// are not supported.
+package mqtt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+const (
+	// DefaultMaxPacketBytes is the maximum permitted size of a packet received from a client.
+	DefaultMaxPacketBytes = 256 << 10 // 256 KB
+
+	// DefaultMaxQueuedMessages is the maximum number of QoS 1 messages queued for a session,
+	// while its client is offline or has too many unacknowledged messages.
+	DefaultMaxQueuedMessages = 1000
+
+	ringSize    = 4096
+	ringBuffers = 4096
+)
+
+// A Broker defines parameters for running an MQTT broker on UringNet.
+type Broker struct {
+	// Addr specifies the TCP address for the broker to listen on, ":1883" if empty.
+	Addr string
+
+	// Authenticate returns true if the client may connect, every client is accepted if nil.
+	// It runs on the goroutine of the ring serving the client.
+	Authenticate func(clientID, username string, password []byte) bool
+
+	// MaxPacketBytes limits the size of the packets sent by the clients, DefaultMaxPacketBytes is used if it is zero.
+	MaxPacketBytes int
+
+	// MaxQueuedMessages limits the QoS 1 messages queued for a session, DefaultMaxQueuedMessages is
+	// used if it is zero. The messages published while the queue is full are dropped for the session.
+	MaxQueuedMessages int
+
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+
+	// ErrorLog specifies an optional logger for the errors of the rings and for rejected clients, it is
+	// used when Logger is nil.
+	ErrorLog *log.Logger
+
+	// Logger logs the events of the rings and the rejected clients, with the fields of the connection.
+	// ErrorLog is used when it is nil, logging.Default when both are.
+	Logger logging.Logger
+
+	initOnce sync.Once
+	mu       sync.RWMutex        // guards the fields below
+	sessions map[string]*session // by client ID
+	subs     topicTree
+	retained map[string]*message // by topic
+}
+
+// ListenAndServe listens on b.Addr and serves MQTT clients on the rings. It only returns on setup errors.
+func (b *Broker) ListenAndServe() error {
+	b.init()
+	addr := b.Addr
+	if addr == "" {
+		addr = ":1883"
+	}
+	rings := b.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp, Address: addr}, ringSize, b.SQPoll, rings, options, nil)
+	if err != nil {
+		return err
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Logger = b.logger()
+		ringNet.Handler = &ringHandler{b: b, ring: ringNet, conns: make(map[int32]*conn)}
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	loop.RunMany2()
+	select {}
+}
+
+// Publish publishes a message to the subscribers of topic, as if a client had published it.
+// It is safe to call from any goroutine.
+func (b *Broker) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if !ValidTopicName(topic) {
+		return errors.New("mqtt: invalid topic name")
+	}
+	if qos > 1 {
+		return errors.New("mqtt: QoS 2 is not supported")
+	}
+	b.init()
+	b.publish(&message{topic: topic, payload: append([]byte(nil), payload...), qos: qos, retain: retain}, "")
+	return nil
+}
+
+func (b *Broker) init() {
+	b.initOnce.Do(func() {
+		b.sessions = make(map[string]*session)
+		b.retained = make(map[string]*message)
+	})
+}
+
+func (b *Broker) maxPacketBytes() int {
+	if b.MaxPacketBytes > 0 {
+		return b.MaxPacketBytes
+	}
+	return DefaultMaxPacketBytes
+}
+
+func (b *Broker) maxQueuedMessages() int {
+	if b.MaxQueuedMessages > 0 {
+		return b.MaxQueuedMessages
+	}
+	return DefaultMaxQueuedMessages
+}
+
+// logger returns Logger, or ErrorLog for errors, or the default logger.
+func (b *Broker) logger() logging.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	if b.ErrorLog != nil {
+		return logging.NewStd(b.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// publish stores msg if it is retained and delivers it to the matching subscriptions.
+// from is the client ID of the publisher, the subscriptions of the publisher with NoLocal are skipped.
+func (b *Broker) publish(msg *message, from string) {
+	if msg.retain {
+		b.mu.Lock()
+		if len(msg.payload) == 0 {
+			delete(b.retained, msg.topic)
+		} else {
+			b.retained[msg.topic] = msg
+		}
+		b.mu.Unlock()
+	}
+
+	type target struct {
+		s   *session
+		sub Subscription
+	}
+	var targets []target
+	b.mu.RLock()
+	b.subs.match(msg.topic, func(clientID string, sub Subscription) {
+		if sub.NoLocal && clientID == from {
+			return
+		}
+		s := b.sessions[clientID]
+		if s == nil {
+			return
+		}
+		// a client with overlapping subscriptions receives the message once, with the maximum QoS.
+		for i := range targets {
+			if targets[i].s == s {
+				if sub.QoS > targets[i].sub.QoS {
+					targets[i].sub.QoS = sub.QoS
+				}
+				targets[i].sub.RetainAsPublished = targets[i].sub.RetainAsPublished || sub.RetainAsPublished
+				return
+			}
+		}
+		targets = append(targets, target{s, sub})
+	})
+	b.mu.RUnlock()
+
+	for _, t := range targets {
+		qos := msg.qos
+		if t.sub.QoS < qos {
+			qos = t.sub.QoS
+		}
+		t.s.deliver(msg, qos, msg.retain && t.sub.RetainAsPublished)
+	}
+}
+
+// attach binds c to the session of its client ID, which is created or resumed, and queues the
+// CONNACK. The client connected with the same ID is taken over.
+func (b *Broker) attach(c *conn, p *Connect) {
+	expiry := uint32(0)
+	if p.ProtocolLevel >= Version5 {
+		if p.Properties.SessionExpiry != nil {
+			expiry = *p.Properties.SessionExpiry
+		}
+	} else if !p.CleanStart {
+		expiry = neverExpire
+	}
+
+	b.mu.Lock()
+	s := b.sessions[p.ClientID]
+	present := false
+	if s != nil {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.takeOver()
+			s.conn = nil
+		}
+		if s.expiryTimer != nil {
+			s.expiryTimer.Stop()
+			s.expiryTimer = nil
+		}
+		if p.CleanStart || s.version != p.ProtocolLevel {
+			s.mu.Unlock()
+			b.discardLocked(s)
+			s = nil
+		} else {
+			present = true
+			s.mu.Unlock()
+		}
+	}
+	if s == nil {
+		s = &session{b: b, clientID: p.ClientID, version: p.ProtocolLevel, subs: make(map[string]Subscription)}
+		b.sessions[p.ClientID] = s
+	}
+	s.mu.Lock()
+	b.mu.Unlock()
+	defer s.mu.Unlock()
+
+	s.expiry = expiry
+	s.conn = c
+	c.session = s
+
+	ack := &Connack{SessionPresent: present}
+	if c.version >= Version5 {
+		maxQoS, noShared, noSubIDs := byte(1), byte(0), byte(0)
+		maxPacket := uint32(b.maxPacketBytes())
+		ack.Properties = Properties{
+			MaximumQoS:         &maxQoS,
+			SharedSubAvailable: &noShared,
+			SubIDAvailable:     &noSubIDs,
+			MaximumPacketSize:  &maxPacket,
+			AssignedClientID:   c.assignedID,
+		}
+	}
+	// the CONNACK is queued while the session is locked, so that no message is sent before it.
+	c.writePacket(ack)
+	s.resume()
+}
+
+// sessionClosed handles the end of the connection of s, the session is discarded
+// or expires later depending on its expiry interval.
+func (b *Broker) sessionClosed(s *session, c *conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.mu.Lock()
+	if s.conn != c {
+		s.mu.Unlock()
+		return
+	}
+	s.conn = nil
+	expiry := s.expiry
+	switch {
+	case expiry == 0:
+		s.mu.Unlock()
+		b.discardLocked(s)
+	case expiry == neverExpire:
+		s.mu.Unlock()
+	default:
+		s.expiryTimer = time.AfterFunc(time.Duration(expiry)*time.Second, func() {
+			b.expire(s)
+		})
+		s.mu.Unlock()
+	}
+}
+
+// expire discards s if its client has not reconnected meanwhile.
+func (b *Broker) expire(s *session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.mu.Lock()
+	offline := s.conn == nil
+	s.mu.Unlock()
+	if offline && b.sessions[s.clientID] == s {
+		b.discardLocked(s)
+	}
+}
+
+// discardLocked removes s and its subscriptions, b.mu must be held.
+func (b *Broker) discardLocked(s *session) {
+	if b.sessions[s.clientID] == s {
+		delete(b.sessions, s.clientID)
+	}
+	s.mu.Lock()
+	for filter := range s.subs {
+		b.subs.remove(s.clientID, filter)
+	}
+	s.subs = nil
+	s.inflight, s.queue = nil, nil
+	s.mu.Unlock()
+}
+
+// subscribe adds the subscriptions of s, it returns the reason codes of the SUBACK and
+// the retained messages to send.
+func (b *Broker) subscribe(s *session, subs []Subscription) ([]byte, []retainedMessage) {
+	codes := make([]byte, len(subs))
+	var retained []retainedMessage
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		// the session has been discarded by a takeover.
+		for i := range codes {
+			codes[i] = UnspecifiedError
+		}
+		return codes, nil
+	}
+	for i, sub := range subs {
+		if sub.QoS > 1 {
+			sub.QoS = 1
+		}
+		_, existed := s.subs[sub.Filter]
+		s.subs[sub.Filter] = sub
+		b.subs.add(s.clientID, sub)
+		codes[i] = sub.QoS
+
+		if sub.RetainHandling == 2 || sub.RetainHandling == 1 && existed {
+			continue
+		}
+		for topic, msg := range b.retained {
+			if MatchTopic(sub.Filter, topic) {
+				qos := msg.qos
+				if sub.QoS < qos {
+					qos = sub.QoS
+				}
+				retained = append(retained, retainedMessage{msg, qos})
+			}
+		}
+	}
+	return codes, retained
+}
+
+// unsubscribe removes the subscriptions of s, it returns the reason codes of the UNSUBACK.
+func (b *Broker) unsubscribe(s *session, filters []string) []byte {
+	codes := make([]byte, len(filters))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, filter := range filters {
+		if _, ok := s.subs[filter]; !ok {
+			codes[i] = NoSubscriptionExisted
+			continue
+		}
+		delete(s.subs, filter)
+		b.subs.remove(s.clientID, filter)
+	}
+	return codes
+}
+
+// ringHandler serves the connections of one ring, its callbacks are only invoked by the goroutine running the ring.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	b     *Broker
+	ring  *uringnet.URingNet
+	conns map[int32]*conn
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	c := &conn{h: h, fd: data.Fd, remoteAddr: h.ring.RemoteAddr(data.Fd)}
+	h.conns[data.Fd] = c
+	c.timer = h.ring.AfterFunc(connectTimeout, func() {
+		if !c.connected {
+			c.closing = true
+			c.shutdown()
+		}
+	})
+	return nil, uringnet.None
+}
+
+func (h *ringHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	c := h.conns[data.Fd]
+	if c == nil {
+		return uringnet.Close
+	}
+	c.lastSeen = time.Now()
+	c.in = append(c.in, data.Buffer[:data.BufSize]...)
+	c.readPackets()
+	c.flush()
+	return uringnet.Read
+}
+
+func (h *ringHandler) OnClose(data uringnet.UserData) uringnet.Action {
+	if c := h.conns[data.Fd]; c != nil {
+		delete(h.conns, data.Fd)
+		c.onClose()
+	}
+	return uringnet.None
+}
+
+// newClientID returns a unique client ID for a client which connected without one.
+func newClientID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "uringnet-" + hex.EncodeToString(b[:])
+}
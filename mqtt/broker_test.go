@@ -0,0 +1,389 @@
+//go:build linux
+
+package mqtt
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAddr = "127.0.0.1:18933"
+
+var (
+	startOnce  sync.Once
+	testBroker *Broker
+)
+
+func startBroker(t *testing.T) {
+	t.Helper()
+	startOnce.Do(func() {
+		testBroker = &Broker{
+			Addr:  testAddr,
+			Rings: 2,
+			Authenticate: func(clientID, username string, password []byte) bool {
+				return username != "intruder"
+			},
+			ErrorLog: log.New(io.Discard, "", 0),
+		}
+		go func() { t.Error(testBroker.ListenAndServe()) }()
+	})
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp4", testAddr); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("broker did not start")
+}
+
+type client struct {
+	net.Conn
+	version byte
+	buf     []byte
+}
+
+func dial(t *testing.T, version byte) *client {
+	t.Helper()
+	startBroker(t)
+	conn, err := net.Dial("tcp4", testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{Conn: conn, version: version}
+}
+
+// connect dials the broker and sends p, it returns the client with the CONNACK.
+func connect(t *testing.T, p *Connect) (*client, *Connack) {
+	t.Helper()
+	p.ProtocolName = "MQTT"
+	c := dial(t, p.ProtocolLevel)
+	c.send(t, p)
+	ack, ok := c.read(t).(*Connack)
+	if !ok {
+		t.Fatalf("expect a CONNACK")
+	}
+	return c, ack
+}
+
+func (c *client) send(t *testing.T, p Packet) {
+	t.Helper()
+	if _, err := c.Write(AppendPacket(nil, p, c.version)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) read(t *testing.T) Packet {
+	t.Helper()
+	for {
+		p, n, err := ParsePacket(c.buf, c.version, maxRemainingLength)
+		if err == nil {
+			// the packet refers to the buffer, which is reused.
+			c.buf = append([]byte(nil), c.buf[n:]...)
+			return p
+		}
+		if err != ErrNeedMore {
+			t.Fatal(err)
+		}
+		var b [4096]byte
+		m, err := c.Read(b[:])
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		c.buf = append(c.buf, b[:m]...)
+	}
+}
+
+// expectClosed reads until the broker closes the connection, it returns the packets received meanwhile.
+func (c *client) expectClosed(t *testing.T) []Packet {
+	t.Helper()
+	var packets []Packet
+	for {
+		p, n, err := ParsePacket(c.buf, c.version, maxRemainingLength)
+		if err == nil {
+			c.buf = c.buf[n:]
+			packets = append(packets, p)
+			continue
+		}
+		var b [4096]byte
+		m, err := c.Read(b[:])
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatalf("expect the connection to be closed, got %v", err)
+		}
+		c.buf = append(c.buf, b[:m]...)
+	}
+}
+
+func (c *client) subscribe(t *testing.T, subs ...Subscription) []byte {
+	t.Helper()
+	c.send(t, &Subscribe{PacketID: 1, Subscriptions: subs})
+	ack, ok := c.read(t).(*Suback)
+	if !ok || ack.PacketID != 1 {
+		t.Fatalf("expect a SUBACK")
+	}
+	return ack.ReasonCodes
+}
+
+func (c *client) expectPublish(t *testing.T, topic, payload string) *Publish {
+	t.Helper()
+	p, ok := c.read(t).(*Publish)
+	if !ok || p.Topic != topic || string(p.Payload) != payload {
+		t.Fatalf("expect a PUBLISH of %q to %q, got %+v", payload, topic, p)
+	}
+	return p
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	sub, ack := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "sub"})
+	defer sub.Close()
+	if ack.ReasonCode != 0 || ack.SessionPresent {
+		t.Fatalf("unexpected CONNACK %+v", ack)
+	}
+	codes := sub.subscribe(t, Subscription{Filter: "sensors/+/temp", QoS: 1}, Subscription{Filter: "sensors/#"},
+		Subscription{Filter: "bad/#/filter"}, Subscription{Filter: "q2/#", QoS: 2})
+	if string(codes) != "\x01\x00\x80\x01" {
+		t.Fatalf("unexpected SUBACK codes %x", codes)
+	}
+
+	pub, _ := connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, ClientID: "pub"})
+	defer pub.Close()
+	pub.send(t, &Publish{QoS: 1, PacketID: 9, Topic: "sensors/1/temp", Payload: []byte("21.5")})
+	if ack, ok := pub.read(t).(*Ack); !ok || ack.PacketType != PUBACK || ack.PacketID != 9 {
+		t.Fatalf("expect a PUBACK")
+	}
+	// the overlapping subscriptions deliver the message once, with the maximum QoS.
+	p := sub.expectPublish(t, "sensors/1/temp", "21.5")
+	if p.QoS != 1 || p.PacketID == 0 || p.Retain {
+		t.Fatalf("unexpected PUBLISH %+v", p)
+	}
+	sub.send(t, &Ack{PacketType: PUBACK, PacketID: p.PacketID})
+
+	pub.send(t, &Publish{Topic: "sensors/1/humidity", Payload: []byte("40")})
+	if p := sub.expectPublish(t, "sensors/1/humidity", "40"); p.QoS != 0 {
+		t.Fatalf("expect QoS 0, got %d", p.QoS)
+	}
+
+	sub.send(t, &Unsubscribe{PacketID: 2, Filters: []string{"sensors/#", "nothing"}})
+	if ack, ok := sub.read(t).(*Unsuback); !ok || ack.PacketID != 2 {
+		t.Fatalf("expect an UNSUBACK")
+	}
+	pub.send(t, &Publish{Topic: "sensors/2/humidity", Payload: []byte("41")})
+	pub.send(t, &Publish{Topic: "sensors/2/temp", Payload: []byte("22")})
+	sub.expectPublish(t, "sensors/2/temp", "22")
+
+	sub.send(t, &Pingreq{})
+	if _, ok := sub.read(t).(*Pingresp); !ok {
+		t.Fatal("expect a PINGRESP")
+	}
+}
+
+func TestRetained(t *testing.T) {
+	pub, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "retain-pub"})
+	defer pub.Close()
+	pub.send(t, &Publish{Retain: true, Topic: "status/door", Payload: []byte("open")})
+	pub.send(t, &Pingreq{})
+	pub.read(t)
+
+	sub, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "retain-sub"})
+	defer sub.Close()
+	sub.subscribe(t, Subscription{Filter: "status/+", QoS: 1})
+	if p := sub.expectPublish(t, "status/door", "open"); !p.Retain {
+		t.Fatal("expect the retain flag on a retained message")
+	}
+
+	// messages delivered to existing subscriptions are not flagged as retained.
+	pub.send(t, &Publish{Retain: true, Topic: "status/door", Payload: []byte("closed")})
+	if p := sub.expectPublish(t, "status/door", "closed"); p.Retain {
+		t.Fatal("expect no retain flag on a live message")
+	}
+
+	// an empty payload deletes the retained message.
+	pub.send(t, &Publish{Retain: true, Topic: "status/door"})
+	sub.expectPublish(t, "status/door", "")
+	sub2, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "retain-sub2"})
+	defer sub2.Close()
+	sub2.subscribe(t, Subscription{Filter: "status/+"})
+	sub2.send(t, &Pingreq{})
+	if _, ok := sub2.read(t).(*Pingresp); !ok {
+		t.Fatal("expect no retained message")
+	}
+}
+
+func TestWill(t *testing.T) {
+	sub, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "will-sub"})
+	defer sub.Close()
+	sub.subscribe(t, Subscription{Filter: "will/#"})
+
+	// a normal DISCONNECT discards the will.
+	c, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "will-1",
+		Will: &Will{Topic: "will/1", Payload: []byte("lost")}})
+	c.send(t, &Disconnect{})
+	c.expectClosed(t)
+
+	c, _ = connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "will-2",
+		Will: &Will{Topic: "will/2", Payload: []byte("lost")}})
+	c.Close()
+	sub.expectPublish(t, "will/2", "lost")
+
+	c, _ = connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, ClientID: "will-3",
+		Will: &Will{Topic: "will/3", Payload: []byte("bye")}})
+	c.send(t, &Disconnect{ReasonCode: DisconnectWithWill})
+	c.expectClosed(t)
+	sub.expectPublish(t, "will/3", "bye")
+}
+
+func TestKeepAlive(t *testing.T) {
+	c, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "idle", KeepAlive: 1,
+		Will: &Will{Topic: "idle/gone", Payload: []byte("timeout")}})
+	sub, _ := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "idle-sub"})
+	defer sub.Close()
+	sub.subscribe(t, Subscription{Filter: "idle/#"})
+
+	start := time.Now()
+	c.expectClosed(t)
+	if d := time.Since(start); d > 2500*time.Millisecond {
+		t.Fatalf("expect the connection to be closed after 1.5s, took %v", d)
+	}
+	sub.expectPublish(t, "idle/gone", "timeout")
+}
+
+func TestPersistentSession(t *testing.T) {
+	c, ack := connect(t, &Connect{ProtocolLevel: Version311, ClientID: "persistent"})
+	if ack.SessionPresent {
+		t.Fatal("expect no session")
+	}
+	c.subscribe(t, Subscription{Filter: "jobs/#", QoS: 1})
+	c.send(t, &Disconnect{})
+	c.expectClosed(t)
+
+	if err := testBroker.Publish("jobs/1", []byte("build"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	_ = testBroker.Publish("jobs/2", []byte("drop"), 0, false)
+
+	c, ack = connect(t, &Connect{ProtocolLevel: Version311, ClientID: "persistent"})
+	if !ack.SessionPresent {
+		t.Fatal("expect the session to be resumed")
+	}
+	p := c.expectPublish(t, "jobs/1", "build")
+	// the message is not acknowledged, it is sent again with DUP to the next connection.
+	c.Close()
+	time.Sleep(50 * time.Millisecond)
+	c, _ = connect(t, &Connect{ProtocolLevel: Version311, ClientID: "persistent"})
+	if p2 := c.expectPublish(t, "jobs/1", "build"); !p2.Dup || p2.PacketID != p.PacketID {
+		t.Fatalf("expect a duplicate of %+v, got %+v", p, p2)
+	}
+	c.send(t, &Ack{PacketType: PUBACK, PacketID: p.PacketID})
+
+	// a clean start discards the session.
+	c.Close()
+	c, ack = connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "persistent"})
+	defer c.Close()
+	if ack.SessionPresent {
+		t.Fatal("expect the session to be discarded")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	expiry := uint32(1)
+	c, _ := connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, ClientID: "expiring",
+		Properties: Properties{SessionExpiry: &expiry}})
+	c.subscribe(t, Subscription{Filter: "x"})
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	c, ack := connect(t, &Connect{ProtocolLevel: Version5, ClientID: "expiring", Properties: Properties{SessionExpiry: &expiry}})
+	if !ack.SessionPresent {
+		t.Fatal("expect the session to be resumed")
+	}
+	c.Close()
+	time.Sleep(1500 * time.Millisecond)
+	c, ack = connect(t, &Connect{ProtocolLevel: Version5, ClientID: "expiring"})
+	defer c.Close()
+	if ack.SessionPresent {
+		t.Fatal("expect the session to have expired")
+	}
+}
+
+func TestMQTT5(t *testing.T) {
+	receiveMax := uint16(1)
+	c, ack := connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, Properties: Properties{ReceiveMaximum: &receiveMax}})
+	defer c.Close()
+	if ack.Properties.AssignedClientID == "" || ack.Properties.MaximumQoS == nil || *ack.Properties.MaximumQoS != 1 {
+		t.Fatalf("unexpected CONNACK properties %+v", ack.Properties)
+	}
+	codes := c.subscribe(t, Subscription{Filter: "flow/#", QoS: 1, NoLocal: true}, Subscription{Filter: "$share/g/x"})
+	if string(codes) != "\x01\x9e" {
+		t.Fatalf("unexpected SUBACK codes %x", codes)
+	}
+
+	// no local: the client does not receive its own messages.
+	c.send(t, &Publish{Topic: "flow/own", Payload: []byte("x")})
+
+	// the receive maximum holds back the second message until the first one is acknowledged.
+	_ = testBroker.Publish("flow/1", []byte("1"), 1, false)
+	_ = testBroker.Publish("flow/2", []byte("2"), 1, false)
+	p := c.expectPublish(t, "flow/1", "1")
+	c.send(t, &Pingreq{})
+	if _, ok := c.read(t).(*Pingresp); !ok {
+		t.Fatal("expect the second message to be held back")
+	}
+	c.send(t, &Ack{PacketType: PUBACK, PacketID: p.PacketID})
+	c.expectPublish(t, "flow/2", "2")
+
+	c.send(t, &Publish{QoS: 2, PacketID: 1, Topic: "flow/q2"})
+	packets := c.expectClosed(t)
+	if len(packets) != 1 || packets[0].(*Disconnect).ReasonCode != QoSNotSupported {
+		t.Fatalf("expect a DISCONNECT with QoS not supported, got %+v", packets)
+	}
+}
+
+func TestTakeOver(t *testing.T) {
+	first, _ := connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, ClientID: "twin"})
+	second, _ := connect(t, &Connect{ProtocolLevel: Version5, CleanStart: true, ClientID: "twin"})
+	defer second.Close()
+	packets := first.expectClosed(t)
+	if len(packets) != 1 || packets[0].(*Disconnect).ReasonCode != SessionTakenOver {
+		t.Fatalf("expect a DISCONNECT with session taken over, got %+v", packets)
+	}
+	second.send(t, &Pingreq{})
+	if _, ok := second.read(t).(*Pingresp); !ok {
+		t.Fatal("expect the new connection to be served")
+	}
+}
+
+func TestRefused(t *testing.T) {
+	c, ack := connect(t, &Connect{ProtocolLevel: Version311, CleanStart: true, ClientID: "x", UsernameFlag: true, Username: "intruder"})
+	if ack.ReasonCode != 0x04 {
+		t.Fatalf("expect bad user name or password, got %#x", ack.ReasonCode)
+	}
+	c.expectClosed(t)
+
+	c, ack = connect(t, &Connect{ProtocolLevel: Version311})
+	if ack.ReasonCode != 0x02 {
+		t.Fatalf("expect identifier rejected, got %#x", ack.ReasonCode)
+	}
+	c.expectClosed(t)
+
+	c = dial(t, Version311)
+	_, _ = c.Write(append([]byte{0x10, 0x0c}, "\x00\x04MQTT\x07\x02\x00\x00\x00\x00"...))
+	packets := c.expectClosed(t)
+	if len(packets) != 1 || packets[0].(*Connack).ReasonCode != 0x01 {
+		t.Fatalf("expect unacceptable protocol version, got %+v", packets)
+	}
+
+	// the first packet must be CONNECT.
+	c = dial(t, Version311)
+	c.send(t, &Pingreq{})
+	if packets := c.expectClosed(t); len(packets) != 0 {
+		t.Fatalf("expect no answer, got %+v", packets)
+	}
+}
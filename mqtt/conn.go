@@ -0,0 +1,450 @@
+//go:build linux
+
+package mqtt
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+)
+
+// connectTimeout is how long a client may stay connected without sending CONNECT.
+const connectTimeout = 10 * time.Second
+
+// conn is a network connection of a client served by a ring.
+type conn struct {
+	h          *ringHandler
+	fd         int32
+	remoteAddr string
+
+	// set on the ring goroutine before the connection is attached to its session, read-only afterwards
+	version      byte
+	clientID     string
+	assignedID   string // client ID assigned by the broker, sent in the CONNACK of MQTT 5.0
+	receiveMax   int    // maximum number of unacknowledged QoS 1 messages the client accepts
+	maxOutPacket int    // maximum size of the packets the client accepts
+
+	mu          sync.Mutex // guards the fields below
+	out         []byte     // encoded packets waiting to be sent
+	flushQueued bool       // a flush has been triggered on the ring
+	closed      bool       // nothing can be written anymore
+
+	// ring goroutine only
+	in             []byte // received bytes which have not been parsed yet
+	connected      bool
+	session        *session
+	will           *message // published if the connection is lost
+	keepAlive      time.Duration
+	lastSeen       time.Time
+	timer          *uringnet.Timer // connect or keep alive timer
+	closing        bool            // the connection is being closed, the packets received are ignored
+	sending        bool            // a send is in flight
+	shutAfterFlush bool            // shut the connection down once out has been sent
+	shut           bool            // the socket has been shut down
+	ringClosed     bool            // the ring has submitted the close of fd
+}
+
+// writePacket queues p, it is safe to call from any goroutine.
+func (c *conn) writePacket(p Packet) {
+	c.write(AppendPacket(nil, p, c.version))
+}
+
+// writePublish queues a PUBLISH of msg, it is dropped if it exceeds the maximum packet size of the client.
+func (c *conn) writePublish(msg *message, qos byte, retain bool, packetID uint16, dup bool) {
+	b := AppendPacket(nil, &Publish{
+		Dup:        dup,
+		QoS:        qos,
+		Retain:     retain,
+		Topic:      msg.topic,
+		PacketID:   packetID,
+		Properties: msg.props,
+		Payload:    msg.payload,
+	}, c.version)
+	if len(b) > c.maxOutPacket {
+		return
+	}
+	c.write(b)
+}
+
+// write queues b and triggers its sending on the ring.
+func (c *conn) write(b []byte) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.out = append(c.out, b...)
+	trigger := !c.flushQueued
+	c.flushQueued = true
+	c.mu.Unlock()
+	if trigger {
+		_ = c.h.ring.Trigger(c.flush)
+	}
+}
+
+// flush sends the queued packets, it runs on the ring goroutine. Only one send is in flight at
+// a time, its completion flushes the packets queued meanwhile.
+func (c *conn) flush() {
+	if c.ringClosed || c.sending {
+		return
+	}
+	c.mu.Lock()
+	buf := c.out
+	c.out, c.flushQueued = nil, false
+	c.mu.Unlock()
+	if len(buf) == 0 {
+		if c.shutAfterFlush {
+			c.shutdown()
+		}
+		return
+	}
+	c.sending = true
+	c.h.ring.SendAll(c.fd, buf, func(err error) {
+		if c.ringClosed {
+			return
+		}
+		c.sending = false
+		if err != nil {
+			c.shutdown()
+			return
+		}
+		c.flush()
+	})
+}
+
+// shutdown ends the connection once, the ring closes it when the read reports the end of the stream.
+func (c *conn) shutdown() {
+	if c.ringClosed || c.shut {
+		return
+	}
+	c.shut = true
+	_ = c.h.ring.ShutdownConn(c.fd)
+}
+
+// closeAfterFlush closes the connection once the queued packets are sent.
+func (c *conn) closeAfterFlush() {
+	c.closing = true
+	c.shutAfterFlush = true
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
+
+// fail closes the connection because of a protocol violation, MQTT 5.0 clients are sent a DISCONNECT with code.
+func (c *conn) fail(code byte) {
+	if c.closing {
+		return
+	}
+	if c.connected && c.version >= Version5 {
+		c.writePacket(&Disconnect{ReasonCode: code})
+	}
+	c.closeAfterFlush()
+}
+
+// refuse answers the CONNECT with a CONNACK carrying code and closes the connection.
+func (c *conn) refuse(code byte) {
+	c.writePacket(&Connack{ReasonCode: connackCode(c.version, code)})
+	c.closeAfterFlush()
+}
+
+// takeOver closes the connection because another one connected with the same client ID,
+// it is called by the ring of the new connection.
+func (c *conn) takeOver() {
+	if c.version >= Version5 {
+		c.writePacket(&Disconnect{ReasonCode: SessionTakenOver})
+	}
+	_ = c.h.ring.Trigger(func() {
+		c.closeAfterFlush()
+		c.flush()
+	})
+}
+
+// readPackets handles the complete packets buffered in c.in.
+func (c *conn) readPackets() {
+	off := 0
+	for !c.closing && off < len(c.in) {
+		p, n, err := ParsePacket(c.in[off:], c.version, c.h.b.maxPacketBytes())
+		if err == ErrNeedMore {
+			break
+		}
+		if err != nil {
+			if err == errBadVersion && !c.connected {
+				c.refuse(UnsupportedProtocolVersion)
+				break
+			}
+			c.fail(err.(*ReasonError).Code)
+			break
+		}
+		off += n
+		c.handle(p)
+	}
+	if c.closing {
+		c.in = nil
+		return
+	}
+	c.in = c.in[:copy(c.in, c.in[off:])]
+}
+
+func (c *conn) handle(p Packet) {
+	if !c.connected {
+		if p, ok := p.(*Connect); ok {
+			c.connect(p)
+		} else {
+			c.fail(ProtocolError)
+		}
+		return
+	}
+	switch p := p.(type) {
+	case *Publish:
+		c.publish(p)
+	case *Ack:
+		if p.PacketType != PUBACK {
+			// QoS 2 is never granted.
+			c.fail(ProtocolError)
+			return
+		}
+		c.session.ack(p.PacketID)
+	case *Subscribe:
+		c.subscribe(p)
+	case *Unsubscribe:
+		codes := c.h.b.unsubscribe(c.session, p.Filters)
+		c.writePacket(&Unsuback{PacketID: p.PacketID, ReasonCodes: codes})
+	case *Pingreq:
+		c.writePacket(&Pingresp{})
+	case *Disconnect:
+		c.disconnect(p)
+	default:
+		c.fail(ProtocolError)
+	}
+}
+
+func (c *conn) connect(p *Connect) {
+	b := c.h.b
+	c.version = p.ProtocolLevel
+	c.clientID = p.ClientID
+	c.receiveMax = 65535
+	c.maxOutPacket = maxRemainingLength + 5
+	if p.ProtocolLevel >= Version5 {
+		if v := p.Properties.ReceiveMaximum; v != nil {
+			if *v == 0 {
+				c.fail(ProtocolError)
+				return
+			}
+			c.receiveMax = int(*v)
+		}
+		if v := p.Properties.MaximumPacketSize; v != nil {
+			if *v == 0 {
+				c.fail(ProtocolError)
+				return
+			}
+			c.maxOutPacket = int(*v)
+		}
+	}
+	if c.clientID == "" {
+		if p.ProtocolLevel < Version5 && !p.CleanStart {
+			c.refuse(ClientIdentifierNotValid)
+			return
+		}
+		c.clientID = newClientID()
+		if p.ProtocolLevel >= Version5 {
+			c.assignedID = c.clientID
+		}
+	}
+	p.ClientID = c.clientID
+	if b.Authenticate != nil && !b.Authenticate(c.clientID, p.Username, p.Password) {
+		c.h.ring.ConnLogger(c.fd).Log(logging.LevelWarn, "client refused: bad user name or password",
+			"client", c.clientID, "remote", c.remoteAddr)
+		c.refuse(BadUserNameOrPassword)
+		return
+	}
+	if w := p.Will; w != nil {
+		if !ValidTopicName(w.Topic) {
+			c.refuse(TopicNameInvalid)
+			return
+		}
+		qos := w.QoS
+		if qos > 1 {
+			if p.ProtocolLevel >= Version5 {
+				c.refuse(QoSNotSupported)
+				return
+			}
+			qos = 1
+		}
+		c.will = &message{
+			topic:   w.Topic,
+			payload: append([]byte(nil), w.Payload...),
+			qos:     qos,
+			retain:  w.Retain,
+			props:   forwardedProperties(&w.Properties),
+		}
+	}
+
+	c.connected = true
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.keepAlive = time.Duration(p.KeepAlive) * time.Second
+	if c.keepAlive > 0 {
+		c.armKeepAlive(c.keepAlive * 3 / 2)
+	}
+	b.attach(c, p)
+}
+
+// armKeepAlive checks the keep alive of the client after d, with a timeout of the ring.
+func (c *conn) armKeepAlive(d time.Duration) {
+	c.timer = c.h.ring.AfterFunc(d, c.checkKeepAlive)
+}
+
+// checkKeepAlive closes the connection if nothing has been received for one and a half keep
+// alive periods (section 3.1.2.10).
+func (c *conn) checkKeepAlive() {
+	if c.ringClosed {
+		return
+	}
+	limit := c.keepAlive * 3 / 2
+	idle := time.Since(c.lastSeen)
+	if idle < limit {
+		c.armKeepAlive(limit - idle)
+		return
+	}
+	// the client is gone, there is no point in sending a DISCONNECT.
+	c.closing = true
+	c.shutdown()
+}
+
+func (c *conn) publish(p *Publish) {
+	switch {
+	case p.QoS > 1:
+		c.fail(QoSNotSupported)
+		return
+	case p.Properties.TopicAlias != nil:
+		c.fail(TopicAliasInvalid)
+		return
+	case !ValidTopicName(p.Topic):
+		c.fail(TopicNameInvalid)
+		return
+	}
+	// the payload refers to the receive buffer of the connection.
+	c.h.b.publish(&message{
+		topic:   p.Topic,
+		payload: append([]byte(nil), p.Payload...),
+		qos:     p.QoS,
+		retain:  p.Retain,
+		props:   forwardedProperties(&p.Properties),
+	}, c.clientID)
+	if p.QoS == 1 {
+		c.writePacket(&Ack{PacketType: PUBACK, PacketID: p.PacketID})
+	}
+}
+
+func (c *conn) subscribe(p *Subscribe) {
+	if len(p.Properties.SubscriptionIdentifier) > 0 {
+		c.fail(SubIDsNotSupported)
+		return
+	}
+	codes := make([]byte, len(p.Subscriptions))
+	var subs []Subscription
+	var index []int
+	for i, sub := range p.Subscriptions {
+		switch {
+		case !ValidTopicFilter(sub.Filter):
+			codes[i] = TopicFilterInvalid
+		case strings.HasPrefix(sub.Filter, "$share/"):
+			codes[i] = SharedSubNotSupported
+		default:
+			subs = append(subs, sub)
+			index = append(index, i)
+			continue
+		}
+		if c.version < Version5 {
+			codes[i] = 0x80 // failure
+		}
+	}
+	granted, retained := c.h.b.subscribe(c.session, subs)
+	for i, code := range granted {
+		codes[index[i]] = code
+	}
+	c.writePacket(&Suback{PacketID: p.PacketID, ReasonCodes: codes})
+	for _, r := range retained {
+		c.session.deliver(r.msg, r.qos, true)
+	}
+}
+
+func (c *conn) disconnect(p *Disconnect) {
+	if v := p.Properties.SessionExpiry; v != nil {
+		s := c.session
+		s.mu.Lock()
+		invalid := s.expiry == 0 && *v != 0
+		if !invalid {
+			s.expiry = *v
+		}
+		s.mu.Unlock()
+		if invalid {
+			c.fail(ProtocolError)
+			return
+		}
+	}
+	if p.ReasonCode != DisconnectWithWill {
+		c.will = nil
+	}
+	c.closeAfterFlush()
+}
+
+// onClose runs on the ring goroutine when the ring closes the socket.
+func (c *conn) onClose() {
+	c.ringClosed = true
+	c.mu.Lock()
+	c.closed = true
+	c.out = nil
+	c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if !c.connected {
+		return
+	}
+	if c.will != nil {
+		c.h.b.publish(c.will, c.clientID)
+	}
+	c.h.b.sessionClosed(c.session, c)
+}
+
+// forwardedProperties returns the properties of a published message which are forwarded to the subscribers.
+func forwardedProperties(p *Properties) Properties {
+	fp := Properties{
+		PayloadFormat:   p.PayloadFormat,
+		MessageExpiry:   p.MessageExpiry,
+		ContentType:     p.ContentType,
+		ResponseTopic:   p.ResponseTopic,
+		CorrelationData: p.CorrelationData,
+		User:            p.User,
+	}
+	if fp.CorrelationData != nil {
+		fp.CorrelationData = append([]byte(nil), fp.CorrelationData...)
+	}
+	return fp
+}
+
+// connackCode returns the CONNACK return code of MQTT 3.1.1 matching a reason code of MQTT 5.0.
+func connackCode(version, reason byte) byte {
+	if version >= Version5 {
+		return reason
+	}
+	switch reason {
+	case Success:
+		return 0x00
+	case UnsupportedProtocolVersion:
+		return 0x01
+	case ClientIdentifierNotValid:
+		return 0x02
+	case BadUserNameOrPassword:
+		return 0x04
+	case NotAuthorized:
+		return 0x05
+	}
+	return 0x03 // server unavailable
+}
@@ -0,0 +1,788 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Protocol levels sent in CONNECT.
+const (
+	Version311 byte = 4
+	Version5   byte = 5
+)
+
+// Control packet types.
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+	AUTH        byte = 15
+)
+
+// Reason codes of MQTT 5.0, the CONNACK return codes of MQTT 3.1.1 are mapped from them.
+const (
+	Success                     byte = 0x00
+	GrantedQoS1                 byte = 0x01
+	DisconnectWithWill          byte = 0x04
+	NoMatchingSubscribers       byte = 0x10
+	NoSubscriptionExisted       byte = 0x11
+	UnspecifiedError            byte = 0x80
+	MalformedPacket             byte = 0x81
+	ProtocolError               byte = 0x82
+	ImplementationSpecificError byte = 0x83
+	UnsupportedProtocolVersion  byte = 0x84
+	ClientIdentifierNotValid    byte = 0x85
+	BadUserNameOrPassword       byte = 0x86
+	NotAuthorized               byte = 0x87
+	ServerUnavailable           byte = 0x88
+	KeepAliveTimeout            byte = 0x8D
+	SessionTakenOver            byte = 0x8E
+	TopicFilterInvalid          byte = 0x8F
+	TopicNameInvalid            byte = 0x90
+	TopicAliasInvalid           byte = 0x94
+	PacketTooLarge              byte = 0x95
+	QoSNotSupported             byte = 0x9B
+	SharedSubNotSupported       byte = 0x9E
+	SubIDsNotSupported          byte = 0xA1
+)
+
+// maxRemainingLength is the largest remaining length a fixed header can encode.
+const maxRemainingLength = 268435455
+
+// ReasonError is a packet violating the protocol, the connection is closed with Code.
+type ReasonError struct {
+	Code byte
+	msg  string
+}
+
+func (e *ReasonError) Error() string {
+	return "mqtt: " + e.msg
+}
+
+var (
+	// ErrNeedMore means the buffer does not hold a complete packet yet.
+	ErrNeedMore = errors.New("mqtt: need more data")
+
+	errMalformed      = &ReasonError{MalformedPacket, "malformed packet"}
+	errProtocol       = &ReasonError{ProtocolError, "protocol error"}
+	errPacketTooLarge = &ReasonError{PacketTooLarge, "packet too large"}
+	errBadVersion     = &ReasonError{UnsupportedProtocolVersion, "unsupported protocol version"}
+)
+
+// Packet is an MQTT control packet.
+type Packet interface {
+	Type() byte
+}
+
+// Will is the will message of a client, published when it disconnects abnormally.
+type Will struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties Properties
+}
+
+// Connect is the CONNECT packet.
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanStart    bool
+	KeepAlive     uint16
+	Properties    Properties
+	ClientID      string
+	Will          *Will
+	UsernameFlag  bool
+	Username      string
+	PasswordFlag  bool
+	Password      []byte
+}
+
+// Connack is the CONNACK packet.
+type Connack struct {
+	SessionPresent bool
+	ReasonCode     byte
+	Properties     Properties
+}
+
+// Publish is the PUBLISH packet.
+type Publish struct {
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Properties Properties
+	Payload    []byte
+}
+
+// Ack is a PUBACK, PUBREC, PUBREL or PUBCOMP packet.
+type Ack struct {
+	PacketType byte
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+// Subscription is a topic filter of a SUBSCRIBE packet with its options.
+type Subscription struct {
+	Filter            string
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+// Subscribe is the SUBSCRIBE packet.
+type Subscribe struct {
+	PacketID      uint16
+	Properties    Properties
+	Subscriptions []Subscription
+}
+
+// Suback is the SUBACK packet, the reason codes are the granted QoS or failures.
+type Suback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+// Unsubscribe is the UNSUBSCRIBE packet.
+type Unsubscribe struct {
+	PacketID   uint16
+	Properties Properties
+	Filters    []string
+}
+
+// Unsuback is the UNSUBACK packet, MQTT 3.1.1 has no reason codes.
+type Unsuback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+// Pingreq is the PINGREQ packet.
+type Pingreq struct{}
+
+// Pingresp is the PINGRESP packet.
+type Pingresp struct{}
+
+// Disconnect is the DISCONNECT packet.
+type Disconnect struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+// Auth is the AUTH packet of MQTT 5.0.
+type Auth struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+func (*Connect) Type() byte     { return CONNECT }
+func (*Connack) Type() byte     { return CONNACK }
+func (*Publish) Type() byte     { return PUBLISH }
+func (a *Ack) Type() byte       { return a.PacketType }
+func (*Subscribe) Type() byte   { return SUBSCRIBE }
+func (*Suback) Type() byte      { return SUBACK }
+func (*Unsubscribe) Type() byte { return UNSUBSCRIBE }
+func (*Unsuback) Type() byte    { return UNSUBACK }
+func (*Pingreq) Type() byte     { return PINGREQ }
+func (*Pingresp) Type() byte    { return PINGRESP }
+func (*Disconnect) Type() byte  { return DISCONNECT }
+func (*Auth) Type() byte        { return AUTH }
+
+// ParsePacket parses the packet at the beginning of buf and returns it with its length in buf.
+// version is the protocol level of the connection, which tells whether packets have properties,
+// it is ignored for CONNECT which carries it. The strings and payloads refer to buf.
+//
+// It returns ErrNeedMore if buf does not hold the complete packet yet, or a *ReasonError if
+// the packet is malformed or longer than maxSize.
+func ParsePacket(buf []byte, version byte, maxSize int) (Packet, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, ErrNeedMore
+	}
+	r := &reader{buf: buf[1:]}
+	length, err := r.varint()
+	if err == ErrNeedMore {
+		return nil, 0, ErrNeedMore
+	}
+	if err != nil {
+		return nil, 0, errMalformed
+	}
+	header := len(buf) - len(r.buf)
+	size := header + int(length)
+	if size > maxSize {
+		return nil, 0, errPacketTooLarge
+	}
+	if len(buf) < size {
+		return nil, 0, ErrNeedMore
+	}
+	r.buf = r.buf[:length]
+
+	typ, flags := buf[0]>>4, buf[0]&0x0f
+	if typ == PUBLISH {
+		p, err := parsePublish(r, flags, version)
+		return p, size, err
+	}
+	expected := byte(0)
+	if typ == PUBREL || typ == SUBSCRIBE || typ == UNSUBSCRIBE {
+		expected = 2
+	}
+	if flags != expected {
+		return nil, 0, errMalformed
+	}
+
+	var p Packet
+	switch typ {
+	case CONNECT:
+		p, err = parseConnect(r)
+	case CONNACK:
+		p, err = parseConnack(r, version)
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
+		p, err = parseAck(r, typ, version)
+	case SUBSCRIBE:
+		p, err = parseSubscribe(r, version)
+	case SUBACK:
+		sa := &Suback{}
+		p = sa
+		if sa.PacketID, err = r.uint16(); err == nil {
+			if sa.Properties, err = r.properties(version); err == nil {
+				sa.ReasonCodes, r.buf = r.buf, nil
+			}
+		}
+	case UNSUBSCRIBE:
+		p, err = parseUnsubscribe(r, version)
+	case UNSUBACK:
+		ua := &Unsuback{}
+		p = ua
+		if ua.PacketID, err = r.uint16(); err == nil && version >= Version5 {
+			if ua.Properties, err = r.properties(version); err == nil {
+				ua.ReasonCodes, r.buf = r.buf, nil
+			}
+		}
+	case PINGREQ:
+		p = &Pingreq{}
+	case PINGRESP:
+		p = &Pingresp{}
+	case DISCONNECT:
+		d := &Disconnect{}
+		p = d
+		if version >= Version5 && len(r.buf) > 0 {
+			if d.ReasonCode, err = r.byte(); err == nil && len(r.buf) > 0 {
+				d.Properties, err = parseProperties(r)
+			}
+		}
+	case AUTH:
+		if version < Version5 {
+			return nil, 0, errMalformed
+		}
+		a := &Auth{}
+		p = a
+		if len(r.buf) > 0 {
+			if a.ReasonCode, err = r.byte(); err == nil && len(r.buf) > 0 {
+				a.Properties, err = parseProperties(r)
+			}
+		}
+	default:
+		return nil, 0, errMalformed
+	}
+	if err == nil && len(r.buf) > 0 {
+		err = errMalformed
+	}
+	if err != nil {
+		return nil, 0, asReasonError(err)
+	}
+	return p, size, nil
+}
+
+func asReasonError(err error) error {
+	if _, ok := err.(*ReasonError); ok {
+		return err
+	}
+	return errMalformed
+}
+
+func parseConnect(r *reader) (*Connect, error) {
+	c := &Connect{}
+	var err error
+	if c.ProtocolName, err = r.string(); err != nil {
+		return nil, err
+	}
+	if c.ProtocolLevel, err = r.byte(); err != nil {
+		return nil, err
+	}
+	switch {
+	case c.ProtocolName == "MQTT" && (c.ProtocolLevel == Version311 || c.ProtocolLevel == Version5):
+	case c.ProtocolName == "MQIsdp" && c.ProtocolLevel == 3:
+	default:
+		return nil, errBadVersion
+	}
+	flags, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if flags&0x01 != 0 {
+		return nil, errMalformed
+	}
+	c.CleanStart = flags&0x02 != 0
+	willFlag := flags&0x04 != 0
+	willQoS := flags >> 3 & 0x03
+	willRetain := flags&0x20 != 0
+	c.PasswordFlag = flags&0x40 != 0
+	c.UsernameFlag = flags&0x80 != 0
+	if !willFlag && (willQoS != 0 || willRetain) || willQoS > 2 {
+		return nil, errMalformed
+	}
+	if c.KeepAlive, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if c.Properties, err = r.properties(c.ProtocolLevel); err != nil {
+		return nil, err
+	}
+	if c.ClientID, err = r.string(); err != nil {
+		return nil, err
+	}
+	if willFlag {
+		w := &Will{QoS: willQoS, Retain: willRetain}
+		if w.Properties, err = r.properties(c.ProtocolLevel); err != nil {
+			return nil, err
+		}
+		if w.Topic, err = r.string(); err != nil {
+			return nil, err
+		}
+		if w.Payload, err = r.binary(); err != nil {
+			return nil, err
+		}
+		c.Will = w
+	}
+	if c.UsernameFlag {
+		if c.Username, err = r.string(); err != nil {
+			return nil, err
+		}
+	}
+	if c.PasswordFlag {
+		if c.Password, err = r.binary(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func parseConnack(r *reader, version byte) (*Connack, error) {
+	c := &Connack{}
+	flags, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if flags&0xfe != 0 {
+		return nil, errMalformed
+	}
+	c.SessionPresent = flags&0x01 != 0
+	if c.ReasonCode, err = r.byte(); err != nil {
+		return nil, err
+	}
+	if c.Properties, err = r.properties(version); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parsePublish(r *reader, flags byte, version byte) (*Publish, error) {
+	p := &Publish{Dup: flags&0x08 != 0, QoS: flags >> 1 & 0x03, Retain: flags&0x01 != 0}
+	if p.QoS > 2 || p.QoS == 0 && p.Dup {
+		return nil, errMalformed
+	}
+	var err error
+	if p.Topic, err = r.string(); err != nil {
+		return nil, asReasonError(err)
+	}
+	if p.QoS > 0 {
+		if p.PacketID, err = r.uint16(); err != nil {
+			return nil, asReasonError(err)
+		}
+		if p.PacketID == 0 {
+			return nil, errProtocol
+		}
+	}
+	if p.Properties, err = r.properties(version); err != nil {
+		return nil, asReasonError(err)
+	}
+	p.Payload, r.buf = r.buf, nil
+	return p, nil
+}
+
+func parseAck(r *reader, typ byte, version byte) (*Ack, error) {
+	a := &Ack{PacketType: typ}
+	var err error
+	if a.PacketID, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if version >= Version5 && len(r.buf) > 0 {
+		if a.ReasonCode, err = r.byte(); err != nil {
+			return nil, err
+		}
+		if len(r.buf) > 0 {
+			if a.Properties, err = parseProperties(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+func parseSubscribe(r *reader, version byte) (*Subscribe, error) {
+	s := &Subscribe{}
+	var err error
+	if s.PacketID, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if s.Properties, err = r.properties(version); err != nil {
+		return nil, err
+	}
+	for len(r.buf) > 0 {
+		var sub Subscription
+		if sub.Filter, err = r.string(); err != nil {
+			return nil, err
+		}
+		opts, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		sub.QoS = opts & 0x03
+		if version >= Version5 {
+			sub.NoLocal = opts&0x04 != 0
+			sub.RetainAsPublished = opts&0x08 != 0
+			sub.RetainHandling = opts >> 4 & 0x03
+			if opts&0xc0 != 0 || sub.RetainHandling == 3 {
+				return nil, errMalformed
+			}
+		} else if opts&0xfc != 0 {
+			return nil, errMalformed
+		}
+		if sub.QoS > 2 {
+			return nil, errMalformed
+		}
+		s.Subscriptions = append(s.Subscriptions, sub)
+	}
+	if len(s.Subscriptions) == 0 {
+		return nil, errProtocol
+	}
+	return s, nil
+}
+
+func parseUnsubscribe(r *reader, version byte) (*Unsubscribe, error) {
+	u := &Unsubscribe{}
+	var err error
+	if u.PacketID, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if u.Properties, err = r.properties(version); err != nil {
+		return nil, err
+	}
+	for len(r.buf) > 0 {
+		filter, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		u.Filters = append(u.Filters, filter)
+	}
+	if len(u.Filters) == 0 {
+		return nil, errProtocol
+	}
+	return u, nil
+}
+
+// AppendPacket appends the encoding of p to dst, version is the protocol level of the connection.
+func AppendPacket(dst []byte, p Packet, version byte) []byte {
+	v5 := version >= Version5
+	var b []byte
+	first := p.Type() << 4
+	switch p := p.(type) {
+	case *Connect:
+		b = appendString(b, p.ProtocolName)
+		b = append(b, p.ProtocolLevel)
+		var flags byte
+		if p.CleanStart {
+			flags |= 0x02
+		}
+		if p.Will != nil {
+			flags |= 0x04 | p.Will.QoS<<3
+			if p.Will.Retain {
+				flags |= 0x20
+			}
+		}
+		if p.PasswordFlag {
+			flags |= 0x40
+		}
+		if p.UsernameFlag {
+			flags |= 0x80
+		}
+		b = append(b, flags)
+		b = binary.BigEndian.AppendUint16(b, p.KeepAlive)
+		v5 = p.ProtocolLevel >= Version5
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+		b = appendString(b, p.ClientID)
+		if p.Will != nil {
+			if v5 {
+				b = appendProperties(b, &p.Will.Properties)
+			}
+			b = appendString(b, p.Will.Topic)
+			b = appendBinary(b, p.Will.Payload)
+		}
+		if p.UsernameFlag {
+			b = appendString(b, p.Username)
+		}
+		if p.PasswordFlag {
+			b = appendBinary(b, p.Password)
+		}
+	case *Connack:
+		if p.SessionPresent {
+			b = append(b, 1, p.ReasonCode)
+		} else {
+			b = append(b, 0, p.ReasonCode)
+		}
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+	case *Publish:
+		first |= p.QoS << 1
+		if p.Dup {
+			first |= 0x08
+		}
+		if p.Retain {
+			first |= 0x01
+		}
+		b = appendString(b, p.Topic)
+		if p.QoS > 0 {
+			b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		}
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+		// the payload is appended after the header to avoid copying it twice.
+		dst = append(dst, first)
+		dst = appendVarint(dst, uint32(len(b)+len(p.Payload)))
+		dst = append(dst, b...)
+		return append(dst, p.Payload...)
+	case *Ack:
+		if p.PacketType == PUBREL {
+			first |= 0x02
+		}
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		if v5 {
+			b = append(b, p.ReasonCode)
+			b = appendProperties(b, &p.Properties)
+		}
+	case *Subscribe:
+		first |= 0x02
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+		for _, sub := range p.Subscriptions {
+			b = appendString(b, sub.Filter)
+			opts := sub.QoS
+			if v5 {
+				if sub.NoLocal {
+					opts |= 0x04
+				}
+				if sub.RetainAsPublished {
+					opts |= 0x08
+				}
+				opts |= sub.RetainHandling << 4
+			}
+			b = append(b, opts)
+		}
+	case *Suback:
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+		b = append(b, p.ReasonCodes...)
+	case *Unsubscribe:
+		first |= 0x02
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+		}
+		for _, f := range p.Filters {
+			b = appendString(b, f)
+		}
+	case *Unsuback:
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+		if v5 {
+			b = appendProperties(b, &p.Properties)
+			b = append(b, p.ReasonCodes...)
+		}
+	case *Disconnect:
+		if v5 {
+			b = append(b, p.ReasonCode)
+			b = appendProperties(b, &p.Properties)
+		}
+	case *Auth:
+		b = append(b, p.ReasonCode)
+		b = appendProperties(b, &p.Properties)
+	}
+	dst = append(dst, first)
+	dst = appendVarint(dst, uint32(len(b)))
+	return append(dst, b...)
+}
+
+// reader decodes the fields of a packet.
+type reader struct {
+	buf []byte
+}
+
+func (r *reader) byte() (byte, error) {
+	if len(r.buf) < 1 {
+		return 0, errMalformed
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *reader) uint16() (uint16, error) {
+	if len(r.buf) < 2 {
+		return 0, errMalformed
+	}
+	v := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return v, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	if len(r.buf) < 4 {
+		return 0, errMalformed
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v, nil
+}
+
+// varint decodes a variable byte integer, it returns ErrNeedMore if the buffer ends in the middle of it.
+func (r *reader) varint() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		if i >= len(r.buf) {
+			return 0, ErrNeedMore
+		}
+		b := r.buf[i]
+		v |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			r.buf = r.buf[i+1:]
+			return v, nil
+		}
+	}
+	return 0, errMalformed
+}
+
+func (r *reader) binary() ([]byte, error) {
+	n, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.buf) < int(n) {
+		return nil, errMalformed
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+// string decodes a UTF-8 encoded string, which must not contain the null character (section 1.5.4).
+func (r *reader) string() (string, error) {
+	b, err := r.binary()
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	if !utf8.ValidString(s) || strings.IndexByte(s, 0) >= 0 {
+		return "", errMalformed
+	}
+	return s, nil
+}
+
+func (r *reader) properties(version byte) (Properties, error) {
+	if version < Version5 {
+		return Properties{}, nil
+	}
+	return parseProperties(r)
+}
+
+// the helpers below decode a property, which must not be present twice.
+
+func (r *reader) bytePtr(prev *byte) (*byte, error) {
+	if prev != nil {
+		return nil, errProtocol
+	}
+	v, err := r.byte()
+	return &v, err
+}
+
+func (r *reader) uint16Ptr(prev *uint16) (*uint16, error) {
+	if prev != nil {
+		return nil, errProtocol
+	}
+	v, err := r.uint16()
+	return &v, err
+}
+
+func (r *reader) uint32Ptr(prev *uint32) (*uint32, error) {
+	if prev != nil {
+		return nil, errProtocol
+	}
+	v, err := r.uint32()
+	return &v, err
+}
+
+func (r *reader) uniqueString(prev string) (string, error) {
+	if prev != "" {
+		return "", errProtocol
+	}
+	return r.string()
+}
+
+func (r *reader) uniqueBinary(prev []byte) ([]byte, error) {
+	if prev != nil {
+		return nil, errProtocol
+	}
+	return r.binary()
+}
+
+func appendVarint(dst []byte, v uint32) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(dst, b)
+		}
+		dst = append(dst, b|0x80)
+	}
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+func appendBinary(dst []byte, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(b)))
+	return append(dst, b...)
+}
@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint32{0, 127, 128, 16383, 16384, 2097151, 2097152, maxRemainingLength} {
+		b := appendVarint(nil, v)
+		r := &reader{buf: b}
+		got, err := r.varint()
+		if err != nil || got != v || len(r.buf) != 0 {
+			t.Fatalf("%d: decoded %d from %x, error %v", v, got, b, err)
+		}
+		r = &reader{buf: b[:len(b)-1]}
+		if _, err := r.varint(); err != ErrNeedMore {
+			t.Fatalf("%d: expect ErrNeedMore for a truncated integer, got %v", v, err)
+		}
+	}
+	r := &reader{buf: []byte{0xff, 0xff, 0xff, 0xff, 0x01}}
+	if _, err := r.varint(); err != errMalformed {
+		t.Fatalf("expect errMalformed for a 5 byte integer, got %v", err)
+	}
+}
+
+func roundTrip(t *testing.T, p Packet, version byte) Packet {
+	t.Helper()
+	b := AppendPacket(nil, p, version)
+	for i := 0; i < len(b); i++ {
+		if _, _, err := ParsePacket(b[:i], version, len(b)); err != ErrNeedMore {
+			t.Fatalf("prefix %d of %x: expect ErrNeedMore, got %v", i, b, err)
+		}
+	}
+	got, n, err := ParsePacket(b, version, len(b))
+	if err != nil || n != len(b) {
+		t.Fatalf("%x: unexpected length %d or error %v", b, n, err)
+	}
+	return got
+}
+
+func TestConnectRoundTrip(t *testing.T) {
+	expiry, receiveMax := uint32(3600), uint16(10)
+	c := &Connect{
+		ProtocolName:  "MQTT",
+		ProtocolLevel: Version5,
+		CleanStart:    true,
+		KeepAlive:     30,
+		Properties:    Properties{SessionExpiry: &expiry, ReceiveMaximum: &receiveMax},
+		ClientID:      "client",
+		Will: &Will{
+			Topic:      "status/client",
+			Payload:    []byte("gone"),
+			QoS:        1,
+			Retain:     true,
+			Properties: Properties{ContentType: "text/plain"},
+		},
+		UsernameFlag: true,
+		Username:     "user",
+		PasswordFlag: true,
+		Password:     []byte("secret"),
+	}
+	if got := roundTrip(t, c, 0); !reflect.DeepEqual(got, c) {
+		t.Fatalf("expect %+v, got %+v", c, got)
+	}
+
+	c = &Connect{ProtocolName: "MQTT", ProtocolLevel: Version311, ClientID: "c"}
+	if got := roundTrip(t, c, 0); !reflect.DeepEqual(got, c) {
+		t.Fatalf("expect %+v, got %+v", c, got)
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	format := byte(1)
+	packets := []Packet{
+		&Connack{SessionPresent: true},
+		&Publish{Topic: "a/b", Payload: []byte("hello")},
+		&Publish{Dup: true, QoS: 1, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte{}},
+		&Ack{PacketType: PUBACK, PacketID: 7},
+		&Ack{PacketType: PUBREL, PacketID: 8},
+		&Subscribe{PacketID: 1, Subscriptions: []Subscription{{Filter: "a/+", QoS: 1}, {Filter: "#"}}},
+		&Suback{PacketID: 1, ReasonCodes: []byte{1, 0x80}},
+		&Unsubscribe{PacketID: 2, Filters: []string{"a/+", "#"}},
+		&Pingreq{},
+		&Pingresp{},
+		&Disconnect{},
+	}
+	for _, p := range packets {
+		if got := roundTrip(t, p, Version311); !reflect.DeepEqual(got, p) {
+			t.Fatalf("3.1.1: expect %+v, got %+v", p, got)
+		}
+	}
+
+	packets = append(packets,
+		&Publish{QoS: 1, Topic: "t", PacketID: 1, Properties: Properties{
+			PayloadFormat:   &format,
+			CorrelationData: []byte{1, 2},
+			User:            []UserProperty{{"k", "v"}, {"k", "w"}},
+		}, Payload: []byte("x")},
+		&Subscribe{PacketID: 3, Subscriptions: []Subscription{{Filter: "x", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}}},
+		&Unsuback{PacketID: 2, ReasonCodes: []byte{0, NoSubscriptionExisted}},
+		&Disconnect{ReasonCode: DisconnectWithWill, Properties: Properties{ReasonString: "bye"}},
+		&Auth{ReasonCode: 0x18, Properties: Properties{AuthMethod: "SCRAM"}},
+	)
+	for _, p := range packets {
+		if got := roundTrip(t, p, Version5); !reflect.DeepEqual(got, p) {
+			t.Fatalf("5.0: expect %+v, got %+v", p, got)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := map[string]struct {
+		raw  []byte
+		code byte
+	}{
+		"reserved flags":     {[]byte{0x82, 0x03, 0, 1, 0}, MalformedPacket},
+		"publish qos 3":      {[]byte{0x36, 0x05, 0, 1, 'a', 0, 1}, MalformedPacket},
+		"publish id 0":       {[]byte{0x32, 0x05, 0, 1, 'a', 0, 0}, ProtocolError},
+		"empty subscribe":    {[]byte{0x82, 0x02, 0, 1}, ProtocolError},
+		"trailing bytes":     {[]byte{0xc0, 0x01, 0}, MalformedPacket},
+		"null in string":     {[]byte{0x30, 0x04, 0, 2, 'a', 0}, MalformedPacket},
+		"invalid utf-8":      {[]byte{0x30, 0x03, 0, 1, 0xff}, MalformedPacket},
+		"truncated string":   {[]byte{0x30, 0x03, 0, 5, 'a'}, MalformedPacket},
+		"unknown version":    {append([]byte{0x10, 0x0c}, "\x00\x04MQTT\x06\x02\x00\x00\x00\x00"...), UnsupportedProtocolVersion},
+		"connect reserved":   {append([]byte{0x10, 0x0c}, "\x00\x04MQTT\x04\x03\x00\x00\x00\x00"...), MalformedPacket},
+		"will qos no flag":   {append([]byte{0x10, 0x0c}, "\x00\x04MQTT\x04\x08\x00\x00\x00\x00"...), MalformedPacket},
+		"reserved type":      {[]byte{0x00, 0x00}, MalformedPacket},
+		"auth in 3.1.1":      {[]byte{0xf0, 0x00}, MalformedPacket},
+		"remaining too long": {[]byte{0x30, 0xff, 0xff, 0xff, 0xff}, MalformedPacket},
+	}
+	for name, c := range cases {
+		_, _, err := ParsePacket(c.raw, Version311, maxRemainingLength)
+		re, ok := err.(*ReasonError)
+		if !ok || re.Code != c.code {
+			t.Errorf("%s: expect reason code %#x, got %v", name, c.code, err)
+		}
+	}
+
+	// a property present twice is a protocol error.
+	raw := []byte{0xe0, 0x0c, 0x00, 0x0a, propSessionExpiry, 0, 0, 0, 1, propSessionExpiry, 0, 0, 0, 2}
+	if _, _, err := ParsePacket(raw, Version5, maxRemainingLength); err != errProtocol {
+		t.Fatalf("expect errProtocol for a duplicate property, got %v", err)
+	}
+
+	big := AppendPacket(nil, &Publish{Topic: "t", Payload: make([]byte, 100)}, Version311)
+	if _, _, err := ParsePacket(big[:2], Version311, 50); err != errPacketTooLarge {
+		t.Fatalf("expect errPacketTooLarge, got %v", err)
+	}
+}
+
+func TestPublishPayloadNotCopied(t *testing.T) {
+	b := AppendPacket(nil, &Publish{Topic: "t", Payload: []byte("payload")}, Version311)
+	p, _, err := ParsePacket(b, Version311, len(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload := p.(*Publish).Payload; !bytes.Equal(payload, []byte("payload")) || &payload[0] != &b[len(b)-7] {
+		t.Fatalf("expect the payload to refer to the buffer, got %q", payload)
+	}
+}
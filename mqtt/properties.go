@@ -0,0 +1,229 @@
+package mqtt
+
+import "encoding/binary"
+
+// Property identifiers of MQTT 5.0 (section 2.2.2.2).
+const (
+	propPayloadFormat          = 0x01
+	propMessageExpiry          = 0x02
+	propContentType            = 0x03
+	propResponseTopic          = 0x08
+	propCorrelationData        = 0x09
+	propSubscriptionIdentifier = 0x0B
+	propSessionExpiry          = 0x11
+	propAssignedClientID       = 0x12
+	propServerKeepAlive        = 0x13
+	propAuthMethod             = 0x15
+	propAuthData               = 0x16
+	propRequestProblemInfo     = 0x17
+	propWillDelay              = 0x18
+	propRequestResponseInfo    = 0x19
+	propResponseInfo           = 0x1A
+	propServerReference        = 0x1C
+	propReasonString           = 0x1F
+	propReceiveMaximum         = 0x21
+	propTopicAliasMaximum      = 0x22
+	propTopicAlias             = 0x23
+	propMaximumQoS             = 0x24
+	propRetainAvailable        = 0x25
+	propUserProperty           = 0x26
+	propMaximumPacketSize      = 0x27
+	propWildcardSubAvailable   = 0x28
+	propSubIDAvailable         = 0x29
+	propSharedSubAvailable     = 0x2A
+)
+
+// UserProperty is a name-value pair sent in the user properties of a packet.
+type UserProperty struct {
+	Key, Value string
+}
+
+// Properties holds the properties of an MQTT 5.0 packet, nil pointers are absent properties.
+// Properties are ignored when encoding and absent when decoding a packet of MQTT 3.1.1.
+type Properties struct {
+	PayloadFormat          *byte
+	MessageExpiry          *uint32
+	ContentType            string
+	ResponseTopic          string
+	CorrelationData        []byte
+	SubscriptionIdentifier []uint32
+	SessionExpiry          *uint32
+	AssignedClientID       string
+	ServerKeepAlive        *uint16
+	AuthMethod             string
+	AuthData               []byte
+	RequestProblemInfo     *byte
+	WillDelay              *uint32
+	RequestResponseInfo    *byte
+	ResponseInfo           string
+	ServerReference        string
+	ReasonString           string
+	ReceiveMaximum         *uint16
+	TopicAliasMaximum      *uint16
+	TopicAlias             *uint16
+	MaximumQoS             *byte
+	RetainAvailable        *byte
+	User                   []UserProperty
+	MaximumPacketSize      *uint32
+	WildcardSubAvailable   *byte
+	SubIDAvailable         *byte
+	SharedSubAvailable     *byte
+}
+
+// parseProperties decodes the properties at the beginning of r.
+func parseProperties(r *reader) (Properties, error) {
+	var p Properties
+	length, err := r.varint()
+	if err != nil {
+		return p, err
+	}
+	if int(length) > len(r.buf) {
+		return p, errMalformed
+	}
+	pr := &reader{buf: r.buf[:length]}
+	r.buf = r.buf[length:]
+	for len(pr.buf) > 0 {
+		id, err := pr.varint()
+		if err != nil {
+			return p, err
+		}
+		switch id {
+		case propPayloadFormat:
+			p.PayloadFormat, err = pr.bytePtr(p.PayloadFormat)
+		case propMessageExpiry:
+			p.MessageExpiry, err = pr.uint32Ptr(p.MessageExpiry)
+		case propContentType:
+			p.ContentType, err = pr.uniqueString(p.ContentType)
+		case propResponseTopic:
+			p.ResponseTopic, err = pr.uniqueString(p.ResponseTopic)
+		case propCorrelationData:
+			p.CorrelationData, err = pr.uniqueBinary(p.CorrelationData)
+		case propSubscriptionIdentifier:
+			var v uint32
+			if v, err = pr.varint(); err == nil {
+				if v == 0 {
+					return p, errProtocol
+				}
+				p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, v)
+			}
+		case propSessionExpiry:
+			p.SessionExpiry, err = pr.uint32Ptr(p.SessionExpiry)
+		case propAssignedClientID:
+			p.AssignedClientID, err = pr.uniqueString(p.AssignedClientID)
+		case propServerKeepAlive:
+			p.ServerKeepAlive, err = pr.uint16Ptr(p.ServerKeepAlive)
+		case propAuthMethod:
+			p.AuthMethod, err = pr.uniqueString(p.AuthMethod)
+		case propAuthData:
+			p.AuthData, err = pr.uniqueBinary(p.AuthData)
+		case propRequestProblemInfo:
+			p.RequestProblemInfo, err = pr.bytePtr(p.RequestProblemInfo)
+		case propWillDelay:
+			p.WillDelay, err = pr.uint32Ptr(p.WillDelay)
+		case propRequestResponseInfo:
+			p.RequestResponseInfo, err = pr.bytePtr(p.RequestResponseInfo)
+		case propResponseInfo:
+			p.ResponseInfo, err = pr.uniqueString(p.ResponseInfo)
+		case propServerReference:
+			p.ServerReference, err = pr.uniqueString(p.ServerReference)
+		case propReasonString:
+			p.ReasonString, err = pr.uniqueString(p.ReasonString)
+		case propReceiveMaximum:
+			p.ReceiveMaximum, err = pr.uint16Ptr(p.ReceiveMaximum)
+		case propTopicAliasMaximum:
+			p.TopicAliasMaximum, err = pr.uint16Ptr(p.TopicAliasMaximum)
+		case propTopicAlias:
+			p.TopicAlias, err = pr.uint16Ptr(p.TopicAlias)
+		case propMaximumQoS:
+			p.MaximumQoS, err = pr.bytePtr(p.MaximumQoS)
+		case propRetainAvailable:
+			p.RetainAvailable, err = pr.bytePtr(p.RetainAvailable)
+		case propUserProperty:
+			var up UserProperty
+			if up.Key, err = pr.string(); err == nil {
+				if up.Value, err = pr.string(); err == nil {
+					p.User = append(p.User, up)
+				}
+			}
+		case propMaximumPacketSize:
+			p.MaximumPacketSize, err = pr.uint32Ptr(p.MaximumPacketSize)
+		case propWildcardSubAvailable:
+			p.WildcardSubAvailable, err = pr.bytePtr(p.WildcardSubAvailable)
+		case propSubIDAvailable:
+			p.SubIDAvailable, err = pr.bytePtr(p.SubIDAvailable)
+		case propSharedSubAvailable:
+			p.SharedSubAvailable, err = pr.bytePtr(p.SharedSubAvailable)
+		default:
+			return p, errMalformed
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// appendProperties appends the encoding of p, preceded by its length.
+func appendProperties(dst []byte, p *Properties) []byte {
+	var b []byte
+	putByte := func(id byte, v *byte) {
+		if v != nil {
+			b = append(b, id, *v)
+		}
+	}
+	putUint16 := func(id byte, v *uint16) {
+		if v != nil {
+			b = binary.BigEndian.AppendUint16(append(b, id), *v)
+		}
+	}
+	putUint32 := func(id byte, v *uint32) {
+		if v != nil {
+			b = binary.BigEndian.AppendUint32(append(b, id), *v)
+		}
+	}
+	putString := func(id byte, v string) {
+		if v != "" {
+			b = appendString(append(b, id), v)
+		}
+	}
+	putBinary := func(id byte, v []byte) {
+		if v != nil {
+			b = appendBinary(append(b, id), v)
+		}
+	}
+
+	putByte(propPayloadFormat, p.PayloadFormat)
+	putUint32(propMessageExpiry, p.MessageExpiry)
+	putString(propContentType, p.ContentType)
+	putString(propResponseTopic, p.ResponseTopic)
+	putBinary(propCorrelationData, p.CorrelationData)
+	for _, id := range p.SubscriptionIdentifier {
+		b = appendVarint(append(b, propSubscriptionIdentifier), id)
+	}
+	putUint32(propSessionExpiry, p.SessionExpiry)
+	putString(propAssignedClientID, p.AssignedClientID)
+	putUint16(propServerKeepAlive, p.ServerKeepAlive)
+	putString(propAuthMethod, p.AuthMethod)
+	putBinary(propAuthData, p.AuthData)
+	putByte(propRequestProblemInfo, p.RequestProblemInfo)
+	putUint32(propWillDelay, p.WillDelay)
+	putByte(propRequestResponseInfo, p.RequestResponseInfo)
+	putString(propResponseInfo, p.ResponseInfo)
+	putString(propServerReference, p.ServerReference)
+	putString(propReasonString, p.ReasonString)
+	putUint16(propReceiveMaximum, p.ReceiveMaximum)
+	putUint16(propTopicAliasMaximum, p.TopicAliasMaximum)
+	putUint16(propTopicAlias, p.TopicAlias)
+	putByte(propMaximumQoS, p.MaximumQoS)
+	putByte(propRetainAvailable, p.RetainAvailable)
+	for _, up := range p.User {
+		b = appendString(appendString(append(b, propUserProperty), up.Key), up.Value)
+	}
+	putUint32(propMaximumPacketSize, p.MaximumPacketSize)
+	putByte(propWildcardSubAvailable, p.WildcardSubAvailable)
+	putByte(propSubIDAvailable, p.SubIDAvailable)
+	putByte(propSharedSubAvailable, p.SharedSubAvailable)
+
+	dst = appendVarint(dst, uint32(len(b)))
+	return append(dst, b...)
+}
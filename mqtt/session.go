@@ -0,0 +1,134 @@
+//go:build linux
+
+package mqtt
+
+import (
+	"sync"
+	"time"
+)
+
+// neverExpire is the session expiry interval of a session which is kept until the client cleans it.
+const neverExpire = 0xFFFFFFFF
+
+// message is a published application message, it is immutable once published.
+type message struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	props   Properties // MQTT 5.0 properties forwarded to the subscribers
+}
+
+type retainedMessage struct {
+	msg *message
+	qos byte
+}
+
+// outgoing is a QoS 1 message sent to a client which has not acknowledged it yet.
+type outgoing struct {
+	msg      *message
+	retain   bool
+	packetID uint16 // 0 until the message is sent
+}
+
+// session is the state of a client which outlives its connections: its subscriptions and the
+// QoS 1 messages which have not been acknowledged yet. It is shared by the rings.
+type session struct {
+	b        *Broker
+	clientID string
+	version  byte
+
+	mu          sync.Mutex // guards the fields below
+	conn        *conn      // the connection of the client, nil while it is offline
+	expiry      uint32     // session expiry interval in seconds
+	expiryTimer *time.Timer
+	subs        map[string]Subscription // by filter, nil once the session is discarded
+	inflight    []*outgoing             // sent and waiting for PUBACK, in the order they were sent
+	queue       []*outgoing             // waiting for the client to come back or to acknowledge inflight messages
+	lastID      uint16
+}
+
+// deliver sends msg to the client with qos, or queues it if the client cannot receive it now.
+func (s *session) deliver(msg *message, qos byte, retain bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		return
+	}
+	if qos == 0 {
+		// QoS 0 messages are not kept for offline clients.
+		if s.conn != nil {
+			s.conn.writePublish(msg, 0, retain, 0, false)
+		}
+		return
+	}
+	o := &outgoing{msg: msg, retain: retain}
+	if s.conn != nil && len(s.queue) == 0 && len(s.inflight) < s.conn.receiveMax {
+		s.send(o)
+		return
+	}
+	if len(s.queue) >= s.b.maxQueuedMessages() {
+		return
+	}
+	s.queue = append(s.queue, o)
+}
+
+// send assigns a packet ID to o and sends it, s.mu must be held and the client online.
+func (s *session) send(o *outgoing) {
+	o.packetID = s.nextID()
+	s.inflight = append(s.inflight, o)
+	s.conn.writePublish(o.msg, 1, o.retain, o.packetID, false)
+}
+
+// nextID returns a packet ID which is not used by the inflight messages.
+func (s *session) nextID() uint16 {
+	for {
+		s.lastID++
+		if s.lastID == 0 {
+			continue
+		}
+		used := false
+		for _, o := range s.inflight {
+			if o.packetID == s.lastID {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return s.lastID
+		}
+	}
+}
+
+// resume resends the unacknowledged messages to the new connection of the client with the DUP flag,
+// then the queued messages. s.mu must be held.
+func (s *session) resume() {
+	for _, o := range s.inflight {
+		s.conn.writePublish(o.msg, 1, o.retain, o.packetID, true)
+	}
+	s.drain()
+}
+
+// drain sends the queued messages the client can receive, s.mu must be held.
+func (s *session) drain() {
+	n := 0
+	for n < len(s.queue) && s.conn != nil && len(s.inflight) < s.conn.receiveMax {
+		s.send(s.queue[n])
+		n++
+	}
+	s.queue = s.queue[:copy(s.queue, s.queue[n:])]
+}
+
+// ack removes the message acknowledged by a PUBACK, it reports whether the packet ID was inflight.
+func (s *session) ack(packetID uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, o := range s.inflight {
+		if o.packetID == packetID {
+			s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+			s.drain()
+			return true
+		}
+	}
+	return false
+}
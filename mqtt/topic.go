@@ -0,0 +1,135 @@
+package mqtt
+
+import "strings"
+
+// ValidTopicName reports whether name can be published to, it must not be empty nor contain wildcards.
+func ValidTopicName(name string) bool {
+	return name != "" && len(name) <= 65535 && !strings.ContainsAny(name, "+#")
+}
+
+// ValidTopicFilter reports whether filter can be subscribed to: "+" must occupy a whole level,
+// "#" must occupy the last level.
+func ValidTopicFilter(filter string) bool {
+	if filter == "" || len(filter) > 65535 {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return false
+		case level != "#" && level != "+" && strings.ContainsAny(level, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTopic reports whether the topic name matches filter. Topics starting with "$" are not
+// matched by a wildcard in the first level (section 4.7.2).
+func MatchTopic(filter, topic string) bool {
+	if topic != "" && topic[0] == '$' && filter != "" && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+	for {
+		f, frest, fmore := strings.Cut(filter, "/")
+		if f == "#" {
+			return true
+		}
+		t, trest, tmore := strings.Cut(topic, "/")
+		if f != "+" && f != t {
+			return false
+		}
+		if !fmore || !tmore {
+			// "a/#" matches "a" as well.
+			return fmore == tmore || fmore && frest == "#"
+		}
+		filter, topic = frest, trest
+	}
+}
+
+// topicTree indexes the subscriptions by the levels of their filter, to find the subscribers
+// of a topic without matching every filter. It is not safe for concurrent use.
+type topicTree struct {
+	root topicNode
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+	subs     map[string]Subscription // by client ID
+}
+
+// add subscribes the client to sub.Filter, it replaces its previous subscription to the same filter.
+func (t *topicTree) add(clientID string, sub Subscription) {
+	n := &t.root
+	for _, level := range strings.Split(sub.Filter, "/") {
+		child := n.children[level]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*topicNode)
+			}
+			child = &topicNode{}
+			n.children[level] = child
+		}
+		n = child
+	}
+	if n.subs == nil {
+		n.subs = make(map[string]Subscription)
+	}
+	n.subs[clientID] = sub
+}
+
+// remove unsubscribes the client from filter, it reports whether the subscription existed.
+func (t *topicTree) remove(clientID, filter string) bool {
+	return t.root.remove(clientID, strings.Split(filter, "/"))
+}
+
+func (n *topicNode) remove(clientID string, levels []string) bool {
+	if len(levels) == 0 {
+		_, ok := n.subs[clientID]
+		delete(n.subs, clientID)
+		return ok
+	}
+	child := n.children[levels[0]]
+	if child == nil {
+		return false
+	}
+	ok := child.remove(clientID, levels[1:])
+	// prune the empty branches.
+	if len(child.subs) == 0 && len(child.children) == 0 {
+		delete(n.children, levels[0])
+	}
+	return ok
+}
+
+// match calls f for every subscription whose filter matches topic.
+func (t *topicTree) match(topic string, f func(clientID string, sub Subscription)) {
+	levels := strings.Split(topic, "/")
+	t.root.match(levels, topic[0] == '$', f)
+}
+
+func (n *topicNode) match(levels []string, system bool, f func(string, Subscription)) {
+	if !system {
+		if child := n.children["#"]; child != nil {
+			child.call(f)
+		}
+	}
+	if len(levels) == 0 {
+		n.call(f)
+		return
+	}
+	if child := n.children[levels[0]]; child != nil {
+		child.match(levels[1:], false, f)
+	}
+	if !system {
+		if child := n.children["+"]; child != nil {
+			child.match(levels[1:], false, f)
+		}
+	}
+}
+
+func (n *topicNode) call(f func(string, Subscription)) {
+	for clientID, sub := range n.subs {
+		f(clientID, sub)
+	}
+}
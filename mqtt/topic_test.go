@@ -0,0 +1,102 @@
+package mqtt
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestValidTopic(t *testing.T) {
+	for _, name := range []string{"a", "a/b", "/", "a//b", "$SYS/x"} {
+		if !ValidTopicName(name) || !ValidTopicFilter(name) {
+			t.Errorf("%q: expect a valid topic name and filter", name)
+		}
+	}
+	for _, name := range []string{"", "a/+", "#", "a+"} {
+		if ValidTopicName(name) {
+			t.Errorf("%q: expect an invalid topic name", name)
+		}
+	}
+	for _, filter := range []string{"+", "#", "+/+", "a/#", "+/b/#", "/+"} {
+		if !ValidTopicFilter(filter) {
+			t.Errorf("%q: expect a valid topic filter", filter)
+		}
+	}
+	for _, filter := range []string{"", "a#", "a/#/b", "a+/b", "a/b+", "##"} {
+		if ValidTopicFilter(filter) {
+			t.Errorf("%q: expect an invalid topic filter", filter)
+		}
+	}
+}
+
+var matchCases = []struct {
+	filter, topic string
+	match         bool
+}{
+	{"a/b", "a/b", true},
+	{"a/b", "a/c", false},
+	{"a/+", "a/b", true},
+	{"a/+", "a/b/c", false},
+	{"a/+", "a", false},
+	{"a/+", "a/", true},
+	{"+/+", "/a", true},
+	{"+", "/a", false},
+	{"a/#", "a", true},
+	{"a/#", "a/b/c", true},
+	{"a/#", "ab", false},
+	{"#", "a/b", true},
+	{"+/b/#", "a/b", true},
+	{"#", "$SYS/uptime", false},
+	{"+/uptime", "$SYS/uptime", false},
+	{"$SYS/#", "$SYS/uptime", true},
+	{"$SYS/+", "$SYS/uptime", true},
+}
+
+func TestMatchTopic(t *testing.T) {
+	for _, c := range matchCases {
+		if got := MatchTopic(c.filter, c.topic); got != c.match {
+			t.Errorf("MatchTopic(%q, %q) = %v", c.filter, c.topic, got)
+		}
+	}
+}
+
+func TestTopicTree(t *testing.T) {
+	var tree topicTree
+	for i, c := range matchCases {
+		tree.add(string(rune('A'+i)), Subscription{Filter: c.filter})
+	}
+	for _, c := range matchCases {
+		var got []string
+		tree.match(c.topic, func(clientID string, sub Subscription) {
+			got = append(got, sub.Filter)
+		})
+		// the tree must agree with MatchTopic for every filter.
+		var want []string
+		for _, f := range matchCases {
+			if MatchTopic(f.filter, c.topic) {
+				want = append(want, f.filter)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Fatalf("%q: expect %q, got %q", c.topic, want, got)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%q: expect %q, got %q", c.topic, want, got)
+			}
+		}
+	}
+
+	for i, c := range matchCases {
+		if !tree.remove(string(rune('A'+i)), c.filter) {
+			t.Fatalf("%q: expect the subscription to be removed", c.filter)
+		}
+	}
+	if tree.remove("A", "a/b") {
+		t.Fatal("expect no subscription left")
+	}
+	if len(tree.root.children) != 0 {
+		t.Fatalf("expect the empty branches to be pruned, got %v", tree.root.children)
+	}
+}
@@ -0,0 +1,379 @@
+//go:build linux
+
+package netconn
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Conn is a net.Conn whose reads and writes are executed by a UringNet ring.
+//
+// The ring keeps reading the socket into an inbound buffer until it holds maxBuffered bytes,
+// Read parks the calling goroutine until data arrives. Write hands the caller's buffer to the
+// ring and parks until the kernel has sent all of it.
+type Conn struct {
+	ring          *uringnet.URingNet
+	fd            int32
+	laddr, raddr  net.Addr
+	maxBuffered   int
+	readWake      chan struct{} // signaled when data, EOF or a new read deadline is available
+	writeMu       sync.Mutex    // serializes writers
+	mu            sync.Mutex    // guards the fields below
+	in            []byte        // received bytes not read yet
+	paused        bool          // the ring stopped reading because in is full
+	eof           bool          // the connection has been closed by the ring, nothing more will arrive
+	closed        bool          // Close has been called
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// ring goroutine only
+	reading    bool // a read is armed for fd
+	ringClosed bool // the ring has submitted the close of fd
+}
+
+func newConn(ring *uringnet.URingNet, fd int32, maxBuffered int) *Conn {
+	c := &Conn{
+		ring:        ring,
+		fd:          fd,
+		maxBuffered: maxBuffered,
+		readWake:    make(chan struct{}, 1),
+		reading:     true,
+	}
+	if sa, err := unix.Getsockname(int(fd)); err == nil {
+		c.laddr = sockaddrToAddr(sa)
+	}
+	if sa, err := unix.Getpeername(int(fd)); err == nil {
+		c.raddr = sockaddrToAddr(sa)
+	}
+	// behind a proxy, the addresses are those of the client and of the proxy listener.
+	if info, ok := ring.ConnInfo(fd); ok && info.Proxy != nil && !info.Proxy.Local {
+		c.raddr, c.laddr = info.Proxy.Source, info.Proxy.Destination
+	}
+	return c
+}
+
+func (c *Conn) notifyReader() {
+	select {
+	case c.readWake <- struct{}{}:
+	default:
+	}
+}
+
+// onTraffic runs on the ring goroutine when bytes have been read, it reports whether reading goes on.
+func (c *Conn) onTraffic(p []byte) bool {
+	c.mu.Lock()
+	c.in = append(c.in, p...)
+	full := len(c.in) >= c.maxBuffered
+	c.paused = full
+	c.mu.Unlock()
+	c.reading = !full
+	c.notifyReader()
+	return !full
+}
+
+// onClose runs on the ring goroutine when the ring closes the socket.
+func (c *Conn) onClose() {
+	c.ringClosed = true
+	c.reading = false
+	c.mu.Lock()
+	c.eof = true
+	c.mu.Unlock()
+	c.notifyReader()
+}
+
+// Read reads data from the connection, it blocks until data is available or the read deadline expires.
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, c.opError("read", net.ErrClosed)
+		}
+		if len(c.in) > 0 {
+			n := copy(p, c.in)
+			c.in = c.in[:copy(c.in, c.in[n:])]
+			resume := c.paused && len(c.in) < c.maxBuffered/2
+			if resume {
+				c.paused = false
+			}
+			c.mu.Unlock()
+			if resume {
+				_ = c.ring.Trigger(c.resumeReading)
+			}
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			if timer == nil {
+				timer = time.NewTimer(d)
+			} else {
+				timer.Reset(d)
+			}
+			expired = timer.C
+		}
+		select {
+		case <-c.readWake:
+		case <-expired:
+			timer = nil
+		}
+	}
+}
+
+// resumeReading arms the read of the connection again, it runs on the ring goroutine.
+func (c *Conn) resumeReading() {
+	if c.ringClosed || c.reading {
+		return
+	}
+	c.reading = true
+	c.ring.ArmRead(c.fd)
+}
+
+// Write writes data to the connection, it blocks until all of p has been sent or the write deadline expires.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		c.mu.Lock()
+		closed, deadline := c.closed, c.writeDeadline
+		c.mu.Unlock()
+		if closed {
+			return written, c.opError("write", net.ErrClosed)
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return written, c.opError("write", os.ErrDeadlineExceeded)
+		}
+		n, err := c.send(p[written:], deadline)
+		written += n
+		if err != nil {
+			return written, c.opError("write", err)
+		}
+	}
+	return written, nil
+}
+
+// send submits one send of buf and waits for its completion. The completion is always awaited,
+// even when the deadline expires, so that the kernel never accesses buf after send returns.
+func (c *Conn) send(buf []byte, deadline time.Time) (int, error) {
+	done := make(chan int32, 1)
+	ids := make(chan uint64, 1)
+	err := c.ring.Trigger(func() {
+		if c.ringClosed {
+			done <- -int32(unix.EPIPE)
+			return
+		}
+		ids <- c.ring.Submit(func(sqe *uring.SQEntry) {
+			uring.Send(sqe, uintptr(c.fd), buf, unix.MSG_NOSIGNAL)
+		}, func(res int32, _ uint32) {
+			done <- res
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var res int32
+	timedOut := false
+	if deadline.IsZero() {
+		res = <-done
+	} else {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case res = <-done:
+		case <-timer.C:
+			timedOut = true
+			select {
+			case id := <-ids:
+				_ = c.ring.Trigger(func() { c.ring.Cancel(id) })
+				res = <-done
+			case res = <-done:
+			}
+		}
+		timer.Stop()
+	}
+	switch {
+	case res >= 0:
+		if timedOut && int(res) < len(buf) {
+			return int(res), os.ErrDeadlineExceeded
+		}
+		return int(res), nil
+	case timedOut && res == -int32(unix.ECANCELED):
+		return 0, os.ErrDeadlineExceeded
+	}
+	return 0, os.NewSyscallError("send", syscall.Errno(-res))
+}
+
+// SendFile sends n bytes of file from offset to the connection without copying them through user
+// space, see uringnet.URingNet.SendFile. It returns the number of bytes sent. The write deadline
+// does not apply, file must not be closed before SendFile returns.
+func (c *Conn) SendFile(file *os.File, offset, n int64) (int64, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, c.opError("sendfile", net.ErrClosed)
+	}
+	type result struct {
+		sent int64
+		err  error
+	}
+	done := make(chan result, 1)
+	err := c.ring.Trigger(func() {
+		if c.ringClosed {
+			done <- result{0, os.NewSyscallError("sendfile", unix.EPIPE)}
+			return
+		}
+		c.ring.SendFile(c.fd, file, offset, n, func(sent int64, err error) {
+			done <- result{sent, err}
+		})
+	})
+	if err != nil {
+		return 0, c.opError("sendfile", err)
+	}
+	r := <-done
+	if r.err != nil {
+		return r.sent, c.opError("sendfile", r.err)
+	}
+	return r.sent, nil
+}
+
+// Close closes the connection. Blocked Read and Write calls return an error.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.notifyReader()
+	return c.ring.Trigger(func() {
+		if c.ringClosed {
+			return
+		}
+		if c.reading {
+			// the pending read completes with EOF and the ring closes the socket.
+			_ = unix.Shutdown(int(c.fd), unix.SHUT_RDWR)
+		} else {
+			c.ring.CloseFd(c.fd)
+		}
+	})
+}
+
+// CloseWrite shuts down the writing side of the connection.
+func (c *Conn) CloseWrite() error {
+	done := make(chan error, 1)
+	if err := c.ring.Trigger(func() {
+		if c.ringClosed {
+			done <- net.ErrClosed
+			return
+		}
+		done <- os.NewSyscallError("shutdown", unix.Shutdown(int(c.fd), unix.SHUT_WR))
+	}); err != nil {
+		return err
+	}
+	if err := <-done; err != nil {
+		return c.opError("close", err)
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines associated with the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+	c.notifyReader()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future and pending Read calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.notifyReader()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls, a pending send is canceled when
+// the deadline in effect at its start expires.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: networkOf(c.laddr), Source: c.laddr, Addr: c.raddr, Err: err}
+}
+
+func networkOf(addr net.Addr) string {
+	if addr == nil {
+		return "tcp"
+	}
+	return addr.Network()
+}
+
+// sockaddrToAddr converts a socket address to a net.Addr of a stream socket.
+func sockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		addr := &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+//go:build linux
+
+// Package netconn exposes UringNet listeners and connections through the net.Listener and net.Conn
+// interfaces, so that code written against the net package, like http.Server.Serve or gRPC servers,
+// runs on io_uring without being rewritten.
+//
+// Reads and writes are executed by the rings, the goroutines calling Accept, Read and Write are
+// parked until the matching completion arrives:
+//
+//	ln, err := netconn.Listen("tcp", ":8080", netconn.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(http.Serve(ln, handler))
+//
+// A connection is closed by the ring as soon as the peer shuts down its writing side,
+// half-closed connections are not supported.
+package netconn
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultMaxBuffered is the default number of received bytes buffered per connection
+	// before the ring stops reading it.
+	DefaultMaxBuffered = 64 << 10
+
+	ringSize    = 4096
+	ringBuffers = 4096
+)
+
+// Config configures the rings created by Listen.
+type Config struct {
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+
+	// Options are applied to the listening socket, TCP_NODELAY and SO_REUSEPORT are set if it is zero.
+	Options *socket.SocketOptions
+
+	// MaxBuffered is the number of received bytes buffered per connection before the ring
+	// stops reading it, DefaultMaxBuffered if zero.
+	MaxBuffered int
+}
+
+// Listener is a net.Listener whose connections are accepted and served by UringNet rings.
+type Listener struct {
+	fds      []int // listening sockets, one per ring with SocketOptions.ListenerPerRing
+	addr     net.Addr
+	loop     *uringnet.Ringloop
+	mu       sync.Mutex
+	pending  []*Conn
+	notify   chan struct{}
+	closed   bool
+	closedCh chan struct{}
+}
+
+// Listen announces on the local network address, network must be "tcp", "tcp4", "tcp6" or "unix".
+func Listen(network, address string, cfg Config) (*Listener, error) {
+	addrType := socket.NetAddressType(network)
+	switch addrType {
+	case socket.Tcp, socket.Tcp4, socket.Tcp6, socket.Unix:
+	default:
+		return nil, errors.ErrUnsupportedProtocol
+	}
+	rings := cfg.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: addrType != socket.Unix}
+	if cfg.Options != nil {
+		options = *cfg.Options
+	}
+	maxBuffered := cfg.MaxBuffered
+	if maxBuffered <= 0 {
+		maxBuffered = DefaultMaxBuffered
+	}
+
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: addrType, Address: address}, ringSize, cfg.SQPoll, rings, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		notify:   make(chan struct{}, 1),
+		closedCh: make(chan struct{}),
+	}
+	for _, ringNet := range ringNets {
+		if len(l.fds) == 0 || l.fds[len(l.fds)-1] != ringNet.SocketFd {
+			l.fds = append(l.fds, ringNet.SocketFd)
+		}
+	}
+	if sa, err := unix.Getsockname(l.fds[0]); err == nil {
+		l.addr = sockaddrToAddr(sa)
+		if ua, ok := l.addr.(*net.UnixAddr); ok {
+			ua.Net = network
+		}
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Handler = &ringHandler{l: l, ring: ringNet, maxBuffered: maxBuffered, conns: make(map[int32]*Conn)}
+	}
+	if l.loop, err = uringnet.NewLoops(ringNets, ringBuffers); err != nil {
+		return nil, err
+	}
+	l.loop.RunMany2()
+	return l, nil
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if len(l.pending) > 0 {
+			c := l.pending[0]
+			l.pending[0] = nil
+			l.pending = l.pending[1:]
+			l.mu.Unlock()
+			return c, nil
+		}
+		closed := l.closed
+		l.mu.Unlock()
+		if closed {
+			return nil, &net.OpError{Op: "accept", Net: l.addr.Network(), Addr: l.addr, Err: net.ErrClosed}
+		}
+		select {
+		case <-l.notify:
+		case <-l.closedCh:
+		}
+	}
+}
+
+// Close stops accepting connections, the connections already accepted stay open.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return &net.OpError{Op: "close", Net: l.addr.Network(), Addr: l.addr, Err: net.ErrClosed}
+	}
+	l.closed = true
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	close(l.closedCh)
+
+	for _, c := range pending {
+		_ = c.Close()
+	}
+	// pending accepts fail with EINVAL once the socket is shut down, the rings stop accepting then.
+	var err error
+	for _, fd := range l.fds {
+		_ = unix.Shutdown(fd, unix.SHUT_RDWR)
+		if cerr := unix.Close(fd); cerr != nil && err == nil {
+			err = os.NewSyscallError("close", cerr)
+		}
+	}
+	return err
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Loop returns the ring loop serving the listener.
+func (l *Listener) Loop() *uringnet.Ringloop {
+	return l.loop
+}
+
+func (l *Listener) enqueue(c *Conn) bool {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return false
+	}
+	l.pending = append(l.pending, c)
+	l.mu.Unlock()
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// ringHandler bridges the events of one ring to its connections, it only runs on the ring goroutine.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	l           *Listener
+	ring        *uringnet.URingNet
+	maxBuffered int
+	conns       map[int32]*Conn
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	c := newConn(h.ring, data.Fd, h.maxBuffered)
+	h.conns[data.Fd] = c
+	if !h.l.enqueue(c) {
+		// the listener is closed, the pending read completes with EOF and the ring closes the socket.
+		_ = unix.Shutdown(int(data.Fd), unix.SHUT_RDWR)
+	}
+	return nil, uringnet.None
+}
+
+func (h *ringHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	c := h.conns[data.Fd]
+	if c == nil {
+		return uringnet.Close
+	}
+	if c.onTraffic(data.Buffer[:data.BufSize]) {
+		return uringnet.Read
+	}
+	return uringnet.None
+}
+
+func (h *ringHandler) OnClose(data uringnet.UserData) uringnet.Action {
+	if c := h.conns[data.Fd]; c != nil {
+		delete(h.conns, data.Fd)
+		c.onClose()
+	}
+	return uringnet.None
+}
@@ -0,0 +1,181 @@
+//go:build linux
+
+package netconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, cfg Config) *Listener {
+	t.Helper()
+	cfg.Rings = 2
+	ln, err := Listen("tcp4", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func TestHTTPServe(t *testing.T) {
+	ln := listen(t, Config{})
+	defer ln.Close()
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(append([]byte("echo: "), body...))
+		}))
+	}()
+
+	url := "http://" + ln.Addr().String() + "/"
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(url, "text/plain", bytes.NewReader(bytes.Repeat([]byte("x"), 100000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) != 100006 || string(body[:6]) != "echo: " {
+			t.Fatalf("unexpected body of %d bytes", len(body))
+		}
+	}
+}
+
+func TestConnDeadlinesAndClose(t *testing.T) {
+	ln := listen(t, Config{MaxBuffered: 1024})
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("expect remote address %v, got %v", client.LocalAddr(), conn.RemoteAddr())
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 16)
+	if _, err = conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// more than MaxBuffered bytes pause and resume the reading of the ring.
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	go func() { _, _ = client.Write(payload) }()
+	got := make([]byte, len(payload))
+	if _, err = io.ReadFull(conn, got); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("unexpected read: %v", err)
+	}
+
+	if _, err = conn.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(client, buf[:4]); err != nil || string(buf[:4]) != "pong" {
+		t.Fatalf("unexpected reply %q: %v", buf[:4], err)
+	}
+
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = client.Read(buf); err != io.EOF {
+		t.Fatalf("expect EOF after close, got %v", err)
+	}
+	if _, err = conn.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+}
+
+func TestPeerClose(t *testing.T) {
+	ln := listen(t, Config{})
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = client.Write([]byte("bye"))
+	client.Close()
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "bye" {
+		t.Fatalf("expect the buffered bytes then EOF, got %q %v", got, err)
+	}
+	if _, err = conn.Write([]byte("x")); err == nil {
+		t.Fatal("expect write on a closed connection to fail")
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	ln := listen(t, Config{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expect ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept is not unblocked by Close")
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	ln := listen(t, Config{})
+	defer ln.Close()
+	content := bytes.Repeat([]byte("firmware"), 300000)
+	f, err := os.CreateTemp(t.TempDir(), "firmware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		n, err := conn.(*Conn).SendFile(f, 8, int64(len(content))-8)
+		if err != nil || n != int64(len(content))-8 {
+			t.Errorf("expect the file to be sent, got %d, %v", n, err)
+		}
+		conn.Close()
+	}()
+	got, err := io.ReadAll(client)
+	<-sent
+	if err != nil || !bytes.Equal(got, content[8:]) {
+		t.Fatalf("expect the content of the file, got %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,229 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// WaitStrategy is how the thread of a ring waits for completions when none is available.
+type WaitStrategy int
+
+// Available wait strategies.
+const (
+	// WaitBlock blocks the thread in io_uring_enter until a completion arrives.
+	WaitBlock WaitStrategy = iota
+	// WaitAdaptive polls the completion queue for SpinTime before blocking, which spares the syscall and
+	// the wake-up of the thread when completions follow each other closely.
+	WaitAdaptive
+)
+
+// EngineOptions tune the io_uring instances of the rings and the way their run loops wait, where
+// socket.SocketOptions tune the sockets. They are given to NewManyWithOptions.
+type EngineOptions struct {
+	// CQEntries is the size of the completion queue of each ring, rounded up to a power of two. It must not
+	// be smaller than the submission queue, the kernel makes it twice as large by default. Completions
+	// overflowing the queue are kept by the kernel until the ring has room for them, at a cost, and are
+	// counted by the CQOverflows metric.
+	CQEntries int
+
+	// Wait is how the rings wait for completions, WaitBlock by default. A waiting ring thread uses no CPU,
+	// with SQPOLL too, unless it spins with WaitAdaptive.
+	Wait WaitStrategy
+
+	// SpinTime is how long the rings poll their completion queue with WaitAdaptive, 50µs by default.
+	SpinTime time.Duration
+
+	// WaitTimeout bounds each blocking wait of the rings for completions, a ring wakes up at least that
+	// often. It requires Linux 5.11 (IORING_FEAT_EXT_ARG) and is ignored on older kernels.
+	WaitTimeout time.Duration
+
+	// SQThreadIdle is how long the SQ thread of a ring set up with sqpoll keeps polling without work
+	// before it sleeps, rounded up to milliseconds. The kernel default is one second. A sleeping SQ thread
+	// is woken up by the next submission (IORING_SQ_NEED_WAKEUP), counted by the SQWakeups metric.
+	SQThreadIdle time.Duration
+
+	// SQThreadCPUs pins the SQ threads of the rings set up with sqpoll: the thread of ring i runs on
+	// CPU SQThreadCPUs[i % len(SQThreadCPUs)]. The SQ threads are not pinned if it is empty.
+	SQThreadCPUs []int
+
+	// SharedSQThread makes the rings set up with sqpoll share the SQ thread of the first ring
+	// (IORING_SETUP_ATTACH_WQ), instead of each having its own.
+	SharedSQThread bool
+
+	// SingleIssuer tells the kernel that only the thread running a ring submits to it, which spares it
+	// some synchronization (IORING_SETUP_SINGLE_ISSUER, Linux 6.0). Operations must then only be submitted
+	// on the ring goroutine, other goroutines go through Trigger.
+	SingleIssuer bool
+
+	// CoopTaskrun keeps the kernel from interrupting the thread of a ring to post its completions, they
+	// are posted when the thread enters the ring (IORING_SETUP_COOP_TASKRUN, Linux 5.19).
+	CoopTaskrun bool
+
+	// TaskrunFlag makes the kernel flag the rings whose completions wait for the thread to enter the ring
+	// (IORING_SETUP_TASKRUN_FLAG, Linux 5.19). It requires CoopTaskrun or DeferTaskrun.
+	TaskrunFlag bool
+
+	// DeferTaskrun defers the completion work of a ring until its thread waits for completions
+	// (IORING_SETUP_DEFER_TASKRUN, Linux 6.1). It implies SingleIssuer.
+	DeferTaskrun bool
+
+	// SubmitAll keeps the kernel submitting a batch of operations after one of them failed to be
+	// submitted (IORING_SETUP_SUBMIT_ALL, Linux 5.18).
+	SubmitAll bool
+}
+
+// defaultSpinTime is how long the rings poll their completion queue with WaitAdaptive if the
+// options do not set it.
+const defaultSpinTime = 50 * time.Microsecond
+
+// defaultBufferCap is the size of the ReadBuffer and WriteBuffer of a ring if the options do not set it.
+const defaultBufferCap = 1024
+
+// bufferCap rounds n up to a power of two, defaultBufferCap if n is not positive.
+func bufferCap(n int) int {
+	if n <= 0 {
+		return defaultBufferCap
+	}
+	c := 1
+	for c < n {
+		c <<= 1
+	}
+	return c
+}
+
+// applyOptions applies to the ring index of rings the engine options and the socket options which
+// are not applied to sockets.
+func (ringNet *URingNet) applyOptions(options socket.SocketOptions, engine EngineOptions, index, rings int) {
+	ringNet.ReadBuffer = make([]byte, bufferCap(options.ReadBufferCap))
+	ringNet.WriteBuffer = make([]byte, bufferCap(options.WriteBufferCap))
+	ringNet.ticker = options.Ticker
+	ringNet.wait, ringNet.spinTime = engine.Wait, engine.SpinTime
+	if ringNet.spinTime <= 0 {
+		ringNet.spinTime = defaultSpinTime
+	}
+	ringNet.waitTimeout = nil
+	if engine.WaitTimeout > 0 {
+		if ringNet.caps != nil && ringNet.caps.Features&uring.IORING_FEAT_EXT_ARG == 0 {
+			logging.Default().Log(logging.LevelWarn, "the kernel cannot bound the waits, WaitTimeout is ignored")
+		} else {
+			ts := unix.NsecToTimespec(int64(engine.WaitTimeout))
+			ringNet.waitTimeout = &ts
+		}
+	}
+	ringNet.cpus.Zero()
+	if !options.LockOSThread {
+		return
+	}
+	cpus := runtime.NumCPU()
+	if options.SteerToCPU && options.ListenerPerRing {
+		// the CPUs whose connections are steered to the listener of the ring.
+		for cpu := index; cpu < cpus; cpu += rings {
+			ringNet.cpus.Set(cpu)
+		}
+	}
+	if ringNet.cpus.Count() == 0 {
+		ringNet.cpus.Set(index % cpus)
+	}
+}
+
+// pin pins the OS thread of the ring, which must be locked, to its CPUs if the options asked for it.
+func (ringNet *URingNet) pin() {
+	if ringNet.cpus.Count() == 0 {
+		return
+	}
+	if err := unix.SchedSetaffinity(0, &ringNet.cpus); err != nil {
+		ringNet.log(logging.LevelWarn, "pinning the ring thread failed", "err", os.NewSyscallError("sched_setaffinity", err))
+	}
+}
+
+// setupFlags returns the IORING_SETUP_* flags of the rings asked for by options, leaving out those
+// the kernel does not support. IORING_SETUP_CQSIZE is set if options size the completion queue.
+// The task work flags are left out with sqpoll, the kernel rejects them.
+func setupFlags(options EngineOptions, caps *Capabilities, sqpoll bool) uint32 {
+	var flags uint32
+	if options.CQEntries > 0 {
+		flags |= uring.IORING_SETUP_CQSIZE
+	}
+	if options.SubmitAll {
+		flags |= uring.IORING_SETUP_SUBMIT_ALL
+	}
+	if options.SingleIssuer || options.DeferTaskrun {
+		flags |= uring.IORING_SETUP_SINGLE_ISSUER
+	}
+	taskrun := options.CoopTaskrun || options.TaskrunFlag || options.DeferTaskrun
+	switch {
+	case taskrun && sqpoll:
+		logging.Default().Log(logging.LevelWarn, "the task run options are ignored with sqpoll")
+	case taskrun:
+		if options.CoopTaskrun {
+			flags |= uring.IORING_SETUP_COOP_TASKRUN
+		}
+		if options.DeferTaskrun {
+			flags |= uring.IORING_SETUP_DEFER_TASKRUN
+		}
+		if options.TaskrunFlag && flags&(uring.IORING_SETUP_COOP_TASKRUN|uring.IORING_SETUP_DEFER_TASKRUN) != 0 {
+			flags |= uring.IORING_SETUP_TASKRUN_FLAG
+		}
+	}
+	if unsupported := flags &^ caps.SetupFlags; unsupported != 0 {
+		logging.Default().Log(logging.LevelWarn, "the kernel does not support some setup flags", "flags", unsupported)
+		flags &^= unsupported
+	}
+	if flags&uring.IORING_SETUP_SINGLE_ISSUER != 0 {
+		// the issuer is the thread enabling the ring, see enable.
+		flags |= uring.IORING_SETUP_R_DISABLED
+	}
+	return flags
+}
+
+// enable enables the ring set up disabled, the calling thread becoming its single issuer, then
+// submits the operations queued so far.
+func (ringNet *URingNet) enable() {
+	if !ringNet.disabled {
+		return
+	}
+	if err := ringNet.ring.EnableRings(); err != nil {
+		ringNet.log(logging.LevelError, "enabling the ring failed", "err", os.NewSyscallError("io_uring_register", err))
+	}
+	ringNet.disabled = false
+	_, _ = ringNet.submit(0)
+}
+
+// sqPoll sets up params for the ring index to be polled by an SQ thread as options say, the SQ thread
+// of the ring whose fd is wq if it is not 0.
+func sqPoll(params *uring.IOUringParams, options EngineOptions, index int, wq uintptr) {
+	params.Flags |= uring.IORING_SETUP_SQPOLL
+	// a shared thread idles as long as the longest idle time of its rings, one second if not set.
+	if options.SQThreadIdle > 0 {
+		params.SQThreadIdle = uint32((options.SQThreadIdle + time.Millisecond - 1) / time.Millisecond)
+	}
+	if wq != 0 {
+		// the CPU is that of the shared thread.
+		params.Flags |= uring.IORING_SETUP_ATTACH_WQ
+		params.WQFd = uint32(wq)
+		return
+	}
+	if len(options.SQThreadCPUs) > 0 {
+		params.Flags |= uring.IORING_SETUP_SQ_AFF
+		params.SQThreadCPU = uint32(options.SQThreadCPUs[index%len(options.SQThreadCPUs)])
+	}
+}
+
+// sqPollUsable reports whether the kernel can poll the rings with an SQ thread. Before 5.11 the
+// thread only submits operations on registered files, which most of those of the rings are not.
+func sqPollUsable(caps *Capabilities) bool {
+	if caps.Features&uring.IORING_FEAT_SQPOLL_NONFIXED != 0 {
+		return true
+	}
+	logging.Default().Log(logging.LevelWarn, "the SQ thread of the kernel needs registered files, sqpoll is ignored")
+	return false
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+type tickHandler struct {
+	echoHandler
+	ticks       int32
+	userTimeout int32 // TCP_USER_TIMEOUT of the last accepted connection
+}
+
+func (h *tickHandler) OnTick() (time.Duration, Action) {
+	atomic.AddInt32(&h.ticks, 1)
+	return 10 * time.Millisecond, None
+}
+
+func (h *tickHandler) OnOpen(data *UserData) ([]byte, Action) {
+	v, _ := unix.GetsockoptInt(int(data.Fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT)
+	atomic.StoreInt32(&h.userTimeout, int32(v))
+	return nil, None
+}
+
+func TestSocketOptions(t *testing.T) {
+	linger := 0
+	options := socket.SocketOptions{
+		NumEventLoop:         2,
+		ReadBufferCap:        3000,
+		Ticker:               true,
+		LockOSThread:         true,
+		TCPKeepAlive:         30 * time.Second,
+		TCPKeepAliveInterval: 5 * time.Second,
+		TCPKeepAliveCount:    4,
+		TCPUserTimeout:       10 * time.Second,
+		TCPFastOpen:          16,
+		TCPDeferAccept:       time.Second,
+		Linger:               &linger,
+		Backlog:              8,
+	}
+	h := &tickHandler{}
+	ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 256, false, 1, options, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ringNets) != 2 || len(ringNets[0].ReadBuffer) != 4096 || len(ringNets[0].WriteBuffer) != defaultBufferCap {
+		t.Fatalf("expect 2 rings with a read buffer of 4096 bytes, got %d rings of %d bytes", len(ringNets), len(ringNets[0].ReadBuffer))
+	}
+
+	fd := ringNets[0].SocketFd
+	for _, opt := range []struct {
+		name          string
+		level, opt    int
+		expect        int
+		expectAtLeast bool
+	}{
+		{"SO_KEEPALIVE", unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1, false},
+		{"TCP_KEEPIDLE", unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, 30, false},
+		{"TCP_KEEPINTVL", unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, 5, false},
+		{"TCP_KEEPCNT", unix.IPPROTO_TCP, unix.TCP_KEEPCNT, 4, false},
+		{"TCP_USER_TIMEOUT", unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, 10000, false},
+		{"TCP_FASTOPEN", unix.IPPROTO_TCP, unix.TCP_FASTOPEN, 16, false},
+		// the kernel rounds the delay to a number of SYN-ACK retransmissions.
+		{"TCP_DEFER_ACCEPT", unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, 1, true},
+	} {
+		v, err := unix.GetsockoptInt(fd, opt.level, opt.opt)
+		if err != nil || v != opt.expect && !(opt.expectAtLeast && v > opt.expect) {
+			t.Errorf("expect %s %d, got %d, %v", opt.name, opt.expect, v, err)
+		}
+	}
+	if l, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER); err != nil || l.Onoff != 1 || l.Linger != 0 {
+		t.Errorf("expect SO_LINGER on with a timeout of 0, got %+v, %v", l, err)
+	}
+
+	loop := SetLoops(ringNets, 64)
+	loop.RunMany2()
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp4", socket.SockaddrToTCPOrUnixAddr(sa).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// with TCP_DEFER_ACCEPT, the connection is accepted once the first data arrived.
+	ping(t, c, "hello")
+	if v := atomic.LoadInt32(&h.userTimeout); v != 10000 {
+		t.Errorf("expect the accepted connection to inherit TCP_USER_TIMEOUT, got %d", v)
+	}
+	waitUntil(t, func() bool { return atomic.LoadInt32(&h.ticks) >= 4 })
+}
+
+func TestTickerOff(t *testing.T) {
+	h := &tickHandler{}
+	_, addr := listen(t, 1, h)
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ping(t, c, "hello")
+	time.Sleep(30 * time.Millisecond)
+	if n := atomic.LoadInt32(&h.ticks); n != 0 {
+		t.Fatalf("expect no tick without SocketOptions.Ticker, got %d", n)
+	}
+}
+
+func TestSetupFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options EngineOptions
+		expect  uint32
+	}{
+		{"single issuer", EngineOptions{SingleIssuer: true, SubmitAll: true},
+			uring.IORING_SETUP_SINGLE_ISSUER | uring.IORING_SETUP_SUBMIT_ALL},
+		{"coop taskrun", EngineOptions{CoopTaskrun: true, TaskrunFlag: true},
+			uring.IORING_SETUP_COOP_TASKRUN | uring.IORING_SETUP_TASKRUN_FLAG},
+		{"defer taskrun", EngineOptions{DeferTaskrun: true, TaskrunFlag: true},
+			uring.IORING_SETUP_SINGLE_ISSUER | uring.IORING_SETUP_DEFER_TASKRUN | uring.IORING_SETUP_TASKRUN_FLAG},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			caps, err := KernelCapabilities()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caps.SetupFlags&tc.expect != tc.expect {
+				t.Skip("the kernel does not support the setup flags")
+			}
+			loop, clients, _ := startEchoWith(t, ringConfig{rings: 2, engine: tc.options}, 4)
+			if flags := loop.RingNet[0].ring.Flags(); flags&tc.expect != tc.expect {
+				t.Fatalf("expect the setup flags %#x, got %#x", tc.expect, flags)
+			}
+			for _, c := range clients {
+				ping(t, c, "hello")
+				ping(t, c, "again")
+			}
+		})
+	}
+}
+
+func TestCQOverflow(t *testing.T) {
+	loop, _ := setUp(t, ringConfig{rings: 1, size: 8, engine: EngineOptions{CQEntries: 8}}, &echoHandler{})
+	ringNet := loop.RingNet[0]
+	if n := ringNet.ring.CQSize(); n != 8 {
+		t.Fatalf("expect a completion queue of 8 entries, got %d", n)
+	}
+	loop.RunMany2()
+
+	// the completions of the operations submitted by a task are reaped once it returns.
+	const ops = 40
+	var done int32
+	if err := ringNet.Trigger(func() {
+		for i := 0; i < ops; i++ {
+			ringNet.Submit(uring.Nop, func(int32, uint32) { atomic.AddInt32(&done, 1) })
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, func() bool { return atomic.LoadInt32(&done) == ops })
+	if m := ringNet.Metrics(); m.CQOverflows == 0 || m.CQEsDropped != 0 {
+		t.Fatalf("expect an overflow without dropped completions, got %d overflows and %d dropped", m.CQOverflows, m.CQEsDropped)
+	}
+}
+
+func TestBatchedSubmissions(t *testing.T) {
+	// the connection is registered once the ring runs.
+	loop, _, _ := startEcho(t, 1, 1)
+	ringNet := loop.RingNet[0]
+
+	// the operations submitted while the loop processes a batch are submitted at once after it.
+	const ops = 16
+	var done int32
+	deferred := make(chan bool, 1)
+	if err := ringNet.Trigger(func() {
+		before := ringNet.Metrics().SQEsSubmitted
+		for i := 0; i < ops; i++ {
+			ringNet.Submit(uring.Nop, func(int32, uint32) { atomic.AddInt32(&done, 1) })
+		}
+		deferred <- ringNet.Metrics().SQEsSubmitted == before
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !<-deferred {
+		t.Error("expect the submissions to be deferred to the end of the batch")
+	}
+	waitUntil(t, func() bool { return atomic.LoadInt32(&done) == ops })
+}
+
+func TestWaitStrategies(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		options            EngineOptions
+		minWaits, maxWaits uint64 // waits of an idle ring in 100ms
+	}{
+		{"block", EngineOptions{}, 0, 1},
+		{"adaptive", EngineOptions{Wait: WaitAdaptive, SpinTime: time.Millisecond}, 0, 1},
+		{"timeout", EngineOptions{WaitTimeout: 5 * time.Millisecond}, 5, 60},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loop, clients, ids := startEchoWith(t, ringConfig{rings: 2, engine: tc.options}, 1)
+			ping(t, clients[0], "hello")
+
+			// the ring without connection stays idle, it waits in the kernel until its wait times out.
+			info, _ := loop.Get(ids[0])
+			idle := loop.RingNet[1-info.Ring]
+			waitUntil(t, func() bool { return idle.Metrics().Waits > 0 })
+			before := idle.Metrics().Waits
+			time.Sleep(100 * time.Millisecond)
+			if n := idle.Metrics().Waits - before; n < tc.minWaits || n > tc.maxWaits {
+				t.Errorf("expect the idle ring to wait %d to %d times, got %d", tc.minWaits, tc.maxWaits, n)
+			}
+			ping(t, clients[0], "again")
+			for _, ringNet := range loop.RingNet {
+				if n := ringNet.Metrics().SubmitErrors; n != 0 {
+					t.Errorf("expect the waits to succeed or time out, got %d errors", n)
+				}
+			}
+		})
+	}
+}
+
+func TestSQBacklog(t *testing.T) {
+	caps, err := KernelCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.SetupFlags&uring.IORING_SETUP_SINGLE_ISSUER == 0 {
+		t.Skip("the kernel does not support single issuer rings")
+	}
+	// the single issuer ring submits nothing until it runs, its submission queue of 4 entries fills up.
+	loop, addr := setUp(t, ringConfig{rings: 1, size: 4, engine: EngineOptions{SingleIssuer: true}}, &echoHandler{})
+	ringNet := loop.RingNet[0]
+	const ops = 20
+	var done int32
+	for i := 0; i < ops; i++ {
+		ringNet.Submit(uring.Nop, func(int32, uint32) { atomic.AddInt32(&done, 1) })
+	}
+	if m := ringNet.Metrics(); m.Backlogged == 0 {
+		t.Fatal("expect entries in the backlog")
+	}
+	loop.RunMany2()
+	waitUntil(t, func() bool { return atomic.LoadInt32(&done) == ops })
+	ping(t, dialN(t, addr, 1)[0], "hello")
+}
+
+func TestSQPoll(t *testing.T) {
+	engine := EngineOptions{SQThreadIdle: 5 * time.Millisecond, SQThreadCPUs: []int{0}, SharedSQThread: true}
+	loop, clients, ids := startEchoWith(t, ringConfig{rings: 2, sqpoll: true, engine: engine}, 1)
+	if flags := loop.RingNet[0].ring.Flags(); flags&uring.IORING_SETUP_SQ_AFF == 0 || flags&uring.IORING_SETUP_ATTACH_WQ != 0 {
+		t.Fatalf("expect the first ring to have its SQ thread pinned, got the setup flags %#x", flags)
+	}
+	if flags := loop.RingNet[1].ring.Flags(); flags&uring.IORING_SETUP_ATTACH_WQ == 0 {
+		t.Fatalf("expect the second ring to share the SQ thread of the first, got the setup flags %#x", flags)
+	}
+	c := clients[0]
+	ping(t, c, "hello")
+
+	// past its idle time, the SQ thread sleeps. It stays asleep while the rings are idle, the ring
+	// threads do not enter the rings either.
+	info, _ := loop.Get(ids[0])
+	ringNet := loop.RingNet[info.Ring]
+	waitUntil(t, func() bool { return ringNet.ring.SQNeedsWakeup() })
+	before := ringNet.Metrics()
+	time.Sleep(50 * time.Millisecond)
+	after := ringNet.Metrics()
+	if !ringNet.ring.SQNeedsWakeup() || after.SQWakeups != before.SQWakeups {
+		t.Error("expect the idle SQ thread to keep sleeping")
+	}
+	if after.Waits != before.Waits || after.SQEsSubmitted != before.SQEsSubmitted {
+		t.Errorf("expect the idle ring not to enter, it waited %d times and submitted %d entries",
+			after.Waits-before.Waits, after.SQEsSubmitted-before.SQEsSubmitted)
+	}
+	ping(t, c, "again")
+}
+
+func TestRingSetupFailure(t *testing.T) {
+	openFds := func() map[string]bool {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			fds[e.Name()] = true
+		}
+		return fds
+	}
+	// the rings of the previous tests may still be closing, only the fds opened since matter.
+	before := openFds()
+	// the SQ thread of the second ring cannot run on a CPU that does not exist.
+	engine := EngineOptions{SQThreadCPUs: []int{0, 1 << 16}}
+	options := socket.SocketOptions{ListenerPerRing: true}
+	if _, err := NewManyWithOptions(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 256, true, 3, options, engine, &echoHandler{}); err == nil {
+		t.Fatal("expect the setup of the second ring to fail")
+	}
+	for fd := range openFds() {
+		if !before[fd] {
+			t.Errorf("expect the rings and listeners to be closed, fd %s is left open", fd)
+		}
+	}
+}
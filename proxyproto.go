@@ -0,0 +1,421 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/logging"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// ProxyMode tells how a loop handles the PROXY protocol header of its connections.
+type ProxyMode int
+
+const (
+	ProxyOff     ProxyMode = iota // connections do not start with a header
+	ProxyStrict                   // connections must start with a header, the others are rejected
+	ProxyLenient                  // connections may start with a header, the others keep the addresses of their socket
+)
+
+// DefaultProxyTimeout is the time allowed to receive a PROXY header if ProxyProtocol.Timeout is 0.
+const DefaultProxyTimeout = 5 * time.Second
+
+// ProxyProtocol configures the PROXY protocol, v1 and v2, on the connections of a loop. The header
+// is read by the engine before OnOpen fires, the handler only sees the data after it.
+//
+// In lenient mode, a connection which sends nothing until the timeout is opened without a header,
+// as is one whose first bytes cannot start a header.
+type ProxyProtocol struct {
+	Mode    ProxyMode
+	Timeout time.Duration // time allowed to receive the header, DefaultProxyTimeout if 0
+}
+
+// SetProxyProtocol sets the PROXY protocol handling of the connections of the loop, nil turns it
+// off. It is safe to call from any goroutine, it applies to the connections accepted afterwards.
+func (loop *Ringloop) SetProxyProtocol(p *ProxyProtocol) {
+	loop.proxy.Store(p)
+}
+
+// ProxyProtocol returns the PROXY protocol handling of the loop, nil if none is set.
+func (loop *Ringloop) ProxyProtocol() *ProxyProtocol {
+	p, _ := loop.proxy.Load().(*ProxyProtocol)
+	return p
+}
+
+// Types of the TLVs of a PROXY protocol v2 header.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+// ProxyTLV is a type-length-value of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is the PROXY protocol header a connection started with.
+type ProxyHeader struct {
+	Version int // 1 or 2
+	// Local is true for a v2 LOCAL command, a v1 UNKNOWN protocol or an unspecified v2 family,
+	// sent by the proxy for its own connections, e.g. health checks. Source and Destination are nil.
+	Local       bool
+	Source      net.Addr // address of the client, a *net.TCPAddr, *net.UDPAddr or *net.UnixAddr
+	Destination net.Addr // address the client connected to
+	TLVs        []ProxyTLV
+}
+
+// TLV returns the value of the first TLV of type typ of the header.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+)
+
+// hasPrefix reports whether b and the start of sig are the same.
+func hasPrefix(b, sig []byte) bool {
+	if len(b) < len(sig) {
+		return bytes.Equal(b, sig[:len(b)])
+	}
+	return bytes.HasPrefix(b, sig)
+}
+
+// hasProxyPrefix reports whether b may be the start of a PROXY header.
+func hasProxyPrefix(b []byte) bool {
+	return hasPrefix(b, proxyV1Signature) || hasPrefix(b, proxyV2Signature)
+}
+
+// parseProxyHeader parses the PROXY header at the start of b and returns it with its length.
+// n is 0 and err nil if b is only the start of a header.
+func parseProxyHeader(b []byte) (h *ProxyHeader, n int, err error) {
+	switch {
+	case hasPrefix(b, proxyV2Signature):
+		return parseProxyV2(b)
+	case hasPrefix(b, proxyV1Signature):
+		return parseProxyV1(b)
+	}
+	return nil, 0, errors.ErrInvalidProxyHeader
+}
+
+func parseProxyV1(b []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= proxyV1MaxLength {
+			return nil, 0, errors.ErrInvalidProxyHeader
+		}
+		return nil, 0, nil
+	}
+	if end+2 > proxyV1MaxLength {
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	h := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(b[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// the rest of the line is ignored.
+		h.Local = true
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	v4 := fields[1] == "TCP4"
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err2 := strconv.ParseUint(fields[5], 10, 16)
+	// an IPv6 address has colons, an IPv4 one none.
+	if src == nil || dst == nil || err1 != nil || err2 != nil ||
+		strings.Contains(fields[2], ":") == v4 || strings.Contains(fields[3], ":") == v4 {
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: int(sport)}
+	h.Destination = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return h, end + 2, nil
+}
+
+func parseProxyV2(b []byte) (*ProxyHeader, int, error) {
+	if len(b) < proxyV2HeaderLen {
+		return nil, 0, nil
+	}
+	if b[12]>>4 != 2 {
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	command, family := b[12]&0xf, b[13]
+	n := proxyV2HeaderLen + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return nil, 0, nil
+	}
+	h := &ProxyHeader{Version: 2}
+	switch command {
+	case 0:
+		// LOCAL: the addresses and TLVs are discarded.
+		h.Local = true
+		return h, n, nil
+	case 1:
+	default:
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	payload := b[proxyV2HeaderLen:n]
+	var size int
+	switch family >> 4 {
+	case 0:
+		h.Local = true
+	case 1:
+		size = 12
+	case 2:
+		size = 36
+	case 3:
+		size = 216
+	default:
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	transport := family & 0xf
+	if len(payload) < size || transport > 2 {
+		return nil, 0, errors.ErrInvalidProxyHeader
+	}
+	addrs := payload[:size]
+	switch family >> 4 {
+	case 1, 2:
+		ipLen := (size - 4) / 2
+		src, dst := net.IP(append([]byte(nil), addrs[:ipLen]...)), net.IP(append([]byte(nil), addrs[ipLen:2*ipLen]...))
+		sport := int(binary.BigEndian.Uint16(addrs[2*ipLen:]))
+		dport := int(binary.BigEndian.Uint16(addrs[2*ipLen+2:]))
+		if transport == 2 {
+			h.Source, h.Destination = &net.UDPAddr{IP: src, Port: sport}, &net.UDPAddr{IP: dst, Port: dport}
+		} else {
+			h.Source, h.Destination = &net.TCPAddr{IP: src, Port: sport}, &net.TCPAddr{IP: dst, Port: dport}
+		}
+	case 3:
+		network := "unix"
+		if transport == 2 {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: unixPath(addrs[:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: unixPath(addrs[108:]), Net: network}
+	}
+	for tlvs := payload[size:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, 0, errors.ErrInvalidProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, 0, errors.ErrInvalidProxyHeader
+		}
+		tlv := ProxyTLV{Type: tlvs[0], Value: append([]byte(nil), tlvs[3:3+l]...)}
+		if tlv.Type == ProxyTLVCRC32C {
+			if l != 4 || !validCRC32C(b[:n], n-len(tlvs)+3) {
+				return nil, 0, errors.ErrInvalidProxyHeader
+			}
+		}
+		h.TLVs = append(h.TLVs, tlv)
+		tlvs = tlvs[3+l:]
+	}
+	return h, n, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// validCRC32C checks the checksum of the header at offset, computed with the checksum zeroed.
+func validCRC32C(header []byte, offset int) bool {
+	sum := binary.BigEndian.Uint32(header[offset:])
+	table := crc32.MakeTable(crc32.Castagnoli)
+	crc := crc32.Update(0, table, header[:offset])
+	crc = crc32.Update(crc, table, []byte{0, 0, 0, 0})
+	crc = crc32.Update(crc, table, header[offset+4:])
+	return crc == sum
+}
+
+// proxyHandshake reads the PROXY header of an accepted connection. The header is peeked, then
+// exactly its bytes are consumed, so that the data following it is left for the handler.
+//
+// In lenient mode nothing is consumed until the whole header is peeked, since bytes which only
+// start a header may turn out to be data of a connection without one.
+type proxyHandshake struct {
+	ringNet  *URingNet
+	data     *UserData
+	lenient  bool
+	buf      []byte // bytes of the header consumed so far
+	seen     []byte // bytes peeked by the last receive, lenient mode only
+	timer    *Timer
+	op       uint64 // user data of the pending receive
+	waiting  bool   // a peek is delayed until more bytes may have arrived
+	timedOut bool
+}
+
+// proxyRepeekDelay is the delay before peeking again a header incomplete in lenient mode.
+const proxyRepeekDelay = 2 * time.Millisecond
+
+// readProxyHeader reads the PROXY header of the connection of data, then opens it.
+func (ringNet *URingNet) readProxyHeader(data *UserData, p ProxyProtocol) {
+	hs := &proxyHandshake{ringNet: ringNet, data: data, lenient: p.Mode == ProxyLenient, buf: make([]byte, 0, proxyV1MaxLength)}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProxyTimeout
+	}
+	hs.timer = ringNet.AfterFunc(timeout, func() {
+		hs.timedOut = true
+		// a delayed peek sees the timeout once it runs.
+		if !hs.waiting {
+			ringNet.Cancel(hs.op)
+		}
+	})
+	hs.peek()
+}
+
+func (hs *proxyHandshake) peek() {
+	hs.op = hs.ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Recv(sqe, uintptr(hs.data.Fd), hs.buf[len(hs.buf):cap(hs.buf)], unix.MSG_PEEK)
+	}, hs.peeked)
+}
+
+func (hs *proxyHandshake) peeked(res int32, _ uint32) {
+	if res <= 0 {
+		if hs.timedOut && hs.lenient && len(hs.buf) == 0 {
+			hs.open(nil)
+			return
+		}
+		hs.fail(res)
+		return
+	}
+	b := hs.buf[:len(hs.buf)+int(res)]
+	if hs.lenient && len(hs.buf) == 0 && !hasProxyPrefix(b) {
+		hs.open(nil)
+		return
+	}
+	h, n, err := parseProxyHeader(b)
+	switch {
+	case err != nil:
+		hs.fail(0)
+	case n == 0 && hs.lenient:
+		hs.grow(b)
+		hs.seen = b
+		hs.repeek()
+	case n == 0:
+		// the peeked bytes only start the header, consume them and wait for the rest.
+		hs.grow(b)
+		hs.consume(len(b), nil)
+	default:
+		hs.consume(n, h)
+	}
+}
+
+// grow makes room in buf for the whole header if b starts a v2 one, whose fixed part tells the
+// length of the rest.
+func (hs *proxyHandshake) grow(b []byte) {
+	if len(b) < proxyV2HeaderLen || !hasPrefix(b, proxyV2Signature) {
+		return
+	}
+	if n := proxyV2HeaderLen + int(binary.BigEndian.Uint16(b[14:16])); n > cap(hs.buf) {
+		buf := make([]byte, len(hs.buf), n)
+		copy(buf, hs.buf)
+		hs.buf = buf
+	}
+}
+
+// repeek peeks the header again after proxyRepeekDelay, a peek would return at once the bytes
+// already seen. Once the handshake has timed out, the connection is opened without a header
+// unless the bytes seen start with a full signature.
+func (hs *proxyHandshake) repeek() {
+	hs.waiting = true
+	hs.ringNet.AfterFunc(proxyRepeekDelay, func() {
+		hs.waiting = false
+		switch {
+		case !hs.timedOut:
+			hs.peek()
+		case bytes.HasPrefix(hs.seen, proxyV1Signature) || bytes.HasPrefix(hs.seen, proxyV2Signature):
+			hs.fail(0)
+		default:
+			hs.open(nil)
+		}
+	})
+}
+
+// consume receives the bytes of the header up to n, h is the header if they complete it.
+func (hs *proxyHandshake) consume(n int, h *ProxyHeader) {
+	hs.op = hs.ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Recv(sqe, uintptr(hs.data.Fd), hs.buf[len(hs.buf):n], 0)
+	}, func(res int32, _ uint32) {
+		if res <= 0 {
+			hs.fail(res)
+			return
+		}
+		hs.buf = hs.buf[:len(hs.buf)+int(res)]
+		if h != nil && len(hs.buf) == n {
+			hs.open(h)
+			return
+		}
+		hs.peek()
+	})
+}
+
+func (hs *proxyHandshake) open(h *ProxyHeader) {
+	hs.timer.Stop()
+	hs.ringNet.open(hs.data, h)
+	_, _ = hs.ringNet.submit(0)
+}
+
+// fail rejects the connection, res is the result of the failed receive.
+func (hs *proxyHandshake) fail(res int32) {
+	hs.timer.Stop()
+	reason := errors.ErrInvalidProxyHeader.Error()
+	switch {
+	case hs.timedOut:
+		reason = "PROXY header timeout"
+	case res == 0:
+		reason = "closed before the PROXY header"
+	case res < 0:
+		reason = unix.Errno(-res).Error()
+	}
+	hs.ringNet.reject(hs.data.Fd, nil, reason)
+}
+
+// proxyAddrs returns the address of the client and its IP from h, nil if h carries none.
+func proxyAddrs(h *ProxyHeader) (net.Addr, net.IP) {
+	if h == nil || h.Local {
+		return nil, nil
+	}
+	switch a := h.Source.(type) {
+	case *net.TCPAddr:
+		return a, a.IP
+	case *net.UDPAddr:
+		return a, a.IP
+	}
+	return h.Source, nil
+}
+
+// logProxy logs the PROXY header of an opened connection.
+func (ringNet *URingNet) logProxy(fd int32, h *ProxyHeader) {
+	ringNet.log(logging.LevelDebug, "PROXY header received", "fd", fd, "version", h.Version, "source", h.Source, "destination", h.Destination)
+}
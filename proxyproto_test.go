@@ -0,0 +1,204 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+)
+
+// proxyV2 builds a v2 PROXY header of family fam with the address block addrs and tlvs, a CRC32C
+// TLV is appended if crc is true.
+func proxyV2(command, fam byte, addrs []byte, crc bool, tlvs ...ProxyTLV) []byte {
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, 0x20|command, fam, 0, 0)
+	b = append(b, addrs...)
+	for _, tlv := range tlvs {
+		b = append(b, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		b = append(b, tlv.Value...)
+	}
+	if crc {
+		b = append(b, ProxyTLVCRC32C, 0, 4, 0, 0, 0, 0)
+	}
+	binary.BigEndian.PutUint16(b[14:], uint16(len(b)-proxyV2HeaderLen))
+	if crc {
+		binary.BigEndian.PutUint32(b[len(b)-4:], crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return b
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0x30, 0x39, 0x01, 0xbb}
+	badCRC := proxyV2(1, 0x11, ipv4, true)
+	badCRC[len(badCRC)-1]++
+	tests := []struct {
+		name   string
+		in     string
+		n      int
+		err    error
+		source string
+		local  bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\nGET", 45, nil, "192.0.2.1:12345", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n", 40, nil, "[2001:db8::1]:1", false},
+		{"v1 unknown", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", 35, nil, "", true},
+		{"v1 incomplete", "PROXY TCP4 192.0.2.1", 0, nil, "", false},
+		{"v1 signature start", "PRO", 0, nil, "", false},
+		{"v1 mixed families", "PROXY TCP4 2001:db8::1 198.51.100.7 1 2\r\n", 0, errors.ErrInvalidProxyHeader, "", false},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.7 70000 2\r\n", 0, errors.ErrInvalidProxyHeader, "", false},
+		{"v1 too long", "PROXY TCP4 " + string(bytes.Repeat([]byte("1"), 100)), 0, errors.ErrInvalidProxyHeader, "", false},
+		{"v2 tcp4", string(proxyV2(1, 0x11, ipv4, true)) + "data", 16 + 12 + 7, nil, "192.0.2.1:12345", false},
+		{"v2 local", string(proxyV2(0, 0x11, ipv4, false)), 28, nil, "", true},
+		{"v2 unix", string(proxyV2(1, 0x31, append(append([]byte("/src"), make([]byte, 104)...), make([]byte, 108)...), false)), 16 + 216, nil, "/src", false},
+		{"v2 incomplete", string(proxyV2(1, 0x11, ipv4, false)[:20]), 0, nil, "", false},
+		{"v2 bad crc", string(badCRC), 0, errors.ErrInvalidProxyHeader, "", false},
+		{"v2 bad version", string(append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0)), 0, errors.ErrInvalidProxyHeader, "", false},
+		{"not a header", "GET / HTTP/1.1\r\n", 0, errors.ErrInvalidProxyHeader, "", false},
+	}
+	for _, tt := range tests {
+		h, n, err := parseProxyHeader([]byte(tt.in))
+		if err != tt.err || n != tt.n {
+			t.Errorf("%s: expect %d, %v, got %d, %v", tt.name, tt.n, tt.err, n, err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if h.Local != tt.local || (!tt.local && h.Source.String() != tt.source) {
+			t.Errorf("%s: unexpected header %+v", tt.name, h)
+		}
+	}
+
+	h, _, _ := parseProxyHeader(proxyV2(1, 0x21, make([]byte, 36), false, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")}))
+	if v, ok := h.TLV(ProxyTLVAuthority); !ok || string(v) != "example.com" {
+		t.Fatalf("expect the authority TLV, got %+v", h.TLVs)
+	}
+	if _, ok := h.Source.(*net.TCPAddr); !ok {
+		t.Fatalf("expect a TCP source, got %T", h.Source)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	loop, addr := listen(t, 1, &echoHandler{})
+	loop.SetProxyProtocol(&ProxyProtocol{Mode: ProxyStrict, Timeout: 200 * time.Millisecond})
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		return c
+	}
+	echoed := func(c net.Conn, msg string) bool {
+		got := make([]byte, len(msg))
+		_, err := io.ReadFull(c, got)
+		return err == nil && string(got) == msg
+	}
+	// the headers carry the port of the client as destination port to find its connection.
+	source := func(c net.Conn) (h *ProxyHeader, remote string) {
+		port := c.LocalAddr().(*net.TCPAddr).Port
+		waitUntil(t, func() bool {
+			loop.Range(func(info ConnInfo) bool {
+				if info.Proxy != nil && info.Proxy.Destination.(*net.TCPAddr).Port == port {
+					h, remote = info.Proxy, info.RemoteAddr.String()
+				}
+				return true
+			})
+			return h != nil
+		})
+		return h, remote
+	}
+
+	// a v1 header sent in pieces, with data in the same segment as its end.
+	c := dial()
+	port := c.LocalAddr().(*net.TCPAddr).Port
+	line := []byte("PROXY TCP4 192.0.2.1 198.51.100.7 12345 " + strconv.Itoa(port) + "\r\nhello")
+	_, _ = c.Write(line[:9])
+	time.Sleep(20 * time.Millisecond)
+	_, _ = c.Write(line[9:])
+	if !echoed(c, "hello") {
+		t.Fatal("expect the data after the v1 header to be echoed")
+	}
+	if h, remote := source(c); h.Version != 1 || remote != "192.0.2.1:12345" {
+		t.Fatalf("expect the source of the header, got %s", remote)
+	}
+
+	// a v2 header with a TLV.
+	c = dial()
+	port = c.LocalAddr().(*net.TCPAddr).Port
+	addrs := []byte{203, 0, 113, 9, 198, 51, 100, 7, 0x10, 0x00, byte(port >> 8), byte(port)}
+	hdr := proxyV2(1, 0x11, addrs, true, ProxyTLV{Type: ProxyTLVUniqueID, Value: bytes.Repeat([]byte("u"), 200)})
+	_, _ = c.Write(append(hdr, "world"...))
+	if !echoed(c, "world") {
+		t.Fatal("expect the data after the v2 header to be echoed")
+	}
+	if h, remote := source(c); h.Version != 2 || remote != "203.0.113.9:4096" {
+		t.Fatalf("expect the source of the header, got %s", remote)
+	}
+
+	// strict: no header, or no data before the timeout, is rejected.
+	c = dial()
+	_, _ = c.Write([]byte("hello"))
+	if echoed(c, "hello") {
+		t.Fatal("expect a connection without a header to be rejected")
+	}
+	c = dial()
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF || time.Since(start) > time.Second {
+		t.Fatalf("expect the connection to be closed after the timeout, got %v", err)
+	}
+
+	// lenient: a connection without a header keeps its address.
+	loop.SetProxyProtocol(&ProxyProtocol{Mode: ProxyLenient, Timeout: 100 * time.Millisecond})
+	c = dial()
+	_, _ = c.Write([]byte("plain"))
+	if !echoed(c, "plain") {
+		t.Fatal("expect a connection without a header to be accepted")
+	}
+	c = dial()
+	time.Sleep(200 * time.Millisecond)
+	_, _ = c.Write([]byte("late"))
+	if !echoed(c, "late") {
+		t.Fatal("expect a silent connection to be accepted after the timeout")
+	}
+
+	// lenient: bytes which only start a signature are kept when the rest does not match it, or
+	// when nothing follows them before the timeout.
+	c = dial()
+	_, _ = c.Write([]byte("PR"))
+	time.Sleep(20 * time.Millisecond)
+	_, _ = c.Write([]byte("INT"))
+	if !echoed(c, "PRINT") {
+		t.Fatal("expect the start of a signature to be kept")
+	}
+	c = dial()
+	_, _ = c.Write([]byte("PRO"))
+	if !echoed(c, "PRO") {
+		t.Fatal("expect the start of a signature to be kept after the timeout")
+	}
+	// a header sent in pieces is still read.
+	c = dial()
+	port = c.LocalAddr().(*net.TCPAddr).Port
+	line = []byte("PROXY TCP4 192.0.2.8 198.51.100.7 4321 " + strconv.Itoa(port) + "\r\nlenient")
+	_, _ = c.Write(line[:2])
+	time.Sleep(20 * time.Millisecond)
+	_, _ = c.Write(line[2:20])
+	time.Sleep(20 * time.Millisecond)
+	_, _ = c.Write(line[20:])
+	if !echoed(c, "lenient") {
+		t.Fatal("expect the data after the lenient v1 header to be echoed")
+	}
+	if _, remote := source(c); remote != "192.0.2.8:4321" {
+		t.Fatalf("expect the source of the header, got %s", remote)
+	}
+}
@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet/logging"
+)
+
+// RateLimit is a token bucket limiting the bytes and messages of a connection. A message is a read
+// completion; bytes are those read from and written to the connection. A zero rate is no limit.
+type RateLimit struct {
+	BytesPerSecond    float64
+	BytesBurst        int // bytes allowed at once, BytesPerSecond if 0
+	MessagesPerSecond float64
+	MessagesBurst     int // messages allowed at once, MessagesPerSecond if 0
+}
+
+// RatePolicy is the rate limiting of the connections of a loop.
+//
+// The limits are enforced by delaying the read of a connection which exceeded them until its
+// buckets are refilled, with a timeout of its ring: no data is dropped, the peer is slowed down by
+// the flow control of the socket. Writes are not delayed but their bytes are charged to the
+// buckets, which delays the next read.
+type RatePolicy struct {
+	PerConn RateLimit // limit of each connection
+	PerIP   RateLimit // limit shared by the connections from a source IP
+}
+
+// SetRatePolicy sets the rate limiting of the connections of the loop, nil for no limit. It is safe
+// to call from any goroutine while the loop runs, the buckets are reset when the policy changes.
+func (loop *Ringloop) SetRatePolicy(p *RatePolicy) {
+	loop.rates.policy.Store(p)
+}
+
+// RatePolicy returns the rate limiting of the connections of the loop, nil if none is set.
+func (loop *Ringloop) RatePolicy() *RatePolicy {
+	p, _ := loop.rates.policy.Load().(*RatePolicy)
+	return p
+}
+
+// rateState holds the policy of a loop and the buckets shared by the connections from an IP.
+type rateState struct {
+	policy atomic.Value // *RatePolicy
+
+	mu    sync.Mutex
+	perIP map[string]*ipBucket
+}
+
+type ipBucket struct {
+	bucket
+	refs int // connections charging the bucket
+}
+
+// bucket is the state of a RateLimit, tokens go negative when more than the burst is charged.
+type bucket struct {
+	limit    RateLimit
+	bytes    float64
+	messages float64
+	last     int64 // monotonic time of the last refill
+}
+
+func (b *bucket) reset(limit RateLimit, now int64) {
+	*b = bucket{limit: limit, last: now}
+	b.bytes, b.messages = burst(limit.BytesPerSecond, limit.BytesBurst), burst(limit.MessagesPerSecond, limit.MessagesBurst)
+}
+
+func burst(rate float64, n int) float64 {
+	if n > 0 {
+		return float64(n)
+	}
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// refill adds the tokens earned since the last refill.
+func (b *bucket) refill(now int64) {
+	elapsed := float64(now-b.last) / float64(time.Second)
+	b.last = now
+	if r := b.limit.BytesPerSecond; r > 0 {
+		if b.bytes += r * elapsed; b.bytes > burst(r, b.limit.BytesBurst) {
+			b.bytes = burst(r, b.limit.BytesBurst)
+		}
+	}
+	if r := b.limit.MessagesPerSecond; r > 0 {
+		if b.messages += r * elapsed; b.messages > burst(r, b.limit.MessagesBurst) {
+			b.messages = burst(r, b.limit.MessagesBurst)
+		}
+	}
+}
+
+// charge takes bytes and messages from the bucket.
+func (b *bucket) charge(now int64, bytes, messages int) {
+	b.refill(now)
+	if b.limit.BytesPerSecond > 0 {
+		b.bytes -= float64(bytes)
+	}
+	if b.limit.MessagesPerSecond > 0 {
+		b.messages -= float64(messages)
+	}
+}
+
+// delay returns how long to wait for the bucket to be out of debt.
+func (b *bucket) delay(now int64) time.Duration {
+	b.refill(now)
+	var d float64
+	if b.bytes < 0 {
+		d = -b.bytes / b.limit.BytesPerSecond
+	}
+	if b.messages < 0 {
+		if m := -b.messages / b.limit.MessagesPerSecond; m > d {
+			d = m
+		}
+	}
+	return time.Duration(d * float64(time.Second))
+}
+
+func (l RateLimit) enabled() bool {
+	return l.BytesPerSecond > 0 || l.MessagesPerSecond > 0
+}
+
+// ipBucket returns the bucket of the source key, counting a reference to it.
+func (s *rateState) ipBucket(key string) *ipBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.perIP == nil {
+		s.perIP = make(map[string]*ipBucket)
+	}
+	b := s.perIP[key]
+	if b == nil {
+		b = &ipBucket{}
+		s.perIP[key] = b
+	}
+	b.refs++
+	return b
+}
+
+// release drops a reference to the bucket of the source key.
+func (s *rateState) release(key string) {
+	s.mu.Lock()
+	if b := s.perIP[key]; b != nil {
+		if b.refs--; b.refs <= 0 {
+			delete(s.perIP, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// charge charges bytes and messages of the connection c to its buckets.
+func (ringNet *URingNet) charge(c *conn, bytes, messages int) {
+	loop := ringNet.ringloop
+	if loop == nil {
+		return
+	}
+	p := loop.RatePolicy()
+	if p == nil {
+		return
+	}
+	now := monotonic()
+	if p.PerConn.enabled() {
+		if c.rate == nil || c.rate.limit != p.PerConn {
+			c.rate = &bucket{}
+			c.rate.reset(p.PerConn, now)
+		}
+		c.rate.charge(now, bytes, messages)
+	}
+	if p.PerIP.enabled() && c.ipKey != "" {
+		if c.ipRate == nil {
+			c.ipRate = loop.rates.ipBucket(c.ipKey)
+		}
+		loop.rates.mu.Lock()
+		if c.ipRate.limit != p.PerIP {
+			c.ipRate.reset(p.PerIP, now)
+		}
+		c.ipRate.charge(now, bytes, messages)
+		loop.rates.mu.Unlock()
+	}
+}
+
+// throttle delays the read of the connection fd if it exceeded its rate limits: the read is armed
+// by a timeout of the ring once the buckets are refilled. It reports whether the read is delayed.
+func (ringNet *URingNet) throttle(fd int32) bool {
+	c := ringNet.conns[fd]
+	if c == nil || ringNet.ringloop == nil {
+		return false
+	}
+	p := ringNet.ringloop.RatePolicy()
+	if p == nil {
+		return false
+	}
+	now := monotonic()
+	var d time.Duration
+	if c.rate != nil && p.PerConn.enabled() {
+		d = c.rate.delay(now)
+	}
+	if c.ipRate != nil && p.PerIP.enabled() {
+		ringNet.ringloop.rates.mu.Lock()
+		if ipd := c.ipRate.delay(now); ipd > d {
+			d = ipd
+		}
+		ringNet.ringloop.rates.mu.Unlock()
+	}
+	if d <= 0 {
+		return false
+	}
+	atomic.AddUint64(&ringNet.metrics.throttled, 1)
+	atomic.AddUint64(&ringNet.metrics.throttledTime, uint64(d))
+	ringNet.log(logging.LevelDebug, "read throttled", "fd", fd, "delay", d)
+	ringNet.AfterFunc(d, func() {
+		// the connection may have been closed, and its fd reused, meanwhile.
+		if cur, ok := ringNet.conns[fd]; ok && cur == c {
+			ringNet.armRead(fd)
+			_, _ = ringNet.submit(0)
+		}
+	})
+	return true
+}
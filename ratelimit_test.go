@@ -0,0 +1,79 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pingPong writes n messages of size bytes to c and reads their echo.
+func pingPong(t *testing.T, c net.Conn, n, size int) {
+	msg := bytes.Repeat([]byte("x"), size)
+	got := make([]byte, size)
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := c.Write(msg); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func throttled(loop *Ringloop) (n uint64, d time.Duration) {
+	for _, m := range loop.Metrics() {
+		n += m.Throttled
+		d += m.ThrottledTime
+	}
+	return n, d
+}
+
+func TestRateLimitPerConn(t *testing.T) {
+	loop, clients, _ := startEcho(t, 1, 1)
+	// 1KB are read at once, the 4KB after them and the 4KB echoed take 0.8s.
+	loop.SetRatePolicy(&RatePolicy{PerConn: RateLimit{BytesPerSecond: 10000, BytesBurst: 1000}})
+	start := time.Now()
+	pingPong(t, clients[0], 5, 1000)
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Fatalf("expect the reads to be delayed, took %v", elapsed)
+	}
+	if n, d := throttled(loop); n == 0 || d < 500*time.Millisecond {
+		t.Fatalf("expect throttled reads, got %d for %v", n, d)
+	}
+
+	loop.SetRatePolicy(nil)
+	start = time.Now()
+	pingPong(t, clients[0], 5, 1000)
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("expect no delay without a policy, took %v", elapsed)
+	}
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	loop, clients, _ := startEcho(t, 2, 2)
+	// the 10 messages of the 2 connections share one bucket of 20 per second, the reads after the
+	// first ones wait for about 0.35s. Each connection alone would wait for 0.15s.
+	loop.SetRatePolicy(&RatePolicy{PerIP: RateLimit{MessagesPerSecond: 20, MessagesBurst: 1}})
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pingPong(t, c, 5, 10)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("expect the connections to share the limit, took %v", elapsed)
+	}
+}
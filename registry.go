@@ -0,0 +1,155 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ConnInfo describes a connection accepted by a ring of a loop.
+type ConnInfo struct {
+	ID         uint64   // identifies the connection, unlike its fd it is never reused
+	Ring       int      // index of the ring owning the connection
+	Fd         int32    // file descriptor of the connection
+	RemoteAddr net.Addr // address of the peer
+	Opened     time.Time
+	BytesIn    uint64 // bytes read from the connection
+	BytesOut   uint64 // bytes written to the connection
+	// Proxy is the PROXY protocol header the connection started with, nil if none. RemoteAddr is
+	// its source address unless it is a LOCAL header.
+	Proxy *ProxyHeader
+}
+
+// Age returns how long the connection has been open.
+func (info ConnInfo) Age() time.Duration {
+	return time.Since(info.Opened)
+}
+
+// connIDs is the last ID given to a connection.
+var connIDs uint64
+
+// register adds the connection fd, accepted from the address peer of source IP ipKey, to the registry.
+// proxy is the PROXY protocol header received on it, if any.
+func (ringNet *URingNet) register(fd int32, peer unix.Sockaddr, remote net.Addr, ipKey string, proxy *ProxyHeader) *conn {
+	c := &conn{
+		id:         atomic.AddUint64(&connIDs, 1),
+		ring:       ringNet,
+		openedAt:   time.Now(),
+		fd:         int(fd),
+		loop:       ringNet.ringloop,
+		peer:       peer,
+		remoteAddr: remote,
+		ipKey:      ipKey,
+		proxy:      proxy,
+	}
+	if ringNet.conns == nil {
+		ringNet.conns = make(map[int32]*conn)
+	}
+	ringNet.conns[fd] = c
+	if ringNet.ringloop != nil {
+		ringNet.ringloop.connections.Store(c.id, c)
+	}
+	return c
+}
+
+// unregister removes the connection fd from the registry once it is closed.
+func (ringNet *URingNet) unregister(fd int32) {
+	c, ok := ringNet.conns[fd]
+	if !ok {
+		return
+	}
+	delete(ringNet.conns, fd)
+	if ringNet.ringloop != nil {
+		ringNet.ringloop.connections.Delete(c.id)
+		if c.admitted {
+			ringNet.ringloop.admission.release(c.ipKey)
+		}
+		if c.ipRate != nil {
+			ringNet.ringloop.rates.release(c.ipKey)
+		}
+	}
+}
+
+func (c *conn) info() ConnInfo {
+	return ConnInfo{
+		ID:         c.id,
+		Ring:       int(c.ring.ringIndex),
+		Fd:         int32(c.fd),
+		RemoteAddr: c.remoteAddr,
+		Opened:     c.openedAt,
+		BytesIn:    atomic.LoadUint64(&c.bytesIn),
+		BytesOut:   atomic.LoadUint64(&c.bytesOut),
+		Proxy:      c.proxy,
+	}
+}
+
+// ConnID returns the ID of the connection fd of the ring, ok is false if fd is not an open
+// connection accepted by the ring. It must be called on the ring goroutine.
+func (ringNet *URingNet) ConnID(fd int32) (id uint64, ok bool) {
+	c, ok := ringNet.conns[fd]
+	if !ok {
+		return 0, false
+	}
+	return c.id, true
+}
+
+// ConnInfo returns the connection fd of the ring, ok is false if fd is not an open connection
+// accepted by the ring. It must be called on the ring goroutine.
+func (ringNet *URingNet) ConnInfo(fd int32) (info ConnInfo, ok bool) {
+	c, ok := ringNet.conns[fd]
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return c.info(), true
+}
+
+// RemoteAddr returns the address of the peer of the connection fd, the source address of its
+// PROXY header if it has one. It is empty if fd is not an open connection accepted by the ring.
+// It must be called on the ring goroutine.
+func (ringNet *URingNet) RemoteAddr(fd int32) string {
+	c, ok := ringNet.conns[fd]
+	if !ok || c.remoteAddr == nil {
+		return ""
+	}
+	return c.remoteAddr.String()
+}
+
+// Range calls f for each open connection of the loop until f returns false. It is safe to call
+// from any goroutine, connections opened or closed meanwhile may or may not be visited.
+func (loop *Ringloop) Range(f func(info ConnInfo) bool) {
+	loop.connections.Range(func(_, v interface{}) bool {
+		return f(v.(*conn).info())
+	})
+}
+
+// Get returns the connection id of the loop, ok is false if it is not open.
+func (loop *Ringloop) Get(id uint64) (info ConnInfo, ok bool) {
+	v, ok := loop.connections.Load(id)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return v.(*conn).info(), true
+}
+
+// CloseConn closes the connection id of the loop. The close runs on the ring owning the connection,
+// which shuts the socket down: its pending read completes and the ring closes the socket, OnClose
+// fires then. CloseConn returns once the close has been handed to the ring.
+func (loop *Ringloop) CloseConn(id uint64) error {
+	v, ok := loop.connections.Load(id)
+	if !ok {
+		return errors.ErrConnectionNotFound
+	}
+	c := v.(*conn)
+	return c.ring.Trigger(func() {
+		// the connection may have been closed, and its fd reused, meanwhile.
+		if cur, ok := c.ring.conns[int32(c.fd)]; ok && cur == c {
+			_ = unix.Shutdown(c.fd, unix.SHUT_RDWR)
+		}
+	})
+}
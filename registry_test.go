@@ -0,0 +1,183 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+type echoHandler struct {
+	BuiltinEventEngine
+}
+
+func (h *echoHandler) OnTraffic(data *UserData, _ *URingNet) Action {
+	data.WriteBuf = append([]byte(nil), data.Buffer[:data.BufSize]...)
+	return Echo
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+// ringConfig sets up the rings of a test server.
+type ringConfig struct {
+	rings  int
+	size   uint // entries of the submission queues, 256 if zero
+	sqpoll bool
+	engine EngineOptions
+}
+
+// setUp sets up the rings of config running h, listening on a free port of the loopback address.
+// It returns their loop, which is not run yet, and the address.
+func setUp(t *testing.T, config ringConfig, h EventHandler) (*Ringloop, string) {
+	t.Helper()
+	size := config.size
+	if size == 0 {
+		size = 256
+	}
+	// the connections closed by the server linger in TIME_WAIT, a fixed port could not be bound again.
+	ringNets, err := NewManyWithOptions(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, size, config.sqpoll,
+		config.rings, socket.SocketOptions{}, config.engine, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := unix.Getsockname(ringNets[0].SocketFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SetLoops(ringNets, 64), socket.SockaddrToTCPOrUnixAddr(sa).String()
+}
+
+// listen runs h on rings rings listening on a free port of the loopback address.
+func listen(t *testing.T, rings int, h EventHandler) (*Ringloop, string) {
+	t.Helper()
+	loop, addr := setUp(t, ringConfig{rings: rings}, h)
+	loop.RunMany2()
+	return loop, addr
+}
+
+// startEcho runs an echo server on rings rings and connects n clients to it.
+func startEcho(t *testing.T, rings, n int) (*Ringloop, []net.Conn, []uint64) {
+	t.Helper()
+	return startEchoWith(t, ringConfig{rings: rings}, n)
+}
+
+// startEchoWith runs an echo server on the rings of config and connects n clients to it.
+func startEchoWith(t *testing.T, config ringConfig, n int) (*Ringloop, []net.Conn, []uint64) {
+	t.Helper()
+	loop, addr := setUp(t, config, &echoHandler{})
+	loop.RunMany2()
+	clients := dialN(t, addr, n)
+	// find the IDs of the connections by the addresses of the clients.
+	ids := make([]uint64, n)
+	waitUntil(t, func() bool {
+		found := 0
+		loop.Range(func(info ConnInfo) bool {
+			for i, c := range clients {
+				if info.RemoteAddr.String() == c.LocalAddr().String() {
+					ids[i] = info.ID
+					found++
+				}
+			}
+			return true
+		})
+		return found == n
+	})
+	return loop, clients, ids
+}
+
+// dialN connects n clients to addr, they are closed at the end of the test.
+func dialN(t *testing.T, addr string, n int) []net.Conn {
+	t.Helper()
+	clients := make([]net.Conn, n)
+	for i := range clients {
+		var err error
+		if clients[i], err = net.Dial("tcp4", addr); err != nil {
+			t.Fatal(err)
+		}
+		c := clients[i]
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	return clients
+}
+
+func TestRegistry(t *testing.T) {
+	loop, clients, ids := startEcho(t, 1, 2)
+	if ids[0] == 0 || ids[0] == ids[1] {
+		t.Fatalf("expect distinct IDs, got %v", ids)
+	}
+	info, _ := loop.Get(ids[0])
+	ringNet := loop.RingNet[info.Ring]
+	idc, addrc := make(chan uint64, 1), make(chan string, 1)
+	_ = ringNet.Trigger(func() {
+		id, _ := ringNet.ConnID(info.Fd)
+		idc <- id
+		addrc <- ringNet.RemoteAddr(info.Fd)
+	})
+	if id := <-idc; id != ids[0] {
+		t.Fatalf("expect ConnID to return %d, got %d", ids[0], id)
+	}
+	if addr := <-addrc; addr != clients[0].LocalAddr().String() {
+		t.Fatalf("expect RemoteAddr to return %s, got %q", clients[0].LocalAddr(), addr)
+	}
+	msg := []byte("ping")
+	if _, err := clients[0].Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(clients[0], make([]byte, len(msg))); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, func() bool {
+		info, ok := loop.Get(ids[0])
+		return ok && info.BytesOut == uint64(len(msg))
+	})
+	info, _ = loop.Get(ids[0])
+	if info.BytesIn != uint64(len(msg)) || info.Ring != 0 || info.Age() <= 0 ||
+		info.RemoteAddr.String() != clients[0].LocalAddr().String() {
+		t.Fatalf("unexpected connection %+v", info)
+	}
+	seen := map[uint64]bool{}
+	loop.Range(func(info ConnInfo) bool {
+		seen[info.ID] = true
+		return true
+	})
+	if len(seen) != 2 || !seen[ids[0]] || !seen[ids[1]] {
+		t.Fatalf("expect both connections, got %v", seen)
+	}
+
+	if err := loop.CloseConn(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	_ = clients[1].SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := clients[1].Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %v", err)
+	}
+	waitUntil(t, func() bool {
+		_, ok := loop.Get(ids[1])
+		return !ok
+	})
+	if err := loop.CloseConn(ids[1]); err != errors.ErrConnectionNotFound {
+		t.Fatalf("expect ErrConnectionNotFound, got %v", err)
+	}
+
+	// the client closing its connection removes it as well.
+	_ = clients[0].Close()
+	waitUntil(t, func() bool {
+		n := 0
+		loop.Range(func(ConnInfo) bool { n++; return true })
+		return n == 0
+	})
+}
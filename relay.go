@@ -0,0 +1,316 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	bsPool "github.com/y001j/uringnet/byteslice"
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultRelayBuffer is the size of the buffer of each direction of a relay if RelayConfig.BufferSize is 0.
+const DefaultRelayBuffer = 32 << 10
+
+// RelayConfig configures a relay between two connections.
+type RelayConfig struct {
+	BufferSize  int           // size of the buffer of each direction, DefaultRelayBuffer if 0
+	IdleTimeout time.Duration // the relay is closed once no data moved for this long, 0 for never
+	// Splice moves the data through a pipe with splice instead of a buffer, it never reaches user
+	// space. The splices from a socket run in the workers of the ring, which costs a thread switch
+	// per chunk: it pays off for large transfers only.
+	Splice bool
+}
+
+// RelayStats counts the bytes moved by a relay in each direction.
+type RelayStats struct {
+	ClientToUpstream uint64
+	UpstreamToClient uint64
+}
+
+// Relay moves bytes between a client connection of the ring and an upstream socket, in both
+// directions, on the ring goroutine.
+//
+// Each direction receives into its buffer and sends it entirely before receiving again, so a slow
+// reader slows the writer down through the flow control of TCP. The end of the data of one side
+// shuts the other side down for writing, the relay ends once both directions have, or on the first
+// error or the idle timeout, ErrRelayIdle, which shut both sides down.
+//
+// The data from the client is mirrored if the client connection is, see Mirror.
+type Relay struct {
+	ringNet  *URingNet
+	client   int32
+	upstream int32
+	toUp     relayDirection
+	toClient relayDirection
+	running  int // directions still moving data
+	err      error
+	idle     time.Duration
+	last     int64 // monotonic time data last moved
+	timer    *Timer
+	done     func(stats RelayStats, err error)
+}
+
+type relayDirection struct {
+	r      *Relay
+	src    int32
+	dst    int32
+	buf    []byte // recv mode
+	pipe   [2]int // splice mode
+	size   int
+	mirror bool        // the data is mirrored if src is
+	tee    *mirrorPipe // pipe the data is teed into for teeMirror
+	teeFor *Mirror
+	bytes  uint64 // accessed atomically
+}
+
+// Relay starts relaying between the connection client of the ring and the socket upstream, owned
+// by the relay. client must have been hijacked, see Hijack. Once the relay ends, upstream is closed,
+// client is closed with CloseFd and done, if not nil, is called with the bytes moved and the first
+// error, nil if both sides ended their data. Relay must be called on the ring goroutine.
+func (ringNet *URingNet) Relay(client, upstream int32, cfg RelayConfig, done func(stats RelayStats, err error)) *Relay {
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = DefaultRelayBuffer
+	}
+	r := &Relay{ringNet: ringNet, client: client, upstream: upstream, running: 2, idle: cfg.IdleTimeout, last: monotonic(), done: done}
+	r.toUp = relayDirection{r: r, src: client, dst: upstream, size: size, mirror: true}
+	r.toClient = relayDirection{r: r, src: upstream, dst: client, size: size}
+	if cfg.Splice && r.toUp.openPipe() && r.toClient.openPipe() {
+		r.toUp.splice()
+		r.toClient.splice()
+	} else {
+		// without pipes, the relay falls back to buffers.
+		r.toUp.closePipe()
+		r.toUp.buf = bsPool.Get(size)
+		r.toClient.buf = bsPool.Get(size)
+		r.toUp.recv()
+		r.toClient.recv()
+	}
+	if r.idle > 0 {
+		r.timer = ringNet.AfterFunc(r.idle, r.checkIdle)
+	}
+	_, _ = ringNet.submit(0)
+	return r
+}
+
+// Stats returns the bytes moved so far, it is safe to call from any goroutine.
+func (r *Relay) Stats() RelayStats {
+	return RelayStats{
+		ClientToUpstream: atomic.LoadUint64(&r.toUp.bytes),
+		UpstreamToClient: atomic.LoadUint64(&r.toClient.bytes),
+	}
+}
+
+func (d *relayDirection) recv() {
+	d.r.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Recv(sqe, uintptr(d.src), d.buf, 0)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res > 0:
+			d.r.last = monotonic()
+			if d.mirror {
+				d.r.ringNet.mirrorIn(d.src, d.buf[:res])
+			}
+			d.send(0, int(res))
+		case res == 0:
+			// the end of the data of src, dst gets it once everything before has been sent.
+			_ = unix.Shutdown(int(d.dst), unix.SHUT_WR)
+			d.r.end(nil)
+		default:
+			d.r.end(os.NewSyscallError("recv", unix.Errno(-res)))
+		}
+		_, _ = d.r.ringNet.submit(0)
+	})
+}
+
+func (d *relayDirection) send(off, n int) {
+	d.r.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Send(sqe, uintptr(d.dst), d.buf[off:n], unix.MSG_NOSIGNAL)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res > 0:
+			d.r.last = monotonic()
+			atomic.AddUint64(&d.bytes, uint64(res))
+			if off += int(res); off < n {
+				d.send(off, n)
+			} else {
+				d.recv()
+			}
+		case res == 0:
+			d.r.end(io.ErrShortWrite)
+		default:
+			d.r.end(os.NewSyscallError("send", unix.Errno(-res)))
+		}
+		_, _ = d.r.ringNet.submit(0)
+	})
+}
+
+func (d *relayDirection) openPipe() bool {
+	if err := unix.Pipe2(d.pipe[:], unix.O_CLOEXEC); err != nil {
+		d.pipe = [2]int{-1, -1}
+		return false
+	}
+	_, _ = unix.FcntlInt(uintptr(d.pipe[1]), unix.F_SETPIPE_SZ, d.size)
+	return true
+}
+
+func (d *relayDirection) closePipe() {
+	if d.pipe[0] > 0 {
+		_ = unix.Close(d.pipe[0])
+		_ = unix.Close(d.pipe[1])
+		d.pipe = [2]int{}
+	}
+	if d.tee != nil {
+		d.tee.release()
+		d.tee = nil
+	}
+}
+
+// splice moves the data of src into the pipe, in splice mode.
+func (d *relayDirection) splice() {
+	d.r.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Splice(sqe, d.src, -1, int32(d.pipe[1]), -1, uint32(d.size), unix.SPLICE_F_MOVE)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res > 0:
+			d.r.last = monotonic()
+			// the engine counts the bytes of recv and send only.
+			d.r.ringNet.countIn(d.src, res)
+			d.teeMirror(int(res))
+		case res == 0:
+			_ = unix.Shutdown(int(d.dst), unix.SHUT_WR)
+			d.r.end(nil)
+		default:
+			d.r.end(os.NewSyscallError("splice", unix.Errno(-res)))
+		}
+		_, _ = d.r.ringNet.submit(0)
+	})
+}
+
+// teeMirror duplicates the n bytes of the pipe into the pipe of the mirror of src, if it is
+// mirrored, before they are spliced to dst. A mirror whose pipe is full gets part of them only,
+// the rest is dropped.
+func (d *relayDirection) teeMirror(n int) {
+	var m *Mirror
+	c := d.r.ringNet.conns[d.src]
+	if d.mirror && c != nil {
+		m = d.r.ringNet.mirrorOf(c)
+	}
+	if m != d.teeFor && d.tee != nil {
+		// the chunks of a pipe must be written in order, by a single mirror.
+		d.tee.release()
+		d.tee = nil
+	}
+	d.teeFor = m
+	if m == nil || !m.reserve(n) {
+		d.drain(n)
+		return
+	}
+	if d.tee == nil || atomic.LoadInt32(&d.tee.broken) != 0 {
+		if d.tee != nil {
+			d.tee.release()
+		}
+		p, err := newMirrorPipe()
+		if err != nil {
+			m.unreserve(n)
+			d.tee = nil
+			d.drain(n)
+			return
+		}
+		d.tee = p
+	}
+	id, p := c.id, d.tee
+	d.r.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Tee(sqe, int32(d.pipe[0]), int32(p.fds[1]), uint32(n), unix.SPLICE_F_NONBLOCK)
+	}, func(res int32, _ uint32) {
+		if res > 0 {
+			if int(res) < n {
+				atomic.AddUint64(&m.dropped, uint64(n-int(res)))
+			}
+			m.pipeChunk(d.r.ringNet, id, p, n, int(res))
+		} else {
+			m.unreserve(n)
+		}
+		d.drain(n)
+		_, _ = d.r.ringNet.submit(0)
+	})
+}
+
+// drain splices the n bytes of the pipe to dst.
+func (d *relayDirection) drain(n int) {
+	d.r.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Splice(sqe, int32(d.pipe[0]), -1, d.dst, -1, uint32(n), 0)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res > 0:
+			d.r.last = monotonic()
+			atomic.AddUint64(&d.bytes, uint64(res))
+			d.r.ringNet.countOut(d.dst, res)
+			if n -= int(res); n > 0 {
+				d.drain(n)
+			} else {
+				d.splice()
+			}
+		case res == 0:
+			d.r.end(io.ErrShortWrite)
+		default:
+			d.r.end(os.NewSyscallError("splice", unix.Errno(-res)))
+		}
+		_, _ = d.r.ringNet.submit(0)
+	})
+}
+
+// end ends a direction, err is why it failed. A failure shuts both sides down so that the other
+// direction ends as well.
+func (r *Relay) end(err error) {
+	if err != nil {
+		r.abort(err)
+	}
+	if r.running--; r.running > 0 {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	for _, d := range []*relayDirection{&r.toUp, &r.toClient} {
+		if d.buf != nil {
+			bsPool.Put(d.buf)
+		}
+		d.closePipe()
+	}
+	r.ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Close(sqe, uintptr(r.upstream))
+	}, func(int32, uint32) {})
+	r.ringNet.CloseFd(r.client)
+	if r.done != nil {
+		r.done(r.Stats(), r.err)
+	}
+}
+
+func (r *Relay) abort(err error) {
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	_ = unix.Shutdown(int(r.client), unix.SHUT_RDWR)
+	_ = unix.Shutdown(int(r.upstream), unix.SHUT_RDWR)
+}
+
+func (r *Relay) checkIdle() {
+	if r.running == 0 {
+		return
+	}
+	idle := time.Duration(monotonic() - r.last)
+	if idle >= r.idle {
+		r.abort(errors.ErrRelayIdle)
+		return
+	}
+	r.timer = r.ringNet.AfterFunc(r.idle-idle, r.checkIdle)
+}
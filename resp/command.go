@@ -0,0 +1,237 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMaxBulkBytes is the maximum permitted size of a bulk string in a command.
+	DefaultMaxBulkBytes = 512 << 20 // 512 MB
+
+	// maxInlineBytes is the maximum size of an inline command.
+	maxInlineBytes = 64 << 10
+
+	// maxArgs is the maximum number of arguments of a command.
+	maxArgs = 1 << 20
+)
+
+// Command is a command sent by a client, Args[0] is the name of the command.
+//
+// The arguments refer to the buffer the command has been parsed from, they are only valid
+// until the handler returns. They must be copied to be retained.
+type Command struct {
+	Args [][]byte
+}
+
+// Name returns the name of the command in lower case.
+func (cmd Command) Name() string {
+	if len(cmd.Args) == 0 {
+		return ""
+	}
+	return strings.ToLower(string(cmd.Args[0]))
+}
+
+// ProtocolError is a malformed command, it is answered with an error and the connection is closed.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+var (
+	// ErrNeedMore means the buffer does not hold a complete command yet.
+	ErrNeedMore = errors.New("resp: need more data")
+
+	errInlineTooBig   = &ProtocolError{"too big inline request"}
+	errUnbalanced     = &ProtocolError{"unbalanced quotes in request"}
+	errBadMultibulk   = &ProtocolError{"invalid multibulk length"}
+	errBadBulk        = &ProtocolError{"invalid bulk length"}
+	errExpectedDollar = &ProtocolError{"expected '$'"}
+	errBadBulkEnd     = &ProtocolError{"bulk string not terminated by CRLF"}
+)
+
+// ParseCommand parses the command at the beginning of buf, in the multi-bulk or the inline format.
+// It returns the command and its length in buf, ErrNeedMore if buf does not hold the complete
+// command yet, or a *ProtocolError. An empty command, like an empty line, is returned with no Args.
+func ParseCommand(buf []byte, maxBulkBytes int) (Command, int, error) {
+	if len(buf) == 0 {
+		return Command{}, 0, ErrNeedMore
+	}
+	if buf[0] == '*' {
+		return parseMultibulk(buf, maxBulkBytes)
+	}
+	return parseInline(buf)
+}
+
+func parseMultibulk(buf []byte, maxBulkBytes int) (Command, int, error) {
+	var cmd Command
+	count, n, err := parseLength(buf, maxArgs, errBadMultibulk)
+	if err != nil || count <= 0 {
+		return cmd, n, err
+	}
+	cmd.Args = make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if n >= len(buf) {
+			return Command{}, 0, ErrNeedMore
+		}
+		if buf[n] != '$' {
+			return Command{}, 0, errExpectedDollar
+		}
+		size, m, err := parseLength(buf[n:], maxBulkBytes, errBadBulk)
+		if err != nil {
+			return Command{}, 0, err
+		}
+		if size < 0 {
+			return Command{}, 0, errBadBulk
+		}
+		n += m
+		if len(buf)-n < size+2 {
+			return Command{}, 0, ErrNeedMore
+		}
+		if buf[n+size] != '\r' || buf[n+size+1] != '\n' {
+			return Command{}, 0, errBadBulkEnd
+		}
+		cmd.Args = append(cmd.Args, buf[n:n+size:n+size])
+		n += size + 2
+	}
+	return cmd, n, nil
+}
+
+// parseLength parses the header line "<type><length>\r\n" at the beginning of buf, whose length
+// must be in [-1, max], and returns the length and the size of the line.
+func parseLength(buf []byte, max int, invalid error) (int, int, error) {
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		if len(buf) > maxInlineBytes {
+			return 0, 0, invalid
+		}
+		return 0, 0, ErrNeedMore
+	}
+	if end < 2 || buf[end-1] != '\r' {
+		return 0, 0, invalid
+	}
+	v, err := strconv.Atoi(string(buf[1 : end-1]))
+	if err != nil || v < -1 || v > max {
+		return 0, 0, invalid
+	}
+	return v, end + 1, nil
+}
+
+func parseInline(buf []byte) (Command, int, error) {
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		if len(buf) > maxInlineBytes {
+			return Command{}, 0, errInlineTooBig
+		}
+		return Command{}, 0, ErrNeedMore
+	}
+	if end > maxInlineBytes {
+		return Command{}, 0, errInlineTooBig
+	}
+	line := buf[:end]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return Command{}, 0, err
+	}
+	return Command{Args: args}, end + 1, nil
+}
+
+// splitArgs splits an inline command into arguments separated by spaces, which may be quoted
+// like redis-cli does: double quotes support the escapes \n, \r, \t, \b, \a, \xHH, \" and \\,
+// single quotes only support \'.
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		switch line[i] {
+		case '"':
+			i++
+			for ; ; i++ {
+				if i == len(line) {
+					return nil, errUnbalanced
+				}
+				c := line[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					case 'x':
+						if i+2 < len(line) {
+							if v, err := strconv.ParseUint(string(line[i+1:i+3]), 16, 8); err == nil {
+								c = byte(v)
+								i += 2
+								break
+							}
+						}
+						c = 'x'
+					default:
+						c = line[i]
+					}
+				}
+				arg = append(arg, c)
+			}
+		case '\'':
+			i++
+			for ; ; i++ {
+				if i == len(line) {
+					return nil, errUnbalanced
+				}
+				c := line[i]
+				if c == '\'' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+				}
+				arg = append(arg, line[i])
+			}
+		default:
+			start := i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			arg = line[start:i:i]
+		}
+		// a closing quote must be followed by a space or the end of the line.
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, errUnbalanced
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,107 @@
+package resp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMultibulk(t *testing.T) {
+	raw := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n*1\r\n$4\r\nPING\r\n"
+	first := len(raw) - len("*1\r\n$4\r\nPING\r\n")
+	for i := 0; i < first; i++ {
+		if _, _, err := ParseCommand([]byte(raw[:i]), DefaultMaxBulkBytes); err != ErrNeedMore {
+			t.Fatalf("prefix %d: expect ErrNeedMore, got %v", i, err)
+		}
+	}
+	cmd, n, err := ParseCommand([]byte(raw), DefaultMaxBulkBytes)
+	if err != nil || n != first {
+		t.Fatalf("unexpected length %d or error %v", n, err)
+	}
+	if cmd.Name() != "set" || len(cmd.Args) != 3 || string(cmd.Args[2]) != "va\r\nl" {
+		t.Fatalf("unexpected command %q", cmd.Args)
+	}
+	if cmd, _, _ = ParseCommand([]byte("*0\r\n"), DefaultMaxBulkBytes); len(cmd.Args) != 0 {
+		t.Fatalf("expect an empty command, got %q", cmd.Args)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	cases := map[string][]string{
+		"PING\r\n":                        {"PING"},
+		"  set  k   v \n":                 {"set", "k", "v"},
+		`set "a b" "x\x41\n\"y"` + "\r\n": {"set", "a b", "xA\n\"y"},
+		`set 'it\'s' ""` + "\r\n":         {"set", "it's", ""},
+		"\r\n":                            nil,
+	}
+	for raw, want := range cases {
+		cmd, n, err := ParseCommand([]byte(raw), DefaultMaxBulkBytes)
+		if err != nil || n != len(raw) || len(cmd.Args) != len(want) {
+			t.Fatalf("%q: unexpected command %q, length %d or error %v", raw, cmd.Args, n, err)
+		}
+		for i := range want {
+			if string(cmd.Args[i]) != want[i] {
+				t.Fatalf("%q: expect %q, got %q", raw, want, cmd.Args)
+			}
+		}
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	cases := map[string]error{
+		"*x\r\n":                    errBadMultibulk,
+		"*1\r\n+PING\r\n":           errExpectedDollar,
+		"*1\r\n$-1\r\n":             errBadBulk,
+		"*1\r\n$100\r\n":            errBadBulk,
+		"*1\r\n$4\r\nPINGxx":        errBadBulkEnd,
+		"set \"unterminated\r\n":    errUnbalanced,
+		"set \"closed\"tail\r\n":    errUnbalanced,
+		string(make([]byte, 70000)): errInlineTooBig,
+	}
+	for raw, want := range cases {
+		if _, _, err := ParseCommand([]byte(raw), 64); err != want {
+			t.Errorf("%.20q: expect %v, got %v", raw, want, err)
+		}
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	var buf []byte
+	buf = AppendMap(buf, 2, 3)
+	buf = AppendBulkString(buf, "a")
+	buf = AppendDouble(buf, math.Inf(1), 3)
+	buf = AppendSimpleString(buf, "b")
+	buf = AppendSet(buf, 3, 3)
+	buf = AppendNull(buf, 3)
+	buf = AppendBool(buf, true, 3)
+	buf = AppendInt(buf, -7)
+	buf = AppendError(buf, "ERR bad\r\nthing")
+
+	r, n, err := ParseReply(buf)
+	if err != nil || r.Type != Map || len(r.Elems) != 4 {
+		t.Fatalf("unexpected reply %+v %v", r, err)
+	}
+	if r.Elems[0].String() != "a" || string(r.Elems[1].Str) != "inf" || r.Elems[1].Type != Double {
+		t.Fatalf("unexpected first pair %+v", r.Elems[:2])
+	}
+	set := r.Elems[3]
+	if set.Type != Set || !set.Elems[0].IsNull || set.Elems[1].Int != 1 || set.Elems[2].Int != -7 {
+		t.Fatalf("unexpected set %+v", set)
+	}
+	r, _, err = ParseReply(buf[n:])
+	if err != nil || r.Type != Error || r.String() != "ERR bad  thing" {
+		t.Fatalf("unexpected error reply %+v %v", r, err)
+	}
+	for i := 0; i < n; i++ {
+		if _, _, err = ParseReply(buf[:i]); err != ErrNeedMore {
+			t.Fatalf("prefix %d: expect ErrNeedMore, got %v", i, err)
+		}
+	}
+
+	// RESP2 encodings of the RESP3 types.
+	buf = AppendMap(nil, 1, 2)
+	buf = AppendNull(buf, 2)
+	buf = AppendBool(buf, false, 2)
+	if string(buf) != "*2\r\n$-1\r\n:0\r\n" {
+		t.Fatalf("unexpected RESP2 encoding %q", buf)
+	}
+}
@@ -0,0 +1,124 @@
+package resp
+
+// Conn is a client connection, it collects the replies of the commands being served.
+//
+// A Conn is owned by the ring serving it: its methods must only be called from the handler,
+// and the replies are sent once every pipelined command received with it has been served.
+type Conn struct {
+	id         int64
+	fd         int32
+	remoteAddr string
+	proto      int
+	name       string
+	ctx        interface{}
+
+	in         []byte // received bytes which have not been parsed yet
+	out        []byte // replies waiting to be sent
+	closeAfter bool   // close the connection once out is sent
+	sending    bool   // a send is in flight, out holds the replies written meanwhile
+	closed     bool   // the ring has closed the connection
+}
+
+// ID returns the unique ID of the connection.
+func (c *Conn) ID() int64 {
+	return c.id
+}
+
+// RemoteAddr returns the network address of the client.
+func (c *Conn) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// Proto returns the protocol version negotiated with HELLO, 2 or 3.
+func (c *Conn) Proto() int {
+	return c.proto
+}
+
+// Name returns the name set with HELLO SETNAME.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Context returns a user-defined context.
+func (c *Conn) Context() interface{} {
+	return c.ctx
+}
+
+// SetContext sets a user-defined context.
+func (c *Conn) SetContext(ctx interface{}) {
+	c.ctx = ctx
+}
+
+// Close closes the connection once the replies written so far are sent, the commands
+// pipelined after the current one are discarded.
+func (c *Conn) Close() {
+	c.closeAfter = true
+}
+
+// WriteString writes a simple string reply like "OK".
+func (c *Conn) WriteString(s string) {
+	c.out = AppendSimpleString(c.out, s)
+}
+
+// WriteError writes an error reply, "ERR " is prepended to msg if it does not start with an error code.
+func (c *Conn) WriteError(msg string) {
+	if errorCode(msg) == "" {
+		msg = "ERR " + msg
+	}
+	c.out = AppendError(c.out, msg)
+}
+
+// WriteInt writes an integer reply.
+func (c *Conn) WriteInt(n int64) {
+	c.out = AppendInt(c.out, n)
+}
+
+// WriteBulk writes a bulk string reply.
+func (c *Conn) WriteBulk(b []byte) {
+	c.out = AppendBulk(c.out, b)
+}
+
+// WriteBulkString writes a bulk string reply.
+func (c *Conn) WriteBulkString(s string) {
+	c.out = AppendBulkString(c.out, s)
+}
+
+// WriteNull writes a null reply.
+func (c *Conn) WriteNull() {
+	c.out = AppendNull(c.out, c.proto)
+}
+
+// WriteNullArray writes a null array reply.
+func (c *Conn) WriteNullArray() {
+	c.out = AppendNullArray(c.out, c.proto)
+}
+
+// WriteArray writes the header of an array reply, its n elements must be written next.
+func (c *Conn) WriteArray(n int) {
+	c.out = AppendArray(c.out, n)
+}
+
+// WriteMap writes the header of a map reply, its n keys and values must be written next, in turn.
+func (c *Conn) WriteMap(n int) {
+	c.out = AppendMap(c.out, n, c.proto)
+}
+
+// WriteSet writes the header of a set reply, its n elements must be written next.
+func (c *Conn) WriteSet(n int) {
+	c.out = AppendSet(c.out, n, c.proto)
+}
+
+// WriteDouble writes a floating point number reply.
+func (c *Conn) WriteDouble(f float64) {
+	c.out = AppendDouble(c.out, f, c.proto)
+}
+
+// WriteBool writes a boolean reply.
+func (c *Conn) WriteBool(b bool) {
+	c.out = AppendBool(c.out, b, c.proto)
+}
+
+// WriteRaw writes an encoded reply as is.
+func (c *Conn) WriteRaw(b []byte) {
+	c.out = append(c.out, b...)
+}
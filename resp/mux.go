@@ -0,0 +1,89 @@
+package resp
+
+import (
+	"strings"
+	"sync"
+)
+
+// A Handler responds to a command by writing replies to the connection.
+type Handler interface {
+	ServeRESP(c *Conn, cmd Command)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as handlers.
+type HandlerFunc func(c *Conn, cmd Command)
+
+// ServeRESP calls f(c, cmd).
+func (f HandlerFunc) ServeRESP(c *Conn, cmd Command) {
+	f(c, cmd)
+}
+
+// ServeMux dispatches commands to the handlers registered for their names, case-insensitively.
+// Unknown commands are answered with an error.
+type ServeMux struct {
+	mu       sync.RWMutex
+	handlers map[string]muxEntry
+}
+
+type muxEntry struct {
+	handler Handler
+	arity   int
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{handlers: make(map[string]muxEntry)}
+}
+
+// Handle registers the handler for the command name. arity is the number of arguments including
+// the name, a negative arity -N means at least N arguments, zero disables the check.
+// Commands with a wrong number of arguments are answered with an error.
+func (mux *ServeMux) Handle(name string, arity int, handler Handler) {
+	if name == "" {
+		panic("resp: empty command name")
+	}
+	if handler == nil {
+		panic("resp: nil handler")
+	}
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.handlers[strings.ToLower(name)] = muxEntry{handler: handler, arity: arity}
+}
+
+// HandleFunc registers the handler function for the command name, see Handle.
+func (mux *ServeMux) HandleFunc(name string, arity int, handler func(c *Conn, cmd Command)) {
+	mux.Handle(name, arity, HandlerFunc(handler))
+}
+
+// ServeRESP dispatches the command to the handler registered for its name.
+func (mux *ServeMux) ServeRESP(c *Conn, cmd Command) {
+	name := cmd.Name()
+	mux.mu.RLock()
+	e, ok := mux.handlers[name]
+	mux.mu.RUnlock()
+	if !ok {
+		c.WriteError(unknownCommand(cmd))
+		return
+	}
+	if e.arity > 0 && len(cmd.Args) != e.arity || e.arity < 0 && len(cmd.Args) < -e.arity {
+		c.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	e.handler.ServeRESP(c, cmd)
+}
+
+func unknownCommand(cmd Command) string {
+	var b strings.Builder
+	b.WriteString("ERR unknown command '")
+	b.Write(cmd.Args[0])
+	b.WriteString("', with args beginning with:")
+	for _, arg := range cmd.Args[1:] {
+		b.WriteString(" '")
+		b.Write(arg)
+		b.WriteString("'")
+		if b.Len() > 128 {
+			break
+		}
+	}
+	return b.String()
+}
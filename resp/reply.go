@@ -0,0 +1,267 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Reply types, RESP3 adds the types after Array.
+const (
+	SimpleString byte = '+'
+	Error        byte = '-'
+	Integer      byte = ':'
+	BulkString   byte = '$'
+	Array        byte = '*'
+	Null         byte = '_'
+	Double       byte = ','
+	Boolean      byte = '#'
+	BigNumber    byte = '('
+	Verbatim     byte = '='
+	Map          byte = '%'
+	Set          byte = '~'
+	Push         byte = '>'
+)
+
+// AppendSimpleString appends a simple string, s must not contain CR or LF.
+func AppendSimpleString(dst []byte, s string) []byte {
+	dst = append(dst, SimpleString)
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// AppendError appends an error, msg starts with the error code like "ERR" or "WRONGTYPE".
+// Line breaks in msg are replaced with spaces.
+func AppendError(dst []byte, msg string) []byte {
+	dst = append(dst, Error)
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c == '\r' || c == '\n' {
+			dst = append(dst, ' ')
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '\r', '\n')
+}
+
+// AppendInt appends an integer.
+func AppendInt(dst []byte, n int64) []byte {
+	dst = append(dst, Integer)
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
+
+// AppendBulk appends a bulk string.
+func AppendBulk(dst []byte, b []byte) []byte {
+	dst = appendHeader(dst, BulkString, len(b))
+	dst = append(dst, b...)
+	return append(dst, '\r', '\n')
+}
+
+// AppendBulkString appends a bulk string.
+func AppendBulkString(dst []byte, s string) []byte {
+	dst = appendHeader(dst, BulkString, len(s))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// AppendArray appends the header of an array of n elements, which must be appended next.
+func AppendArray(dst []byte, n int) []byte {
+	return appendHeader(dst, Array, n)
+}
+
+// AppendNull appends a null, the null bulk string of RESP2 or the null of RESP3.
+func AppendNull(dst []byte, proto int) []byte {
+	if proto >= 3 {
+		return append(dst, Null, '\r', '\n')
+	}
+	return append(dst, "$-1\r\n"...)
+}
+
+// AppendNullArray appends a null array, which RESP3 encodes as a null.
+func AppendNullArray(dst []byte, proto int) []byte {
+	if proto >= 3 {
+		return append(dst, Null, '\r', '\n')
+	}
+	return append(dst, "*-1\r\n"...)
+}
+
+// AppendMap appends the header of a map of n key-value pairs, which must be appended next.
+// RESP2 encodes it as a flat array of 2n elements.
+func AppendMap(dst []byte, n int, proto int) []byte {
+	if proto >= 3 {
+		return appendHeader(dst, Map, n)
+	}
+	return appendHeader(dst, Array, 2*n)
+}
+
+// AppendSet appends the header of a set of n elements, which RESP2 encodes as an array.
+func AppendSet(dst []byte, n int, proto int) []byte {
+	if proto >= 3 {
+		return appendHeader(dst, Set, n)
+	}
+	return appendHeader(dst, Array, n)
+}
+
+// AppendDouble appends a floating point number, which RESP2 encodes as a bulk string.
+func AppendDouble(dst []byte, f float64, proto int) []byte {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	if proto >= 3 {
+		dst = append(dst, Double)
+		dst = append(dst, s...)
+		return append(dst, '\r', '\n')
+	}
+	return AppendBulkString(dst, s)
+}
+
+// AppendBool appends a boolean, which RESP2 encodes as the integer 1 or 0.
+func AppendBool(dst []byte, b bool, proto int) []byte {
+	if proto >= 3 {
+		if b {
+			return append(dst, "#t\r\n"...)
+		}
+		return append(dst, "#f\r\n"...)
+	}
+	if b {
+		return AppendInt(dst, 1)
+	}
+	return AppendInt(dst, 0)
+}
+
+// AppendCommand appends a command in the multi-bulk format, as sent by clients.
+func AppendCommand(dst []byte, args ...string) []byte {
+	dst = AppendArray(dst, len(args))
+	for _, arg := range args {
+		dst = AppendBulkString(dst, arg)
+	}
+	return dst
+}
+
+func appendHeader(dst []byte, typ byte, n int) []byte {
+	dst = append(dst, typ)
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, '\r', '\n')
+}
+
+// Reply is a reply decoded by ParseReply.
+type Reply struct {
+	// Type is the type byte of the reply, like SimpleString or Array.
+	Type byte
+
+	// Str holds simple strings, errors, bulk strings, doubles, big numbers and verbatim strings.
+	Str []byte
+
+	// Int holds integers and booleans (1 or 0).
+	Int int64
+
+	// Elems holds the elements of arrays, sets and pushes, and the keys and values of maps in turn.
+	Elems []Reply
+
+	// IsNull is set for RESP3 nulls and the null bulk strings and arrays of RESP2.
+	IsNull bool
+}
+
+// String returns the reply as a string, for the string types and integers.
+func (r Reply) String() string {
+	if r.Type == Integer {
+		return strconv.FormatInt(r.Int, 10)
+	}
+	return string(r.Str)
+}
+
+var errBadReply = errors.New("resp: malformed reply")
+
+// ParseReply decodes the reply at the beginning of buf, sent by a RESP2 or RESP3 server.
+// It returns the reply and its length in buf, or ErrNeedMore if buf does not hold the complete reply yet.
+// The strings of the reply refer to buf.
+func ParseReply(buf []byte) (Reply, int, error) {
+	var r Reply
+	end := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 {
+		return r, 0, ErrNeedMore
+	}
+	if end == 0 {
+		return r, 0, errBadReply
+	}
+	r.Type = buf[0]
+	line := buf[1:end]
+	n := end + 2
+	switch r.Type {
+	case SimpleString, Error, Double, BigNumber:
+		r.Str = line
+	case Null:
+		r.IsNull = true
+	case Integer:
+		v, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return r, 0, errBadReply
+		}
+		r.Int = v
+	case Boolean:
+		if len(line) != 1 || line[0] != 't' && line[0] != 'f' {
+			return r, 0, errBadReply
+		}
+		if line[0] == 't' {
+			r.Int = 1
+		}
+	case BulkString, Verbatim:
+		size, err := strconv.Atoi(string(line))
+		if err != nil || size < -1 {
+			return r, 0, errBadReply
+		}
+		if size == -1 {
+			r.IsNull = true
+			break
+		}
+		if len(buf)-n < size+2 {
+			return r, 0, ErrNeedMore
+		}
+		r.Str = buf[n : n+size]
+		n += size + 2
+	case Array, Set, Push, Map:
+		count, err := strconv.Atoi(string(line))
+		if err != nil || count < -1 {
+			return r, 0, errBadReply
+		}
+		if count == -1 {
+			r.IsNull = true
+			break
+		}
+		if r.Type == Map {
+			count *= 2
+		}
+		r.Elems = make([]Reply, 0, count)
+		for i := 0; i < count; i++ {
+			elem, m, err := ParseReply(buf[n:])
+			if err != nil {
+				return Reply{}, 0, err
+			}
+			r.Elems = append(r.Elems, elem)
+			n += m
+		}
+	default:
+		return r, 0, errBadReply
+	}
+	return r, n, nil
+}
+
+// errorCode returns the error code of an error message, the first word if it is in upper case.
+func errorCode(msg string) string {
+	code, _, _ := strings.Cut(msg, " ")
+	if code == "" || strings.ToUpper(code) != code {
+		return ""
+	}
+	return code
+}
@@ -0,0 +1,276 @@
+//go:build linux
+
+// Package resp implements the Redis serialization protocol (RESP2 and RESP3) and a server
+// running natively on UringNet rings.
+//
+// Commands are parsed incrementally from the bytes delivered by the rings, in the multi-bulk
+// or the inline format, pipelined commands are served in order and their replies are sent together:
+//
+//	mux := resp.NewServeMux()
+//	mux.HandleFunc("ping", -1, func(c *resp.Conn, cmd resp.Command) {
+//		c.WriteString("PONG")
+//	})
+//	srv := &resp.Server{Addr: ":6379", Handler: mux}
+//	log.Fatal(srv.ListenAndServe())
+//
+// HELLO and QUIT are handled by the server. Handlers run on the goroutine of the ring that owns
+// the connection, so a handler that blocks stalls every connection of that ring.
+package resp
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+const (
+	ringSize    = 4096
+	ringBuffers = 4096
+)
+
+// A Server defines parameters for running a RESP server on UringNet.
+type Server struct {
+	// Addr specifies the TCP address for the server to listen on, ":6379" if empty.
+	Addr string
+
+	// Handler to invoke for every command except HELLO and QUIT.
+	Handler Handler
+
+	// MaxBulkBytes is the maximum size of a bulk string in a command, DefaultMaxBulkBytes is used if it is zero.
+	MaxBulkBytes int
+
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+
+	// ErrorLog specifies an optional logger for the errors of the rings and for panics in handlers, it is
+	// used when Logger is nil.
+	ErrorLog *log.Logger
+
+	// Logger logs the events of the rings and the panics in handlers, with the fields of the connection.
+	// ErrorLog is used when it is nil, logging.Default when both are.
+	Logger logging.Logger
+}
+
+// ListenAndServe listens on srv.Addr and serves commands on the rings. It only returns on setup errors.
+func (srv *Server) ListenAndServe() error {
+	if srv.Handler == nil {
+		return errors.New("resp: nil Handler")
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":6379"
+	}
+	rings := srv.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp, Address: addr}, ringSize, srv.SQPoll, rings, options, nil)
+	if err != nil {
+		return err
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Logger = srv.logger()
+		ringNet.Handler = &ringHandler{srv: srv, ring: ringNet, conns: make(map[int32]*Conn)}
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	loop.RunMany2()
+	select {}
+}
+
+func (srv *Server) maxBulkBytes() int {
+	if srv.MaxBulkBytes > 0 {
+		return srv.MaxBulkBytes
+	}
+	return DefaultMaxBulkBytes
+}
+
+// logger returns Logger, or ErrorLog for errors, or the default logger.
+func (srv *Server) logger() logging.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	if srv.ErrorLog != nil {
+		return logging.NewStd(srv.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// serve handles every complete command buffered in c, the replies are appended to c.out.
+func (srv *Server) serve(c *Conn) {
+	off := 0
+	for off < len(c.in) && !c.closeAfter {
+		cmd, n, err := ParseCommand(c.in[off:], srv.maxBulkBytes())
+		if err == ErrNeedMore {
+			break
+		}
+		if err != nil {
+			c.out = AppendError(c.out, "ERR "+err.Error())
+			c.closeAfter = true
+			break
+		}
+		off += n
+		if len(cmd.Args) == 0 {
+			continue
+		}
+		switch cmd.Name() {
+		case "hello":
+			srv.hello(c, cmd)
+		case "quit":
+			c.WriteString("OK")
+			c.closeAfter = true
+		default:
+			srv.invoke(c, cmd)
+		}
+	}
+	c.in = c.in[:copy(c.in, c.in[off:])]
+}
+
+// invoke calls the handler and recovers from its panics, which must not bring the ring down.
+func (srv *Server) invoke(c *Conn, cmd Command) {
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			srv.logger().Log(logging.LevelError, "panic serving a command", "fd", c.fd, "remote", c.remoteAddr,
+				"command", cmd.Name(), "err", err, "stack", string(buf))
+			c.WriteError("ERR internal error")
+			c.closeAfter = true
+		}
+	}()
+	srv.Handler.ServeRESP(c, cmd)
+}
+
+// hello handles HELLO [protover [SETNAME clientname]], which switches the protocol version.
+func (srv *Server) hello(c *Conn, cmd Command) {
+	proto := c.proto
+	args := cmd.Args[1:]
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v != 2 && v != 3 {
+			c.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+		args = args[1:]
+	}
+	name := c.name
+	for len(args) > 0 {
+		if strings.EqualFold(string(args[0]), "setname") && len(args) >= 2 {
+			name = string(args[1])
+			args = args[2:]
+			continue
+		}
+		c.WriteError("ERR Syntax error in HELLO option '" + string(args[0]) + "'")
+		return
+	}
+	c.proto, c.name = proto, name
+
+	c.WriteMap(7)
+	c.WriteBulkString("server")
+	c.WriteBulkString("uringnet")
+	c.WriteBulkString("version")
+	c.WriteBulkString("1.0.0")
+	c.WriteBulkString("proto")
+	c.WriteInt(int64(c.proto))
+	c.WriteBulkString("id")
+	c.WriteInt(c.id)
+	c.WriteBulkString("mode")
+	c.WriteBulkString("standalone")
+	c.WriteBulkString("role")
+	c.WriteBulkString("master")
+	c.WriteBulkString("modules")
+	c.WriteArray(0)
+}
+
+var lastConnID int64
+
+// ringHandler serves the connections of one ring, its callbacks are only invoked by the goroutine running the ring.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	srv   *Server
+	ring  *uringnet.URingNet
+	conns map[int32]*Conn
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	h.open(data.Fd)
+	return nil, uringnet.None
+}
+
+func (h *ringHandler) open(fd int32) *Conn {
+	c := &Conn{id: atomic.AddInt64(&lastConnID, 1), fd: fd, remoteAddr: h.ring.RemoteAddr(fd), proto: 2}
+	h.conns[fd] = c
+	return c
+}
+
+func (h *ringHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	c := h.conns[data.Fd]
+	if c == nil {
+		c = h.open(data.Fd)
+	}
+	if c.closeAfter {
+		// the bytes following the last command are dropped.
+		return uringnet.Read
+	}
+	c.in = append(c.in, data.Buffer[:data.BufSize]...)
+	h.srv.serve(c)
+	if c.closeAfter && len(c.out) == 0 && !c.sending {
+		return uringnet.Close
+	}
+	h.flush(c)
+	return uringnet.Read
+}
+
+// flush sends the replies of c. Only one send is in flight at a time, its completion sends the
+// replies written meanwhile. Once the last reply of a connection closing is sent, its socket is
+// shut down: the read then completes with EOF and the ring closes it.
+func (h *ringHandler) flush(c *Conn) {
+	if c.closed || c.sending {
+		return
+	}
+	if len(c.out) == 0 {
+		if c.closeAfter {
+			_ = h.ring.ShutdownConn(c.fd)
+		}
+		return
+	}
+	buf := c.out
+	c.out, c.sending = nil, true
+	h.ring.SendAll(c.fd, buf, func(err error) {
+		c.sending = false
+		if c.closed {
+			return
+		}
+		if err != nil {
+			c.closeAfter, c.out = true, nil
+		}
+		h.flush(c)
+	})
+}
+
+func (h *ringHandler) OnClose(data uringnet.UserData) uringnet.Action {
+	if c := h.conns[data.Fd]; c != nil {
+		c.closed = true
+		delete(h.conns, data.Fd)
+	}
+	return uringnet.None
+}
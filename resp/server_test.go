@@ -0,0 +1,199 @@
+//go:build linux
+
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAddr = "127.0.0.1:18932"
+
+var startOnce sync.Once
+
+// largeValue is far larger than the send buffer of a socket, the kernel takes it in several sends.
+var largeValue = bytes.Repeat([]byte("0123456789abcdef"), 8<<20/16)
+
+func startServer(t *testing.T) {
+	t.Helper()
+	startOnce.Do(func() {
+		values := make(map[string]string)
+		var mu sync.Mutex
+		mux := NewServeMux()
+		mux.HandleFunc("set", 3, func(c *Conn, cmd Command) {
+			mu.Lock()
+			values[string(cmd.Args[1])] = string(cmd.Args[2])
+			mu.Unlock()
+			c.WriteString("OK")
+		})
+		mux.HandleFunc("get", 2, func(c *Conn, cmd Command) {
+			mu.Lock()
+			v, ok := values[string(cmd.Args[1])]
+			mu.Unlock()
+			if !ok {
+				c.WriteNull()
+				return
+			}
+			c.WriteBulkString(v)
+		})
+		mux.HandleFunc("large", 1, func(c *Conn, cmd Command) {
+			c.WriteBulk(largeValue)
+		})
+		mux.HandleFunc("panic", 1, func(c *Conn, cmd Command) {
+			panic("boom")
+		})
+		srv := &Server{Addr: testAddr, Handler: mux, Rings: 2, ErrorLog: log.New(io.Discard, "", 0)}
+		go func() { t.Error(srv.ListenAndServe()) }()
+	})
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp4", testAddr); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+}
+
+type client struct {
+	net.Conn
+	r   *bufio.Reader
+	buf []byte
+}
+
+func dial(t *testing.T) *client {
+	t.Helper()
+	startServer(t)
+	conn, err := net.Dial("tcp4", testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) send(t *testing.T, raw []byte) {
+	t.Helper()
+	if _, err := c.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) recv(t *testing.T) Reply {
+	t.Helper()
+	for {
+		r, n, err := ParseReply(c.buf)
+		if err == nil {
+			c.buf = c.buf[n:]
+			return r
+		}
+		if err != ErrNeedMore {
+			t.Fatal(err)
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading a reply: %v", err)
+		}
+		c.buf = append(c.buf, b)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	c := dial(t)
+	defer c.Close()
+
+	var raw []byte
+	raw = AppendCommand(raw, "SET", "k", "v1")
+	raw = AppendCommand(raw, "get", "k")
+	raw = AppendCommand(raw, "GET", "missing")
+	raw = append(raw, "get k\r\nnope a b\r\nGET\r\n"...)
+	// the last command is split across writes.
+	c.send(t, raw)
+	c.send(t, AppendCommand(nil, "SET", "k", "v2")[:9])
+	time.Sleep(20 * time.Millisecond)
+	c.send(t, AppendCommand(nil, "SET", "k", "v2")[9:])
+
+	want := []string{"OK", "v1", "", "v1", "ERR unknown command 'nope', with args beginning with: 'a' 'b'",
+		"ERR wrong number of arguments for 'get' command", "OK"}
+	for i, w := range want {
+		r := c.recv(t)
+		if r.String() != w {
+			t.Fatalf("reply %d: expect %q, got %+v", i, w, r)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	c := dial(t)
+	defer c.Close()
+
+	c.send(t, AppendCommand(nil, "HELLO", "4"))
+	if r := c.recv(t); r.Type != Error || r.String() != "NOPROTO unsupported protocol version" {
+		t.Fatalf("unexpected reply %+v", r)
+	}
+	c.send(t, AppendCommand(nil, "HELLO", "3", "SETNAME", "sidecar"))
+	r := c.recv(t)
+	if r.Type != Map || len(r.Elems) != 14 || r.Elems[4].String() != "proto" || r.Elems[5].Int != 3 {
+		t.Fatalf("unexpected HELLO reply %+v", r)
+	}
+	c.send(t, AppendCommand(nil, "GET", "missing"))
+	if r = c.recv(t); r.Type != Null {
+		t.Fatalf("expect a RESP3 null, got %+v", r)
+	}
+	c.send(t, AppendCommand(nil, "QUIT"))
+	if r = c.recv(t); r.String() != "OK" {
+		t.Fatalf("unexpected QUIT reply %+v", r)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the server to close the connection, got %v", err)
+	}
+}
+
+func TestErrorsCloseConnection(t *testing.T) {
+	for _, raw := range []string{"*1\r\n+PING\r\n", "*1\r\n$4\r\nPANIC\r\n"} {
+		c := dial(t)
+		c.send(t, []byte(raw))
+		if r := c.recv(t); r.Type != Error {
+			t.Fatalf("%q: expect an error, got %+v", raw, r)
+		}
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Fatalf("%q: expect the server to close the connection, got %v", raw, err)
+		}
+		c.Close()
+	}
+}
+
+func TestLargeReply(t *testing.T) {
+	c := dial(t)
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// the replies are sent in order, the connection is closed once they all are.
+	var raw []byte
+	raw = AppendCommand(raw, "LARGE")
+	raw = AppendCommand(raw, "LARGE")
+	raw = AppendCommand(raw, "QUIT")
+	c.send(t, raw)
+	want := AppendBulk(nil, largeValue)
+	got := make([]byte, len(want))
+	for i := 0; i < 2; i++ {
+		if _, err := io.ReadFull(c.r, got); err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("reply %d: the bulk string is corrupted", i)
+		}
+	}
+	if r := c.recv(t); r.String() != "OK" {
+		t.Fatalf("unexpected QUIT reply %+v", r)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the server to close the connection, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"net"
+	"testing"
+
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+func TestListenerPerRing(t *testing.T) {
+	for _, steer := range []bool{false, true} {
+		options := socket.SocketOptions{ListenerPerRing: true, SteerToCPU: steer}
+		ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 256, false, 3, options, &echoHandler{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var addr string
+		fds := map[int]bool{}
+		for _, ringNet := range ringNets {
+			sa, err := unix.Getsockname(ringNet.SocketFd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bound := socket.SockaddrToTCPOrUnixAddr(sa).String()
+			if addr != "" && bound != addr {
+				t.Fatalf("expect every listener on %s, got %s", addr, bound)
+			}
+			addr = bound
+			fds[ringNet.SocketFd] = true
+		}
+		if len(fds) != len(ringNets) {
+			t.Fatalf("expect a listener per ring, got %d", len(fds))
+		}
+
+		loop := SetLoops(ringNets, 64)
+		loop.RunMany2()
+		rings := map[int]bool{}
+		for i := 0; i < 32; i++ {
+			c, err := net.Dial("tcp4", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ping(t, c, "hello")
+			loop.Range(func(info ConnInfo) bool {
+				if info.RemoteAddr.String() == c.LocalAddr().String() {
+					rings[info.Ring] = true
+				}
+				return true
+			})
+			_ = c.Close()
+		}
+		// without steering, the connections are spread by the hash of their addresses.
+		if !steer && len(rings) < 2 {
+			t.Fatalf("expect the connections to be spread over the rings, got rings %v", rings)
+		}
+	}
+}
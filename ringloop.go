@@ -4,15 +4,17 @@ package uringnet
 
 import (
 	"bytes"
-	"fmt"
+	"github.com/y001j/uringnet/logging"
 	"github.com/y001j/uringnet/uring"
 
 	"golang.org/x/sys/unix"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
 type Ringloop struct {
@@ -25,7 +27,11 @@ type Ringloop struct {
 	buffer      [][bufLength]byte // read packet buffer whose capacity is set by user, default value is 64KB
 	RingCount   int32             // number of active connections in event-loop
 	udpSockets  map[int]*conn     // client-side UDP socket map: fd -> conn
-	connections sync.Map          // map[int]*conn // TCP connection map: fd -> conn
+	connections sync.Map          // open connections of every ring: ID -> *conn
+	admission   admissionState    // limits and counts of the connections accepted on the listener
+	rates       rateState         // rate limiting of the connections
+	proxy       atomic.Value      // *ProxyProtocol
+	mirror      atomic.Value      // mirrorRef, mirror of every connection
 	//eventHandler EventHandler  // user eventHandler
 }
 
@@ -36,8 +42,15 @@ const bufLength uint64 = 2048
 //
 //	@Description: set the ringloop for the engine
 //	@param urings
-//	@return *Ringloop
+//	@return *Ringloop, nil if the rings cannot be set up, NewLoops tells why.
 func SetLoops(urings []*URingNet, bufferSize int) *Ringloop {
+	loop, _ := NewLoops(urings, bufferSize)
+	return loop
+}
+
+// NewLoops sets the ringloop of the engine up like SetLoops. If the kernel cannot run the rings,
+// it closes them and their listening sockets and returns the error.
+func NewLoops(urings []*URingNet, bufferSize int) (*Ringloop, error) {
 	size := len(urings)
 	theloop := &Ringloop{}
 	theloop.RingCount = int32(size)
@@ -46,27 +59,55 @@ func SetLoops(urings []*URingNet, bufferSize int) *Ringloop {
 	for i := 0; i < size; i++ {
 
 		urings[i].ringloop = theloop
+		urings[i].ringIndex = uint16(i)
 		theloop.RingNet[i] = urings[i]
 		theloop.socketFd = urings[i].SocketFd
 
+		caps := urings[i].caps
+		if caps == nil {
+			var err error
+			if caps, err = KernelCapabilities(); err != nil {
+				urings[i].log(logging.LevelError, "io_uring is not usable", "err", err)
+				closeRings(urings)
+				return nil, err
+			}
+			urings[i].caps = caps
+		}
+		urings[i].multishotAccept = caps.MultishotAccept
+
 		fdstack := make([]int32, 0, 1024)
 		fdstack = append(fdstack, int32(theloop.socketFd))
-		err := theloop.RingNet[i].ring.RegisterFiles(fdstack)
-		if err != nil {
-			return nil
+		if err := theloop.RingNet[i].ring.RegisterFiles(fdstack); err != nil {
+			// the accepts use the fd of the listener.
+			urings[i].log(logging.LevelWarn, "registering the listener failed", "fd", theloop.socketFd, "err", err)
+		} else {
+			urings[i].fixedListener = true
 		}
 
 		//set buffer
-		sqe2 := theloop.RingNet[i].ring.GetSQEntry()
 		urings[i].Autobuffer = make([][bufLength]byte, bufferSize)
-		uring.ProvideBuf(sqe2, urings[i].Autobuffer, uint32(bufferSize), uint32(bufLength), uint16(i))
-		data := makeUserData(provideBuffer)
-		sqe2.SetUserData(data.id)
-		theloop.RingNet[i].userDataList.Store(data.id, data)
-		fmt.Println("Add Kernel buffer... for ring ", i)
-		_, _ = theloop.RingNet[i].ring.Submit(1, &paraFlags)
+		if caps.BufRing && bufferSize <= 1<<15 {
+			urings[i].bufRing = urings[i].registerBufRing(bufferSize)
+		}
+		switch {
+		case urings[i].bufRing != nil:
+			urings[i].providedBuffers = true
+			urings[i].log(logging.LevelDebug, "buffer ring registered", "buffers", bufferSize, "size", bufLength)
+		case caps.Supports(uring.IORING_OP_PROVIDE_BUFFERS):
+			urings[i].providedBuffers = true
+			sqe2 := theloop.RingNet[i].getSQEntry()
+			uring.ProvideBuf(sqe2, urings[i].Autobuffer, uint32(bufferSize), uint32(bufLength), uint16(i))
+			data := makeUserData(provideBuffer)
+			sqe2.SetUserData(data.id)
+			theloop.RingNet[i].userDataList.Store(data.id, data)
+			urings[i].log(logging.LevelDebug, "kernel buffers provided", "buffers", bufferSize, "size", bufLength)
+			_, _ = theloop.RingNet[i].submit(1)
+		default:
+			urings[i].log(logging.LevelWarn, "the kernel cannot provide buffers, the ring reads into its own buffer")
+		}
 	}
-	return theloop
+	registerLoop(theloop)
+	return theloop, nil
 }
 
 func (loop *Ringloop) GetBuffer() [][bufLength]byte {
@@ -77,37 +118,108 @@ func (loop *Ringloop) GetBuffer() [][bufLength]byte {
 // to accept should be set every time when server is initiated.
 func (ringNet *URingNet) EchoLoop() {
 
-	sqe := ringNet.ring.GetSQEntry()
+	sqe := ringNet.getSQEntry()
 	data := makeUserData(accepted)
-	// len  := unix.SizeofSockaddrAny
-	length := uint32(unix.SizeofSockaddrAny)
-	data.ClientSock = &syscall.RawSockaddrAny{}
-	data.socklen = &length
 	sqe.SetUserData(data.id)
-	sqe.SetFlags(uring.IOSQE_FIXED_FILE)
-
-	//sqe.SetAddr()
-	//fmt.Println(sqe.UserData())
+	// the listener is the registered file 0, unless it could not be registered.
+	fd := uintptr(ringNet.SocketFd)
+	if ringNet.fixedListener {
+		sqe.SetFlags(uring.IOSQE_FIXED_FILE)
+		fd = 0
+	}
 	ringNet.userDataList.Store(data.id, data)
-	//ringNet.userDataMap[data.id] = data
-	//set client address in data.client
-	//uring.Accept(sqe, uintptr(ringNet.SocketFd), nil, nil)
-	uring.Accept(sqe, uintptr(0), nil, nil)
+	if ringNet.multishotAccept {
+		// the completions share the entry, the address of each client is read from its socket.
+		uring.Accept(sqe, fd, nil, nil)
+		sqe.SetIOPrio(uring.IORING_ACCEPT_MULTISHOT)
+	} else {
+		length := uint32(unix.SizeofSockaddrAny)
+		data.ClientSock = &syscall.RawSockaddrAny{}
+		data.socklen = &length
+		uring.Accept(sqe, fd, data.ClientSock, data.socklen)
+	}
 
-	_, err := ringNet.ring.Submit(0, &paraFlags)
+	_, err := ringNet.submit(0)
 
 	//fmt.Println("echo server running...")
 
 	if err != nil {
-		fmt.Printf("prep request error: %v\n", err)
+		ringNet.log(logging.LevelError, "arming the accept failed", "err", err)
 		return
 	}
 }
 
+// registerBufRing registers the Autobuffer of the ring as its buffer ring, nil if it fails.
+func (ringNet *URingNet) registerBufRing(buffers int) *uring.BufRing {
+	entries := 1
+	for entries < buffers {
+		entries <<= 1
+	}
+	br, err := ringNet.ring.RegisterBufRing(uint16(entries), ringNet.ringIndex)
+	if err != nil {
+		ringNet.log(logging.LevelWarn, "registering the buffer ring failed", "err", err)
+		return nil
+	}
+	for j := range ringNet.Autobuffer {
+		br.Add(unsafe.Pointer(&ringNet.Autobuffer[j][0]), uint32(bufLength), uint16(j))
+	}
+	br.Advance()
+	return br
+}
+
+// onAccept handles the completion of the accept of data, res being the accepted fd or an error.
+// A multishot accept goes on while flags have IORING_CQE_F_MORE, another one is armed otherwise.
+func (ringNet *URingNet) onAccept(data *UserData, res int32, flags uint32) {
+	more := ringNet.multishotAccept && flags&uring.IORING_CQE_F_MORE != 0
+	if !more {
+		ringNet.userDataList.Delete(data.id)
+	}
+	if res < 0 {
+		// the accept failed, arm the next one unless the listener has been shut down.
+		if res != -int32(unix.EINVAL) {
+			ringNet.log(logging.LevelWarn, "accept failed", "err", unix.Errno(-res))
+			if !more {
+				ringNet.EchoLoop()
+			}
+		}
+		return
+	}
+	if !more {
+		ringNet.EchoLoop()
+	}
+	if ringNet.multishotAccept {
+		conn := makeUserData(accepted)
+		conn.ClientSock = peerName(res)
+		data = conn
+	}
+	data.Fd = res
+	ringNet.accept(data)
+}
+
+// peerName returns the address of the peer of the socket fd, nil if it cannot be read.
+func peerName(fd int32) *syscall.RawSockaddrAny {
+	rsa := &syscall.RawSockaddrAny{}
+	length := uint32(unix.SizeofSockaddrAny)
+	_, _, errno := unix.Syscall(unix.SYS_GETPEERNAME, uintptr(fd), uintptr(unsafe.Pointer(rsa)), uintptr(unsafe.Pointer(&length)))
+	if errno != 0 {
+		return nil
+	}
+	return rsa
+}
+
+// listen arms the first accept of the ring, or the first receive if it listens on a UDP address.
+func (ringNet *URingNet) listen() {
+	if ringNet.isDatagram() {
+		ringNet.armDatagram()
+		return
+	}
+	ringNet.EchoLoop()
+}
+
 func (loop *Ringloop) RunMany() {
 
 	for i := 0; i < int(loop.RingCount); i++ {
-		loop.RingNet[i].EchoLoop()
+		loop.RingNet[i].listen()
 		go loop.RingNet[i].Run2(uint16(i))
 	}
 }
@@ -115,7 +227,11 @@ func (loop *Ringloop) RunMany() {
 func (loop *Ringloop) RunMany2() {
 
 	for i := 0; i < int(loop.RingCount); i++ {
-		loop.RingNet[i].EchoLoop()
+		loop.RingNet[i].listen()
+		if !loop.RingNet[i].providedBuffers {
+			go loop.RingNet[i].Run2(uint16(i))
+			continue
+		}
 		go loop.RingNet[i].Run(uint16(i))
 	}
 }
@@ -314,8 +430,8 @@ type (
 		// Note that the bytes returned by OnOpened will be sent back to the peer without being encoded.
 		OnOpen(data *UserData) (out []byte, action Action)
 
-		// OnClose fires when a connection has been closed.
-		// The parameter err is the last known connection error.
+		// OnClose fires when a connection is being closed, right before its close is submitted to the ring.
+		// No operation may be issued for data.Fd afterwards.
 		OnClose(data UserData) (action Action)
 
 		// OnTraffic fires when a socket receives data from the peer.
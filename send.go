@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// SendAll sends buf to the connection fd. The socket may take part of buf only, the rest is then
+// sent again until all of it is. done is called on the ring goroutine once buf is sent, or with
+// the error of the first failed send; errors.ErrConnectionNotFound if the connection is closed
+// meanwhile, the rest is then dropped. buf must not be modified until done is called.
+//
+// SendAll must be called on the ring goroutine. The sends are not ordered with the other writes
+// to the connection, the handler must not write to it until done is called.
+func (ringNet *URingNet) SendAll(fd int32, buf []byte, done func(err error)) {
+	c := ringNet.conns[fd]
+	ringNet.sendAll(fd, c, buf, done)
+}
+
+func (ringNet *URingNet) sendAll(fd int32, c *conn, buf []byte, done func(err error)) {
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Send(sqe, uintptr(fd), buf, unix.MSG_NOSIGNAL)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res < 0:
+			done(unix.Errno(-res))
+		case int(res) == len(buf):
+			done(nil)
+		case c != nil && ringNet.conns[fd] != c:
+			// the connection has been closed, and its fd may have been reused.
+			done(errors.ErrConnectionNotFound)
+		default:
+			ringNet.sendAll(fd, c, buf[res:], done)
+		}
+	})
+}
+
+// ShutdownConn shuts the socket of the connection fd down. Its pending read then completes with
+// EOF and the ring closes the socket, OnClose fires then. The bytes already sent are delivered
+// to the peer before the connection ends.
+func (ringNet *URingNet) ShutdownConn(fd int32) error {
+	return unix.Shutdown(int(fd), unix.SHUT_RDWR)
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// sendHandler answers each read with its content, then shuts the connection down.
+type sendHandler struct {
+	BuiltinEventEngine
+	content []byte
+	results chan error
+}
+
+func (h *sendHandler) OnTraffic(data *UserData, ringNet *URingNet) Action {
+	ringNet.SendAll(data.Fd, h.content, func(err error) {
+		h.results <- err
+		_ = ringNet.ShutdownConn(data.Fd)
+	})
+	return Read
+}
+
+func TestSendAll(t *testing.T) {
+	// far larger than the send buffer of the socket, it is taken in several sends.
+	content := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	h := &sendHandler{content: content, results: make(chan error, 1)}
+	_, addr := listen(t, 1, h)
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err = c.Write([]byte("get")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expect the %d bytes of the content, got %d", len(content), len(got))
+	}
+	if err = <-h.results; err != nil {
+		t.Fatal(err)
+	}
+}
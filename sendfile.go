@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"io"
+	"os"
+
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// sendFilePipeSize is the size asked for the pipe of a SendFile, the data is moved by chunks of
+// the size of the pipe.
+const sendFilePipeSize = 1 << 20
+
+// fileSender moves data from a file to a socket through a pipe. Each chunk is spliced from the
+// file into the pipe and, by a linked entry, from the pipe into the socket.
+type fileSender struct {
+	ringNet *URingNet
+	fd      int32 // socket
+	file    int32
+	offset  int64
+	left    int64 // bytes of the file still to move into the pipe
+	pipe    [2]int
+	size    int   // capacity of the pipe
+	inPipe  int   // bytes in the pipe not sent yet
+	sent    int64 // bytes sent to the socket
+	err     error
+	done    func(sent int64, err error)
+}
+
+// SendFile sends n bytes of file from offset to the connection fd without copying them to user
+// space: they are spliced from the file to a pipe and from the pipe to the socket. done is called
+// on the ring goroutine with the number of bytes sent once they all are, or on the first error;
+// io.ErrUnexpectedEOF if the file ends before n bytes. file must be kept open until then.
+//
+// SendFile must be called on the ring goroutine. The sends are not ordered with the other writes
+// to the connection, the handler must not write to it until done is called.
+func (ringNet *URingNet) SendFile(fd int32, file *os.File, offset, n int64, done func(sent int64, err error)) {
+	if n <= 0 {
+		done(0, nil)
+		return
+	}
+	s := &fileSender{ringNet: ringNet, fd: fd, file: int32(file.Fd()), offset: offset, left: n, done: done}
+	if err := unix.Pipe2(s.pipe[:], unix.O_CLOEXEC); err != nil {
+		done(0, os.NewSyscallError("pipe2", err))
+		return
+	}
+	// the default size is used if the limit of the system is lower.
+	_, _ = unix.FcntlInt(uintptr(s.pipe[1]), unix.F_SETPIPE_SZ, sendFilePipeSize)
+	size, err := unix.FcntlInt(uintptr(s.pipe[1]), unix.F_GETPIPE_SZ, 0)
+	if err != nil {
+		size = 1 << 16
+	}
+	s.size = size
+	s.step()
+	_, _ = ringNet.submit(0)
+}
+
+// step queues the next splices, or completes the transfer.
+func (s *fileSender) step() {
+	switch {
+	case s.err != nil:
+		s.finish()
+	case s.inPipe > 0:
+		// the socket took part of the chunk, send the rest of the pipe.
+		s.toSocket(s.inPipe)
+	case s.left == 0:
+		s.finish()
+	default:
+		chunk := s.size
+		if s.left < int64(chunk) {
+			chunk = int(s.left)
+		}
+		s.ringNet.queue(func(sqe *uring.SQEntry) {
+			uring.Splice(sqe, s.file, s.offset, int32(s.pipe[1]), -1, uint32(chunk), 0)
+			// a short splice breaks the link, the send of the pipe is then canceled.
+			sqe.SetFlags(uring.IOSQE_IO_LINK)
+		}, func(res int32, _ uint32) {
+			switch {
+			case res < 0:
+				s.err = os.NewSyscallError("splice", unix.Errno(-res))
+			case res == 0:
+				s.err = io.ErrUnexpectedEOF
+			default:
+				s.offset += int64(res)
+				s.left -= int64(res)
+				s.inPipe += int(res)
+			}
+		})
+		s.toSocket(chunk)
+	}
+}
+
+// toSocket queues the splice of n bytes from the pipe to the socket, it drives the next step
+// once completed.
+func (s *fileSender) toSocket(n int) {
+	s.ringNet.queue(func(sqe *uring.SQEntry) {
+		uring.Splice(sqe, int32(s.pipe[0]), -1, s.fd, -1, uint32(n), 0)
+	}, func(res int32, _ uint32) {
+		switch {
+		case res == -int32(unix.ECANCELED):
+			// the splice into the pipe was short or failed, it recorded its result.
+		case res < 0:
+			s.err = os.NewSyscallError("splice", unix.Errno(-res))
+		case res == 0:
+			s.err = io.ErrShortWrite
+		default:
+			s.inPipe -= int(res)
+			s.sent += int64(res)
+			s.ringNet.countOut(s.fd, res)
+		}
+		s.step()
+		_, _ = s.ringNet.submit(0)
+	})
+}
+
+func (s *fileSender) finish() {
+	_ = unix.Close(s.pipe[0])
+	_ = unix.Close(s.pipe[1])
+	s.done(s.sent, s.err)
+}
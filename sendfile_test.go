@@ -0,0 +1,88 @@
+//go:build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fileResult struct {
+	sent int64
+	err  error
+}
+
+// fileHandler sends a range of its file requested as the offset and the length of the range.
+type fileHandler struct {
+	BuiltinEventEngine
+	file    *os.File
+	results chan fileResult
+}
+
+func (h *fileHandler) OnTraffic(data *UserData, ringNet *URingNet) Action {
+	req := data.Buffer[:data.BufSize]
+	offset, n := int64(binary.BigEndian.Uint64(req)), int64(binary.BigEndian.Uint64(req[8:]))
+	ringNet.SendFile(data.Fd, h.file, offset, n, func(sent int64, err error) {
+		h.results <- fileResult{sent, err}
+	})
+	return Read
+}
+
+func TestSendFile(t *testing.T) {
+	content := make([]byte, 3<<20+12345)
+	rand.New(rand.NewSource(1)).Read(content)
+	path := filepath.Join(t.TempDir(), "firmware.bin")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	h := &fileHandler{file: file, results: make(chan fileResult, 1)}
+	_, addr := listen(t, 1, h)
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	request := func(offset, n int64) {
+		req := binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(offset)), uint64(n))
+		if _, err := c.Write(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// larger than the pipe, the file is sent in several chunks.
+	n := int64(len(content)) - 200
+	request(100, n)
+	got := make([]byte, n)
+	if _, err = io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[100:len(content)-100]) {
+		t.Fatal("expect the content of the file")
+	}
+	if r := <-h.results; r.err != nil || r.sent != n {
+		t.Fatalf("expect %d bytes sent, got %d, %v", n, r.sent, r.err)
+	}
+
+	// the file ends before n bytes.
+	request(int64(len(content))-10, 100)
+	if _, err = io.ReadFull(c, got[:10]); err != nil || !bytes.Equal(got[:10], content[len(content)-10:]) {
+		t.Fatalf("expect the end of the file, got %v", err)
+	}
+	if r := <-h.results; r.err != io.ErrUnexpectedEOF || r.sent != 10 {
+		t.Fatalf("expect io.ErrUnexpectedEOF after 10 bytes, got %d, %v", r.sent, r.err)
+	}
+}
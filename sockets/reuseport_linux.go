@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ancillary data of classic BPF, see linux/filter.h.
+const (
+	skfAdOff = 0xfffff000 // SKF_AD_OFF, -0x1000
+	skfAdCPU = 36
+)
+
+// AttachReuseportCPU attaches to the SO_REUSEPORT group of fd a classic BPF program which steers
+// each connection, or datagram, to the socket of index cpu % n, cpu being the CPU which received it.
+// The sockets of the group are indexed in the order they were bound, the kernel falls back to
+// hashing if the index is out of range, after a socket of the group is closed.
+func AttachReuseportCPU(fd, n int) error {
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: uint32(skfAdOff + skfAdCPU)},
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(n)},
+		{Code: unix.BPF_RET | unix.BPF_A},
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: (*unix.SockFilter)(unsafe.Pointer(&filter[0]))}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, &prog))
+}
@@ -74,7 +74,7 @@ type SocketOptions struct {
 	// ================================== Options for only server-side ==================================
 
 	// NumEventLoop is set up to start the given number of event-loop goroutine.
-	// Note: Setting up NumEventLoop will override Multicore.
+	// Note: Setting up NumEventLoop will override the number of rings given to NewMany.
 	NumEventLoop int
 
 	// LB represents the load-balancing algorithm used when assigning new connections.
@@ -86,36 +86,73 @@ type SocketOptions struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// ListenerPerRing gives each ring its own listening socket bound to the address with SO_REUSEPORT,
+	// instead of one socket shared by all rings, so that the rings do not contend to accept the same
+	// connections. It implies ReusePort and applies to TCP and UDP addresses.
+	ListenerPerRing bool
+
+	// SteerToCPU attaches to the listeners of ListenerPerRing a classic BPF program which hands each
+	// connection to the ring of index cpu % rings, cpu being the CPU which received it. Pair it with
+	// LockOSThread and ring goroutines pinned to their CPUs to keep a connection on one CPU.
+	SteerToCPU bool
+
 	// ============================= Options for both server-side and client-side =============================
 
-	// ReadBufferCap is the maximum number of bytes that can be read from the peer when the readable event comes.
-	// The default value is 64KB, it can either be reduced to avoid starving the subsequent connections or increased
+	// ReadBufferCap is the maximum number of bytes that can be read from the peer when the readable event comes,
+	// by the rings reading into their own buffer (RunMany), the provided buffers of RunMany2 have a fixed size.
+	// The default value is 1KB, it can either be reduced to avoid starving the subsequent connections or increased
 	// to read more data from a socket.
 	//
 	// Note that ReadBufferCap will always be converted to the least power of two integer value greater than
 	// or equal to its real amount.
 	ReadBufferCap int
 
-	// WriteBufferCap is the maximum number of bytes that a static outbound buffer can hold,
-	// if the data exceeds this value, the overflow will be stored in the elastic linked list buffer.
-	// The default value is 64KB.
+	// WriteBufferCap is the size of the WriteBuffer of each ring, a scratch buffer the handlers of the ring
+	// may encode their replies into.
+	// The default value is 1KB.
 	//
 	// Note that WriteBufferCap will always be converted to the least power of two integer value greater than
 	// or equal to its real amount.
 	WriteBufferCap int
 
-	// LockOSThread is used to determine whether each I/O event-loop is associated to an OS thread, it is useful when you
-	// need some kind of mechanisms like thread local storage, or invoke certain C libraries (such as graphics lib: GLib)
-	// that require thread-level manipulation via cgo, or want all I/O event-loops to actually run in parallel for a
-	// potential higher performance.
+	// LockOSThread pins the OS thread of each ring to CPUs: ring i runs on CPU i, or on the CPUs steered
+	// to it with SteerToCPU. The rings always run on a locked OS thread.
 	LockOSThread bool
 
-	// Ticker indicates whether the ticker has been set up.
+	// Ticker indicates whether the ticker has been set up: OnTick fires on every ring.
 	Ticker bool
 
-	// TCPKeepAlive sets up a duration for (SO_KEEPALIVE) socket option.
+	// TCPKeepAlive sets up a duration for (SO_KEEPALIVE) socket option, the idle time before the first probe
+	// and, unless TCPKeepAliveInterval is set, between the probes. It is rounded up to seconds.
 	TCPKeepAlive time.Duration
 
+	// TCPKeepAliveInterval sets the interval between the keepalive probes (TCP_KEEPINTVL), rounded up to seconds.
+	TCPKeepAliveInterval time.Duration
+
+	// TCPKeepAliveCount sets the number of unanswered keepalive probes after which a connection is dropped (TCP_KEEPCNT).
+	TCPKeepAliveCount int
+
+	// TCPUserTimeout sets how long transmitted data may stay unacknowledged before a connection is dropped
+	// (TCP_USER_TIMEOUT).
+	TCPUserTimeout time.Duration
+
+	// TCPFastOpen enables TCP Fast Open on the listener with a queue of this many pending requests (TCP_FASTOPEN).
+	TCPFastOpen int
+
+	// TCPDeferAccept delays the accept of a connection until data has arrived on it, or up to this duration,
+	// rounded up to seconds (TCP_DEFER_ACCEPT).
+	TCPDeferAccept time.Duration
+
+	// Linger sets the SO_LINGER option of the connections as net.TCPConn.SetLinger does: with a negative value
+	// the system sends the unsent data in the background after a close, with 0 it discards the data and resets
+	// the connection, with a positive value the close waits for up to this many seconds for the data.
+	// nil leaves the option of the system unchanged.
+	Linger *int
+
+	// Backlog is the maximum length of the queue of pending connections of the listener, the limit of the
+	// system (net.core.somaxconn) if 0.
+	Backlog int
+
 	// TCPNoDelay controls whether the operating system should delay
 	// packet transmission in hopes of sending fewer packets (Nagle's algorithm).
 	//
@@ -132,7 +169,7 @@ type SocketOptions struct {
 
 func SetOptions(network string, options SocketOptions) []Option {
 	var sockOpts []Option
-	if options.ReusePort || strings.HasPrefix(network, "udp") {
+	if options.ReusePort || options.ListenerPerRing || strings.HasPrefix(network, "udp") {
 		sockOpt := Option{SetSockOpt: SetReuseport, Opt: 1}
 		sockOpts = append(sockOpts, sockOpt)
 	}
@@ -152,5 +189,18 @@ func SetOptions(network string, options SocketOptions) []Option {
 		sockOpt := Option{SetSockOpt: SetSendBuffer, Opt: options.SocketSendBuffer}
 		sockOpts = append(sockOpts, sockOpt)
 	}
-	return sockOpts
+	if options.TCPKeepAlive > 0 && strings.HasPrefix(network, "tcp") {
+		sockOpt := Option{SetSockOpt: SetKeepAlivePeriod, Opt: seconds(options.TCPKeepAlive)}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.Linger != nil && !strings.HasPrefix(network, "udp") {
+		sockOpt := Option{SetSockOpt: SetLinger, Opt: *options.Linger}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	return append(sockOpts, platformOptions(network, options)...)
+}
+
+// seconds rounds d up to seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
 }
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetKeepAliveInterval sets the interval in seconds between the keepalive probes (TCP_KEEPINTVL).
+func SetKeepAliveInterval(fd, secs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs))
+}
+
+// SetKeepAliveCount sets the number of unanswered keepalive probes after which the connection is
+// dropped (TCP_KEEPCNT).
+func SetKeepAliveCount(fd, count int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count))
+}
+
+// SetUserTimeout sets the time in milliseconds transmitted data may stay unacknowledged before the
+// connection is dropped (TCP_USER_TIMEOUT).
+func SetUserTimeout(fd, msecs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, msecs))
+}
+
+// SetFastOpen enables TCP Fast Open on a listener, qlen is the maximum number of pending fast open
+// requests (TCP_FASTOPEN).
+func SetFastOpen(fd, qlen int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, qlen))
+}
+
+// SetDeferAccept makes a listener wake up the acceptor only once data has arrived on a connection,
+// waiting for up to secs seconds (TCP_DEFER_ACCEPT).
+func SetDeferAccept(fd, secs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs))
+}
+
+// SetBacklog sets the maximum length of the queue of pending connections of the listener fd.
+func SetBacklog(fd, backlog int) error {
+	return os.NewSyscallError("listen", unix.Listen(fd, backlog))
+}
+
+// platformOptions returns the options of options which are only supported on Linux.
+func platformOptions(network string, options SocketOptions) []Option {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil
+	}
+	var sockOpts []Option
+	if options.TCPKeepAliveInterval > 0 {
+		sockOpts = append(sockOpts, Option{SetSockOpt: SetKeepAliveInterval, Opt: seconds(options.TCPKeepAliveInterval)})
+	}
+	if options.TCPKeepAliveCount > 0 {
+		sockOpts = append(sockOpts, Option{SetSockOpt: SetKeepAliveCount, Opt: options.TCPKeepAliveCount})
+	}
+	if options.TCPUserTimeout > 0 {
+		sockOpts = append(sockOpts, Option{SetSockOpt: SetUserTimeout, Opt: int(options.TCPUserTimeout.Milliseconds())})
+	}
+	if options.TCPFastOpen > 0 {
+		sockOpts = append(sockOpts, Option{SetSockOpt: SetFastOpen, Opt: options.TCPFastOpen})
+	}
+	if options.TCPDeferAccept > 0 {
+		sockOpts = append(sockOpts, Option{SetSockOpt: SetDeferAccept, Opt: seconds(options.TCPDeferAccept)})
+	}
+	return sockOpts
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package socket
+
+// platformOptions returns the options of options which are only supported on Linux, none here.
+func platformOptions(string, SocketOptions) []Option {
+	return nil
+}
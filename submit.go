@@ -0,0 +1,187 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"sync/atomic"
+
+	"github.com/y001j/uringnet/logging"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// getSQEntry returns a free entry of the submission queue. If the queue is full, the queued
+// entries are submitted to free their slots. If it stays full, the entry is queued in user space,
+// in the backlog of the ring, and copied to the submission queue once it has room: operations are
+// delayed rather than lost.
+func (ringNet *URingNet) getSQEntry() *uring.SQEntry {
+	if len(ringNet.backlog) == 0 {
+		if sqe := ringNet.ring.GetSQEntry(); sqe != nil {
+			return sqe
+		}
+		atomic.AddUint64(&ringNet.metrics.sqFull, 1)
+		_, _ = ringNet.enter(0)
+		if sqe := ringNet.ring.GetSQEntry(); sqe != nil {
+			return sqe
+		}
+	}
+	// the entries queued after the backlog follow it, the order of the operations is kept.
+	sqe := &uring.SQEntry{}
+	ringNet.backlog = append(ringNet.backlog, sqe)
+	atomic.AddUint64(&ringNet.metrics.backlogged, 1)
+	return sqe
+}
+
+// unqueue copies the entries of the backlog to the submission queue, as many as it has room for.
+// It returns their number.
+func (ringNet *URingNet) unqueue() int {
+	n := 0
+	for ; n < len(ringNet.backlog); n++ {
+		sqe := ringNet.ring.GetSQEntry()
+		if sqe == nil {
+			break
+		}
+		*sqe = *ringNet.backlog[n]
+	}
+	rest := copy(ringNet.backlog, ringNet.backlog[n:])
+	for i := rest; i < len(ringNet.backlog); i++ {
+		ringNet.backlog[i] = nil
+	}
+	ringNet.backlog = ringNet.backlog[:rest]
+	return n
+}
+
+// checkOverflow counts and logs the overflows of the completion queue. The overflowed completions
+// are flushed by the next wait of reap.
+func (ringNet *URingNet) checkOverflow() {
+	overflow := ringNet.ring.CQOverflow()
+	if overflow && !ringNet.overflowing {
+		atomic.AddUint64(&ringNet.metrics.cqOverflows, 1)
+		ringNet.log(logging.LevelWarn, "the completion queue overflowed", "entries", ringNet.ring.CQSize())
+	}
+	ringNet.overflowing = overflow
+	if dropped := ringNet.ring.CQDropped(); dropped != ringNet.cqDropped {
+		atomic.AddUint64(&ringNet.metrics.cqesDropped, uint64(dropped-ringNet.cqDropped))
+		ringNet.log(logging.LevelError, "completions were dropped", "dropped", dropped-ringNet.cqDropped)
+		ringNet.cqDropped = dropped
+	}
+}
+
+// submit hands the queued entries to the kernel and waits for minComplete completions. While the
+// run loop processes a batch of completions, the entries stay queued: the loop submits them at once.
+func (ringNet *URingNet) submit(minComplete uint32) (uint32, error) {
+	if ringNet.batching && minComplete == 0 {
+		return 0, nil
+	}
+	return ringNet.enter(minComplete)
+}
+
+// enter submits the queued entries, the backlog included, and waits for minComplete completions.
+func (ringNet *URingNet) enter(minComplete uint32) (uint32, error) {
+	if ringNet.disabled {
+		// the entries stay queued until the thread of the ring enables it.
+		return 0, nil
+	}
+	var total uint32
+	for len(ringNet.backlog) > 0 {
+		moved := ringNet.unqueue()
+		if len(ringNet.backlog) == 0 {
+			break
+		}
+		// the submission queue is full, it is submitted to make room for the rest of the backlog.
+		flags := paraFlags
+		if ringNet.ring.Flags()&uring.IORING_SETUP_SQPOLL != 0 {
+			// the SQ thread consumes the entries, wait until it has.
+			flags |= uring.IORING_ENTER_SQ_WAIT
+		}
+		n := ringNet.ring.Flush()
+		atomic.AddUint64(&ringNet.metrics.sqesSubmitted, uint64(n))
+		submitted, err := ringNet.ring.EnterWait(n, 0, &flags)
+		total += submitted
+		if err != nil && err != unix.EINTR {
+			atomic.AddUint64(&ringNet.metrics.submitErrors, 1)
+			return total, err
+		}
+		if moved == 0 && submitted == 0 && err == nil {
+			// the kernel takes no more entries for now, the next enter retries.
+			break
+		}
+	}
+	submitted, err := ringNet.flush(minComplete)
+	return total + submitted, err
+}
+
+// flush submits the entries of the submission queue and waits for minComplete completions.
+func (ringNet *URingNet) flush(minComplete uint32) (uint32, error) {
+	n := ringNet.ring.Flush()
+	if n > 0 && ringNet.ring.Flags()&uring.IORING_SETUP_SQPOLL != 0 && ringNet.ring.SQNeedsWakeup() {
+		// the SQ thread sleeps, the enter wakes it up.
+		atomic.AddUint64(&ringNet.metrics.sqWakeups, 1)
+	}
+	atomic.AddUint64(&ringNet.metrics.sqesSubmitted, uint64(n))
+	var (
+		submitted uint32
+		err       error
+	)
+	if minComplete > 0 {
+		atomic.AddUint64(&ringNet.metrics.waits, 1)
+	}
+	switch {
+	case minComplete > 0 && ringNet.waitTimeout != nil:
+		submitted, err = ringNet.ring.EnterWaitTimeout(n, minComplete, &paraFlags, ringNet.waitTimeout)
+	case minComplete > 0:
+		submitted, err = ringNet.ring.EnterWait(n, minComplete, &paraFlags)
+	default:
+		submitted, err = ringNet.ring.Enter(n, 0, &paraFlags)
+	}
+	if err != nil && err != unix.EINTR && err != unix.ETIME {
+		atomic.AddUint64(&ringNet.metrics.submitErrors, 1)
+	}
+	return submitted, err
+}
+
+// spin submits the queued entries, then polls the completion queue for the spin time of the ring.
+// It returns the number of completions peeked into cqes, 0 if none arrived.
+func (ringNet *URingNet) spin() int {
+	if ringNet.ring.Flags()&uring.IORING_SETUP_DEFER_TASKRUN != 0 {
+		// the completions are only posted by a wait.
+		return 0
+	}
+	_, _ = ringNet.enter(0)
+	deadline := monotonic() + int64(ringNet.spinTime)
+	for {
+		if n := ringNet.ring.PeekCQEntries(ringNet.cqes); n > 0 {
+			return n
+		}
+		if monotonic() >= deadline {
+			return 0
+		}
+	}
+}
+
+// reap fills the cqes of the ring with the available completions and consumes them. If none is
+// available, it waits for one as the wait strategy of the ring says. The entries queued by the
+// previous batch are submitted first, by the same io_uring_enter which waits for a completion.
+func (ringNet *URingNet) reap() (int, error) {
+	if ringNet.cqes == nil {
+		ringNet.cqes = make([]uring.CQEntry, ringNet.ring.CQSize())
+	}
+	n := ringNet.ring.PeekCQEntries(ringNet.cqes)
+	if n == 0 && ringNet.wait == WaitAdaptive {
+		n = ringNet.spin()
+	}
+	wait := uint32(0)
+	if n == 0 {
+		wait = 1
+	}
+	if _, err := ringNet.enter(wait); err != nil && err != unix.EINTR && err != unix.EAGAIN && err != unix.ETIME {
+		return 0, err
+	}
+	if n == 0 {
+		n = ringNet.ring.PeekCQEntries(ringNet.cqes)
+	}
+	ringNet.ring.CQAdvance(uint32(n))
+	atomic.AddUint64(&ringNet.metrics.cqesReaped, uint64(n))
+	return n, nil
+}
@@ -0,0 +1,170 @@
+//go:build linux
+
+// Package tcpproxy implements a layer-4 TCP proxy running natively on UringNet rings.
+//
+// Each accepted connection is paired with a connection dialed to the upstream address, and the
+// bytes are relayed in both directions on the ring owning the connection, see uringnet.Relay:
+//
+//	srv := &tcpproxy.Server{Addr: ":8443", Upstream: "10.0.0.2:443", IdleTimeout: 5 * time.Minute}
+//	log.Fatal(srv.ListenAndServe())
+package tcpproxy
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ringSize    = 4096
+	ringBuffers = 4096
+
+	// DefaultDialTimeout bounds the connection to the upstream if Server.DialTimeout is 0.
+	DefaultDialTimeout = 10 * time.Second
+)
+
+// A Server defines parameters for running a TCP proxy on UringNet.
+type Server struct {
+	// Addr specifies the TCP address for the server to listen on.
+	Addr string
+	// Upstream is the TCP address every connection is relayed to.
+	Upstream string
+	// DialTimeout bounds the connection to the upstream, DefaultDialTimeout if zero.
+	DialTimeout time.Duration
+	// IdleTimeout closes a connection once no byte moved for this long, no limit if zero.
+	IdleTimeout time.Duration
+	// BufferSize is the size of the buffer of each direction of a connection, uringnet.DefaultRelayBuffer if zero.
+	BufferSize int
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+	// Splice relays the bytes through pipes with splice, they are not copied to user space.
+	Splice bool
+
+	upstream unix.Sockaddr
+	stats    Stats
+
+	mu     sync.Mutex
+	loop   *uringnet.Ringloop
+	mirror *uringnet.Mirror
+}
+
+// Stats counts the connections and the bytes relayed by a server.
+type Stats struct {
+	Relays           uint64 // connections relayed to the upstream
+	Active           int64  // connections being relayed
+	DialFailures     uint64 // connections closed because the upstream could not be reached
+	ClientToUpstream uint64 // bytes of the relays which have ended
+	UpstreamToClient uint64
+}
+
+// Stats returns the counters of the server, it is safe to call from any goroutine.
+func (srv *Server) Stats() Stats {
+	return Stats{
+		Relays:           atomic.LoadUint64(&srv.stats.Relays),
+		Active:           atomic.LoadInt64(&srv.stats.Active),
+		DialFailures:     atomic.LoadUint64(&srv.stats.DialFailures),
+		ClientToUpstream: atomic.LoadUint64(&srv.stats.ClientToUpstream),
+		UpstreamToClient: atomic.LoadUint64(&srv.stats.UpstreamToClient),
+	}
+}
+
+// SetMirror mirrors the bytes sent by the clients to m, nil stops it. It may be called before or
+// while the server runs, from any goroutine.
+func (srv *Server) SetMirror(m *uringnet.Mirror) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.mirror = m
+	if srv.loop != nil {
+		srv.loop.SetMirror(m)
+	}
+}
+
+// ListenAndServe listens on srv.Addr and relays the connections to srv.Upstream. It only returns on setup errors.
+func (srv *Server) ListenAndServe() error {
+	if srv.Upstream == "" {
+		return errors.New("tcpproxy: no Upstream")
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", srv.Upstream)
+	if err != nil {
+		return err
+	}
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		sa := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		srv.upstream = sa
+	} else {
+		sa := &unix.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa.Addr[:], tcpAddr.IP.To16())
+		srv.upstream = sa
+	}
+	rings := srv.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp, Address: srv.Addr}, ringSize, srv.SQPoll, rings, options, nil)
+	if err != nil {
+		return err
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Handler = &ringHandler{srv: srv, ring: ringNet}
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	srv.loop = loop
+	loop.SetMirror(srv.mirror)
+	srv.mu.Unlock()
+	loop.RunMany2()
+	select {}
+}
+
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+	srv  *Server
+	ring *uringnet.URingNet
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	client := data.Fd
+	if err := h.ring.Hijack(client); err != nil {
+		return nil, uringnet.None
+	}
+	timeout := h.srv.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	h.ring.Dial(h.srv.upstream, timeout, func(upstream int32, err error) {
+		if err != nil {
+			atomic.AddUint64(&h.srv.stats.DialFailures, 1)
+			h.ring.ConnLogger(client).Log(logging.LevelWarn, "dialing the upstream failed", "upstream", h.srv.Upstream, "err", err)
+			h.ring.CloseFd(client)
+			return
+		}
+		_ = unix.SetsockoptInt(int(upstream), unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
+		atomic.AddUint64(&h.srv.stats.Relays, 1)
+		atomic.AddInt64(&h.srv.stats.Active, 1)
+		cfg := uringnet.RelayConfig{BufferSize: h.srv.BufferSize, IdleTimeout: h.srv.IdleTimeout, Splice: h.srv.Splice}
+		h.ring.Relay(client, upstream, cfg, func(stats uringnet.RelayStats, err error) {
+			atomic.AddInt64(&h.srv.stats.Active, -1)
+			atomic.AddUint64(&h.srv.stats.ClientToUpstream, stats.ClientToUpstream)
+			atomic.AddUint64(&h.srv.stats.UpstreamToClient, stats.UpstreamToClient)
+			if err != nil {
+				h.ring.ConnLogger(client).Log(logging.LevelDebug, "relay ended", "err", err)
+			}
+		})
+	})
+	return nil, uringnet.None
+}
@@ -0,0 +1,188 @@
+//go:build linux
+
+package tcpproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet"
+)
+
+var (
+	upstreamOnce sync.Once
+	upstreamAddr string
+
+	serversMu sync.Mutex
+	servers   = map[string]*Server{}
+)
+
+// upstream runs a server which replies to the data of each connection, once the client has
+// ended it, with the data in upper case.
+func upstream(t *testing.T) string {
+	t.Helper()
+	upstreamOnce.Do(func() {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstreamAddr = ln.Addr().String()
+		go serveUpper(ln)
+	})
+	return upstreamAddr
+}
+
+func serveUpper(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			data, _ := io.ReadAll(c)
+			_, _ = c.Write(bytes.ToUpper(data))
+		}()
+	}
+}
+
+// startServer starts srv once per address, servers run until the end of the tests.
+func startServer(t *testing.T, srv *Server) *Server {
+	t.Helper()
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	addr := srv.Addr
+	if started := servers[addr]; started != nil {
+		return started
+	}
+	srv.Rings = 2
+	servers[addr] = srv
+	go func() { t.Error(srv.ListenAndServe()) }()
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp4", addr); err == nil {
+			c.Close()
+			return srv
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	srv := startServer(t, &Server{Addr: "127.0.0.1:18936", Upstream: upstream(t), IdleTimeout: 2 * time.Second})
+
+	payload := bytes.Repeat([]byte("firmware chunk "), 600000)
+	c, err := net.Dial("tcp4", "127.0.0.1:18936")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	go func() {
+		_, _ = c.Write(payload)
+		// the half-close reaches the upstream, which replies once it has read everything.
+		_ = c.(*net.TCPConn).CloseWrite()
+	}()
+	got, err := io.ReadAll(c)
+	if err != nil || !bytes.Equal(got, bytes.ToUpper(payload)) {
+		t.Fatalf("expect the reply of the upstream, got %d bytes, %v", len(got), err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Stats().Active != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := srv.Stats()
+	if stats.Active != 0 || stats.ClientToUpstream < uint64(len(payload)) || stats.UpstreamToClient < uint64(len(payload)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	startServer(t, &Server{Addr: "127.0.0.1:18937", Upstream: upstream(t), IdleTimeout: 100 * time.Millisecond})
+	c, err := net.Dial("tcp4", "127.0.0.1:18937")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if _, err = c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect the idle connection to be closed")
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expect the connection to be closed after the idle timeout, took %v", elapsed)
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	// port 1 of the loopback address has no listener.
+	srv := startServer(t, &Server{Addr: "127.0.0.1:18938", Upstream: "127.0.0.1:1"})
+	c, err := net.Dial("tcp4", "127.0.0.1:18938")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %v", err)
+	}
+	if srv.Stats().DialFailures == 0 {
+		t.Fatal("expect the dial failure to be counted")
+	}
+}
+
+func TestSpliceMirror(t *testing.T) {
+	srv := startServer(t, &Server{Addr: "127.0.0.1:18939", Upstream: upstream(t), Splice: true})
+	name := filepath.Join(t.TempDir(), "capture")
+	m, err := uringnet.NewFileMirror(name, uringnet.MirrorConfig{MaxQueued: 64 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetMirror(m)
+	defer srv.SetMirror(nil)
+
+	payload := bytes.Repeat([]byte("spliced request "), 100000)
+	c, err := net.Dial("tcp4", "127.0.0.1:18939")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	go func() {
+		_, _ = c.Write(payload)
+		_ = c.(*net.TCPConn).CloseWrite()
+	}()
+	got, err := io.ReadAll(c)
+	if err != nil || !bytes.Equal(got, bytes.ToUpper(payload)) {
+		t.Fatalf("expect the reply of the upstream, got %d bytes, %v", len(got), err)
+	}
+
+	// the pipe of the mirror may be too small for a chunk, which is then dropped in part.
+	deadline := time.Now().Add(2 * time.Second)
+	for stats := m.Stats(); stats.Bytes+stats.DroppedBytes < uint64(len(payload)) && time.Now().Before(deadline); stats = m.Stats() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := m.Stats()
+	if stats.Bytes+stats.DroppedBytes != uint64(len(payload)) || stats.Bytes == 0 || stats.Errors != 0 {
+		t.Fatalf("unexpected mirror stats %+v", stats)
+	}
+	_ = m.Close()
+	captured, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DroppedBytes == 0 && !bytes.Equal(captured, payload) {
+		t.Fatalf("expect the capture to be the request, got %d bytes", len(captured))
+	}
+	if len(captured) != int(stats.Bytes) {
+		t.Fatalf("expect %d captured bytes, got %d", stats.Bytes, len(captured))
+	}
+}
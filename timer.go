@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+	"time"
+)
+
+// Timer is a timer of a ring, created by AfterFunc. Its methods must be called on the ring goroutine.
+type Timer struct {
+	ringNet *URingNet
+	id      uint64
+	ts      unix.Timespec
+	fired   bool
+	stopped bool
+}
+
+// AfterFunc runs f on the ring goroutine once d has elapsed. The timer is a timeout operation of
+// the ring, no goroutine nor runtime timer is involved. It must be called on the ring goroutine.
+func (ringNet *URingNet) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{ringNet: ringNet, ts: unix.NsecToTimespec(int64(d))}
+	t.id = ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Timeout(sqe, &t.ts, false, 0)
+	}, func(res int32, _ uint32) {
+		if t.stopped || res != -int32(unix.ETIME) {
+			return
+		}
+		t.fired = true
+		f()
+	})
+	return t
+}
+
+// Stop prevents the timer from firing. It returns false if the timer has already fired or been stopped.
+func (t *Timer) Stop() bool {
+	if t.fired || t.stopped {
+		return false
+	}
+	t.stopped = true
+	t.ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.TimeoutRemove(sqe, t.id, 0)
+	}, func(int32, uint32) {})
+	return true
+}
+
+// tick fires OnTick and schedules the next tick after the delay it returns.
+func (ringNet *URingNet) tick() {
+	if delay, _ := ringNet.Handler.OnTick(); delay > 0 {
+		ringNet.AfterFunc(delay, ringNet.tick)
+	}
+}
@@ -0,0 +1,136 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"encoding/binary"
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/logging"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+	"sync/atomic"
+)
+
+// Trigger runs task on the goroutine running the ring, it is safe to call from any goroutine.
+// The ring is woken up through an eventfd, tasks triggered before the ring runs are executed once it starts.
+func (ringNet *URingNet) Trigger(task func()) error {
+	if atomic.LoadInt32(&ringNet.inShutdown) != 0 {
+		return errors.ErrEngineShutdown
+	}
+	ringNet.taskMu.Lock()
+	ringNet.tasks = append(ringNet.tasks, task)
+	ringNet.taskMu.Unlock()
+
+	fd := atomic.LoadInt32(&ringNet.wakeFd)
+	if fd > 0 && atomic.CompareAndSwapInt32(&ringNet.wakePending, 0, 1) {
+		var one [8]byte
+		binary.LittleEndian.PutUint64(one[:], 1)
+		if _, err := unix.Write(int(fd), one[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Submit fills a free SQE with prep and submits it. done is called on the ring goroutine with
+// the result once the operation completes. prep must not set the user data of the SQE.
+//
+// It returns the user data identifying the operation, which can be passed to Cancel.
+// Submit must only be called on the ring goroutine, i.e. from the event handler or from a triggered task.
+func (ringNet *URingNet) Submit(prep func(sqe *uring.SQEntry), done func(res int32, flags uint32)) uint64 {
+	id := ringNet.queue(prep, done)
+	_, _ = ringNet.submit(0)
+	return id
+}
+
+// queue fills a free SQE like Submit, without submitting it. Operations queued together are
+// submitted at once by the next submit.
+func (ringNet *URingNet) queue(prep func(sqe *uring.SQEntry), done func(res int32, flags uint32)) uint64 {
+	sqe := ringNet.getSQEntry()
+	data := makeUserData(completion)
+	data.callback = done
+	prep(sqe)
+	data.opcode = sqe.Opcode()
+	data.Fd = sqe.FD()
+	if !waits(data.opcode) {
+		data.start = monotonic()
+	}
+	sqe.SetUserData(data.id)
+	ringNet.userDataList.Store(data.id, data)
+	return data.id
+}
+
+// Cancel asks the kernel to cancel the operation identified by userData, as returned by Submit.
+// The canceled operation still completes, usually with -ECANCELED.
+func (ringNet *URingNet) Cancel(userData uint64) {
+	ringNet.Submit(func(sqe *uring.SQEntry) {
+		uring.Cancel(sqe, userData, 0)
+	}, func(int32, uint32) {})
+}
+
+// ArmRead arms a read for the connection fd, OnTraffic fires once data has arrived.
+// It is used to resume reading after OnTraffic returned None. It must be called on the ring goroutine.
+//
+// If the connection exceeded its rate limits, the read is armed once they allow it.
+func (ringNet *URingNet) ArmRead(fd int32) {
+	if ringNet.throttle(fd) {
+		return
+	}
+	ringNet.armRead(fd)
+}
+
+// armRead arms a read for the connection fd regardless of its rate limits.
+func (ringNet *URingNet) armRead(fd int32) {
+	sqe := ringNet.getSQEntry()
+	if ringNet.autoBuffer {
+		ringNet.read(fd, sqe, ringNet.ringIndex)
+	} else {
+		ringNet.recv(fd, sqe, ringNet.ringIndex)
+	}
+}
+
+// CloseFd closes the connection fd, OnClose fires right away. It must be called on the ring goroutine
+// and only when no read is armed for fd, a pending read keeps the socket open. Use unix.Shutdown to
+// terminate a connection which is being read, the read then completes and the ring closes the socket.
+func (ringNet *URingNet) CloseFd(fd int32) {
+	ringNet.closeConn(&UserData{Fd: fd})
+}
+
+// startWakeup creates the eventfd of the ring and arms its first read.
+func (ringNet *URingNet) startWakeup() {
+	fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		ringNet.log(logging.LevelError, "creating the wakeup eventfd failed", "err", err)
+		return
+	}
+	atomic.StoreInt32(&ringNet.wakeFd, int32(fd))
+	ringNet.armWakeup()
+	ringNet.runTasks()
+}
+
+func (ringNet *URingNet) armWakeup() {
+	sqe := ringNet.getSQEntry()
+	data := makeUserData(wakeup)
+	sqe.SetUserData(data.id)
+	uring.Read(sqe, uintptr(atomic.LoadInt32(&ringNet.wakeFd)), ringNet.wakeBuf[:])
+	ringNet.userDataList.Store(data.id, data)
+	_, _ = ringNet.submit(0)
+}
+
+// wakeUp handles the completed read of the eventfd.
+func (ringNet *URingNet) wakeUp() {
+	atomic.StoreInt32(&ringNet.wakePending, 0)
+	ringNet.armWakeup()
+	ringNet.runTasks()
+}
+
+func (ringNet *URingNet) runTasks() {
+	ringNet.taskMu.Lock()
+	tasks := ringNet.tasks
+	ringNet.tasks = nil
+	ringNet.taskMu.Unlock()
+	for _, task := range tasks {
+		task()
+	}
+}
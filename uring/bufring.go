@@ -0,0 +1,95 @@
+package uring
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bufRingEntrySize is the size of struct io_uring_buf.
+const bufRingEntrySize = 16
+
+// bufReg is struct io_uring_buf_reg.
+type bufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// BufRing is a ring of provided buffers shared with the kernel (IORING_REGISTER_PBUF_RING, Linux 5.19).
+// Unlike IORING_OP_PROVIDE_BUFFERS, a buffer is given back to the kernel by writing it to the ring,
+// without a submission. The operations select a buffer of the ring with IOSQE_BUFFER_SELECT and the
+// group ID of the ring.
+//
+// A BufRing is not safe for concurrent use.
+type BufRing struct {
+	ring    *Ring
+	mem     []byte
+	mask    uint16
+	tail    uint16 // published to the kernel
+	pending uint16 // buffers added since the last Advance
+	gid     uint16
+}
+
+// RegisterBufRing registers a buffer ring of entries entries, a power of two up to 32768, with
+// the group ID gid. It returns an error, EINVAL on kernels before 5.19, if it cannot be registered.
+func (r *Ring) RegisterBufRing(entries, gid uint16) (*BufRing, error) {
+	if entries == 0 || entries&(entries-1) != 0 || entries > 1<<15 {
+		return nil, unix.EINVAL
+	}
+	// the ring must be page aligned, an anonymous mapping is.
+	size := (int(entries)*bufRingEntrySize + unix.Getpagesize() - 1) &^ (unix.Getpagesize() - 1)
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANONYMOUS|unix.MAP_PRIVATE|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+	reg := bufReg{ringAddr: uint64(uintptr(unsafe.Pointer(&mem[0]))), ringEntries: uint32(entries), bgid: gid}
+	for {
+		_, _, errno := unix.Syscall6(IO_URING_REGISTER, uintptr(r.fd), IORING_REGISTER_PBUF_RING,
+			uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			_ = unix.Munmap(mem)
+			return nil, errno
+		}
+		break
+	}
+	return &BufRing{ring: r, mem: mem, mask: entries - 1, gid: gid}, nil
+}
+
+// Add queues the buffer of length n at addr with the buffer ID bid, it is given to the kernel by
+// the next Advance.
+func (b *BufRing) Add(addr unsafe.Pointer, n uint32, bid uint16) {
+	e := unsafe.Pointer(&b.mem[int((b.tail+b.pending)&b.mask)*bufRingEntrySize])
+	*(*uint64)(e) = uint64(uintptr(addr))
+	*(*uint32)(unsafe.Add(e, 8)) = n
+	*(*uint16)(unsafe.Add(e, 12)) = bid
+	b.pending++
+}
+
+// Advance gives the kernel the buffers queued by Add.
+func (b *BufRing) Advance() {
+	b.tail += b.pending
+	b.pending = 0
+	// the tail is the reserved field of the first entry, next to its buffer ID; both are stored at
+	// once so that the kernel sees the tail after the entries (little endian layout).
+	word := (*uint32)(unsafe.Pointer(&b.mem[12]))
+	atomic.StoreUint32(word, uint32(*(*uint16)(unsafe.Pointer(&b.mem[12])))|uint32(b.tail)<<16)
+}
+
+// Close unregisters the buffer ring and releases its memory.
+func (b *BufRing) Close() error {
+	reg := bufReg{bgid: b.gid}
+	_, _, errno := unix.Syscall6(IO_URING_REGISTER, uintptr(b.ring.fd), IORING_UNREGISTER_PBUF_RING,
+		uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	err := unix.Munmap(b.mem)
+	if errno != 0 {
+		return errno
+	}
+	return err
+}
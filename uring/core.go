@@ -53,6 +53,15 @@ const (
 	IORING_OP_MKDIRAT
 	IORING_OP_SYMLINKAT
 	IORING_OP_LINKAT
+	IORING_OP_MSG_RING
+	IORING_OP_FSETXATTR
+	IORING_OP_SETXATTR
+	IORING_OP_FGETXATTR
+	IORING_OP_GETXATTR
+	IORING_OP_SOCKET
+	IORING_OP_URING_CMD
+	IORING_OP_SEND_ZC
+	IORING_OP_SENDMSG_ZC
 	IORING_OP_LAST
 )
 
@@ -75,8 +84,17 @@ const IORING_TIMEOUT_ABS uint32 = 1 << 0
 // sqe splice flags
 const SPLICE_F_FD_IN_FIXED uint32 = 1 << 31
 
+// sqe accept flags, set in the ioprio field
+const IORING_ACCEPT_MULTISHOT uint16 = 1 << 0
+
 // cqe flags
-const IORING_CQE_F_BUFFER uint32 = 1 << 0
+const (
+	IORING_CQE_F_BUFFER uint32 = 1 << iota
+	// IORING_CQE_F_MORE is set while a multishot operation goes on posting completions.
+	IORING_CQE_F_MORE
+	IORING_CQE_F_SOCK_NONEMPTY
+	IORING_CQE_F_NOTIF
+)
 
 const IORING_CQE_BUFFER_SHIFT uint32 = 16
 
@@ -91,6 +109,14 @@ const (
 	IORING_SETUP_CQSIZE
 	IORING_SETUP_CLAMP
 	IORING_SETUP_ATTACH_WQ
+	IORING_SETUP_R_DISABLED
+	IORING_SETUP_SUBMIT_ALL
+	IORING_SETUP_COOP_TASKRUN
+	IORING_SETUP_TASKRUN_FLAG
+	IORING_SETUP_SQE128
+	IORING_SETUP_CQE32
+	IORING_SETUP_SINGLE_ISSUER
+	IORING_SETUP_DEFER_TASKRUN
 )
 
 // offsets for mmap
@@ -104,12 +130,16 @@ const (
 const (
 	IORING_SQ_NEED_WAKEUP uint32 = 1 << iota
 	IORING_SQ_CQ_OVERFLOW
+	// IORING_SQ_TASKRUN is set with IORING_SETUP_TASKRUN_FLAG while completions wait for an enter to be posted.
+	IORING_SQ_TASKRUN
 )
 
 // enter flags
 const (
 	IORING_ENTER_GETEVENTS uint32 = 1 << iota
 	IORING_ENTER_SQ_WAKEUP
+	IORING_ENTER_SQ_WAIT
+	IORING_ENTER_EXT_ARG
 )
 
 // params feature flags
@@ -122,6 +152,12 @@ const (
 	IORING_FEAT_FAST_POLL
 	IORING_FEAT_POLL_32BITS
 	IORING_FEAT_SQPOLL_NONFIXED
+	IORING_FEAT_EXT_ARG
+	IORING_FEAT_NATIVE_WORKERS
+	IORING_FEAT_RSRC_TAGS
+	IORING_FEAT_CQE_SKIP
+	IORING_FEAT_LINKED_FILE
+	IORING_FEAT_REG_REG_RING
 )
 
 const (
@@ -217,6 +253,16 @@ func (e *SQEntry) UserData() uint64 {
 	return e.userData
 }
 
+// FD returns the file descriptor of the entry.
+func (e *SQEntry) FD() int32 {
+	return e.fd
+}
+
+// Opcode returns the operation of the entry.
+func (e *SQEntry) Opcode() uint8 {
+	return e.opcode
+}
+
 // SetOpcode ...
 func (e *SQEntry) SetOpcode(opcode uint8) {
 	e.opcode = opcode
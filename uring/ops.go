@@ -124,6 +124,55 @@ func Recv(sqe *SQEntry, fd uintptr, buf []byte, flags uint32) {
 	sqe.SetOpcodeFlags(flags)
 }
 
+// Connect connects the socket fd to the address addr of length addrLen, addr must be kept alive
+// until the operation completes.
+func Connect(sqe *SQEntry, fd uintptr, addr unsafe.Pointer, addrLen uint32) {
+	sqe.SetOpcode(IORING_OP_CONNECT)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr(uint64(uintptr(addr)))
+	sqe.SetOffset(uint64(addrLen))
+}
+
+// Splice moves n bytes from fdIn at offIn to fdOut at offOut, one of the descriptors must be a
+// pipe. An offset of -1 stands for the current position of the file and must be used for a pipe.
+// flags are the SPLICE_F_* flags, SPLICE_F_FD_IN_FIXED if fdIn is a registered file.
+func Splice(sqe *SQEntry, fdIn int32, offIn int64, fdOut int32, offOut int64, n uint32, flags uint32) {
+	sqe.SetOpcode(IORING_OP_SPLICE)
+	sqe.SetFD(fdOut)
+	sqe.SetAddr2(uint64(offOut))
+	sqe.SetSpliceOffIn(uint64(offIn))
+	sqe.SetLen(n)
+	sqe.SetOpcodeFlags(flags)
+	sqe.SetSpliceFdIn(fdIn)
+}
+
+// Tee duplicates up to n bytes of the pipe fdIn to the pipe fdOut, without consuming them from fdIn.
+func Tee(sqe *SQEntry, fdIn int32, fdOut int32, n uint32, flags uint32) {
+	sqe.SetOpcode(IORING_OP_TEE)
+	sqe.SetFD(fdOut)
+	sqe.SetLen(n)
+	sqe.SetOpcodeFlags(flags)
+	sqe.SetSpliceFdIn(fdIn)
+}
+
+// SendMsg sends the message described by msg, it is used to send datagrams to a given address.
+func SendMsg(sqe *SQEntry, fd uintptr, msg *unix.Msghdr, flags uint32) {
+	sqe.SetOpcode(IORING_OP_SENDMSG)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(msg))))
+	sqe.SetLen(1)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// RecvMsg receives a message into msg, the address of the sender of a datagram is stored in msg.Name.
+func RecvMsg(sqe *SQEntry, fd uintptr, msg *unix.Msghdr, flags uint32) {
+	sqe.SetOpcode(IORING_OP_RECVMSG)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(msg))))
+	sqe.SetLen(1)
+	sqe.SetOpcodeFlags(flags)
+}
+
 // Timeout operation.
 // if abs is true then IORING_TIMEOUT_ABS will be added to timeoutFlags.
 // count is the number of events to wait.
@@ -149,6 +198,22 @@ func LinkTimeout(sqe *SQEntry, ts *unix.Timespec, abs bool) {
 	}
 }
 
+// TimeoutRemove removes the timeout identified by userData, which then completes with -ECANCELED.
+func TimeoutRemove(sqe *SQEntry, userData uint64, flags uint32) {
+	sqe.SetOpcode(IORING_OP_TIMEOUT_REMOVE)
+	sqe.SetFD(-1)
+	sqe.SetAddr(userData)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// Cancel attempts to cancel an already issued operation identified by userData.
+func Cancel(sqe *SQEntry, userData uint64, flags uint32) {
+	sqe.SetOpcode(IORING_OP_ASYNC_CANCEL)
+	sqe.SetFD(-1)
+	sqe.SetAddr(userData)
+	sqe.SetOpcodeFlags(flags)
+}
+
 // Read ...
 func Mkdirat(sqe *SQEntry, fd uintptr, buf []byte) {
 	sqe.opcode = IORING_OP_MKDIRAT
@@ -167,7 +232,7 @@ func Accept(sqe *SQEntry, fd uintptr, clientAddr *syscall.RawSockaddrAny, len *u
 	sqe.SetAddr(uint64(uintptr(unsafe.Pointer(clientAddr))))
 
 	sqe.len = 0
-	sqe.SetOffset(uint64(uintptr(unsafe.Pointer(&len))))
+	sqe.SetOffset(uint64(uintptr(unsafe.Pointer(len))))
 }
 
 func ProvideBuf(sqe *SQEntry, clientAddr [][BufferSize]byte, bufferCount uint32, bufferSize uint32, gid uint16) {
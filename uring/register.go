@@ -17,6 +17,19 @@ const (
 	IORING_REGISTER_PROBE
 	IORING_REGISTER_PERSONALITY
 	IORING_UNREGISTER_PERSONALITY
+	IORING_REGISTER_RESTRICTIONS
+	IORING_REGISTER_ENABLE_RINGS
+	IORING_REGISTER_FILES2
+	IORING_REGISTER_FILES_UPDATE2
+	IORING_REGISTER_BUFFERS2
+	IORING_REGISTER_BUFFERS_UPDATE
+	IORING_REGISTER_IOWQ_AFF
+	IORING_UNREGISTER_IOWQ_AFF
+	IORING_REGISTER_IOWQ_MAX_WORKERS
+	IORING_REGISTER_RING_FDS
+	IORING_UNREGISTER_RING_FDS
+	IORING_REGISTER_PBUF_RING
+	IORING_UNREGISTER_PBUF_RING
 )
 
 const (
@@ -95,6 +108,25 @@ func (r *Ring) RegisterFiles(fds []int32) error {
 	}
 }
 
+// EnableRings enables a ring set up with IORING_SETUP_R_DISABLED. With IORING_SETUP_SINGLE_ISSUER,
+// the calling thread becomes the only one allowed to submit to the ring.
+func (r *Ring) EnableRings() error {
+	for {
+		_, _, errno := unix.Syscall6(
+			IO_URING_REGISTER,
+			uintptr(r.fd),
+			IORING_REGISTER_ENABLE_RINGS,
+			0, 0, 0, 0)
+		if errno > 0 {
+			if errno == unix.EINTR {
+				continue
+			}
+			return errno
+		}
+		return nil
+	}
+}
+
 // UnregisterFiles ...
 func (r *Ring) UnregisterFiles() error {
 	for {
@@ -1,9 +1,12 @@
 package uring
 
 import (
+	"runtime"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 // sqRing ...
@@ -101,6 +104,40 @@ func (r *Ring) SQSize() uint32 {
 	return r.params.SQEntries
 }
 
+// Features returns the IORING_FEAT_* flags the kernel reported when the ring was set up.
+func (r *Ring) Features() uint32 {
+	return r.params.Features
+}
+
+// Flags returns the IORING_SETUP_* flags the ring was set up with.
+func (r *Ring) Flags() uint32 {
+	return r.params.Flags
+}
+
+// SQNeedsWakeup reports whether the SQ thread of a ring set up with IORING_SETUP_SQPOLL sleeps. Enter
+// wakes it up.
+func (r *Ring) SQNeedsWakeup() bool {
+	return atomic.LoadUint32(r.sq.flags)&IORING_SQ_NEED_WAKEUP > 0
+}
+
+// CQOverflow reports whether completions overflowed the completion queue. The kernel keeps them
+// (IORING_FEAT_NODROP) until they are flushed by FlushCQOverflow or GetCQEntry.
+func (r *Ring) CQOverflow() bool {
+	return atomic.LoadUint32(r.sq.flags)&IORING_SQ_CQ_OVERFLOW > 0
+}
+
+// CQDropped returns the number of completions the kernel dropped because the completion queue
+// was full and they could not be kept.
+func (r *Ring) CQDropped() uint32 {
+	return atomic.LoadUint32(r.cq.overflow)
+}
+
+// FlushCQOverflow moves the overflowed completions to the completion queue, as far as it has room.
+func (r *Ring) FlushCQOverflow() error {
+	_, err := r.enter(0, 0, IORING_ENTER_GETEVENTS, false)
+	return err
+}
+
 // GetSQEntry returns earliest available SQEntry. May return nil if there are
 // no available entries.
 // Entry can be reused after Submit or Enter.
@@ -146,17 +183,55 @@ func (r *Ring) Flush() uint32 {
 
 // Enter io_uring instance. submited and minComplete will be passed as is.
 func (r *Ring) Enter(submitted uint32, minComplete uint32, flags *uint32) (uint32, error) {
-	//var flags uint32
-	if r.sqNeedsEnter(submitted, flags) || minComplete > 0 {
+	// flags is copied, the same variable is shared by the rings of a loop.
+	enterFlags := *flags
+	if r.sqNeedsEnter(submitted, &enterFlags) || minComplete > 0 {
 		if minComplete > 0 || (r.params.Flags&IORING_SETUP_IOPOLL) > 0 {
-			*flags |= IORING_ENTER_GETEVENTS
+			enterFlags |= IORING_ENTER_GETEVENTS
 		}
-		return r.enter(submitted, minComplete, *flags, true)
+		return r.enter(submitted, minComplete, enterFlags, true)
 	}
 	//fmt.Println("We have not submitted.")
 	return 0, nil
 }
 
+// EnterWait is Enter for waiting for completions. Unlike Enter, which makes a raw syscall, it lets
+// the Go scheduler run other goroutines while the thread is blocked in the kernel.
+func (r *Ring) EnterWait(submitted uint32, minComplete uint32, flags *uint32) (uint32, error) {
+	enterFlags := *flags
+	r.sqNeedsEnter(submitted, &enterFlags)
+	if minComplete > 0 || (r.params.Flags&IORING_SETUP_IOPOLL) > 0 {
+		enterFlags |= IORING_ENTER_GETEVENTS
+	}
+	return r.enter(submitted, minComplete, enterFlags, false)
+}
+
+// getEventsArg is struct io_uring_getevents_arg.
+type getEventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	pad       uint32
+	ts        uint64
+}
+
+// EnterWaitTimeout is EnterWait giving up waiting after timeout, it then returns ETIME. It requires
+// IORING_FEAT_EXT_ARG, Linux 5.11.
+func (r *Ring) EnterWaitTimeout(submitted uint32, minComplete uint32, flags *uint32, timeout *unix.Timespec) (uint32, error) {
+	enterFlags := *flags | IORING_ENTER_EXT_ARG
+	r.sqNeedsEnter(submitted, &enterFlags)
+	if minComplete > 0 || (r.params.Flags&IORING_SETUP_IOPOLL) > 0 {
+		enterFlags |= IORING_ENTER_GETEVENTS
+	}
+	arg := getEventsArg{ts: uint64(uintptr(unsafe.Pointer(timeout)))}
+	r1, _, errno := syscall.Syscall6(IO_URING_ENTER, uintptr(r.fd), uintptr(submitted), uintptr(minComplete),
+		uintptr(enterFlags), uintptr(unsafe.Pointer(&arg)), unsafe.Sizeof(arg))
+	runtime.KeepAlive(timeout)
+	if errno == 0 {
+		return uint32(r1), nil
+	}
+	return uint32(r1), error(errno)
+}
+
 // Submit and wait for specified number of entries.
 func (r *Ring) Submit(minComplete uint32, enterflags *uint32) (uint32, error) {
 	return r.Enter(r.Flush(), minComplete, enterflags)
@@ -169,7 +244,8 @@ func (r *Ring) Submit(minComplete uint32, enterflags *uint32) (uint32, error) {
 func (r *Ring) GetCQEntry(minComplete uint32) (CQEntry, error) {
 	needs := r.cqNeedsEnter()
 	if needs {
-		if _, err := r.enter(0, minComplete, 0, false); err != nil {
+		// GETEVENTS flushes the overflowed completions and runs the deferred task work.
+		if _, err := r.enter(0, minComplete, IORING_ENTER_GETEVENTS, false); err != nil {
 			return CQEntry{}, err
 		}
 	}
@@ -192,6 +268,33 @@ func (r *Ring) GetCQEntry(minComplete uint32) (CQEntry, error) {
 	return CQEntry{}, syscall.EAGAIN
 }
 
+// PeekCQEntries copies the available completions to cqes, as many as it holds, and returns their
+// number. They stay in the completion queue until CQAdvance consumes them.
+//
+//	n := ring.PeekCQEntries(cqes)
+//	ring.CQAdvance(uint32(n))
+//	for _, cqe := range cqes[:n] {
+//		...
+//	}
+func (r *Ring) PeekCQEntries(cqes []CQEntry) int {
+	head := *r.cq.head
+	ready := atomic.LoadUint32(r.cq.tail) - head
+	if uint32(len(cqes)) < ready {
+		ready = uint32(len(cqes))
+	}
+	for i := uint32(0); i < ready; i++ {
+		cqes[i] = r.cq.cqes.get((head + i) & *r.cq.ringmask)
+	}
+	return int(ready)
+}
+
+// CQAdvance consumes n completions, the kernel may then post new ones in their place.
+func (r *Ring) CQAdvance(n uint32) {
+	if n > 0 {
+		atomic.StoreUint32(r.cq.head, *r.cq.head+n)
+	}
+}
+
 func (r *Ring) enter(submitted, minComplete, flags uint32, raw bool) (uint32, error) {
 	var (
 		r1    uintptr
@@ -219,13 +322,14 @@ func (r *Ring) peekCQEntry() (CQEntry, bool) {
 	return CQEntry{}, false
 }
 
+// cqNeedsEnter reports whether completions may only be posted by entering the ring with
+// IORING_ENTER_GETEVENTS: overflowed ones, those of task work pending with IORING_SETUP_TASKRUN_FLAG,
+// and all of them with IORING_SETUP_DEFER_TASKRUN.
 func (r *Ring) cqNeedsEnter() bool {
-	if r.cq.flags != nil {
-		if atomic.LoadUint32(r.cq.flags)&IORING_SQ_CQ_OVERFLOW > 0 {
-			return true
-		}
+	if atomic.LoadUint32(r.sq.flags)&(IORING_SQ_CQ_OVERFLOW|IORING_SQ_TASKRUN) > 0 {
+		return true
 	}
-	return r.params.Flags&IORING_SETUP_IOPOLL > 0
+	return r.params.Flags&(IORING_SETUP_IOPOLL|IORING_SETUP_DEFER_TASKRUN) > 0
 }
 
 func (r *Ring) sqNeedsEnter(submitted uint32, flags *uint32) bool {
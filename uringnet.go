@@ -5,16 +5,20 @@ package uringnet
 
 import (
 	"crypto/tls"
-	"fmt"
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/logging"
 	socket "github.com/y001j/uringnet/sockets"
 	"github.com/y001j/uringnet/uring"
 	"golang.org/x/sys/unix"
 	"log"
+	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
 type URingNet struct {
@@ -31,7 +35,8 @@ type URingNet struct {
 	Fd                atomic.Uintptr
 	//TLSNextProto      map[string]func(*URingNet, *tls.Conn, Handler)
 	//ConnState         func(net.Conn, ConnState)
-	ErrorLog *log.Logger
+	ErrorLog *log.Logger    // logs the errors of the ring when Logger is nil
+	Logger   logging.Logger // logs the events of the ring, logging.Default is used when both are nil
 
 	disableKeepAlives int32 // accessed atomically.
 	inShutdown        int32
@@ -43,11 +48,41 @@ type URingNet struct {
 	userDataMap       map[uint64]*UserData
 	ReadBuffer        []byte
 	WriteBuffer       []byte
+	ticker            bool        // OnTick fires, see SocketOptions.Ticker
+	cpus              unix.CPUSet // CPUs the thread of the ring is pinned to, none if empty
+	caps              *Capabilities
+	multishotAccept   bool            // one accept completes for every connection
+	fixedListener     bool            // the listener is the registered file 0
+	providedBuffers   bool            // the kernel has the Autobuffer, Run can read into it
+	bufRing           *uring.BufRing  // ring of the Autobuffer, nil if they are provided by submissions
+	disabled          bool            // the ring waits for its thread to enable it, see EngineOptions.SingleIssuer
+	overflowing       bool            // completions overflowed the completion queue
+	cqDropped         uint32          // completions dropped by the kernel so far
+	cqes              []uring.CQEntry // completions of the batch processed by the run loop
+	batching          bool            // the run loop processes a batch, submit defers to the loop
+	wait              WaitStrategy
+	spinTime          time.Duration    // polling time of WaitAdaptive
+	waitTimeout       *unix.Timespec   // bound of the blocking waits, none if nil
+	backlog           []*uring.SQEntry // entries waiting for room in the submission queue
 
 	Autobuffer [][bufLength]byte // it is just prepared for auto buffer of io_uring
 
 	ringloop *Ringloop
 
+	ringIndex  uint16 // index of the ring in the loop, it is also the buffer group id
+	autoBuffer bool   // reads use the kernel buffers of the ring
+
+	dgram *datagramReader // buffers of the pending receive of a ring listening on a UDP address
+
+	metrics ringMetrics
+	conns   map[int32]*conn // connections of the ring by fd, only used on the ring goroutine
+
+	wakeFd      int32   // eventfd used to wake the ring up, accessed atomically.
+	wakePending int32   // the eventfd has been written since the ring last woke up, accessed atomically.
+	wakeBuf     [8]byte // the counter read from wakeFd
+	taskMu      sync.Mutex
+	tasks       []func() // tasks waiting to run on the ring goroutine
+
 	mu sync.Mutex
 	//listeners map[*net.Listener]struct{}
 	//activeConn map[*conn]struct{} // 活跃连接
@@ -72,6 +107,8 @@ const (
 	PrepareWriter                      // 2. network write is completed
 	closed                             // 3. the socket is closed.
 	provideBuffer                      // 4. buffer has been created.
+	wakeup                             // 5. the ring has been woken up to run triggered tasks.
+	completion                         // 6. an operation issued by Submit is completed.
 )
 
 type UserData struct {
@@ -94,6 +131,11 @@ type UserData struct {
 	ClientSock *syscall.RawSockaddrAny
 	socklen    *uint32
 
+	// for operations issued by Submit
+	callback func(res int32, flags uint32)
+
+	start int64 // monotonic time of the submission, 0 if its latency is not observed
+
 	//Bytebuffer bytes.Buffer
 
 	//r0 interface{}
@@ -121,22 +163,13 @@ type request struct {
 var increase uint64 = 1
 
 func makeUserData(state UserdataState) *UserData {
-	defer func() {
-		err := recover() // 内置函数，可以捕获异常
-		if err != nil {
-			fmt.Println("err:", err)
-			fmt.Println("发生异常............")
-		}
-	}()
-
 	userData := new(UserData)
 	//userData := &UserData{
 	//	//ringNet: ringNet,
 	//	state: uint32(state),
 	//}
 	userData.state = uint32(state)
-	userData.id = increase
-	increase++
+	userData.id = atomic.AddUint64(&increase, 1)
 
 	return userData
 }
@@ -144,8 +177,11 @@ func makeUserData(state UserdataState) *UserData {
 // SetUring creates an IO_Uring instance
 func (ringNet *URingNet) SetUring(size uint, params *uring.IOUringParams) (ring *uring.Ring, err error) {
 	thering, err := uring.Setup(size, params)
+	if err != nil {
+		return nil, err
+	}
 	ringNet.ring = *thering
-	return thering, err
+	return thering, nil
 }
 
 var paraFlags uint32
@@ -155,90 +191,93 @@ var paraFlags uint32
 func (ringNet *URingNet) Run2(ringing uint16) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
+	ringNet.pin()
+	ringNet.enable()
+	ringNet.ringIndex, ringNet.autoBuffer = ringing, false
+	ringNet.startWakeup()
 	ringNet.Handler.OnBoot(ringNet)
+	if ringNet.ticker {
+		ringNet.tick()
+	}
 	//var connect_num uint32 = 0
 	for {
-		cqe, err := ringNet.ring.GetCQEntry(1)
-
-		//defer ringnet.ring.Close()
-		// have accepted
-		//theFd := ringnet.Fd.Load()
-		//if theFd != 0 {
-		//	sqe := ringnet.ring.GetSQEntry()
-		//	ringnet.read(int32(theFd), sqe, ringindex)
-		//	ringnet.Fd.Store(0)
-		//}
-
+		ringNet.checkOverflow()
+		n, err := ringNet.reap()
 		if err != nil {
-			if err == unix.EAGAIN {
-				//log.Println("Completion queue is empty!")
-				continue
-			}
-			//log.Println("uring has fatal error! ", err)
+			ringNet.log(logging.LevelDebug, "waiting for completions failed", "err", err)
 			continue
 		}
 
-		data, suc := ringNet.userDataList.Load(cqe.UserData())
-
-		//data, suc := ringnet.userDataMap[cqe.UserData()]
-		if !suc {
-			//log.Println("Cannot find matched userdata!")
-			//ringnet.ring.Flush()
-			continue
-		}
+		ringNet.batching = true
+		for _, cqe := range ringNet.cqes[:n] {
+			data, suc := ringNet.userDataList.Load(cqe.UserData())
 
-		thedata := (data).(*UserData)
+			//data, suc := ringnet.userDataMap[cqe.UserData()]
+			if !suc {
+				//log.Println("Cannot find matched userdata!")
+				//ringnet.ring.Flush()
+				continue
+			}
 
-		//ioc := unix.Iovec{}
-		//ioc.SetLen(1)
-		switch thedata.state {
-		case uint32(provideBuffer):
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(accepted):
-			ringNet.Handler.OnOpen(thedata)
-			ringNet.EchoLoop()
-			Fd := cqe.Result()
-			//connect_num++
-			//log.Printf("URing Number: %d Client Conn %d: \n", ringindex, connect_num)
-			//log.Println("URing Number: ", ringindex, " Client Conn %d:", connect_num)
-
-			sqe := ringNet.ring.GetSQEntry()
-			//claim buffer for read
-			//buffer := make([]byte, 1024) //ringnet.BufferPool.Get().(*[]byte)
-			//temp := ringnet.BufferPool.Get()
-			//bb := temp.(*[]byte)
-			//ringNet.read2(Fd, sqe)
-			ringNet.recv(Fd, sqe, ringing)
-
-			//ringnet.read(Fd, sqe, ringindex)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-			//recycle the buffer
-			//ringnet.BufferPool.Put(thedata.buffer)
-			//delete(ringnet.userDataMap, thedata.id)
+			thedata := (data).(*UserData)
+			ringNet.observe(thedata)
 
-		case uint32(prepareReader):
-			if cqe.Result() <= 0 {
+			//ioc := unix.Iovec{}
+			//ioc.SetLen(1)
+			switch thedata.state {
+			case uint32(provideBuffer):
+				ringNet.userDataList.Delete(thedata.id)
 				continue
-			}
-			//fmt.Println(BytesToString(thedata.Buffer))
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			response(ringNet, thedata, ringing, 0)
-			continue
-		case uint32(PrepareWriter):
-			if cqe.Result() <= 0 {
+			case uint32(wakeup):
+				ringNet.userDataList.Delete(thedata.id)
+				ringNet.wakeUp()
 				continue
-			}
-			ringNet.Handler.OnWritten(*thedata)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(closed):
-			ringNet.Handler.OnClose(*thedata)
-			//delete(ringnet.userDataMap, thedata.id)
-			ringNet.userDataList.Delete(thedata.id)
+			case uint32(completion):
+				ringNet.userDataList.Delete(thedata.id)
+				ringNet.countTransfer(thedata.opcode, thedata.Fd, cqe.Result())
+				thedata.callback(cqe.Result(), cqe.Flags())
+				continue
+			case uint32(accepted):
+				ringNet.onAccept(thedata, cqe.Result(), cqe.Flags())
+				continue
+				//recycle the buffer
+				//ringnet.BufferPool.Put(thedata.buffer)
+				//delete(ringnet.userDataMap, thedata.id)
+
+			case uint32(prepareReader):
+				if cqe.Result() <= 0 {
+					// the peer has closed the connection or the read failed.
+					if cqe.Result() < 0 {
+						ringNet.log(logging.LevelDebug, "read failed", "fd", thedata.Fd, "err", unix.Errno(-cqe.Result()))
+					}
+					ringNet.closeConn(thedata)
+					ringNet.userDataList.Delete(thedata.id)
+					continue
+				}
+				ringNet.countIn(thedata.Fd, cqe.Result())
+				thedata.Buffer = ringNet.ReadBuffer[:cqe.Result()]
+				thedata.BufSize = cqe.Result()
+				ringNet.mirrorIn(thedata.Fd, thedata.Buffer)
+				//fmt.Println(BytesToString(thedata.Buffer))
+				//log.Println("the buffer:", BytesToString(thedata.Buffer))
+				response(ringNet, thedata, ringing, 0)
+				continue
+			case uint32(PrepareWriter):
+				if cqe.Result() <= 0 {
+					ringNet.userDataList.Delete(thedata.id)
+					continue
+				}
+				ringNet.countOut(thedata.Fd, cqe.Result())
+				ringNet.Handler.OnWritten(*thedata)
+				ringNet.userDataList.Delete(thedata.id)
+				continue
+			case uint32(closed):
+				//delete(ringnet.userDataMap, thedata.id)
+				ringNet.userDataList.Delete(thedata.id)
 
+			}
 		}
+		ringNet.batching = false
 	}
 }
 
@@ -246,97 +285,128 @@ func (ringNet *URingNet) Run2(ringing uint16) {
 func (ringNet *URingNet) Run(ringing uint16) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
+	ringNet.pin()
+	ringNet.enable()
+	ringNet.ringIndex, ringNet.autoBuffer = ringing, true
+	ringNet.startWakeup()
 	ringNet.Handler.OnBoot(ringNet)
+	if ringNet.ticker {
+		ringNet.tick()
+	}
 	//var connect_num uint32 = 0
 	for {
-		cqe, err := ringNet.ring.GetCQEntry(1)
-
-		//defer ringnet.ring.Close()
-		// have accepted
-		//theFd := ringnet.Fd.Load()
-		//if theFd != 0 {
-		//	sqe := ringnet.ring.GetSQEntry()
-		//	ringnet.read(int32(theFd), sqe, ringindex)
-		//	ringnet.Fd.Store(0)
-		//}
-
+		ringNet.checkOverflow()
+		n, err := ringNet.reap()
 		if err != nil {
-			if err == unix.EAGAIN {
-				//log.Println("Completion queue is empty!")
-				continue
-			}
-			//log.Println("uring has fatal error! ", err)
+			ringNet.log(logging.LevelDebug, "waiting for completions failed", "err", err)
 			continue
 		}
 
-		data, suc := ringNet.userDataList.Load(cqe.UserData())
-
-		//data, suc := ringnet.userDataMap[cqe.UserData()]
-		if !suc {
-			//log.Println("Cannot find matched userdata!")
-			//ringnet.ring.Flush()
-			continue
-		}
+		ringNet.batching = true
+		for _, cqe := range ringNet.cqes[:n] {
+			data, suc := ringNet.userDataList.Load(cqe.UserData())
 
-		thedata := (data).(*UserData)
+			//data, suc := ringnet.userDataMap[cqe.UserData()]
+			if !suc {
+				//log.Println("Cannot find matched userdata!")
+				//ringnet.ring.Flush()
+				continue
+			}
 
-		//ioc := unix.Iovec{}
-		//ioc.SetLen(1)
-		switch thedata.state {
-		case uint32(provideBuffer):
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(accepted):
-			ringNet.Handler.OnOpen(thedata)
-			ringNet.EchoLoop()
-			Fd := cqe.Result()
-			//connect_num++
-			//log.Printf("URing Number: %d Client Conn %d: \n", ringindex, connect_num)
-			//log.Println("URing Number: ", ringindex, " Client Conn %d:", connect_num)
-
-			sqe := ringNet.ring.GetSQEntry()
-			//claim buffer for read
-			//buffer := make([]byte, 1024) //ringnet.BufferPool.Get().(*[]byte)
-			//temp := ringnet.BufferPool.Get()
-			//bb := temp.(*[]byte)
-			ringNet.read2(Fd, sqe)
-
-			//ringnet.read(Fd, sqe, ringindex)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-			//recycle the buffer
-			//ringnet.BufferPool.Put(thedata.buffer)
-			//delete(ringnet.userDataMap, thedata.id)
+			thedata := (data).(*UserData)
+			ringNet.observe(thedata)
 
-		case uint32(prepareReader):
-			if cqe.Result() <= 0 {
+			//ioc := unix.Iovec{}
+			//ioc.SetLen(1)
+			switch thedata.state {
+			case uint32(provideBuffer):
+				ringNet.userDataList.Delete(thedata.id)
 				continue
-			}
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			offset := uint64(cqe.Flags() >> uring.IORING_CQE_BUFFER_SHIFT)
-			thedata.Buffer = ringNet.Autobuffer[offset][:]
-			thedata.BufSize = cqe.Result()
-			//fmt.Println(BytesToString(thedata.Buffer))
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			responseWithBuffer(ringNet, thedata, ringing, offset)
-			continue
-		case uint32(PrepareWriter):
-			if cqe.Result() <= 0 {
+			case uint32(wakeup):
+				ringNet.userDataList.Delete(thedata.id)
+				ringNet.wakeUp()
+				continue
+			case uint32(completion):
+				ringNet.userDataList.Delete(thedata.id)
+				ringNet.countTransfer(thedata.opcode, thedata.Fd, cqe.Result())
+				thedata.callback(cqe.Result(), cqe.Flags())
+				continue
+			case uint32(accepted):
+				ringNet.onAccept(thedata, cqe.Result(), cqe.Flags())
+				continue
+				//recycle the buffer
+				//ringnet.BufferPool.Put(thedata.buffer)
+				//delete(ringnet.userDataMap, thedata.id)
+
+			case uint32(prepareReader):
+				if cqe.Result() <= 0 {
+					if cqe.Flags()&uring.IORING_CQE_F_BUFFER != 0 {
+						ringNet.addBuffer(uint64(cqe.Flags()>>uring.IORING_CQE_BUFFER_SHIFT), ringing)
+					}
+					if cqe.Result() == -int32(unix.ENOBUFS) {
+						atomic.AddUint64(&ringNet.metrics.bufferExhausted, 1)
+						// all kernel buffers are in use, read again once some are restored.
+						ringNet.read(thedata.Fd, ringNet.getSQEntry(), ringing)
+					} else {
+						// the peer has closed the connection or the read failed.
+						if cqe.Result() < 0 {
+							ringNet.log(logging.LevelDebug, "read failed", "fd", thedata.Fd, "err", unix.Errno(-cqe.Result()))
+						}
+						ringNet.closeConn(thedata)
+					}
+					ringNet.userDataList.Delete(thedata.id)
+					continue
+				}
+				//log.Println("the buffer:", BytesToString(thedata.Buffer))
+				ringNet.countIn(thedata.Fd, cqe.Result())
+				offset := uint64(cqe.Flags() >> uring.IORING_CQE_BUFFER_SHIFT)
+				thedata.Buffer = ringNet.Autobuffer[offset][:]
+				thedata.BufSize = cqe.Result()
+				ringNet.mirrorIn(thedata.Fd, thedata.Buffer[:cqe.Result()])
+				//fmt.Println(BytesToString(thedata.Buffer))
+				//log.Println("the buffer:", BytesToString(thedata.Buffer))
+				responseWithBuffer(ringNet, thedata, ringing, offset)
+				continue
+			case uint32(PrepareWriter):
+				if cqe.Result() <= 0 {
+					ringNet.userDataList.Delete(thedata.id)
+					continue
+				}
+				ringNet.countOut(thedata.Fd, cqe.Result())
+				ringNet.Handler.OnWritten(*thedata)
+				ringNet.userDataList.Delete(thedata.id)
 				continue
+			case uint32(closed):
+				//delete(ringnet.userDataMap, thedata.id)
+				ringNet.userDataList.Delete(thedata.id)
 			}
-			ringNet.Handler.OnWritten(*thedata)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(closed):
-			ringNet.Handler.OnClose(*thedata)
-			//delete(ringnet.userDataMap, thedata.id)
-			ringNet.userDataList.Delete(thedata.id)
+		}
+		ringNet.batching = false
+	}
+}
+
+// closeRings closes the io_uring instances of rings which will not run, and their listening sockets.
+func closeRings(rings []*URingNet) {
+	closed := make(map[int]bool)
+	for _, ringNet := range rings {
+		if ringNet.bufRing != nil {
+			_ = ringNet.bufRing.Close()
+			ringNet.bufRing = nil
+		}
+		_ = ringNet.ring.Close()
+		if !closed[ringNet.SocketFd] {
+			closed[ringNet.SocketFd] = true
+			_ = unix.Close(ringNet.SocketFd)
 		}
 	}
 }
 
 func (ringNet *URingNet) ShutDown() {
 	ringNet.ring.Flush()
+	if ringNet.bufRing != nil {
+		_ = ringNet.bufRing.Close()
+		ringNet.bufRing = nil
+	}
 	ringNet.ring.Close()
 	ringNet.inShutdown = 1
 	ringNet.ReadBuffer = nil
@@ -353,47 +423,51 @@ func response(ringnet *URingNet, data *UserData, gid uint16, offset uint64) {
 	case Echo: // Echo: First write and then add another read event into SQEs.
 
 		//sqe2 := ringnet.ring.GetSQEntry()
-		sqe1 := ringnet.ring.GetSQEntry()
+		sqe1 := ringnet.getSQEntry()
 
 		//ringnet.write(data, sqe2)
 		//ringnet.write(data, sqe1)
 		ringnet.send(data, sqe1, gid)
 
-		sqe := ringnet.ring.GetSQEntry()
-
-		ringnet.recv(data.Fd, sqe, gid)
+		if !ringnet.throttle(data.Fd) {
+			sqe := ringnet.getSQEntry()
+			ringnet.recv(data.Fd, sqe, gid)
+		}
 		//fmt.Println("read is set for uring ", gid)
 
 	case Read:
-		sqe := ringnet.ring.GetSQEntry()
-		//ringnet.read2(data.Fd, sqe)
-		ringnet.recv(data.Fd, sqe, gid)
+		if !ringnet.throttle(data.Fd) {
+			sqe := ringnet.getSQEntry()
+			//ringnet.read2(data.Fd, sqe)
+			ringnet.recv(data.Fd, sqe, gid)
+		}
 	case Write:
-		sqe1 := ringnet.ring.GetSQEntry()
+		sqe1 := ringnet.getSQEntry()
 		//ringnet.write(data, sqe1)
 		ringnet.send(data, sqe1, gid)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		_, err := ringnet.submit(0)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringnet.log(logging.LevelError, "submit failed", "fd", data.Fd, "err", err)
 		}
 		//EchoAndClose type just send a write event into SQEs and then close the socket connection. the write and close event should be linked together.
 	case EchoAndClose:
-		sqe2 := ringnet.ring.GetSQEntry()
+		sqe2 := ringnet.getSQEntry()
 		// claim buffer for I/O write
 		//bw := ringnet.BufferPool.Get().(*[]byte)
 		//bw := make([]byte, 1024)
 		//sqe2.SetFlags(uring.IOSQE_IO_LINK)
 		//ringnet.write(data, sqe2)
 		ringnet.send(data, sqe2, gid)
-		sqe := ringnet.ring.GetSQEntry()
-		sqe.SetFlags(uring.IOSQE_IO_DRAIN)
+		// the close is linked to the send, it runs once the send is completed, even if it failed.
+		sqe2.SetFlags(uring.IOSQE_IO_HARDLINK)
+		sqe := ringnet.getSQEntry()
 		ringnet.close(data, sqe)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		_, err := ringnet.submit(0)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringnet.log(logging.LevelError, "submit failed", "fd", data.Fd, "err", err)
 		}
 	case Close:
-		sqe := ringnet.ring.GetSQEntry()
+		sqe := ringnet.getSQEntry()
 
 		ringnet.close(data, sqe)
 
@@ -415,37 +489,41 @@ func responseWithBuffer(ringnet *URingNet, data *UserData, gid uint16, offset ui
 	switch action {
 	case Echo: // Echo: First write and then add another read event into SQEs.
 
-		sqe1 := ringnet.ring.GetSQEntry()
+		sqe1 := ringnet.getSQEntry()
 		ringnet.write(data, sqe1)
 
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.read(data.Fd, sqe, gid)
+		if !ringnet.throttle(data.Fd) {
+			sqe := ringnet.getSQEntry()
+			ringnet.read(data.Fd, sqe, gid)
+		}
 		//fmt.Println("read is set for uring ", gid)
 
 	case Read:
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.read(data.Fd, sqe, gid)
+		if !ringnet.throttle(data.Fd) {
+			sqe := ringnet.getSQEntry()
+			ringnet.read(data.Fd, sqe, gid)
+		}
 	case Write:
-		sqe1 := ringnet.ring.GetSQEntry()
+		sqe1 := ringnet.getSQEntry()
 		ringnet.write(data, sqe1)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		_, err := ringnet.submit(0)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringnet.log(logging.LevelError, "submit failed", "fd", data.Fd, "err", err)
 		}
 		//EchoAndClose type just send a write event into SQEs and then close the socket connection. the write and close event should be linked together.
 	case EchoAndClose:
-		sqe2 := ringnet.ring.GetSQEntry()
+		sqe2 := ringnet.getSQEntry()
 		ringnet.write(data, sqe2)
-		sqe := ringnet.ring.GetSQEntry()
-		sqe.SetFlags(uring.IOSQE_IO_DRAIN)
+		// the close is linked to the write, it runs once the write is completed, even if it failed.
+		sqe2.SetFlags(uring.IOSQE_IO_HARDLINK)
+		sqe := ringnet.getSQEntry()
 		ringnet.close(data, sqe)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		_, err := ringnet.submit(0)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringnet.log(logging.LevelError, "submit failed", "fd", data.Fd, "err", err)
 		}
 	case Close:
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.addBuffer(offset, gid)
+		sqe := ringnet.getSQEntry()
 		ringnet.close(data, sqe)
 
 	}
@@ -456,21 +534,38 @@ func responseWithBuffer(ringnet *URingNet, data *UserData, gid uint16, offset ui
 	//delete(ringnet.userDataMap, data.id)
 }
 
+// close submits the close of the socket. OnClose fires right away, so that nothing
+// can be issued for the descriptor once the kernel may hand it out again.
 func (ringNet *URingNet) close(thedata *UserData, sqe *uring.SQEntry) {
 	data := makeUserData(closed)
 	data.Fd = thedata.Fd
+	data.start = monotonic()
+	atomic.AddUint64(&ringNet.metrics.closed, 1)
+	atomic.AddInt64(&ringNet.metrics.active, -1)
+	ringNet.log(logging.LevelDebug, "connection closed", "fd", thedata.Fd)
+	ringNet.unregister(thedata.Fd)
+	ringNet.Handler.OnClose(*data)
 	ringNet.userDataList.Store(data.id, data)
 	//ringnet.userDataMap[data.id] = data
 
 	sqe.SetUserData(data.id)
-	sqe.SetLen(1)
 	uring.Close(sqe, uintptr(thedata.Fd))
 	//return data
 }
 
+// closeConn closes the socket of thedata right away, OnClose fires before the close is submitted.
+func (ringNet *URingNet) closeConn(thedata *UserData) {
+	sqe := ringNet.getSQEntry()
+	ringNet.close(thedata, sqe)
+	_, _ = ringNet.submit(0)
+}
+
 func (ringNet *URingNet) write(thedata *UserData, sqe2 *uring.SQEntry) {
 	data1 := makeUserData(PrepareWriter)
 	data1.Fd = thedata.Fd
+	data1.start = monotonic()
+	// keep the buffer referenced until the write is completed.
+	data1.WriteBuf = thedata.WriteBuf
 	//thebuffer := make([]byte, 1024)
 	//thedata.buffer = thebuffer
 	//copy(thebuffer, thedata.buffer)
@@ -485,9 +580,10 @@ func (ringNet *URingNet) write(thedata *UserData, sqe2 *uring.SQEntry) {
 	//ringnet.ring.Submit(0, &paraFlags)
 }
 func (ringNet *URingNet) write2(Fd int32, buffer []byte) {
-	sqe2 := ringNet.ring.GetSQEntry()
+	sqe2 := ringNet.getSQEntry()
 	data1 := makeUserData(PrepareWriter)
 	data1.Fd = Fd
+	data1.start = monotonic()
 
 	//ringnet.userDataMap[data1.id] = data1
 	ringNet.userDataList.Store(data1.id, data1)
@@ -495,7 +591,7 @@ func (ringNet *URingNet) write2(Fd int32, buffer []byte) {
 	sqe2.SetUserData(data1.id)
 
 	uring.Write(sqe2, uintptr(data1.Fd), buffer)
-	ringNet.ring.Submit(0, &paraFlags)
+	ringNet.submit(0)
 
 }
 
@@ -526,7 +622,7 @@ func (ringNet *URingNet) read(Fd int32, sqe *uring.SQEntry, ringIndex uint16) {
 	ringNet.userDataList.Store(data2.id, data2)
 
 	//paraFlags = uring.IORING_SETUP_SQPOLL
-	ringNet.ring.Submit(0, &paraFlags)
+	ringNet.submit(0)
 }
 
 func (ringNet *URingNet) recv(Fd int32, sqe *uring.SQEntry, ringIndex uint16) {
@@ -537,12 +633,15 @@ func (ringNet *URingNet) recv(Fd int32, sqe *uring.SQEntry, ringIndex uint16) {
 	ringNet.userDataList.Store(data2.id, data2)
 	//paraFlags = uring.IORING_ENTER_SQ_WAKEUP
 	//}
-	ringNet.ring.Submit(0, &paraFlags)
+	ringNet.submit(0)
 }
 
 func (ringNet *URingNet) send(thedata *UserData, sqe *uring.SQEntry, ringIndex uint16) {
 	data2 := makeUserData(PrepareWriter)
 	data2.Fd = thedata.Fd
+	data2.start = monotonic()
+	// keep the buffer referenced until the send is completed.
+	data2.WriteBuf = thedata.WriteBuf
 	sqe.SetUserData(data2.id)
 	uring.Send(sqe, uintptr(data2.Fd), thedata.WriteBuf, unix.MSG_DONTWAIT|unix.MSG_ZEROCOPY)
 	ringNet.userDataList.Store(data2.id, data2)
@@ -561,7 +660,7 @@ func (ringNet *URingNet) read_multi(Fd int32, sqes []*uring.SQEntry, ringIndex u
 		ringNet.userDataList.Store(data2.id, data2)
 	}
 	//sqes的长度如何获取:
-	ringNet.ring.Submit(uint32(len(sqes)), &paraFlags)
+	ringNet.submit(uint32(len(sqes)))
 }
 
 // this function is used to read data from the network socket without auto buffer.
@@ -576,36 +675,50 @@ func (ringNet *URingNet) read2(Fd int32, sqe *uring.SQEntry) {
 
 	uring.Read(sqe, uintptr(Fd), ringNet.ReadBuffer)
 
-	ringNet.ring.Submit(0, &paraFlags)
+	ringNet.submit(0)
 }
 
 // New Creates a new uRingnNet which is used to
 func New(addr NetAddress, size uint, sqpoll bool, options socket.SocketOptions) (*URingNet, error) {
 	//1. set the socket
 	//var ringNet *URingNet
-	ringNet := &URingNet{}
+	caps, err := kernelCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	ringNet := &URingNet{caps: caps}
 	ringNet.userDataMap = make(map[uint64]*UserData)
-	ops := socket.SetOptions(string(addr.AddrType), options)
-	switch addr.AddrType {
-	case socket.Tcp, socket.Tcp4, socket.Tcp6:
-		ringNet.SocketFd, _, _ = socket.TCPSocket(string(addr.AddrType), addr.Address, true, ops...) //ListenTCPSocket(addr)
-	case socket.Udp, socket.Udp4, socket.Udp6:
-		ringNet.SocketFd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, true, ops...)
-	case socket.Unix:
-		ringNet.SocketFd, _, _ = socket.UnixSocket(string(addr.AddrType), addr.Address, true, ops...)
-
-	default:
+	sockfds, err := listenMany(addr, 1, options)
+	switch {
+	case err == errors.ErrUnsupportedProtocol:
+		// a ring without listener, which only issues its own operations.
 		ringNet.SocketFd = -1
+	case err != nil:
+		logging.Default().Log(logging.LevelError, "socket setup failed", "network", addr.AddrType, "addr", addr.Address, "err", err)
+		return nil, err
+	default:
+		ringNet.SocketFd = sockfds[0]
+		logging.Default().Log(logging.LevelDebug, "socket set up", "network", addr.AddrType, "addr", addr.Address, "fd", ringNet.SocketFd)
 	}
+	ringNet.applyOptions(options, EngineOptions{}, 0, 1)
 	ringNet.Addr = addr.Address
 	ringNet.Type = addr.AddrType
 
 	//ringNet.userDataList = make(sync.Map, 1024)
 	//Create the io_uring instance
+	sqpoll = sqpoll && sqPollUsable(caps)
+	flags := setupFlags(EngineOptions{}, caps, sqpoll)
+	params := &uring.IOUringParams{Flags: flags}
 	if sqpoll {
-		ringNet.SetUring(size, &uring.IOUringParams{Flags: uring.IORING_SETUP_SQPOLL | uring.IORING_SETUP_SQ_AFF, SQThreadCPU: 1})
-	} else {
-		ringNet.SetUring(size, nil)
+		sqPoll(params, EngineOptions{}, 0, 0)
+	}
+	_, err = ringNet.SetUring(size, params)
+	ringNet.disabled = flags&uring.IORING_SETUP_R_DISABLED != 0
+	if err != nil {
+		if ringNet.SocketFd >= 0 {
+			_ = unix.Close(ringNet.SocketFd)
+		}
+		return nil, err
 	}
 	return ringNet, nil
 }
@@ -620,42 +733,127 @@ func New(addr NetAddress, size uint, sqpoll bool, options socket.SocketOptions)
 //	@return *[]URingNet
 //	@return error
 func NewMany(addr NetAddress, size uint, sqpoll bool, num int, options socket.SocketOptions, handler EventHandler) ([]*URingNet, error) {
+	return NewManyWithOptions(addr, size, sqpoll, num, options, EngineOptions{}, handler)
+}
+
+// NewManyWithOptions creates num rings like NewMany, their io_uring instances and run loops being
+// tuned by engine. The SQ threads of sqpoll are tuned by engine.SQThreadIdle, SQThreadCPUs and
+// SharedSQThread.
+func NewManyWithOptions(addr NetAddress, size uint, sqpoll bool, num int, options socket.SocketOptions, engine EngineOptions, handler EventHandler) ([]*URingNet, error) {
+	if options.NumEventLoop > 0 {
+		num = options.NumEventLoop
+	}
+	if num <= 0 {
+		num = runtime.NumCPU()
+	}
+	caps, err := kernelCapabilities()
+	if err != nil {
+		return nil, err
+	}
 	//1. set the socket
-	var sockfd int
-	ops := socket.SetOptions(string(addr.AddrType), options)
-	switch addr.AddrType {
-	case socket.Tcp, socket.Tcp4, socket.Tcp6:
-		sockfd, _, _ = socket.TCPSocket(string(addr.AddrType), addr.Address, true, ops...) //ListenTCPSocket(addr)
-	case socket.Udp, socket.Udp4, socket.Udp6:
-		sockfd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, true, ops...)
-	case socket.Unix:
-		sockfd, _, _ = socket.UnixSocket(string(addr.AddrType), addr.Address, true, ops...)
-	default:
-		sockfd = -1
+	sockfds, err := listenMany(addr, num, options)
+	if err != nil {
+		logging.Default().Log(logging.LevelError, "socket setup failed", "network", addr.AddrType, "addr", addr.Address, "err", err)
+		return nil, err
 	}
+	logging.Default().Log(logging.LevelDebug, "socket set up", "network", addr.AddrType, "addr", addr.Address, "fd", sockfds[0], "listeners", len(sockfds))
 	uringArray := make([]*URingNet, num) //*URingNet{}
+	sqpoll = sqpoll && sqPollUsable(caps)
+	flags := setupFlags(engine, caps, sqpoll)
 	//ringNet.userDataList = make(sync.Map, 1024)
 	//Create the io_uring instance
 	for i := 0; i < num; i++ {
-		uringArray[i] = &URingNet{}
+		uringArray[i] = &URingNet{caps: caps}
 		//uringArray[i].userDataMap = make(map[uint64]*UserData)
-		uringArray[i].ReadBuffer = make([]byte, 1024)
-		uringArray[i].WriteBuffer = make([]byte, 1024)
-		uringArray[i].SocketFd = sockfd
+		uringArray[i].applyOptions(options, engine, i, num)
+		uringArray[i].SocketFd = sockfds[i%len(sockfds)]
 		uringArray[i].Addr = addr.Address
 		uringArray[i].Type = addr.AddrType
 		uringArray[i].Handler = handler
+		uringArray[i].ringIndex = uint16(i)
 
+		params := &uring.IOUringParams{Flags: flags, CQEntries: uint32(engine.CQEntries), Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP}
 		if sqpoll {
-			uringArray[i].SetUring(size, &uring.IOUringParams{Flags: uring.IORING_SETUP_SQPOLL, Features: uring.IORING_FEAT_NODROP | uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_SQPOLL_NONFIXED}) //Features: uring.IORING_FEAT_FAST_POLL|uring.IORING_FEAT_NODROP})
-		} else {
-			uringArray[i].SetUring(size, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP})
+			var wq uintptr
+			if engine.SharedSQThread && i > 0 {
+				// the rings are polled by the SQ thread of the first one.
+				wq = uringArray[0].ring.Fd()
+			}
+			sqPoll(params, engine, i, wq)
+		}
+		_, err = uringArray[i].SetUring(size, params)
+		if err != nil {
+			logging.Default().Log(logging.LevelError, "ring setup failed", "ring", i, "err", err)
+			for _, ringNet := range uringArray[:i] {
+				_ = ringNet.ring.Close()
+			}
+			for _, fd := range sockfds {
+				_ = unix.Close(fd)
+			}
+			return nil, err
 		}
-		fmt.Println("Uring instance initiated!")
+		uringArray[i].disabled = flags&uring.IORING_SETUP_R_DISABLED != 0
+		logging.Default().Log(logging.LevelDebug, "ring set up", "ring", i, "entries", size)
 	}
 	return uringArray, nil
 }
 
+// listenMany sets up the listening sockets of num rings: one shared by all of them, or one per ring
+// with options.ListenerPerRing.
+func listenMany(addr NetAddress, num int, options socket.SocketOptions) ([]int, error) {
+	ops := socket.SetOptions(string(addr.AddrType), options)
+	listen := func(address string) (fd int, err error) {
+		switch addr.AddrType {
+		case socket.Tcp, socket.Tcp4, socket.Tcp6:
+			fd, _, err = socket.TCPSocket(string(addr.AddrType), address, true, ops...) //ListenTCPSocket(addr)
+		case socket.Udp, socket.Udp4, socket.Udp6:
+			fd, _, err = socket.UDPSocket(string(addr.AddrType), address, false, ops...)
+		case socket.Unix:
+			fd, _, err = socket.UnixSocket(string(addr.AddrType), address, true, ops...)
+		default:
+			err = errors.ErrUnsupportedProtocol
+		}
+		if err == nil && options.Backlog > 0 && !strings.HasPrefix(string(addr.AddrType), "udp") {
+			// the socket listens with the limit of the system, listening again changes the backlog.
+			if err = socket.SetBacklog(fd, options.Backlog); err != nil {
+				_ = unix.Close(fd)
+			}
+		}
+		return
+	}
+	fd, err := listen(addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	fds := []int{fd}
+	if !options.ListenerPerRing || addr.AddrType == socket.Unix || num < 2 {
+		return fds, nil
+	}
+	// the other listeners join the group of the first one, on the port it was given if the
+	// address asked for any.
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	bound := socket.SockaddrToTCPOrUnixAddr(sa).String()
+	for len(fds) < num && err == nil {
+		if fd, err = listen(bound); err == nil {
+			fds = append(fds, fd)
+		}
+	}
+	if err == nil && options.SteerToCPU {
+		err = socket.AttachReuseportCPU(fds[0], num)
+	}
+	if err != nil {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+		return nil, err
+	}
+	return fds, nil
+}
+
 type NetAddress struct {
 	AddrType socket.NetAddressType
 	Address  string
@@ -664,9 +862,15 @@ type NetAddress struct {
 // addBuffer  kernel buffer should be restored after using
 
 func (ringNet *URingNet) addBuffer(offset uint64, gid uint16) {
-	sqe := ringNet.ring.GetSQEntry()
+	if ringNet.bufRing != nil {
+		ringNet.bufRing.Add(unsafe.Pointer(&ringNet.Autobuffer[offset][0]), uint32(bufLength), uint16(offset))
+		ringNet.bufRing.Advance()
+		return
+	}
+	sqe := ringNet.getSQEntry()
 	uring.ProvideSingleBuf(sqe, &ringNet.Autobuffer[offset], 1, uint32(bufLength), gid, offset)
 	data := makeUserData(provideBuffer)
+	data.start = monotonic()
 	sqe.SetUserData(data.id)
 	ringNet.userDataList.Store(data.id, data)
 	//ringNet.ringloop.ringNet.userDataMap[data.id] = data
@@ -0,0 +1,294 @@
+//go:build linux
+
+package websocket
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/y001j/uringnet/http"
+	"github.com/y001j/uringnet/logging"
+)
+
+// closeTimeout is how long the server waits for the close frame of the peer after sending its own.
+const closeTimeout = 5 * time.Second
+
+// ErrClosed is returned when writing to a connection whose close frame has already been sent.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded WebSocket connection served by a ring.
+//
+// Its write methods are safe to call from any goroutine: frames are queued and sent by the ring
+// in the order they were written, without waiting for the peer.
+type Conn struct {
+	h           *ringHandler
+	fd          int32
+	remoteAddr  string
+	req         *http.Request
+	subprotocol string
+	ctx         interface{}
+
+	mu          sync.Mutex  // guards the fields below
+	out         []byte      // encoded frames waiting to be sent
+	flushQueued bool        // a flush has been triggered on the ring
+	closeSent   bool        // a close frame has been queued, nothing can be written afterwards
+	closeTimer  *time.Timer // shuts the connection down if the peer does not answer the close frame
+
+	// ring goroutine only
+	upgraded       bool
+	in             []byte      // received bytes which have not been parsed yet
+	msgOp          Opcode      // opcode of the fragmented message being received, OpContinuation if none
+	msg            []byte      // fragments of the message being received
+	closing        bool        // a close frame has been received or the protocol has been violated
+	closeErr       *CloseError // reported to OnClose
+	sending        bool        // a send is in flight
+	shutAfterFlush bool        // shut the connection down once out has been sent
+	shut           bool        // the socket has been shut down
+	ringClosed     bool        // the ring has submitted the close of fd
+}
+
+// Request returns the upgrade request of the connection.
+func (c *Conn) Request() *http.Request {
+	return c.req
+}
+
+// RemoteAddr returns the network address of the peer.
+func (c *Conn) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Context returns a user-defined context.
+func (c *Conn) Context() interface{} {
+	return c.ctx
+}
+
+// SetContext sets a user-defined context.
+func (c *Conn) SetContext(ctx interface{}) {
+	c.ctx = ctx
+}
+
+// WriteMessage sends data as a single message, op must be OpText or OpBinary.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if op != OpText && op != OpBinary {
+		return errBadOpcode
+	}
+	return c.write(op, data)
+}
+
+// Ping sends a ping frame, the peer answers with a pong carrying the same data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errBadControl
+	}
+	return c.write(OpPing, data)
+}
+
+// Close starts the closing handshake by sending a close frame with code and text.
+// The connection is closed once the peer answers, or after a timeout.
+func (c *Conn) Close(code int, text string) error {
+	if err := c.write(OpClose, closePayload(code, text)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closeTimer == nil {
+		c.closeTimer = time.AfterFunc(closeTimeout, func() {
+			_ = c.h.ring.Trigger(c.shutdown)
+		})
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// write queues a frame and triggers its sending on the ring.
+func (c *Conn) write(op Opcode, payload []byte) error {
+	queued, err := c.enqueue(op, payload)
+	if err != nil || !queued {
+		return err
+	}
+	return c.h.ring.Trigger(c.flush)
+}
+
+// enqueue appends a frame to the output, it reports whether a flush has to be triggered.
+func (c *Conn) enqueue(op Opcode, payload []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return false, ErrClosed
+	}
+	c.out = AppendFrame(c.out, Frame{Fin: true, Opcode: op, Payload: payload})
+	if op == OpClose {
+		c.closeSent = true
+	}
+	if c.flushQueued {
+		return false, nil
+	}
+	c.flushQueued = true
+	return true, nil
+}
+
+// flush sends the queued frames, it runs on the ring goroutine. Only one send is in flight at
+// a time, its completion flushes the frames queued meanwhile.
+func (c *Conn) flush() {
+	if c.ringClosed || c.sending {
+		return
+	}
+	c.mu.Lock()
+	buf := c.out
+	c.out, c.flushQueued = nil, false
+	c.mu.Unlock()
+	if len(buf) == 0 {
+		if c.shutAfterFlush {
+			c.shutdown()
+		}
+		return
+	}
+	c.sending = true
+	c.h.ring.SendAll(c.fd, buf, func(err error) {
+		if c.ringClosed {
+			return
+		}
+		c.sending = false
+		if err != nil {
+			c.shutdown()
+			return
+		}
+		c.flush()
+	})
+}
+
+// shutdown ends the connection without waiting for the close frame of the peer, see
+// URingNet.ShutdownConn.
+func (c *Conn) shutdown() {
+	if c.ringClosed || c.shut {
+		return
+	}
+	c.shut = true
+	_ = c.h.ring.ShutdownConn(c.fd)
+}
+
+// readFrames handles the complete frames buffered in c.in.
+func (c *Conn) readFrames() {
+	off := 0
+	for !c.closing && off < len(c.in) {
+		f, n, err := ParseFrame(c.in[off:], c.h.srv.maxMessageBytes())
+		if err == ErrNeedMore {
+			break
+		}
+		if err != nil {
+			c.fail(err.(*CloseError))
+			break
+		}
+		off += n
+		if !f.Masked {
+			c.fail(errUnmasked)
+			break
+		}
+		c.handleFrame(f)
+	}
+	if c.closing {
+		c.in, c.msg = nil, nil
+		return
+	}
+	c.in = c.in[:copy(c.in, c.in[off:])]
+}
+
+func (c *Conn) handleFrame(f Frame) {
+	switch f.Opcode {
+	case OpPing:
+		_, _ = c.enqueue(OpPong, f.Payload)
+	case OpPong:
+	case OpClose:
+		ce, err := parseClosePayload(f.Payload)
+		if err != nil {
+			c.fail(err.(*CloseError))
+			return
+		}
+		// echo the status code and close the connection once it is sent (RFC 6455, section 5.5.1).
+		c.closing, c.closeErr = true, ce
+		_, _ = c.enqueue(OpClose, closePayload(ce.Code, ""))
+		c.shutAfterFlush = true
+	case OpContinuation:
+		if c.msgOp == OpContinuation {
+			c.fail(errBadContinuation)
+			return
+		}
+		if int64(len(c.msg)+len(f.Payload)) > c.h.srv.maxMessageBytes() {
+			c.fail(errMessageTooBig)
+			return
+		}
+		c.msg = append(c.msg, f.Payload...)
+		if f.Fin {
+			op := c.msgOp
+			c.msgOp = OpContinuation
+			c.deliver(op, c.msg)
+			c.msg = c.msg[:0]
+		}
+	default:
+		if c.msgOp != OpContinuation {
+			c.fail(errBadFragment)
+			return
+		}
+		if f.Fin {
+			c.deliver(f.Opcode, f.Payload)
+			return
+		}
+		c.msgOp = f.Opcode
+		c.msg = append(c.msg[:0], f.Payload...)
+	}
+}
+
+// deliver passes a complete message to the handler.
+func (c *Conn) deliver(op Opcode, msg []byte) {
+	if op == OpText && !utf8.Valid(msg) {
+		c.fail(errInvalidUTF8)
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			c.h.ring.ConnLogger(c.fd).Log(logging.LevelError, "panic serving a message", "remote", c.remoteAddr,
+				"err", err, "stack", string(buf))
+			c.fail(&CloseError{CloseInternalServerErr, "internal server error"})
+		}
+	}()
+	c.h.srv.Handler.OnMessage(c, op, msg)
+}
+
+// fail closes the connection because of err, the close frame is sent without waiting for the peer's.
+func (c *Conn) fail(err *CloseError) {
+	if c.closing {
+		return
+	}
+	c.closing, c.closeErr = true, err
+	_, _ = c.enqueue(OpClose, closePayload(err.Code, err.Text))
+	c.shutAfterFlush = true
+}
+
+// onClose runs on the ring goroutine when the ring closes the socket.
+func (c *Conn) onClose() {
+	c.ringClosed = true
+	c.mu.Lock()
+	c.closeSent = true
+	c.out = nil
+	if c.closeTimer != nil {
+		c.closeTimer.Stop()
+	}
+	c.mu.Unlock()
+	if !c.upgraded {
+		return
+	}
+	err := c.closeErr
+	if err == nil {
+		err = errAbnormalClosure
+	}
+	c.h.srv.Handler.OnClose(c, err)
+}
@@ -0,0 +1,237 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Opcode is the type of a frame (RFC 6455, section 5.2).
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// IsControl reports whether op is a control opcode, control frames may be interleaved with fragmented messages.
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+func (op Opcode) valid() bool {
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	}
+	return false
+}
+
+// Close codes defined in RFC 6455, section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+// CloseError describes why a connection has been closed, Code is CloseAbnormalClosure
+// when the connection was lost without a close frame.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if e.Text != "" {
+		s += " " + e.Text
+	}
+	return s
+}
+
+// maxControlPayload is the maximum payload length of a control frame.
+const maxControlPayload = 125
+
+var (
+	// ErrNeedMore means the buffer does not hold a complete frame yet.
+	ErrNeedMore = errors.New("websocket: need more data")
+
+	errReservedBits    = &CloseError{CloseProtocolError, "reserved bits set"}
+	errBadOpcode       = &CloseError{CloseProtocolError, "unknown opcode"}
+	errBadControl      = &CloseError{CloseProtocolError, "fragmented or oversized control frame"}
+	errBadLength       = &CloseError{CloseProtocolError, "invalid payload length"}
+	errUnmasked        = &CloseError{CloseProtocolError, "client frame is not masked"}
+	errBadContinuation = &CloseError{CloseProtocolError, "unexpected continuation frame"}
+	errBadFragment     = &CloseError{CloseProtocolError, "new message inside a fragmented message"}
+	errBadClosePayload = &CloseError{CloseProtocolError, "invalid close frame payload"}
+	errInvalidUTF8     = &CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 text"}
+	errMessageTooBig   = &CloseError{CloseMessageTooBig, "message too big"}
+	errAbnormalClosure = &CloseError{Code: CloseAbnormalClosure}
+)
+
+// Frame is a single WebSocket frame.
+type Frame struct {
+	Fin     bool
+	Opcode  Opcode
+	Masked  bool
+	Mask    [4]byte
+	Payload []byte
+}
+
+// ParseFrame parses the frame at the beginning of buf and returns it with its length in buf.
+// The payload of a masked frame is unmasked in place, and Payload refers to buf.
+//
+// It returns ErrNeedMore if buf does not hold the complete frame yet, or a *CloseError
+// if the frame violates the protocol or its payload is longer than maxPayload.
+func ParseFrame(buf []byte, maxPayload int64) (Frame, int, error) {
+	var f Frame
+	if len(buf) < 2 {
+		return f, 0, ErrNeedMore
+	}
+	b0, b1 := buf[0], buf[1]
+	f.Fin = b0&0x80 != 0
+	f.Opcode = Opcode(b0 & 0x0f)
+	f.Masked = b1&0x80 != 0
+	if b0&0x70 != 0 {
+		return f, 0, errReservedBits
+	}
+	if !f.Opcode.valid() {
+		return f, 0, errBadOpcode
+	}
+
+	n := 2
+	length := int64(b1 & 0x7f)
+	switch length {
+	case 126:
+		if len(buf) < n+2 {
+			return f, 0, ErrNeedMore
+		}
+		length = int64(binary.BigEndian.Uint16(buf[n:]))
+		n += 2
+	case 127:
+		if len(buf) < n+8 {
+			return f, 0, ErrNeedMore
+		}
+		l := binary.BigEndian.Uint64(buf[n:])
+		if l>>63 != 0 {
+			return f, 0, errBadLength
+		}
+		length = int64(l)
+		n += 8
+	}
+	if f.Opcode.IsControl() && (!f.Fin || length > maxControlPayload) {
+		return f, 0, errBadControl
+	}
+	if length > maxPayload {
+		return f, 0, errMessageTooBig
+	}
+	if f.Masked {
+		if len(buf) < n+4 {
+			return f, 0, ErrNeedMore
+		}
+		copy(f.Mask[:], buf[n:])
+		n += 4
+	}
+	if int64(len(buf)-n) < length {
+		return f, 0, ErrNeedMore
+	}
+	f.Payload = buf[n : n+int(length)]
+	if f.Masked {
+		maskBytes(f.Mask, f.Payload)
+	}
+	return f, n + int(length), nil
+}
+
+// AppendFrame appends the encoding of f to dst. The payload is masked with f.Mask if f.Masked is set,
+// as clients must do, f.Payload itself is left untouched.
+func AppendFrame(dst []byte, f Frame) []byte {
+	b0 := byte(f.Opcode)
+	if f.Fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if f.Masked {
+		b1 = 0x80
+	}
+	length := len(f.Payload)
+	switch {
+	case length <= 125:
+		dst = append(dst, b0, b1|byte(length))
+	case length <= 0xffff:
+		dst = append(dst, b0, b1|126)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(length))
+	default:
+		dst = append(dst, b0, b1|127)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(length))
+	}
+	if !f.Masked {
+		return append(dst, f.Payload...)
+	}
+	dst = append(dst, f.Mask[:]...)
+	start := len(dst)
+	dst = append(dst, f.Payload...)
+	maskBytes(f.Mask, dst[start:])
+	return dst
+}
+
+// maskBytes applies the masking key to the payload b, masking and unmasking are the same operation.
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// closePayload encodes the payload of a close frame, a code of CloseNoStatusReceived is sent as an empty payload.
+func closePayload(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	if len(text) > maxControlPayload-2 {
+		text = text[:maxControlPayload-2]
+	}
+	buf := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	return append(buf, text...)
+}
+
+// parseClosePayload decodes the payload of a close frame received from the peer.
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	switch len(payload) {
+	case 0:
+		return &CloseError{Code: CloseNoStatusReceived}, nil
+	case 1:
+		return nil, errBadClosePayload
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, errBadClosePayload
+	}
+	if !utf8.Valid(payload[2:]) {
+		return nil, errInvalidUTF8
+	}
+	return &CloseError{Code: code, Text: string(payload[2:])}, nil
+}
+
+// validCloseCode reports whether code may be sent in a close frame (RFC 6455, section 7.4).
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 5, 125, 126, 65535, 65536} {
+		payload := bytes.Repeat([]byte("x"), size)
+		f := Frame{Fin: true, Opcode: OpBinary, Masked: true, Mask: [4]byte{1, 2, 3, 4}, Payload: payload}
+		buf := AppendFrame(nil, f)
+		if size > 0 && bytes.Contains(buf, payload[:1]) {
+			t.Fatalf("size %d: payload is not masked", size)
+		}
+		for i := 0; i < len(buf); i += 1 + len(buf)/8 {
+			if _, _, err := ParseFrame(append([]byte(nil), buf[:i]...), 1<<20); err != ErrNeedMore {
+				t.Fatalf("size %d, prefix %d: expect ErrNeedMore, got %v", size, i, err)
+			}
+		}
+		got, n, err := ParseFrame(buf, 1<<20)
+		if err != nil || n != len(buf) {
+			t.Fatalf("size %d: unexpected length %d or error %v", size, n, err)
+		}
+		if !got.Fin || got.Opcode != OpBinary || !got.Masked || !bytes.Equal(got.Payload, payload) {
+			t.Fatalf("size %d: unexpected frame %+v", size, got)
+		}
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	cases := map[string]struct {
+		buf []byte
+		err error
+	}{
+		"reserved bits":     {[]byte{0xC1, 0x80, 0, 0, 0, 0}, errReservedBits},
+		"unknown opcode":    {[]byte{0x83, 0x80, 0, 0, 0, 0}, errBadOpcode},
+		"fragmented ping":   {[]byte{0x09, 0x80, 0, 0, 0, 0}, errBadControl},
+		"oversized close":   {[]byte{0x88, 0xFE, 0, 126}, errBadControl},
+		"64-bit length msb": {[]byte{0x82, 0xFF, 0x80, 0, 0, 0, 0, 0, 0, 0}, errBadLength},
+		"too big":           {[]byte{0x82, 0xFE, 0x10, 0}, errMessageTooBig},
+	}
+	for name, c := range cases {
+		if _, _, err := ParseFrame(c.buf, 1024); err != c.err {
+			t.Errorf("%s: expect %v, got %v", name, c.err, err)
+		}
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	ce, err := parseClosePayload(closePayload(CloseGoingAway, "bye"))
+	if err != nil || ce.Code != CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("unexpected close %v %v", ce, err)
+	}
+	if ce, _ = parseClosePayload(nil); ce.Code != CloseNoStatusReceived {
+		t.Fatalf("expect CloseNoStatusReceived, got %d", ce.Code)
+	}
+	for _, payload := range [][]byte{{3}, {0x03, 0xED}, {0x03, 0xE8, 0xff}} {
+		if _, err = parseClosePayload(payload); err == nil {
+			t.Errorf("%v: expect an error", payload)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+//go:build linux
+
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/y001j/uringnet/http"
+)
+
+// keyGUID is concatenated with the key of the client to compute Sec-WebSocket-Accept.
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HandshakeError describes why an upgrade request has been refused, it is answered with Status.
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+var (
+	errNotGet          = &HandshakeError{405, "upgrade request method is not GET"}
+	errNotUpgrade      = &HandshakeError{400, "'upgrade' token not found in 'Connection' header"}
+	errNotWebSocket    = &HandshakeError{400, "'websocket' token not found in 'Upgrade' header"}
+	errBadVersion      = &HandshakeError{426, "unsupported version: 13 not found in 'Sec-Websocket-Version' header"}
+	errBadKey          = &HandshakeError{400, "'Sec-WebSocket-Key' header is missing or invalid"}
+	errBadOrigin       = &HandshakeError{403, "request origin not allowed"}
+	errNotFound        = &HandshakeError{404, "not found"}
+	errMalformedHeader = &HandshakeError{400, "malformed upgrade request"}
+)
+
+// AcceptKey computes the Sec-WebSocket-Accept value answering the Sec-WebSocket-Key challengeKey.
+func AcceptKey(challengeKey string) string {
+	h := sha1.New()
+	h.Write([]byte(challengeKey))
+	h.Write([]byte(keyGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkUpgrade validates the upgrade request req (RFC 6455, section 4.2.1) and returns the
+// response switching the protocols, with the subprotocol selected among the ones of srv.
+func (srv *Server) checkUpgrade(req *http.Request) ([]byte, string, error) {
+	if req.Method != "GET" {
+		return nil, "", errNotGet
+	}
+	if !hasToken(req.Header, "Connection", "upgrade") {
+		return nil, "", errNotUpgrade
+	}
+	if !hasToken(req.Header, "Upgrade", "websocket") {
+		return nil, "", errNotWebSocket
+	}
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		return nil, "", errBadVersion
+	}
+	challengeKey := strings.TrimSpace(req.Header.Get("Sec-Websocket-Key"))
+	if key, err := base64.StdEncoding.DecodeString(challengeKey); err != nil || len(key) != 16 {
+		return nil, "", errBadKey
+	}
+	if srv.Path != "" && req.URL.Path != srv.Path {
+		return nil, "", errNotFound
+	}
+	checkOrigin := srv.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, "", errBadOrigin
+	}
+
+	subprotocol := srv.selectSubprotocol(req)
+	out := make([]byte, 0, 160)
+	out = append(out, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
+	out = append(out, AcceptKey(challengeKey)...)
+	if subprotocol != "" {
+		out = append(out, "\r\nSec-WebSocket-Protocol: "...)
+		out = append(out, subprotocol...)
+	}
+	out = append(out, "\r\n\r\n"...)
+	return out, subprotocol, nil
+}
+
+// selectSubprotocol returns the first subprotocol of the server asked by the client.
+func (srv *Server) selectSubprotocol(req *http.Request) string {
+	for _, p := range srv.Subprotocols {
+		if hasToken(req.Header, "Sec-Websocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// checkSameOrigin accepts requests without an Origin header or whose origin host is the Host of the request.
+func checkSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// appendRefusal appends the response refusing an upgrade because of err.
+func appendRefusal(out []byte, err error) []byte {
+	he, ok := err.(*HandshakeError)
+	if !ok {
+		he = errMalformedHeader
+	}
+	body := he.Reason + "\n"
+	out = append(out, "HTTP/1.1 "...)
+	out = strconv.AppendInt(out, int64(he.Status), 10)
+	out = append(out, ' ')
+	out = append(out, nethttp.StatusText(he.Status)...)
+	if he == errBadVersion {
+		out = append(out, "\r\nSec-WebSocket-Version: 13"...)
+	}
+	out = append(out, "\r\nConnection: close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: "...)
+	out = strconv.AppendInt(out, int64(len(body)), 10)
+	out = append(out, "\r\n\r\n"...)
+	return append(out, body...)
+}
+
+// hasToken reports whether the comma separated values of key contain token, case-insensitively.
+func hasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
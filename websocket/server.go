@@ -0,0 +1,201 @@
+//go:build linux
+
+// Package websocket implements a WebSocket server (RFC 6455) which runs natively on UringNet rings.
+//
+// The rings perform the HTTP upgrade handshake, decode the frames sent by the clients and
+// reassemble fragmented messages, which are delivered to a Handler. No goroutine is started
+// per connection:
+//
+//	srv := &websocket.Server{Addr: ":8080", Path: "/live", Handler: handler}
+//	log.Fatal(srv.ListenAndServe())
+//
+// Handler callbacks run on the goroutine of the ring that owns the connection, so they should not
+// block. Messages can be written from any goroutine, e.g. to push telemetry to every client.
+package websocket
+
+import (
+	"errors"
+	"log"
+	"runtime"
+
+	"github.com/y001j/uringnet"
+	"github.com/y001j/uringnet/http"
+	"github.com/y001j/uringnet/logging"
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+const (
+	// DefaultMaxMessageBytes is the maximum permitted size of a message received from a client.
+	DefaultMaxMessageBytes = 1 << 20 // 1 MB
+
+	// DefaultMaxHeaderBytes is the maximum permitted size of the headers of an upgrade request.
+	DefaultMaxHeaderBytes = 16 << 10 // 16 KB
+
+	ringSize    = 4096
+	ringBuffers = 4096
+)
+
+// Handler responds to the events of WebSocket connections.
+type Handler interface {
+	// OnOpen fires once the handshake of c has completed.
+	OnOpen(c *Conn)
+
+	// OnMessage fires when a complete text or binary message has been received, op is OpText or OpBinary.
+	// msg is only valid until OnMessage returns.
+	OnMessage(c *Conn, op Opcode, msg []byte)
+
+	// OnClose fires when the connection is closed, err tells the close code sent by the peer,
+	// or the protocol violation which made the server close it.
+	OnClose(c *Conn, err *CloseError)
+}
+
+// A Server defines parameters for running a WebSocket server on UringNet.
+type Server struct {
+	// Addr specifies the TCP address for the server to listen on, ":http" if empty.
+	Addr string
+
+	// Path is the request path accepted for upgrades, any path is accepted if empty.
+	Path string
+
+	// Handler receives the events of the connections.
+	Handler Handler
+
+	// CheckOrigin returns true if the Origin header of the upgrade request is acceptable.
+	// If nil, requests are only accepted if their origin host is the Host of the request.
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols lists the subprotocols supported by the server in order of preference.
+	Subprotocols []string
+
+	// MaxHeaderBytes limits the size of the upgrade request, DefaultMaxHeaderBytes is used if it is zero.
+	MaxHeaderBytes int
+
+	// MaxMessageBytes limits the size of a message once reassembled,
+	// DefaultMaxMessageBytes is used if it is zero.
+	MaxMessageBytes int64
+
+	// Rings is the number of io_uring instances serving connections, runtime.NumCPU() if zero.
+	Rings int
+
+	// SQPoll lets a kernel thread poll the submission queue of every ring.
+	SQPoll bool
+
+	// ErrorLog specifies an optional logger for the errors of the rings and for panics in handlers, it is
+	// used when Logger is nil.
+	ErrorLog *log.Logger
+
+	// Logger logs the events of the rings and the panics in handlers, with the fields of the connection.
+	// ErrorLog is used when it is nil, logging.Default when both are.
+	Logger logging.Logger
+}
+
+// ListenAndServe listens on srv.Addr and serves WebSocket connections on the rings. It only returns on setup errors.
+func (srv *Server) ListenAndServe() error {
+	if srv.Handler == nil {
+		return errors.New("websocket: nil Handler")
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	rings := srv.Rings
+	if rings <= 0 {
+		rings = runtime.NumCPU()
+	}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp, Address: addr}, ringSize, srv.SQPoll, rings, options, nil)
+	if err != nil {
+		return err
+	}
+	for _, ringNet := range ringNets {
+		ringNet.Logger = srv.logger()
+		ringNet.Handler = &ringHandler{srv: srv, ring: ringNet, conns: make(map[int32]*Conn)}
+	}
+	loop, err := uringnet.NewLoops(ringNets, ringBuffers)
+	if err != nil {
+		return err
+	}
+	loop.RunMany2()
+	select {}
+}
+
+func (srv *Server) maxHeaderBytes() int {
+	if srv.MaxHeaderBytes > 0 {
+		return srv.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (srv *Server) maxMessageBytes() int64 {
+	if srv.MaxMessageBytes > 0 {
+		return srv.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+// logger returns Logger, or ErrorLog for errors, or the default logger.
+func (srv *Server) logger() logging.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	if srv.ErrorLog != nil {
+		return logging.NewStd(srv.ErrorLog, logging.LevelError)
+	}
+	return logging.Default()
+}
+
+// ringHandler serves the connections of one ring, its callbacks are only invoked by the goroutine running the ring.
+type ringHandler struct {
+	uringnet.BuiltinEventEngine
+
+	srv   *Server
+	ring  *uringnet.URingNet
+	conns map[int32]*Conn
+}
+
+func (h *ringHandler) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+	h.conns[data.Fd] = &Conn{h: h, fd: data.Fd, remoteAddr: h.ring.RemoteAddr(data.Fd)}
+	return nil, uringnet.None
+}
+
+func (h *ringHandler) OnTraffic(data *uringnet.UserData, _ *uringnet.URingNet) uringnet.Action {
+	c := h.conns[data.Fd]
+	if c == nil {
+		return uringnet.Close
+	}
+	c.in = append(c.in, data.Buffer[:data.BufSize]...)
+	if !c.upgraded {
+		req, n, err := http.ParseRequest(c.in, h.srv.maxHeaderBytes())
+		if err == http.ErrNeedMore {
+			return uringnet.Read
+		}
+		var resp []byte
+		if err == nil {
+			resp, c.subprotocol, err = h.srv.checkUpgrade(req)
+		}
+		if err != nil {
+			data.WriteBuf = appendRefusal(nil, err)
+			delete(h.conns, c.fd)
+			return uringnet.EchoAndClose
+		}
+		req.RemoteAddr = c.remoteAddr
+		c.req, c.upgraded = req, true
+		c.in = c.in[:copy(c.in, c.in[n:])]
+		// the handshake response goes first, OnOpen may already queue messages.
+		c.mu.Lock()
+		c.out = resp
+		c.mu.Unlock()
+		h.srv.Handler.OnOpen(c)
+	}
+	c.readFrames()
+	c.flush()
+	return uringnet.Read
+}
+
+func (h *ringHandler) OnClose(data uringnet.UserData) uringnet.Action {
+	if c := h.conns[data.Fd]; c != nil {
+		delete(h.conns, data.Fd)
+		c.onClose()
+	}
+	return uringnet.None
+}
@@ -0,0 +1,244 @@
+//go:build linux
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAddr = "127.0.0.1:18931"
+
+// echoHandler echoes the messages and records how the connections were closed.
+type echoHandler struct {
+	mu     sync.Mutex
+	closes []*CloseError
+}
+
+func (h *echoHandler) OnOpen(c *Conn) {
+	_ = c.WriteMessage(OpText, []byte("welcome "+c.Subprotocol()))
+}
+
+func (h *echoHandler) OnMessage(c *Conn, op Opcode, msg []byte) {
+	switch string(msg) {
+	case "panic":
+		panic("boom")
+	case "push":
+		// writing from another goroutine goes through the ring as well.
+		go func() { _ = c.WriteMessage(OpBinary, []byte("pushed")) }()
+	case "bye":
+		_ = c.Close(CloseGoingAway, "see you")
+	default:
+		_ = c.WriteMessage(op, msg)
+	}
+}
+
+func (h *echoHandler) OnClose(c *Conn, err *CloseError) {
+	h.mu.Lock()
+	h.closes = append(h.closes, err)
+	h.mu.Unlock()
+}
+
+var (
+	startOnce sync.Once
+	handler   = &echoHandler{}
+)
+
+func startServer(t *testing.T) {
+	t.Helper()
+	startOnce.Do(func() {
+		srv := &Server{Addr: testAddr, Path: "/ws", Handler: handler, Subprotocols: []string{"chat"},
+			Rings: 2, MaxMessageBytes: 1 << 16, ErrorLog: log.New(io.Discard, "", 0)}
+		go func() { t.Error(srv.ListenAndServe()) }()
+	})
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp4", testAddr); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+}
+
+type client struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dial(t *testing.T, path string, extra string) (*client, string) {
+	t.Helper()
+	startServer(t)
+	conn, err := net.Dial("tcp4", testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + testAddr + "\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" + extra + "\r\n"
+	if _, err = conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{Conn: conn, r: bufio.NewReader(conn)}
+	var resp strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the handshake response: %v", err)
+		}
+		resp.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+	return c, resp.String()
+}
+
+func (c *client) send(t *testing.T, f Frame) {
+	t.Helper()
+	f.Masked, f.Mask = true, [4]byte{9, 8, 7, 6}
+	if _, err := c.Write(AppendFrame(nil, f)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) recv(t *testing.T) Frame {
+	t.Helper()
+	buf := make([]byte, 0, 256)
+	for {
+		f, _, err := ParseFrame(buf, 1<<20)
+		if err == nil {
+			return f
+		}
+		if err != ErrNeedMore {
+			t.Fatal(err)
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading a frame: %v", err)
+		}
+		buf = append(buf, b)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// example of RFC 6455, section 1.3.
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	c, resp := dial(t, "/ws", "Sec-WebSocket-Protocol: mqtt, chat\r\n")
+	defer c.Close()
+	if !strings.HasPrefix(resp, "HTTP/1.1 101 ") || !strings.Contains(resp, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n") ||
+		!strings.Contains(resp, "Sec-WebSocket-Protocol: chat\r\n") {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if f := c.recv(t); f.Opcode != OpText || string(f.Payload) != "welcome chat" {
+		t.Fatalf("unexpected greeting %q", f.Payload)
+	}
+
+	for path, extra := range map[string]string{
+		"/nope": "",
+		"/ws":   "Origin: http://evil.example\r\n",
+	} {
+		c, resp := dial(t, path, extra)
+		c.Close()
+		if strings.HasPrefix(resp, "HTTP/1.1 101 ") {
+			t.Fatalf("%s %q: expect the upgrade to be refused", path, extra)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	c, _ := dial(t, "/ws", "")
+	defer c.Close()
+	c.recv(t)
+
+	c.send(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("hello")})
+	if f := c.recv(t); f.Opcode != OpText || string(f.Payload) != "hello" {
+		t.Fatalf("unexpected echo %+v", f)
+	}
+
+	// a fragmented message with a ping in the middle.
+	c.send(t, Frame{Opcode: OpBinary, Payload: []byte("frag")})
+	c.send(t, Frame{Fin: true, Opcode: OpPing, Payload: []byte("p")})
+	c.send(t, Frame{Fin: true, Opcode: OpContinuation, Payload: bytes.Repeat([]byte("m"), 60000)})
+	if f := c.recv(t); f.Opcode != OpPong || string(f.Payload) != "p" {
+		t.Fatalf("expect a pong, got %+v", f)
+	}
+	if f := c.recv(t); f.Opcode != OpBinary || len(f.Payload) != 60004 || string(f.Payload[:5]) != "fragm" {
+		t.Fatalf("unexpected reassembled message %v of %d bytes", f.Opcode, len(f.Payload))
+	}
+
+	// messages are limited to MaxMessageBytes.
+	c.send(t, Frame{Fin: true, Opcode: OpBinary, Payload: make([]byte, 70000)})
+	f := c.recv(t)
+	if ce, err := parseClosePayload(f.Payload); f.Opcode != OpClose || err != nil || ce.Code != CloseMessageTooBig {
+		t.Fatalf("expect close code %d, got %+v", CloseMessageTooBig, f)
+	}
+}
+
+func TestPushAndClose(t *testing.T) {
+	c, _ := dial(t, "/ws", "")
+	defer c.Close()
+	c.recv(t)
+
+	c.send(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("push")})
+	if f := c.recv(t); f.Opcode != OpBinary || string(f.Payload) != "pushed" {
+		t.Fatalf("unexpected push %+v", f)
+	}
+
+	c.send(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("bye")})
+	f := c.recv(t)
+	ce, err := parseClosePayload(f.Payload)
+	if f.Opcode != OpClose || err != nil || ce.Code != CloseGoingAway || ce.Text != "see you" {
+		t.Fatalf("unexpected close frame %+v", f)
+	}
+	c.send(t, Frame{Fin: true, Opcode: OpClose, Payload: closePayload(CloseNormalClosure, "")})
+	if _, err = c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the server to close the connection, got %v", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	cases := []struct {
+		frame Frame
+		code  int
+	}{
+		{Frame{Fin: true, Opcode: OpText, Payload: []byte{0xff, 0xfe}}, CloseInvalidFramePayloadData},
+		{Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("x")}, CloseProtocolError},
+		{Frame{Fin: true, Opcode: OpText, Payload: []byte("panic")}, CloseInternalServerErr},
+	}
+	for _, tc := range cases {
+		c, _ := dial(t, "/ws", "")
+		c.recv(t)
+		c.send(t, tc.frame)
+		f := c.recv(t)
+		ce, err := parseClosePayload(f.Payload)
+		if f.Opcode != OpClose || err != nil || ce.Code != tc.code {
+			t.Fatalf("expect close code %d, got %+v", tc.code, f)
+		}
+		if _, err = c.r.ReadByte(); err != io.EOF {
+			t.Fatalf("expect the server to close the connection, got %v", err)
+		}
+		c.Close()
+	}
+
+	// unmasked frames are refused.
+	c, _ := dial(t, "/ws", "")
+	defer c.Close()
+	c.recv(t)
+	_, _ = c.Write(AppendFrame(nil, Frame{Fin: true, Opcode: OpText, Payload: []byte("x")}))
+	if f := c.recv(t); f.Opcode != OpClose {
+		t.Fatalf("expect a close frame, got %+v", f)
+	}
+}